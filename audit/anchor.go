@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Head is the position and hash of the last entry of a chain
+type Head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Anchor keeps the head of a chain somewhere the sink's writers cannot reach. A chain that
+// has lost entries off its end is still intact, so only a head kept elsewhere shows it.
+type Anchor interface {
+	// Save records head as the newest
+	Save(ctx context.Context, head Head) error
+	// Load returns the recorded head, or nil if none has been recorded
+	Load(ctx context.Context) (*Head, error)
+}
+
+// FileAnchor keeps the head in a file, which belongs on storage the database or the audit
+// directory does not share
+type FileAnchor struct {
+	path string
+}
+
+// NewFileAnchor creates an anchor that writes the head to path
+func NewFileAnchor(path string) *FileAnchor {
+	return &FileAnchor{path: path}
+}
+
+// Save replaces the file by renaming a new one over it, so a crash leaves the old head or the
+// new one and never half of either
+func (a *FileAnchor) Save(ctx context.Context, head Head) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit head: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create audit anchor: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write audit anchor: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write audit anchor: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return fmt.Errorf("failed to replace audit anchor: %w", err)
+	}
+	return nil
+}
+
+func (a *FileAnchor) Load(ctx context.Context) (*Head, error) {
+	data, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit anchor: %w", err)
+	}
+	head := &Head{}
+	if err := json.Unmarshal(data, head); err != nil {
+		return nil, fmt.Errorf("failed to decode audit anchor: %w", err)
+	}
+	return head, nil
+}
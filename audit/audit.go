@@ -0,0 +1,379 @@
+// Package audit keeps a tamper-evident trail of access to user data. Every entry carries the
+// hash of the entry before it, so changing, removing or reordering entries breaks the chain
+// from that point on, which Verify detects. Hashes are HMACs under a key kept away from the
+// sink, so that whoever can write to the sink cannot compute a new chain, and the head of the
+// chain can be anchored outside the sink, so that removing entries from the end shows too.
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// ErrChainBroken is returned by Verify when an entry does not match the chain
+var ErrChainBroken = errors.New("audit chain is broken")
+
+// Entry is one audited action. Seq numbers entries from 1 without gaps.
+type Entry struct {
+	Seq       int64     `json:"seq" xml:"seq"`
+	Time      time.Time `json:"time" xml:"time"`
+	Actor     string    `json:"actor" xml:"actor"`
+	Action    string    `json:"action" xml:"action"`
+	Target    string    `json:"target" xml:"target"`
+	Outcome   string    `json:"outcome" xml:"outcome"`
+	Status    int       `json:"status" xml:"status"`
+	RequestID string    `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	PrevHash  string    `json:"prev_hash" xml:"prev_hash"`
+	Hash      string    `json:"hash" xml:"hash"`
+}
+
+// ComputeHash returns the HMAC-SHA256 under key of the entry's fields and the hash of the
+// entry before it
+func (e *Entry) ComputeHash(key []byte) string {
+	// a JSON array keeps the fields unambiguous whatever characters they contain
+	fields, _ := json.Marshal([]string{
+		strconv.FormatInt(e.Seq, 10),
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.Action,
+		e.Target,
+		e.Outcome,
+		strconv.Itoa(e.Status),
+		e.RequestID,
+		e.PrevHash,
+	})
+	mac := hmac.New(sha256.New, key)
+	mac.Write(fields)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Options configure how a chain is written and checked
+type Options struct {
+	// Key is the HMAC key of the entry hashes. Keep it outside the sink, or whoever can
+	// change the sink can rehash the chain after changing it.
+	Key []byte
+	// Anchor, if set, keeps the head of the chain after every append and is checked by Verify
+	Anchor Anchor
+}
+
+// Filter selects entries. Zero fields match everything.
+type Filter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	// AfterSeq skips entries up to and including this sequence number
+	AfterSeq int64
+	Limit    int
+}
+
+func (f Filter) matches(e *Entry) bool {
+	switch {
+	case e.Seq <= f.AfterSeq:
+		return false
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.Target != "" && e.Target != f.Target:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Sink stores audit entries. Sinks only ever append; they never change stored entries.
+type Sink interface {
+	// Append stores an entry after all earlier ones
+	Append(ctx context.Context, e *Entry) error
+	// Last returns the most recent entry, or nil if there is none
+	Last(ctx context.Context) (*Entry, error)
+	// Scan calls fn with the entries matching filter in sequence order, stopping at the
+	// first error fn returns
+	Scan(ctx context.Context, filter Filter, fn func(*Entry) error) error
+}
+
+// SharedSink is a sink that several processes can append to at once. Each entry is chained
+// to whatever entry is last when it is stored, not to the last one this process wrote.
+type SharedSink interface {
+	Sink
+	// AppendNext reads the last entry and stores entries after it, without any other entry
+	// being stored in between. link is called for each of entries in turn with the entry
+	// before it, nil for the first entry of the chain, to chain it.
+	AppendNext(ctx context.Context, entries []*Entry, link func(e, prev *Entry)) error
+}
+
+const (
+	// queueSize bounds the entries waiting to be appended. Entries recorded while it is
+	// full are dropped and counted as failures rather than holding up their request.
+	queueSize = 4096
+	// maxBatch bounds the entries appended together
+	maxBatch = 100
+	// appendTimeout bounds each append, so a stalled sink drops entries instead of
+	// collecting them forever
+	appendTimeout = 5 * time.Second
+)
+
+// queued is an entry waiting to be appended. done, if set, receives the outcome; an entry
+// of nil only waits for the entries queued before it.
+type queued struct {
+	e    *Entry
+	done chan error
+}
+
+// Logger appends entries to a sink, chaining each to the one before. Entries are queued and
+// appended in batches by a single writer, so that recording never waits for the sink. Sinks
+// other than a SharedSink have a single writer: processes must not share them.
+type Logger struct {
+	sink     Sink
+	last     *Entry
+	loaded   bool // whether last was read from the sink
+	opts     Options
+	now      func() time.Time
+	timeout  time.Duration
+	failures atomic.Int64
+
+	queue chan queued
+	start sync.Once
+}
+
+// NewLogger creates a logger that continues the chain already in sink
+func NewLogger(ctx context.Context, sink Sink, opts Options) (*Logger, error) {
+	last, err := sink.Last(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read last audit entry: %w", err)
+	}
+	l := NewDeferredLogger(sink, opts)
+	l.last, l.loaded = last, true
+	return l, nil
+}
+
+// NewDeferredLogger creates a logger that reads where the chain in sink ends when it
+// records its first entry, for sinks that may not be reachable yet
+func NewDeferredLogger(sink Sink, opts Options) *Logger {
+	return &Logger{sink: sink, opts: opts, now: time.Now, timeout: appendTimeout, queue: make(chan queued, queueSize)}
+}
+
+// Sink returns the sink the logger writes to
+func (l *Logger) Sink() Sink {
+	return l.sink
+}
+
+// Enqueue queues e to be assigned the next sequence number, the current time and its
+// hashes, and appended to the sink. It does not wait. Entries that cannot be appended, or
+// that find the queue full, are logged and counted in Failures.
+func (l *Logger) Enqueue(e *Entry) {
+	l.start.Do(func() { go l.write() })
+	select {
+	case l.queue <- queued{e: e}:
+	default:
+		l.failures.Add(1)
+		log.Printf("Audit queue is full, dropping entry for %s %s", e.Action, e.Target)
+	}
+}
+
+// Record queues e like Enqueue and waits until it is appended or ctx is done. Entries that
+// cannot be appended are counted in Failures.
+func (l *Logger) Record(ctx context.Context, e *Entry) error {
+	l.start.Do(func() { go l.write() })
+	done := make(chan error, 1)
+	select {
+	case l.queue <- queued{e: e, done: done}:
+	case <-ctx.Done():
+		l.failures.Add(1)
+		return fmt.Errorf("failed to queue audit entry: %w", ctx.Err())
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("failed to wait for audit entry: %w", ctx.Err())
+	}
+}
+
+// Flush waits until the entries queued so far are appended or have failed
+func (l *Logger) Flush(ctx context.Context) error {
+	l.start.Do(func() { go l.write() })
+	done := make(chan error, 1)
+	select {
+	case l.queue <- queued{done: done}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Failures returns the number of entries that could not be appended
+func (l *Logger) Failures() int64 {
+	return l.failures.Load()
+}
+
+// write appends queued entries in batches of what has queued up while the last batch was
+// being appended
+func (l *Logger) write() {
+	for first := range l.queue {
+		batch := []queued{first}
+	collect:
+		for len(batch) < maxBatch {
+			select {
+			case q := <-l.queue:
+				batch = append(batch, q)
+			default:
+				break collect
+			}
+		}
+
+		entries := make([]*Entry, 0, len(batch))
+		for _, q := range batch {
+			if q.e != nil {
+				entries = append(entries, q.e)
+			}
+		}
+		var err error
+		if len(entries) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+			var stored int
+			stored, err = l.append(ctx, entries)
+			if err != nil {
+				l.failures.Add(int64(len(entries) - stored))
+				log.Printf("Error appending %d audit entries: %v", len(entries)-stored, err)
+			}
+			if stored > 0 && l.opts.Anchor != nil {
+				head := entries[stored-1]
+				if err := l.opts.Anchor.Save(ctx, Head{Seq: head.Seq, Hash: head.Hash}); err != nil {
+					log.Printf("Error anchoring audit entry %d: %v", head.Seq, err)
+				}
+			}
+			cancel()
+		}
+		for _, q := range batch {
+			if q.done != nil {
+				q.done <- err
+			}
+		}
+	}
+}
+
+// append chains entries after the last entry and stores them, returning how many it stored
+func (l *Logger) append(ctx context.Context, entries []*Entry) (int, error) {
+	// a shared sink chains under its own lock, which also covers the other processes
+	if shared, ok := l.sink.(SharedSink); ok {
+		if err := shared.AppendNext(ctx, entries, l.link); err != nil {
+			return 0, fmt.Errorf("failed to append audit entries: %w", err)
+		}
+		return len(entries), nil
+	}
+
+	if !l.loaded {
+		last, err := l.sink.Last(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read last audit entry: %w", err)
+		}
+		l.last, l.loaded = last, true
+	}
+	for i, e := range entries {
+		l.link(e, l.last)
+		if err := l.sink.Append(ctx, e); err != nil {
+			return i, fmt.Errorf("failed to append audit entry: %w", err)
+		}
+		stored := *e
+		l.last = &stored
+	}
+	return len(entries), nil
+}
+
+// link makes e the entry after prev, which is nil for the first entry, and hashes it
+func (l *Logger) link(e, prev *Entry) {
+	e.Seq = 1
+	e.PrevHash = ""
+	if prev != nil {
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+	// sinks store microseconds, like MySQL's DATETIME(6), so hashes survive a round trip
+	e.Time = l.now().UTC().Truncate(time.Microsecond)
+	e.Hash = e.ComputeHash(l.opts.Key)
+}
+
+// Verify walks the whole chain in sink and checks that sequence numbers have no gaps, that
+// every entry's hashes match under opts.Key and that the chain still holds the head kept by
+// opts.Anchor. It returns the number of entries checked.
+func Verify(ctx context.Context, sink Sink, opts Options) (int64, error) {
+	var anchored *Head
+	if opts.Anchor != nil {
+		var err error
+		if anchored, err = opts.Anchor.Load(ctx); err != nil {
+			return 0, fmt.Errorf("failed to load audit anchor: %w", err)
+		}
+	}
+
+	var count int64
+	var prev *Entry
+	err := sink.Scan(ctx, Filter{}, func(e *Entry) error {
+		switch {
+		case prev == nil && e.Seq != 1:
+			return fmt.Errorf("%w: chain starts at entry %d", ErrChainBroken, e.Seq)
+		case prev == nil && e.PrevHash != "":
+			return fmt.Errorf("%w: entry %d has a previous hash", ErrChainBroken, e.Seq)
+		case prev != nil && e.Seq != prev.Seq+1:
+			return fmt.Errorf("%w: entry %d follows entry %d", ErrChainBroken, e.Seq, prev.Seq)
+		case prev != nil && e.PrevHash != prev.Hash:
+			return fmt.Errorf("%w: entry %d does not link to entry %d", ErrChainBroken, e.Seq, prev.Seq)
+		case e.Hash != e.ComputeHash(opts.Key):
+			return fmt.Errorf("%w: entry %d was modified", ErrChainBroken, e.Seq)
+		case anchored != nil && e.Seq == anchored.Seq && e.Hash != anchored.Hash:
+			return fmt.Errorf("%w: entry %d is not the one anchored", ErrChainBroken, e.Seq)
+		}
+		count++
+		copied := *e
+		prev = &copied
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if anchored != nil && count < anchored.Seq {
+		return count, fmt.Errorf("%w: chain ends at entry %d, before anchored entry %d", ErrChainBroken, count, anchored.Seq)
+	}
+
+	log.Printf("Verified %d audit entries", count)
+	return count, nil
+}
+
+// Outcome classifies an HTTP status code
+func Outcome(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return OutcomeDenied
+	case status >= 400:
+		return OutcomeFailure
+	default:
+		return OutcomeSuccess
+	}
+}
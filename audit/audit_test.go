@@ -0,0 +1,358 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"userapi/reqctx"
+)
+
+var testOptions = Options{Key: []byte("test key")}
+
+func TestLogger_FileSink(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir, 1024)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	logger, err := NewLogger(ctx, sink, testOptions)
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := logger.Record(ctx, &Entry{Actor: "alice", Action: "user.get", Target: "/users/1", Outcome: OutcomeSuccess, Status: 200}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	sink.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, segmentPattern))
+	if len(segments) < 2 {
+		t.Errorf("10 entries in 1KB segments used %d segments, want rotation", len(segments))
+	}
+
+	// a restarted logger continues the chain
+	sink, err = NewFileSink(dir, 1024)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	defer sink.Close()
+	logger, err = NewLogger(ctx, sink, testOptions)
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	e := &Entry{Actor: "bob", Action: "user.delete", Target: "/users/2", Outcome: OutcomeFailure, Status: 404}
+	if err := logger.Record(ctx, e); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if e.Seq != 11 {
+		t.Errorf("Record() after restart seq = %d, want 11", e.Seq)
+	}
+
+	// so does one that reads where the chain ends only when it first records
+	deferred := NewDeferredLogger(sink, testOptions)
+	e = &Entry{Actor: "carol", Action: "user.get", Target: "/users/3", Outcome: OutcomeSuccess, Status: 200}
+	if err := deferred.Record(ctx, e); err != nil {
+		t.Fatalf("Record() on deferred logger error = %v", err)
+	}
+	if e.Seq != 12 {
+		t.Errorf("Record() on deferred logger seq = %d, want 12", e.Seq)
+	}
+
+	if count, err := Verify(ctx, sink, testOptions); err != nil || count != 12 {
+		t.Fatalf("Verify() = %d, %v, want 12 entries", count, err)
+	}
+
+	var found []*Entry
+	if err := sink.Scan(ctx, Filter{Actor: "bob"}, func(e *Entry) error {
+		found = append(found, e)
+		return nil
+	}); err != nil || len(found) != 1 || found[0].Seq != 11 {
+		t.Errorf("Scan() by actor = %v, %v", found, err)
+	}
+	found = nil
+	sink.Scan(ctx, Filter{AfterSeq: 3, Limit: 2}, func(e *Entry) error {
+		found = append(found, e)
+		return nil
+	})
+	if len(found) != 2 || found[0].Seq != 4 || found[1].Seq != 5 {
+		t.Errorf("Scan() page = %v", found)
+	}
+}
+
+func TestVerify_DetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+	}{
+		{"modified entry", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"actor":"alice"`, `"actor":"mallory"`, 1)
+			return lines
+		}},
+		{"removed entry", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}},
+		{"reordered entries", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}},
+		{"removed first entry", func(lines []string) []string {
+			return lines[1:]
+		}},
+		{"removed last entry", func(lines []string) []string {
+			return lines[:len(lines)-1]
+		}},
+		{"modified entry rehashed without the key", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"actor":"alice"`, `"actor":"mallory"`, 1)
+			return rehash(t, lines, []byte("guessed key"))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			sink, err := NewFileSink(dir, 1<<20)
+			if err != nil {
+				t.Fatalf("NewFileSink() error = %v", err)
+			}
+			defer sink.Close()
+			opts := Options{Key: testOptions.Key, Anchor: NewFileAnchor(filepath.Join(t.TempDir(), "head.json"))}
+			logger, _ := NewLogger(ctx, sink, opts)
+			for i := 0; i < 3; i++ {
+				logger.Record(ctx, &Entry{Actor: "alice", Action: "user.get", Target: "/users/1", Outcome: OutcomeSuccess, Status: 200})
+			}
+			if count, err := Verify(ctx, sink, opts); err != nil || count != 3 {
+				t.Fatalf("Verify() before tampering = %d, %v", count, err)
+			}
+
+			path := filepath.Join(dir, "audit-00000001.ndjson")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading segment: %v", err)
+			}
+			lines := tt.tamper(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+			os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o640)
+
+			if _, err := Verify(ctx, sink, opts); !errors.Is(err, ErrChainBroken) {
+				t.Errorf("Verify() error = %v, want %v", err, ErrChainBroken)
+			}
+		})
+	}
+}
+
+// rehash recomputes the chain of NDJSON lines under key, as someone who can write the log
+// but does not have its key would
+func rehash(t *testing.T, lines []string, key []byte) []string {
+	prevHash := ""
+	for i, line := range lines {
+		e := &Entry{}
+		if err := json.Unmarshal([]byte(line), e); err != nil {
+			t.Fatalf("decoding entry: %v", err)
+		}
+		e.PrevHash = prevHash
+		e.Hash = e.ComputeHash(key)
+		prevHash = e.Hash
+		data, _ := json.Marshal(e)
+		lines[i] = string(data)
+	}
+	return lines
+}
+
+func TestLogger_Handler(t *testing.T) {
+	ctx := context.Background()
+	sink, err := NewFileSink(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	defer sink.Close()
+	logger, _ := NewLogger(ctx, sink, testOptions)
+
+	handler := logger.Handler("user.get", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	req := httptest.NewRequest("GET", "/users/7?fields=email", nil)
+	req = req.WithContext(reqctx.WithRequestID(reqctx.WithActor(ctx, "alice"), "req-1"))
+	handler(httptest.NewRecorder(), req)
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/8?access_token=secret&fields=name", nil))
+	if err := logger.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	var entries []*Entry
+	sink.Scan(ctx, Filter{}, func(e *Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if len(entries) != 2 {
+		t.Fatalf("recorded %d entries, want 2", len(entries))
+	}
+	want := Entry{Seq: 1, Actor: "alice", Action: "user.get", Target: "/users/7?fields=email", Outcome: OutcomeFailure, Status: 404, RequestID: "req-1"}
+	got := *entries[0]
+	got.Time, got.PrevHash, got.Hash = want.Time, "", ""
+	if got != want {
+		t.Errorf("entry = %+v, want %+v", got, want)
+	}
+	if entries[1].Actor != anonymousActor {
+		t.Errorf("entry without actor has actor %q, want %q", entries[1].Actor, anonymousActor)
+	}
+	if entries[1].Target != "/users/8?fields=name" {
+		t.Errorf("target = %q, want it without the access token", entries[1].Target)
+	}
+}
+
+// sharedSink keeps entries in memory like SQLSink keeps them in a table shared by every
+// instance, failing appends while failing is set
+type sharedSink struct {
+	mu      sync.Mutex
+	entries []*Entry
+	failing bool
+	batches int
+	// stalled makes appends hang until their context is done
+	stalled bool
+}
+
+func (s *sharedSink) Append(ctx context.Context, e *Entry) error {
+	return errors.New("entries of a shared sink must be appended with AppendNext")
+}
+
+func (s *sharedSink) AppendNext(ctx context.Context, entries []*Entry, link func(e, prev *Entry)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stalled {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if s.failing {
+		return errors.New("sink is down")
+	}
+	s.batches++
+	var last *Entry
+	if len(s.entries) > 0 {
+		last = s.entries[len(s.entries)-1]
+	}
+	for _, e := range entries {
+		link(e, last)
+		stored := *e
+		s.entries = append(s.entries, &stored)
+		last = &stored
+	}
+	return nil
+}
+
+func (s *sharedSink) Last(ctx context.Context) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) == 0 {
+		return nil, nil
+	}
+	return s.entries[len(s.entries)-1], nil
+}
+
+func (s *sharedSink) Scan(ctx context.Context, filter Filter, fn func(*Entry) error) error {
+	s.mu.Lock()
+	entries := append([]*Entry(nil), s.entries...)
+	s.mu.Unlock()
+	for _, e := range entries {
+		if filter.matches(e) {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestLogger_SharedSink(t *testing.T) {
+	ctx := context.Background()
+	sink := &sharedSink{}
+	// loggers of two instances, each created before the other wrote anything
+	first, _ := NewLogger(ctx, sink, testOptions)
+	second := NewDeferredLogger(sink, testOptions)
+
+	var wg sync.WaitGroup
+	for _, logger := range []*Logger{first, second} {
+		wg.Add(1)
+		go func(logger *Logger) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := logger.Record(ctx, &Entry{Actor: "alice", Action: "user.get", Target: "/users/1", Outcome: OutcomeSuccess, Status: 200}); err != nil {
+					t.Errorf("Record() error = %v", err)
+				}
+			}
+		}(logger)
+	}
+	wg.Wait()
+
+	if count, err := Verify(ctx, sink, testOptions); err != nil || count != 100 {
+		t.Fatalf("Verify() = %d, %v, want one chain of 100 entries", count, err)
+	}
+
+	sink.failing = true
+	if err := first.Record(ctx, &Entry{Actor: "alice", Action: "user.get"}); err == nil {
+		t.Error("Record() on a failing sink succeeded")
+	}
+	if first.Failures() != 1 || second.Failures() != 0 {
+		t.Errorf("failures = %d, %d, want 1, 0", first.Failures(), second.Failures())
+	}
+}
+
+func TestLogger_Batches(t *testing.T) {
+	ctx := context.Background()
+	sink := &sharedSink{}
+	logger, _ := NewLogger(ctx, sink, testOptions)
+
+	// entries queued while the sink is busy are appended together
+	sink.mu.Lock()
+	for i := 0; i < 250; i++ {
+		logger.Enqueue(&Entry{Actor: "alice", Action: "user.get", Target: "/users/1", Outcome: OutcomeSuccess, Status: 200})
+	}
+	sink.mu.Unlock()
+	if err := logger.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if count, err := Verify(ctx, sink, testOptions); err != nil || count != 250 {
+		t.Fatalf("Verify() = %d, %v, want one chain of 250 entries", count, err)
+	}
+	if sink.batches > 5 {
+		t.Errorf("appended 250 entries in %d batches, want them batched by up to %d", sink.batches, maxBatch)
+	}
+}
+
+func TestLogger_StalledSink(t *testing.T) {
+	ctx := context.Background()
+	sink := &sharedSink{stalled: true}
+	logger, _ := NewLogger(ctx, sink, testOptions)
+	logger.timeout = 50 * time.Millisecond
+
+	// recording does not wait for the sink, and entries beyond the queue are dropped
+	start := time.Now()
+	for i := 0; i < queueSize+maxBatch+10; i++ {
+		logger.Enqueue(&Entry{Actor: "alice", Action: "user.get", Target: "/users/1", Outcome: OutcomeSuccess, Status: 200})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Enqueue() on a stalled sink took %v", elapsed)
+	}
+	if logger.Failures() < 10 {
+		t.Errorf("failures = %d, want the entries that found the queue full", logger.Failures())
+	}
+
+	// appends give up after their timeout, so the queue drains
+	if err := logger.Record(ctx, &Entry{Actor: "alice", Action: "user.get"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Record() on a stalled sink error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if failures := logger.Failures(); failures != int64(queueSize+maxBatch+11) {
+		t.Errorf("failures = %d, want every entry", failures)
+	}
+}
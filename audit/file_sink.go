@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const segmentPattern = "audit-*.ndjson"
+
+// FileSink writes entries as NDJSON to numbered segment files in a directory, starting a new
+// segment once the current one reaches its size limit
+type FileSink struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	file     *os.File
+	segment  int
+	size     int64
+}
+
+// NewFileSink creates a sink that appends to the newest segment in dir
+func NewFileSink(dir string, maxBytes int64) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	s := &FileSink{dir: dir, maxBytes: maxBytes}
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	s.segment = 1
+	if len(segments) > 0 {
+		newest := filepath.Base(segments[len(segments)-1])
+		if _, err := fmt.Sscanf(newest, "audit-%d.ndjson", &s.segment); err != nil {
+			return nil, fmt.Errorf("failed to parse audit segment name %s: %w", newest, err)
+		}
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Append(ctx context.Context, e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Last(ctx context.Context) (*Entry, error) {
+	var last *Entry
+	err := s.Scan(ctx, Filter{}, func(e *Entry) error {
+		last = e
+		return nil
+	})
+	return last, err
+}
+
+func (s *FileSink) Scan(ctx context.Context, filter Filter, fn func(*Entry) error) error {
+	s.mu.Lock()
+	segments, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, path := range segments {
+		done, err := scanSegment(ctx, path, func(e *Entry) (bool, error) {
+			if !filter.matches(e) {
+				return false, nil
+			}
+			if err := fn(e); err != nil {
+				return true, err
+			}
+			count++
+			return filter.Limit > 0 && count >= filter.Limit, nil
+		})
+		if done || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the current segment
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// scanSegment decodes the entries of one segment until fn reports it is done
+func scanSegment(ctx context.Context, path string, fn func(*Entry) (bool, error)) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open audit segment: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return true, err
+		}
+		e := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return true, fmt.Errorf("%w: %s line %d is not an entry: %v", ErrChainBroken, filepath.Base(path), line, err)
+		}
+		if done, err := fn(e); done || err != nil {
+			return true, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("failed to read audit segment: %w", err)
+	}
+	return false, nil
+}
+
+// segments returns the paths of the segment files, oldest first
+func (s *FileSink) segments() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, segmentPattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit segments: %w", err)
+	}
+	// segment numbers are zero-padded, so names sort in order
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (s *FileSink) segmentPath(n int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("audit-%08d.ndjson", n))
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.segmentPath(s.segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit segment: %w", err)
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit segment: %w", err)
+	}
+	s.segment++
+	return s.open()
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"net/http"
+	"userapi/reqctx"
+)
+
+// anonymousActor is recorded for requests that do not name their actor
+const anonymousActor = reqctx.AnonymousActor
+
+// maxTargetLength is the size of the target column of the audit_log table
+const maxTargetLength = 2048
+
+// Handler queues an entry for action after every call of next. The target is the request
+// URI, which names the users accessed and any filters. The request does not wait for the
+// entry to be appended; failing to append it is logged and counted.
+func (l *Logger) Handler(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next(sw, r)
+
+		actor := reqctx.Actor(r.Context())
+		if actor == "" {
+			actor = anonymousActor
+		}
+		target := requestTarget(r)
+		if len(target) > maxTargetLength {
+			target = target[:maxTargetLength]
+		}
+		e := &Entry{
+			Actor:     actor,
+			Action:    action,
+			Target:    target,
+			Outcome:   Outcome(sw.status),
+			Status:    sw.status,
+			RequestID: reqctx.RequestID(r.Context()),
+		}
+		l.Enqueue(e)
+	}
+}
+
+// requestTarget returns the request URI without the access_token parameter, which
+// authenticates the request and must not end up in the log
+func requestTarget(r *http.Request) string {
+	query := r.URL.Query()
+	if !query.Has("access_token") {
+		return r.URL.RequestURI()
+	}
+	query.Del("access_token")
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
+// statusResponseWriter remembers the status code of a response
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
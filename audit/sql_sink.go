@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// maxAppendAttempts bounds how often AppendNext retries after losing a race for the end of
+// the chain to another process
+const maxAppendAttempts = 5
+
+// SQLSink stores entries in the audit_log table. Every instance of the service can append to
+// the same table; see AppendNext.
+type SQLSink struct {
+	db *sql.DB
+}
+
+// queryer is what scan needs of a *sql.DB or *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// NewSQLSink creates a sink that writes to the audit_log table of db
+func NewSQLSink(db *sql.DB) *SQLSink {
+	return &SQLSink{db: db}
+}
+
+const insertEntryQuery = `INSERT INTO audit_log (seq, created_at, actor, action, target, outcome, status, request_id, prev_hash, hash)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func (s *SQLSink) Append(ctx context.Context, e *Entry) error {
+	_, err := s.db.ExecContext(ctx, insertEntryQuery, e.Seq, e.Time, e.Actor, e.Action, e.Target, e.Outcome, e.Status, e.RequestID, e.PrevHash, e.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// AppendNext reads the last entry with a locking read and inserts entries after it in the
+// same transaction, so entries from several instances form one chain and a batch takes the
+// lock once. The primary key on seq turns any race the lock does not prevent, such as two
+// first entries, into a duplicate or a deadlock, after which the chain is read again.
+func (s *SQLSink) AppendNext(ctx context.Context, entries []*Entry, link func(e, prev *Entry)) error {
+	for attempt := 1; ; attempt++ {
+		err := s.appendNext(ctx, entries, link)
+		if err == nil || attempt == maxAppendAttempts || !isChainConflict(err) {
+			return err
+		}
+		log.Printf("Audit entries from %d were taken by another instance, retrying (attempt %d/%d)", entries[0].Seq, attempt, maxAppendAttempts)
+	}
+}
+
+func (s *SQLSink) appendNext(ctx context.Context, entries []*Entry, link func(e, prev *Entry)) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var last *Entry
+	err = scan(ctx, tx, `ORDER BY seq DESC LIMIT 1 FOR UPDATE`, nil, func(prev *Entry) error {
+		last = prev
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// seq is set here, not by auto_increment, so one statement can insert the whole batch
+	placeholders := make([]string, len(entries))
+	args := make([]interface{}, 0, 10*len(entries))
+	for i, e := range entries {
+		link(e, last)
+		last = e
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, e.Seq, e.Time, e.Actor, e.Action, e.Target, e.Outcome, e.Status, e.RequestID, e.PrevHash, e.Hash)
+	}
+	query := `INSERT INTO audit_log (seq, created_at, actor, action, target, outcome, status, request_id, prev_hash, hash) VALUES ` + strings.Join(placeholders, ", ")
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to insert audit entries: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit audit entries: %w", err)
+	}
+	return nil
+}
+
+// isChainConflict reports whether err comes from another instance appending at the same time
+func isChainConflict(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062 || mysqlErr.Number == 1213 // duplicate entry, deadlock
+	}
+	return false
+}
+
+func (s *SQLSink) Last(ctx context.Context) (*Entry, error) {
+	var last *Entry
+	err := scan(ctx, s.db, `ORDER BY seq DESC LIMIT 1`, nil, func(e *Entry) error {
+		last = e
+		return nil
+	})
+	return last, err
+}
+
+func (s *SQLSink) Scan(ctx context.Context, filter Filter, fn func(*Entry) error) error {
+	var conditions []string
+	var args []interface{}
+	if filter.AfterSeq > 0 {
+		conditions = append(conditions, "seq > ?")
+		args = append(args, filter.AfterSeq)
+	}
+	for _, c := range []struct{ column, value string }{
+		{"actor", filter.Actor}, {"action", filter.Action}, {"target", filter.Target},
+	} {
+		if c.value != "" {
+			conditions = append(conditions, c.column+" = ?")
+			args = append(args, c.value)
+		}
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.Until.UTC())
+	}
+
+	clauses := ""
+	if len(conditions) > 0 {
+		clauses = "WHERE " + strings.Join(conditions, " AND ") + " "
+	}
+	clauses += "ORDER BY seq"
+	if filter.Limit > 0 {
+		clauses += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+	return scan(ctx, s.db, clauses, args, fn)
+}
+
+func scan(ctx context.Context, q queryer, clauses string, args []interface{}, fn func(*Entry) error) error {
+	query := `SELECT seq, created_at, actor, action, target, outcome, status, request_id, prev_hash, hash FROM audit_log ` + clauses
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		e := &Entry{}
+		if err := rows.Scan(&e.Seq, &e.Time, &e.Actor, &e.Action, &e.Target, &e.Outcome, &e.Status, &e.RequestID, &e.PrevHash, &e.Hash); err != nil {
+			return fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		e.Time = e.Time.UTC()
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate audit log: %w", err)
+	}
+	return nil
+}
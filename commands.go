@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+	"userapi/audit"
+	"userapi/loadtest"
+	"userapi/models"
+	"userapi/recording"
+	"userapi/repository"
+	"userapi/seed"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// runCommand runs the maintenance command name and returns the process exit code
+func runCommand(name string, args []string) int {
+	switch name {
+	case "verify":
+		return runVerify(args)
+	case "seed":
+		return runSeed(args)
+	case "loadtest":
+		return runLoadTest(args)
+	case "replay":
+		return runReplay(args)
+	case "backfill-phones":
+		return runBackfillPhones(args)
+	case "help", "-h", "--help":
+		printUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", name)
+		printUsage()
+		return 2
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, `usage: userapi [command]
+
+Without a command the API server starts. Commands:
+  verify    check the hash chain of the audit log
+  seed      create fake users for demos and load tests
+  loadtest  drive the /users routes of a running server and report latencies
+  replay    send recorded traffic to a running server and compare the responses
+  backfill-phones
+            convert phone numbers stored before migration 002 to E.164`)
+}
+
+// runVerify checks the audit log configured by the same environment as the server
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// the server only connects to MySQL for STORAGE=mysql, which is also the audit default
+	var db *sql.DB
+	if getEnv("STORAGE", "mysql") == "mysql" && getEnv("AUDIT_SINK", "db") == "db" {
+		db = openDatabase()
+		defer db.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), getEnvDuration("DB_CONNECT_TIMEOUT", time.Minute))
+		defer cancel()
+		if err := connectDatabase(ctx, db).Wait(ctx); err != nil {
+			log.Printf("Could not connect to database: %v", err)
+			return 1
+		}
+	}
+	sink, err := openAuditSink(db)
+	if err != nil {
+		log.Printf("Could not open audit log: %v", err)
+		return 1
+	}
+
+	opts, err := auditOptions()
+	if err != nil {
+		log.Printf("Could not configure audit log: %v", err)
+		return 1
+	}
+	count, err := audit.Verify(context.Background(), sink, opts)
+	if errors.Is(err, audit.ErrChainBroken) {
+		fmt.Printf("audit log is NOT intact after %d entries: %v\n", count, err)
+		return 1
+	}
+	if err != nil {
+		log.Printf("Could not verify audit log: %v", err)
+		return 1
+	}
+	fmt.Printf("audit log intact: %d entries\n", count)
+	return 0
+}
+
+// runSeed generates fake users and creates them in the database configured by the same
+// environment as the server, or prints them as NDJSON with -dry-run
+func runSeed(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	count := flags.Int("count", 100, "number of users to generate")
+	seedValue := flags.Int64("seed", 1, "seed of the generator; the same seed generates the same users")
+	rate := flags.Float64("rate", 0, "users to create per second, 0 for as fast as possible")
+	workers := flags.Int("workers", 4, "users to create concurrently")
+	localeList := flags.String("locales", "", "comma separated locales to generate users for, all by default")
+	dryRun := flags.Bool("dry-run", false, "print the users as NDJSON instead of creating them")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	gen, err := seed.NewGenerator(*seedValue, splitList(*localeList)...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *dryRun {
+		enc := json.NewEncoder(os.Stdout)
+		for i := 0; i < *count; i++ {
+			if err := enc.Encode(gen.Next()); err != nil {
+				log.Printf("Could not write user: %v", err)
+				return 1
+			}
+		}
+		return 0
+	}
+
+	if storage := getEnv("STORAGE", "mysql"); storage != "mysql" {
+		fmt.Fprintf(os.Stderr, "seeding needs STORAGE=mysql, the server keeps STORAGE=%s users to itself; use -dry-run to print them\n", storage)
+		return 2
+	}
+	db := openDatabase()
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	connectCtx, cancel := context.WithTimeout(ctx, getEnvDuration("DB_CONNECT_TIMEOUT", time.Minute))
+	defer cancel()
+	if err := connectDatabase(connectCtx, db).Wait(connectCtx); err != nil {
+		log.Printf("Could not connect to database: %v", err)
+		return 1
+	}
+
+	start := time.Now()
+	result, err := seed.Run(ctx, repository.NewMySQLUserRepository(db), gen, seed.Options{Count: *count, Rate: *rate, Workers: *workers})
+	fmt.Printf("created %d users, %d already existed, %d failed in %v\n", result.Created, result.Existing, result.Failed, time.Since(start).Round(time.Millisecond))
+	if err != nil {
+		log.Printf("Seeding stopped: %v", err)
+		return 1
+	}
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runBackfillPhones converts the phone numbers that migration 002 left as they were entered
+func runBackfillPhones(args []string) int {
+	flags := flag.NewFlagSet("backfill-phones", flag.ContinueOnError)
+	batchSize := flags.Int("batch", 500, "users to read at a time")
+	region := flags.String("region", getEnv("DEFAULT_PHONE_REGION", models.DefaultPhoneRegion), "region of numbers without a country code, DEFAULT_PHONE_REGION by default")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if phonenumbers.GetCountryCodeForRegion(strings.ToUpper(*region)) == 0 {
+		fmt.Fprintf(os.Stderr, "unknown region: %s\n", *region)
+		return 2
+	}
+
+	if storage := getEnv("STORAGE", "mysql"); storage != "mysql" {
+		fmt.Fprintf(os.Stderr, "backfilling needs STORAGE=mysql, STORAGE=%s users are not kept anywhere\n", storage)
+		return 2
+	}
+	db := openDatabase()
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	connectCtx, cancel := context.WithTimeout(ctx, getEnvDuration("DB_CONNECT_TIMEOUT", time.Minute))
+	defer cancel()
+	if err := connectDatabase(connectCtx, db).Wait(connectCtx); err != nil {
+		log.Printf("Could not connect to database: %v", err)
+		return 1
+	}
+
+	result, err := repository.BackfillPhoneNumbers(ctx, db, strings.ToUpper(*region), *batchSize)
+	fmt.Printf("checked %d users, converted %d phone numbers, %d were not valid\n", result.Checked, result.Converted, result.Invalid)
+	if err != nil {
+		log.Printf("Backfill stopped: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// runLoadTest sends a mix of requests to the /users routes of a running server at a fixed
+// rate and reports latency percentiles and errors
+func runLoadTest(args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := flags.String("target", "http://localhost:8080", "base URL of the server")
+	rate := flags.Float64("rate", 100, "requests to start per second")
+	duration := flags.Duration("duration", 30*time.Second, "how long to send requests for")
+	mix := flags.String("mix", "get=70,list=5,search=5,create=10,update=8,delete=2", "operations to send, by weight")
+	arrival := flags.String("arrival", "uniform", "spacing of requests: uniform, or poisson for random arrivals")
+	maxInFlight := flags.Int("max-in-flight", 10000, "requests to wait for at once before skipping due ones")
+	timeout := flags.Duration("timeout", 5*time.Second, "timeout of a request")
+	seedValue := flags.Int64("seed", 1, "seed of the generated users and the order of operations")
+	prepare := flags.Int("prepare", 100, "users to create before measuring")
+	token := flags.String("token", os.Getenv("LOADTEST_TOKEN"), "bearer token to send, LOADTEST_TOKEN by default")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	parsedMix, err := loadtest.ParseMix(*mix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *arrival != "uniform" && *arrival != "poisson" {
+		fmt.Fprintf(os.Stderr, "unknown arrival: %s\n", *arrival)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "sending %.0f requests/s to %s for %v\n", *rate, *target, *duration)
+	report, err := loadtest.Run(ctx, loadtest.Config{
+		Target:      *target,
+		Rate:        *rate,
+		Duration:    *duration,
+		Mix:         parsedMix,
+		Poisson:     *arrival == "poisson",
+		MaxInFlight: *maxInFlight,
+		Timeout:     *timeout,
+		Seed:        *seedValue,
+		Prepare:     *prepare,
+		Token:       *token,
+	})
+	if err != nil {
+		log.Printf("Load test failed: %v", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Printf("Could not write report: %v", err)
+			return 1
+		}
+		return 0
+	}
+	report.Print(os.Stdout)
+	return 0
+}
+
+// runReplay sends the exchanges of a recording made with RECORD_FILE to a running server and
+// reports those whose responses differ from the recorded ones
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	target := flags.String("target", "http://localhost:8080", "base URL of the server")
+	file := flags.String("file", "", "recording to replay")
+	ignore := flags.String("ignore", "", "comma separated JSON fields to leave out of the comparison, besides IDs and timestamps")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of a request")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "-file is required")
+		return 2
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Printf("Could not open recording: %v", err)
+		return 1
+	}
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := recording.Replay(ctx, f, recording.ReplayOptions{
+		Target: *target,
+		Ignore: splitList(*ignore),
+		Client: &http.Client{Timeout: *timeout},
+	})
+	if err != nil {
+		log.Printf("Replay failed: %v", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Printf("Could not write report: %v", err)
+			return 1
+		}
+	} else {
+		for _, d := range report.Differences {
+			fmt.Printf("line %d: %s %s: status %d, replayed %d", d.Line, d.Method, d.URI, d.Recorded, d.Replayed)
+			if len(d.Fields) > 0 {
+				fmt.Printf(", differs at %s", strings.Join(d.Fields, ", "))
+			}
+			fmt.Println()
+		}
+		fmt.Printf("%d matched, %d mismatched, %d skipped\n", report.Matched, report.Mismatched, report.Skipped)
+	}
+	if report.Mismatched > 0 {
+		return 1
+	}
+	return 0
+}
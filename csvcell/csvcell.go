@@ -0,0 +1,38 @@
+// Package csvcell keeps values written to CSV files from being run as formulas when the file
+// is opened in a spreadsheet.
+package csvcell
+
+import "strings"
+
+// triggers are the characters that make a spreadsheet read a cell as a formula
+const triggers = "=+-@"
+
+// Escape prefixes s with a quote if it starts with a character that would make it a formula,
+// which spreadsheets then show as text and do not display the quote of
+func Escape(s string) string {
+	if isFormula(s) {
+		return "'" + s
+	}
+	return s
+}
+
+// Unescape removes the quote Escape added, so that files written by this service can be read
+// back. Values that start with a quote otherwise are left as they are.
+func Unescape(s string) string {
+	if len(s) > 1 && s[0] == '\'' && isFormula(s[1:]) {
+		return s[1:]
+	}
+	return s
+}
+
+// Record escapes every value of record in place and returns it
+func Record(record []string) []string {
+	for i, value := range record {
+		record[i] = Escape(value)
+	}
+	return record
+}
+
+func isFormula(s string) bool {
+	return s != "" && strings.IndexByte(triggers, s[0]) >= 0
+}
@@ -0,0 +1,32 @@
+package csvcell
+
+import "testing"
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "John Doe", "John Doe"},
+		{"empty", "", ""},
+		{"formula", "=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"plus", "+14155552671", "'+14155552671"},
+		{"minus", "-1", "'-1"},
+		{"at", "@SUM(A1)", "'@SUM(A1)"},
+		{"trigger not first", "a=b", "a=b"},
+		{"quoted", "'quoted", "'quoted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Escape(tt.value)
+			if got != tt.want {
+				t.Errorf("Escape(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+			if back := Unescape(got); back != tt.value {
+				t.Errorf("Unescape(%q) = %q, want %q", got, back, tt.value)
+			}
+		})
+	}
+}
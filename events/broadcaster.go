@@ -0,0 +1,91 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"userapi/models"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 256
+
+// Broadcaster is a Sink that fans events out to in-process subscribers, such as streaming
+// HTTP responses. Publishing never waits for a subscriber: one that falls too far behind is
+// dropped and has to catch up from the event log.
+type Broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	// Events delivers the events. It is closed when the subscription is dropped or closed.
+	Events <-chan *models.UserEvent
+
+	events      chan *models.UserEvent
+	broadcaster *Broadcaster
+	dropped     bool
+}
+
+// NewBroadcaster creates a broadcaster without subscribers
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription to every event published from now on. It must be closed.
+func (b *Broadcaster) Subscribe() *Subscription {
+	events := make(chan *models.UserEvent, subscriberBuffer)
+	s := &Subscription{Events: events, events: events, broadcaster: b}
+
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish hands e to every subscriber with room for it and drops the others
+func (b *Broadcaster) Publish(ctx context.Context, e *models.UserEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscribers {
+		select {
+		case s.events <- e:
+		default:
+			log.Printf("Dropping event subscriber that is %d events behind", subscriberBuffer)
+			s.dropped = true
+			b.remove(s)
+		}
+	}
+	return nil
+}
+
+// Subscribers returns the number of current subscribers
+func (b *Broadcaster) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.broadcaster.mu.Lock()
+	defer s.broadcaster.mu.Unlock()
+	s.broadcaster.remove(s)
+}
+
+// Dropped reports whether the subscription was ended because it fell behind. It is only
+// meaningful once Events is closed.
+func (s *Subscription) Dropped() bool {
+	s.broadcaster.mu.Lock()
+	defer s.broadcaster.mu.Unlock()
+	return s.dropped
+}
+
+func (b *Broadcaster) remove(s *Subscription) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"userapi/models"
+)
+
+func TestBroadcaster(t *testing.T) {
+	ctx := context.Background()
+	b := NewBroadcaster()
+	fast := b.Subscribe()
+	defer fast.Close()
+	slow := b.Subscribe()
+	defer slow.Close()
+
+	// the fast subscriber keeps up while the slow one never reads
+	for i := int64(1); i <= subscriberBuffer+1; i++ {
+		if err := b.Publish(ctx, &models.UserEvent{ID: i, Type: models.EventUserCreated, UserID: i}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+		if e := <-fast.Events; e.ID != i {
+			t.Fatalf("fast subscriber received event %d, want %d", e.ID, i)
+		}
+	}
+
+	var received int
+	for range slow.Events {
+		received++
+	}
+	if received != subscriberBuffer || !slow.Dropped() {
+		t.Errorf("slow subscriber received %d events, dropped = %v, want %d and dropped", received, slow.Dropped(), subscriberBuffer)
+	}
+	if fast.Dropped() || b.Subscribers() != 1 {
+		t.Errorf("fast dropped = %v, subscribers = %d, want false and 1", fast.Dropped(), b.Subscribers())
+	}
+
+	fast.Close()
+	if _, ok := <-fast.Events; ok || b.Subscribers() != 0 {
+		t.Errorf("closed subscription still receives events or is still subscribed")
+	}
+}
@@ -0,0 +1,155 @@
+// Package events publishes user events from the transactional outbox to downstream sinks.
+//
+// Delivery is at least once: an event is marked published only after every sink accepted
+// it, so a crash or a failing sink leads to the event, and possibly its neighbours, being
+// delivered again. Sinks should deduplicate by event ID. Events of the same user are
+// delivered in order: when one fails, the later events of that user wait for it.
+//
+// Published sinks, such as the Broadcaster behind live streams, are told about events once
+// they have been marked published, so that the events carry their position in the published
+// stream. They are not retried: their subscribers catch up from the outbox.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"userapi/models"
+	"userapi/repository"
+)
+
+// Sink receives published events
+type Sink interface {
+	Publish(ctx context.Context, e *models.UserEvent) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(ctx context.Context, e *models.UserEvent) error
+
+func (f SinkFunc) Publish(ctx context.Context, e *models.UserEvent) error {
+	return f(ctx, e)
+}
+
+// LogSink writes every event to the service log as JSON
+var LogSink = SinkFunc(func(ctx context.Context, e *models.UserEvent) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	log.Printf("Event %d: %s", e.ID, payload)
+	return nil
+})
+
+// Relay moves events from the outbox to its sinks. Only one relay may run against an
+// outbox, or events of a user could be delivered out of order.
+type Relay struct {
+	outbox         repository.OutboxRepository
+	sinks          []Sink
+	publishedSinks []Sink
+	interval       time.Duration
+	batchSize      int
+}
+
+// NewRelay creates a relay that polls outbox every interval for up to batchSize events
+func NewRelay(outbox repository.OutboxRepository, interval time.Duration, batchSize int, sinks ...Sink) *Relay {
+	return &Relay{
+		outbox:    outbox,
+		sinks:     sinks,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// AddSink adds a sink. It must be called before Run.
+func (r *Relay) AddSink(sink Sink) {
+	r.sinks = append(r.sinks, sink)
+}
+
+// AddPublishedSink adds a sink that receives events after they have been marked published,
+// in the order of their Seq. Its errors are logged. It must be called before Run.
+func (r *Relay) AddPublishedSink(sink Sink) {
+	r.publishedSinks = append(r.publishedSinks, sink)
+}
+
+// Run relays events until ctx is done. Full batches are followed by the next one right
+// away; otherwise the relay waits for the next poll.
+func (r *Relay) Run(ctx context.Context) {
+	log.Printf("Event relay started with %d sinks, polling every %v", len(r.sinks), r.interval)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			log.Printf("Error relaying events: %v", err)
+		}
+		if err == nil && n == r.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Event relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes one batch of pending events and returns how many it fetched
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	pending, err := r.outbox.PendingEvents(ctx, r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch pending events: %w", err)
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	// a user whose event failed gets no later events until it goes through
+	blocked := make(map[int64]bool)
+	published := make([]*models.UserEvent, 0, len(pending))
+	for _, e := range pending {
+		if blocked[e.UserID] {
+			continue
+		}
+		if err := r.publish(ctx, e); err != nil {
+			log.Printf("Error publishing event %d of user %d: %v", e.ID, e.UserID, err)
+			blocked[e.UserID] = true
+			if err := r.outbox.MarkFailed(ctx, e.ID, err); err != nil {
+				log.Printf("Error recording failure of event %d: %v", e.ID, err)
+			}
+			continue
+		}
+		published = append(published, e)
+	}
+
+	if err := r.outbox.MarkPublished(ctx, published...); err != nil {
+		return len(pending), fmt.Errorf("failed to mark events published: %w", err)
+	}
+	for _, e := range published {
+		for _, sink := range r.publishedSinks {
+			if err := sink.Publish(ctx, e); err != nil {
+				log.Printf("Error passing on published event %d: %v", e.ID, err)
+			}
+		}
+	}
+	if len(published) > 0 {
+		log.Printf("Published %d of %d pending events", len(published), len(pending))
+	}
+	if len(published) < len(pending) {
+		return len(pending), fmt.Errorf("%d events could not be published", len(pending)-len(published))
+	}
+	return len(pending), nil
+}
+
+func (r *Relay) publish(ctx context.Context, e *models.UserEvent) error {
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
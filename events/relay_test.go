@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"userapi/models"
+	"userapi/repository"
+)
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	outbox := repo.(repository.OutboxRepository)
+
+	john := &models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+	jane := &models.User{Name: "Jane Doe", Age: 28, PhoneNumber: "+14155552672", Email: "jane@example.com"}
+	repo.Create(ctx, john)
+	repo.Create(ctx, jane)
+	john.Age = 31
+	repo.Update(ctx, john)
+	repo.Delete(ctx, jane.ID)
+
+	var received []*models.UserEvent
+	failJohn := true
+	sink := SinkFunc(func(ctx context.Context, e *models.UserEvent) error {
+		if e.UserID == john.ID && failJohn {
+			return errors.New("receiver unavailable")
+		}
+		received = append(received, e)
+		return nil
+	})
+	relay := NewRelay(outbox, time.Second, 10, sink)
+
+	if n, err := relay.RelayOnce(ctx); err == nil || n != 4 {
+		t.Fatalf("RelayOnce() with failing sink = %d, %v, want 4 and an error", n, err)
+	}
+	if len(received) != 2 || received[0].Type != models.EventUserCreated || received[1].Type != models.EventUserDeleted {
+		t.Fatalf("received %+v, want Jane created and deleted", received)
+	}
+
+	failJohn = false
+	received = nil
+	if _, err := relay.RelayOnce(ctx); err != nil {
+		t.Fatalf("RelayOnce() error = %v", err)
+	}
+	if len(received) != 2 || received[0].Type != models.EventUserCreated || received[1].Type != models.EventUserUpdated {
+		t.Fatalf("received %+v, want John created then updated", received)
+	}
+	if update := received[1]; update.User.Age != 31 || len(update.Changes) != 1 || update.Changes[0].Field != "age" {
+		t.Errorf("update event = %+v", update)
+	}
+
+	if pending, _ := outbox.PendingEvents(ctx, 10); len(pending) != 0 {
+		t.Errorf("PendingEvents() after relaying = %d events, want 0", len(pending))
+	}
+}
+
+func TestRelay_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := repository.NewMemoryUserRepository()
+
+	published := make(chan *models.UserEvent, 10)
+	relay := NewRelay(repo.(repository.OutboxRepository), 10*time.Millisecond, 10, SinkFunc(func(ctx context.Context, e *models.UserEvent) error {
+		published <- e
+		return nil
+	}))
+	go relay.Run(ctx)
+
+	repo.Create(ctx, &models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"})
+	select {
+	case e := <-published:
+		if e.Type != models.EventUserCreated || e.User.Email != "john@example.com" {
+			t.Errorf("published %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event was not published")
+	}
+}
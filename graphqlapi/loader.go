@@ -0,0 +1,98 @@
+package graphqlapi
+
+import (
+	"context"
+	"sync"
+	"userapi/models"
+	"userapi/repository"
+)
+
+type loaderKey struct{}
+
+// userLoader batches user lookups made while resolving one request. Load only queues the
+// ID; the first returned thunk that is called fetches every queued ID with a single
+// GetByIDs call. Results are cached for the rest of the request.
+type userLoader struct {
+	repo repository.UserRepository
+
+	mu      sync.Mutex
+	pending []int64
+	users   map[int64]*models.User
+	errs    map[int64]error
+}
+
+func newUserLoader(repo repository.UserRepository) *userLoader {
+	return &userLoader{
+		repo:  repo,
+		users: make(map[int64]*models.User),
+		errs:  make(map[int64]error),
+	}
+}
+
+// withLoader returns a context carrying a new loader for one request
+func withLoader(ctx context.Context, repo repository.UserRepository) context.Context {
+	return context.WithValue(ctx, loaderKey{}, newUserLoader(repo))
+}
+
+func loaderFrom(ctx context.Context) *userLoader {
+	loader, _ := ctx.Value(loaderKey{}).(*userLoader)
+	return loader
+}
+
+// Load queues id and returns a thunk that yields the user, or nil if it does not exist
+func (l *userLoader) Load(ctx context.Context, id int64) func() (interface{}, error) {
+	l.mu.Lock()
+	_, loaded := l.users[id]
+	_, failed := l.errs[id]
+	if !loaded && !failed {
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.dispatch(ctx)
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if err := l.errs[id]; err != nil {
+			return nil, err
+		}
+		if user := l.users[id]; user != nil {
+			return user, nil
+		}
+		return nil, nil
+	}
+}
+
+func (l *userLoader) dispatch(ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.pending) == 0 {
+		return
+	}
+	ids := l.pending
+	l.pending = nil
+
+	users, err := l.repo.GetByIDs(ctx, ids)
+	for _, id := range ids {
+		if err != nil {
+			l.errs[id] = err
+			continue
+		}
+		l.users[id] = nil
+	}
+	for _, user := range users {
+		l.users[user.ID] = user
+	}
+}
+
+// prime caches users that were loaded some other way, such as by a list query
+func (l *userLoader) prime(users ...*models.User) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, user := range users {
+		l.users[user.ID] = user
+	}
+}
@@ -0,0 +1,437 @@
+package graphqlapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"userapi/models"
+	"userapi/repository"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	cursorPrefix    = "user:"
+)
+
+// Service executes GraphQL requests against a UserRepository
+type Service struct {
+	repo   repository.UserRepository
+	schema graphql.Schema
+}
+
+// NewService builds the schema and creates a new GraphQL service
+func NewService(repo repository.UserRepository) (*Service, error) {
+	s := &Service{repo: repo}
+	schema, err := s.buildSchema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build GraphQL schema: %w", err)
+	}
+	s.schema = schema
+	return s, nil
+}
+
+// Do executes a single GraphQL request. User lookups made while resolving it are batched.
+func (s *Service) Do(ctx context.Context, query string, variables map[string]interface{}, operationName string) *graphql.Result {
+	return graphql.Do(graphql.Params{
+		Schema:         s.schema,
+		RequestString:  query,
+		VariableValues: variables,
+		OperationName:  operationName,
+		Context:        withLoader(ctx, s.repo),
+	})
+}
+
+// IsMutation reports whether the operation a request would run is a mutation
+func IsMutation(query, operationName string) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" || (op.Name != nil && op.Name.Value == operationName) {
+			return op.Operation == ast.OperationTypeMutation
+		}
+	}
+	return false
+}
+
+func (s *Service) buildSchema() (graphql.Schema, error) {
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "User",
+		Description: "A user in the system",
+		Fields:      outputFields(reflect.TypeOf(models.User{})),
+	})
+
+	userInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   "UserInput",
+		Fields: inputFields(reflect.TypeOf(models.User{})),
+	})
+
+	userFilter := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "UserFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":   &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Only users whose name contains this value"},
+			"email":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"phone":  &graphql.InputObjectFieldConfig{Type: graphql.String, Description: "Only users with this phone number, in any format"},
+			"minAge": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"maxAge": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		},
+	})
+
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"node":   &graphql.Field{Type: graphql.NewNonNull(userType)},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"endCursor":   &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"edges":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType)))},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type:        userType,
+				Description: "Get a user by ID",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: s.resolveUser,
+			},
+			"users": &graphql.Field{
+				Type:        graphql.NewNonNull(connectionType),
+				Description: "List users ordered by ID",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: userFilter},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: s.resolveUsers,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(userInput)},
+				},
+				Resolve: s.resolveCreateUser,
+			},
+			"updateUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(userInput)},
+				},
+				Resolve: s.resolveUpdateUser,
+			},
+			"deleteUser": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: s.resolveDeleteUser,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+func (s *Service) resolveUser(p graphql.ResolveParams) (interface{}, error) {
+	id, err := parseID(p.Args["id"])
+	if err != nil {
+		return nil, err
+	}
+	return loaderFrom(p.Context).Load(p.Context, id), nil
+}
+
+func (s *Service) resolveUsers(p graphql.ResolveParams) (interface{}, error) {
+	first, _ := p.Args["first"].(int)
+	if first <= 0 || first > maxPageSize {
+		return nil, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+	}
+
+	filter := repository.UserFilter{Limit: first + 1}
+	if raw, ok := p.Args["filter"].(map[string]interface{}); ok {
+		filter.Name, _ = raw["name"].(string)
+		filter.Email, _ = raw["email"].(string)
+		filter.Phone, _ = raw["phone"].(string)
+		filter.MinAge, _ = raw["minAge"].(int)
+		filter.MaxAge, _ = raw["maxAge"].(int)
+	}
+	if after, ok := p.Args["after"].(string); ok && after != "" {
+		id, err := decodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		filter.AfterID = id
+	}
+
+	users, err := s.repo.List(p.Context, filter)
+	if err != nil {
+		log.Printf("Error retrieving users list: %v", err)
+		return nil, errors.New("Error retrieving users")
+	}
+
+	hasNextPage := len(users) > first
+	if hasNextPage {
+		users = users[:first]
+	}
+	loaderFrom(p.Context).prime(users...)
+
+	edges := make([]map[string]interface{}, len(users))
+	for i, user := range users {
+		edges[i] = map[string]interface{}{"cursor": encodeCursor(user.ID), "node": user}
+	}
+	pageInfo := map[string]interface{}{"hasNextPage": hasNextPage}
+	if len(users) > 0 {
+		pageInfo["endCursor"] = encodeCursor(users[len(users)-1].ID)
+	}
+
+	log.Printf("Successfully retrieved %d users", len(users))
+	return map[string]interface{}{"edges": edges, "pageInfo": pageInfo}, nil
+}
+
+func (s *Service) resolveCreateUser(p graphql.ResolveParams) (interface{}, error) {
+	user, err := userFromInput(p.Args["input"])
+	if err != nil {
+		return nil, err
+	}
+	if err := user.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(p.Context, user); err != nil {
+		log.Printf("Error creating user: %v", err)
+		if errors.Is(err, repository.ErrEmailTaken) {
+			return nil, errors.New("Email already in use")
+		}
+		return nil, errors.New("Error creating user")
+	}
+
+	log.Printf("Successfully created user with ID: %d", user.ID)
+	return user, nil
+}
+
+func (s *Service) resolveUpdateUser(p graphql.ResolveParams) (interface{}, error) {
+	id, err := parseID(p.Args["id"])
+	if err != nil {
+		return nil, err
+	}
+	user, err := userFromInput(p.Args["input"])
+	if err != nil {
+		return nil, err
+	}
+	user.ID = id
+	if err := user.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Update(p.Context, user); err != nil {
+		log.Printf("Error updating user with ID %d: %v", id, err)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, errors.New("User not found")
+		}
+		if errors.Is(err, repository.ErrEmailTaken) {
+			return nil, errors.New("Email already in use")
+		}
+		return nil, errors.New("Error updating user")
+	}
+
+	log.Printf("Successfully updated user with ID: %d", id)
+	return user, nil
+}
+
+func (s *Service) resolveDeleteUser(p graphql.ResolveParams) (interface{}, error) {
+	id, err := parseID(p.Args["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Delete(p.Context, id); err != nil {
+		log.Printf("Error deleting user with ID %d: %v", id, err)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, errors.New("User not found")
+		}
+		return nil, errors.New("Error deleting user")
+	}
+
+	log.Printf("Successfully deleted user with ID: %d", id)
+	return true, nil
+}
+
+// outputFields generates the fields of a GraphQL object from the exported fields of a
+// struct. Fields are named after their JSON names in camelCase and an int64 "id" becomes
+// an ID.
+func outputFields(t reflect.Type) graphql.Fields {
+	fields := graphql.Fields{}
+	for _, f := range structFields(t) {
+		index := f.index
+		fields[f.name] = &graphql.Field{
+			Type: graphql.NewNonNull(f.gqlType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				v := reflect.Indirect(reflect.ValueOf(p.Source))
+				if v.Kind() != reflect.Struct {
+					return nil, nil
+				}
+				return v.Field(index).Interface(), nil
+			},
+		}
+	}
+	return fields
+}
+
+// inputFields generates the fields of a GraphQL input object like outputFields, leaving
+// out the ID
+func inputFields(t reflect.Type) graphql.InputObjectConfigFieldMap {
+	fields := graphql.InputObjectConfigFieldMap{}
+	for _, f := range structFields(t) {
+		if f.gqlType == graphql.ID {
+			continue
+		}
+		if f.optional {
+			fields[f.name] = &graphql.InputObjectFieldConfig{Type: f.gqlType}
+			continue
+		}
+		fields[f.name] = &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(f.gqlType)}
+	}
+	return fields
+}
+
+type structField struct {
+	name    string
+	index   int
+	gqlType graphql.Output
+	// optional is set for fields tagged omitempty, which are nullable in input objects
+	optional bool
+}
+
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		jsonName := tag[0]
+		if !f.IsExported() || jsonName == "-" {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = f.Name
+		}
+
+		var gqlType graphql.Output
+		switch f.Type.Kind() {
+		case reflect.String:
+			gqlType = graphql.String
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			gqlType = graphql.Int
+			if jsonName == "id" {
+				gqlType = graphql.ID
+			}
+		case reflect.Bool:
+			gqlType = graphql.Boolean
+		case reflect.Float32, reflect.Float64:
+			gqlType = graphql.Float
+		default:
+			continue
+		}
+		optional := false
+		for _, opt := range tag[1:] {
+			optional = optional || opt == "omitempty"
+		}
+		fields = append(fields, structField{name: camelCase(jsonName), index: i, gqlType: gqlType, optional: optional})
+	}
+	return fields
+}
+
+// userFromInput fills a user from a UserInput argument using the same field mapping as
+// inputFields
+func userFromInput(arg interface{}) (*models.User, error) {
+	input, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("input is required")
+	}
+
+	user := &models.User{}
+	v := reflect.ValueOf(user).Elem()
+	for _, f := range structFields(v.Type()) {
+		value, ok := input[f.name]
+		if !ok || f.gqlType == graphql.ID {
+			continue
+		}
+		field := v.Field(f.index)
+		rv := reflect.ValueOf(value)
+		if !rv.Type().ConvertibleTo(field.Type()) {
+			return nil, fmt.Errorf("invalid value for %s", f.name)
+		}
+		field.Set(rv.Convert(field.Type()))
+	}
+	return user, nil
+}
+
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func parseID(arg interface{}) (int64, error) {
+	s, _ := arg.(string)
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid user ID: %q", s)
+	}
+	return id, nil
+}
+
+func encodeCursor(id int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	b, err := base64.StdEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(b), cursorPrefix) {
+		if id, err := strconv.ParseInt(strings.TrimPrefix(string(b), cursorPrefix), 10, 64); err == nil {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid cursor: %q", cursor)
+}
@@ -0,0 +1,159 @@
+package graphqlapi
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"userapi/models"
+	"userapi/repository"
+)
+
+type fakeUserRepository struct {
+	repository.UserRepository
+	users         []*models.User
+	getByIDsCalls [][]int64
+}
+
+func (f *fakeUserRepository) GetByIDs(ctx context.Context, ids []int64) ([]*models.User, error) {
+	f.getByIDsCalls = append(f.getByIDsCalls, ids)
+	var users []*models.User
+	for _, user := range f.users {
+		for _, id := range ids {
+			if user.ID == id {
+				users = append(users, user)
+			}
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeUserRepository) List(ctx context.Context, filter repository.UserFilter) ([]*models.User, error) {
+	var users []*models.User
+	for _, user := range f.users {
+		if user.ID > filter.AfterID && (filter.MinAge == 0 || user.Age >= filter.MinAge) {
+			users = append(users, user)
+		}
+		if filter.Limit > 0 && len(users) == filter.Limit {
+			break
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeUserRepository) Create(ctx context.Context, user *models.User) error {
+	user.ID = int64(len(f.users) + 1)
+	f.users = append(f.users, user)
+	return nil
+}
+
+func newTestService(t *testing.T) (*Service, *fakeUserRepository) {
+	t.Helper()
+	repo := &fakeUserRepository{}
+	for i, name := range []string{"Ann", "Bob", "Cid"} {
+		repo.users = append(repo.users, &models.User{ID: int64(i + 1), Name: name, Age: 20 + i, PhoneNumber: "+14155552671", Email: name + "@example.com"})
+	}
+	s, err := NewService(repo)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	return s, repo
+}
+
+func decode(t *testing.T, data interface{}, v interface{}) {
+	t.Helper()
+	b, _ := json.Marshal(data)
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("Could not decode result: %v", err)
+	}
+}
+
+func TestService_UserLookupsAreBatched(t *testing.T) {
+	s, repo := newTestService(t)
+
+	result := s.Do(context.Background(), `{
+		a: user(id: "1") { id name phoneNumber }
+		b: user(id: "3") { name }
+		c: user(id: "99") { name }
+	}`, nil, "")
+	if result.HasErrors() {
+		t.Fatalf("Do() errors = %v", result.Errors)
+	}
+
+	var data struct {
+		A *struct{ ID, Name, PhoneNumber string }
+		B *struct{ Name string }
+		C *struct{ Name string }
+	}
+	decode(t, result.Data, &data)
+	if data.A == nil || data.A.ID != "1" || data.A.Name != "Ann" || data.A.PhoneNumber != "+14155552671" {
+		t.Errorf("user a = %+v", data.A)
+	}
+	if data.B == nil || data.B.Name != "Cid" {
+		t.Errorf("user b = %+v", data.B)
+	}
+	if data.C != nil {
+		t.Errorf("user c = %+v, want null", data.C)
+	}
+	if len(repo.getByIDsCalls) != 1 || len(repo.getByIDsCalls[0]) != 3 {
+		t.Errorf("GetByIDs calls = %v, want one call with 3 IDs", repo.getByIDsCalls)
+	}
+}
+
+func TestService_UsersPagination(t *testing.T) {
+	s, _ := newTestService(t)
+
+	var page struct {
+		Users struct {
+			Edges []struct {
+				Cursor string
+				Node   struct{ Name string }
+			}
+			PageInfo struct {
+				HasNextPage bool
+				EndCursor   string
+			}
+		}
+	}
+	query := `query($after: String) { users(first: 2, after: $after) { edges { cursor node { name } } pageInfo { hasNextPage endCursor } } }`
+
+	result := s.Do(context.Background(), query, nil, "")
+	if result.HasErrors() {
+		t.Fatalf("Do() errors = %v", result.Errors)
+	}
+	decode(t, result.Data, &page)
+	if len(page.Users.Edges) != 2 || !page.Users.PageInfo.HasNextPage {
+		t.Fatalf("first page = %+v", page.Users)
+	}
+
+	result = s.Do(context.Background(), query, map[string]interface{}{"after": page.Users.PageInfo.EndCursor}, "")
+	decode(t, result.Data, &page)
+	if len(page.Users.Edges) != 1 || page.Users.Edges[0].Node.Name != "Cid" || page.Users.PageInfo.HasNextPage {
+		t.Errorf("second page = %+v", page.Users)
+	}
+}
+
+func TestService_CreateUser(t *testing.T) {
+	s, repo := newTestService(t)
+
+	result := s.Do(context.Background(), `mutation { createUser(input: {name: "Dee", age: 40, phoneNumber: "+14155552671", email: "dee@example.com"}) { id email } }`, nil, "")
+	if result.HasErrors() {
+		t.Fatalf("Do() errors = %v", result.Errors)
+	}
+	if len(repo.users) != 4 {
+		t.Errorf("repository has %d users, want 4", len(repo.users))
+	}
+
+	result = s.Do(context.Background(), `mutation { createUser(input: {name: "Eve", age: 40, phoneNumber: "+14155552671", email: "invalid"}) { id } }`, nil, "")
+	if !result.HasErrors() {
+		t.Errorf("Do() with invalid email returned no errors")
+	}
+}
+
+func TestIsMutation(t *testing.T) {
+	if !IsMutation(`mutation { deleteUser(id: "1") }`, "") {
+		t.Errorf("IsMutation() = false for a mutation")
+	}
+	if IsMutation(`query Q { user(id: "1") { id } } mutation M { deleteUser(id: "1") }`, "Q") {
+		t.Errorf("IsMutation() = true for a named query")
+	}
+}
@@ -0,0 +1,115 @@
+package grpcserver
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"userapi/audit"
+	"userapi/reqctx"
+	"userapi/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// auditActions names the audited methods of the user service like the HTTP API names its
+// routes. Health checks and reflection are not audited.
+var auditActions = map[string]string{
+	"CreateUser":  "user.create",
+	"GetUser":     "user.get",
+	"UpdateUser":  "user.update",
+	"DeleteUser":  "user.delete",
+	"ListUsers":   "user.list",
+	"StreamUsers": "user.stream",
+}
+
+// auditUnaryInterceptor records an audit entry for every call of an audited method once it
+// has been handled. Failing to record is logged, and counted by the logger.
+func auditUnaryInterceptor(auditLog *audit.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if action, ok := auditAction(info.FullMethod); ok {
+			recordCall(ctx, auditLog, action, callTarget(info.FullMethod, req), err)
+		}
+		return resp, err
+	}
+}
+
+// auditStreamInterceptor is auditUnaryInterceptor for streaming methods
+func auditStreamInterceptor(auditLog *audit.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		if action, ok := auditAction(info.FullMethod); ok {
+			recordCall(ss.Context(), auditLog, action, info.FullMethod, err)
+		}
+		return err
+	}
+}
+
+func auditAction(fullMethod string) (string, bool) {
+	service := "/" + userpb.UserService_ServiceDesc.ServiceName + "/"
+	if len(fullMethod) <= len(service) || fullMethod[:len(service)] != service {
+		return "", false
+	}
+	action, ok := auditActions[fullMethod[len(service):]]
+	return action, ok
+}
+
+// callTarget is the method, followed by the ID of the user the request names, if any
+func callTarget(fullMethod string, req interface{}) string {
+	var id int64
+	switch r := req.(type) {
+	case interface{ GetId() int64 }:
+		id = r.GetId()
+	case interface{ GetUser() *userpb.User }:
+		id = r.GetUser().GetId()
+	}
+	if id == 0 {
+		return fullMethod
+	}
+	return fullMethod + "?id=" + strconv.FormatInt(id, 10)
+}
+
+func recordCall(ctx context.Context, auditLog *audit.Logger, action, target string, err error) {
+	code := httpStatus(status.Code(err))
+	e := &audit.Entry{
+		Actor:     reqctx.Actor(ctx),
+		Action:    action,
+		Target:    target,
+		Outcome:   audit.Outcome(code),
+		Status:    code,
+		RequestID: reqctx.RequestID(ctx),
+	}
+	if e.Actor == "" {
+		e.Actor = anonymousActor
+	}
+	auditLog.Enqueue(e)
+}
+
+// httpStatus maps a gRPC code to the HTTP status audit entries hold
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
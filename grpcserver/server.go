@@ -0,0 +1,293 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"userapi/audit"
+	"userapi/models"
+	"userapi/repository"
+	"userapi/reqctx"
+	"userapi/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// anonymousActor is recorded for calls that do not authenticate
+const anonymousActor = reqctx.AnonymousActor
+
+// UserServer implements userpb.UserServiceServer on top of a UserRepository
+type UserServer struct {
+	userpb.UnimplementedUserServiceServer
+	repo repository.UserRepository
+}
+
+// NewUserServer creates a new gRPC user service
+func NewUserServer(repo repository.UserRepository) *UserServer {
+	return &UserServer{repo: repo}
+}
+
+// NewServer creates a gRPC server with the user service, the health service and
+// server reflection registered. Calls are made on behalf of the actor authenticate returns,
+// and calls of the user service are recorded in auditLog.
+func NewServer(repo repository.UserRepository, authenticate Authenticator, auditLog *audit.Logger, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(loggingUnaryInterceptor, requestContextUnaryInterceptor(authenticate), auditUnaryInterceptor(auditLog)),
+		grpc.ChainStreamInterceptor(loggingStreamInterceptor, requestContextStreamInterceptor(authenticate), auditStreamInterceptor(auditLog)),
+	)
+	server := grpc.NewServer(opts...)
+
+	userpb.RegisterUserServiceServer(server, NewUserServer(repo))
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(userpb.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+	return server
+}
+
+func (s *UserServer) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.User, error) {
+	if req.GetUser() == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+
+	user := fromProto(req.GetUser())
+	user.ID = 0
+	if err := user.Validate(); err != nil {
+		log.Printf("Validation error for user: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		log.Printf("Error creating user: %v", err)
+		return nil, toStatus(err, "Error creating user")
+	}
+
+	log.Printf("Successfully created user with ID: %d", user.ID)
+	return toProto(user), nil
+}
+
+func (s *UserServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	user, err := s.repo.GetByID(ctx, req.GetId())
+	if err != nil {
+		log.Printf("Error retrieving user with ID %d: %v", req.GetId(), err)
+		return nil, toStatus(err, "Error retrieving user")
+	}
+	if user == nil {
+		log.Printf("User not found with ID: %d", req.GetId())
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+
+	log.Printf("Successfully retrieved user with ID: %d", user.ID)
+	return toProto(user), nil
+}
+
+func (s *UserServer) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	if req.GetUser() == nil {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+
+	user := fromProto(req.GetUser())
+	if user.ID <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "user id is required")
+	}
+	if err := user.Validate(); err != nil {
+		log.Printf("Validation error for user update: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.repo.Update(ctx, user); err != nil {
+		log.Printf("Error updating user with ID %d: %v", user.ID, err)
+		return nil, toStatus(err, "Error updating user")
+	}
+
+	log.Printf("Successfully updated user with ID: %d", user.ID)
+	return toProto(user), nil
+}
+
+func (s *UserServer) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	if err := s.repo.Delete(ctx, req.GetId()); err != nil {
+		log.Printf("Error deleting user with ID %d: %v", req.GetId(), err)
+		return nil, toStatus(err, "Error deleting user")
+	}
+
+	log.Printf("Successfully deleted user with ID: %d", req.GetId())
+	return &userpb.DeleteUserResponse{}, nil
+}
+
+func (s *UserServer) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	users, err := s.repo.List(ctx, filterFromProto(req.GetFilter()))
+	if err != nil {
+		log.Printf("Error retrieving users list: %v", err)
+		return nil, toStatus(err, "Error retrieving users")
+	}
+
+	resp := &userpb.ListUsersResponse{Users: make([]*userpb.User, len(users))}
+	for i, user := range users {
+		resp.Users[i] = toProto(user)
+	}
+
+	log.Printf("Successfully retrieved %d users", len(users))
+	return resp, nil
+}
+
+func (s *UserServer) StreamUsers(req *userpb.ListUsersRequest, stream userpb.UserService_StreamUsersServer) error {
+	var count int64
+	err := s.repo.Stream(stream.Context(), filterFromProto(req.GetFilter()), func(user *models.User) error {
+		count++
+		return stream.Send(toProto(user))
+	})
+	if err != nil {
+		log.Printf("Error streaming users after %d users: %v", count, err)
+		return toStatus(err, "Error streaming users")
+	}
+
+	log.Printf("Successfully streamed %d users", count)
+	return nil
+}
+
+// toStatus maps repository errors to gRPC status errors. Errors that already carry a
+// status, such as failed sends on a stream, are passed through.
+func toStatus(err error, message string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		return status.Error(codes.NotFound, "User not found")
+	case errors.Is(err, repository.ErrEmailTaken):
+		return status.Error(codes.AlreadyExists, "Email already in use")
+	case errors.Is(err, repository.ErrCircuitOpen):
+		return status.Error(codes.Unavailable, "Service temporarily unavailable")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, message)
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, message)
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
+
+func toProto(user *models.User) *userpb.User {
+	return &userpb.User{
+		Id:          user.ID,
+		Name:        user.Name,
+		Age:         int32(user.Age),
+		PhoneNumber: user.PhoneNumber,
+		Email:       user.Email,
+	}
+}
+
+func fromProto(user *userpb.User) *models.User {
+	return &models.User{
+		ID:          user.GetId(),
+		Name:        user.GetName(),
+		Age:         int(user.GetAge()),
+		PhoneNumber: user.GetPhoneNumber(),
+		Email:       user.GetEmail(),
+	}
+}
+
+func filterFromProto(filter *userpb.UserFilter) repository.UserFilter {
+	return repository.UserFilter{
+		Name:   filter.GetName(),
+		Email:  filter.GetEmail(),
+		MinAge: int(filter.GetMinAge()),
+		MaxAge: int(filter.GetMaxAge()),
+	}
+}
+
+func loggingUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	log.Printf("Started %s", info.FullMethod)
+
+	resp, err := handler(ctx, req)
+
+	log.Printf("Completed %s with %s in %v", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
+}
+
+// Authenticator checks the credentials in the metadata of a call and returns who is making it
+type Authenticator func(ctx context.Context) (actor string, ok bool)
+
+// TokenAuthenticator accepts calls whose authorization metadata carries one of tokens, which
+// map to their actors, as a bearer token, like the HTTP API
+func TokenAuthenticator(tokens map[string]string) Authenticator {
+	return func(ctx context.Context) (string, bool) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+			return "", false
+		}
+		token := strings.TrimPrefix(values[0], "Bearer ")
+		for known, actor := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				return actor, true
+			}
+		}
+		return "", false
+	}
+}
+
+// requestContextUnaryInterceptor stores the actor a call authenticates as, or anonymous, and
+// its x-request-id metadata in the context, like the HTTP API, so that changes are attributed
+// in the user history. x-actor metadata is not verified and only logged.
+func requestContextUnaryInterceptor(authenticate Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(callContext(ctx, authenticate, info.FullMethod), req)
+	}
+}
+
+// requestContextStreamInterceptor is requestContextUnaryInterceptor for streaming methods
+func requestContextStreamInterceptor(authenticate Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: callContext(ss.Context(), authenticate, info.FullMethod)})
+	}
+}
+
+func callContext(ctx context.Context, authenticate Authenticator, fullMethod string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	actor, ok := authenticate(ctx)
+	if !ok {
+		actor = anonymousActor
+		if values := md.Get("x-actor"); len(values) > 0 && values[0] != "" {
+			log.Printf("Call to %s claims to be made by %q without authenticating, recording it as %s", fullMethod, values[0], actor)
+		}
+	}
+	ctx = reqctx.WithActor(ctx, actor)
+	if values := md.Get("x-request-id"); len(values) > 0 && values[0] != "" {
+		ctx = reqctx.WithRequestID(ctx, values[0])
+	}
+	return ctx
+}
+
+// contextServerStream replaces the context of a stream
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func loggingStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	log.Printf("Started %s", info.FullMethod)
+
+	err := handler(srv, ss)
+
+	log.Printf("Completed %s with %s in %v", info.FullMethod, status.Code(err), time.Since(start))
+	return err
+}
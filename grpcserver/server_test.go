@@ -0,0 +1,232 @@
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"testing"
+	"userapi/audit"
+	"userapi/models"
+	"userapi/repository"
+	"userapi/reqctx"
+	"userapi/userpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeUserRepository struct {
+	repository.UserRepository
+	users  map[int64]*models.User
+	nextID int64
+}
+
+func (f *fakeUserRepository) Create(ctx context.Context, user *models.User) error {
+	f.nextID++
+	user.ID = f.nextID
+	f.users[user.ID] = user
+	return nil
+}
+
+func (f *fakeUserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	return f.users[id], nil
+}
+
+func (f *fakeUserRepository) Update(ctx context.Context, user *models.User) error {
+	if _, ok := f.users[user.ID]; !ok {
+		return repository.ErrUserNotFound
+	}
+	f.users[user.ID] = user
+	return nil
+}
+
+func (f *fakeUserRepository) Delete(ctx context.Context, id int64) error {
+	if _, ok := f.users[id]; !ok {
+		return repository.ErrUserNotFound
+	}
+	delete(f.users, id)
+	return nil
+}
+
+func (f *fakeUserRepository) List(ctx context.Context, filter repository.UserFilter) ([]*models.User, error) {
+	var users []*models.User
+	f.Stream(ctx, filter, func(u *models.User) error {
+		users = append(users, u)
+		return nil
+	})
+	return users, nil
+}
+
+func (f *fakeUserRepository) Stream(ctx context.Context, filter repository.UserFilter, fn func(*models.User) error) error {
+	var ids []int64
+	for id := range f.users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if filter.MinAge > 0 && f.users[id].Age < filter.MinAge {
+			continue
+		}
+		if err := fn(f.users[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTestClient serves a fake repository, accepting the token secret for alice, and returns
+// a client of it and its audit log
+func newTestClient(t *testing.T) (userpb.UserServiceClient, *grpc.ClientConn, *audit.Logger) {
+	t.Helper()
+	sink, err := audit.NewFileSink(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	auditLog, err := audit.NewLogger(context.Background(), sink, audit.Options{})
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	server := NewServer(&fakeUserRepository{users: make(map[int64]*models.User)}, TokenAuthenticator(map[string]string{"secret": "alice"}), auditLog)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Could not dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return userpb.NewUserServiceClient(conn), conn, auditLog
+}
+
+func TestUserServer(t *testing.T) {
+	client, conn, auditLog := newTestClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+
+	created, err := client.CreateUser(ctx, &userpb.CreateUserRequest{User: &userpb.User{
+		Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com",
+	}})
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if created.GetId() == 0 {
+		t.Errorf("CreateUser() did not assign an ID")
+	}
+	client.CreateUser(ctx, &userpb.CreateUserRequest{User: &userpb.User{
+		Name: "Jane Doe", Age: 20, PhoneNumber: "+14155552671", Email: "jane@example.com",
+	}})
+
+	_, err = client.CreateUser(ctx, &userpb.CreateUserRequest{User: &userpb.User{Name: "Bad", Age: 30, PhoneNumber: "+1", Email: "bad"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateUser() with invalid email code = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+
+	got, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: created.GetId()})
+	if err != nil || got.GetEmail() != "john@example.com" {
+		t.Errorf("GetUser() = %v, %v", got, err)
+	}
+	if _, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: 999}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetUser() for missing user code = %v, want %v", status.Code(err), codes.NotFound)
+	}
+
+	update := &userpb.User{Id: 999, Name: "X", Age: 1, PhoneNumber: "+14155552671", Email: "x@example.com"}
+	if _, err := client.UpdateUser(ctx, &userpb.UpdateUserRequest{User: update}); status.Code(err) != codes.NotFound {
+		t.Errorf("UpdateUser() for missing user code = %v, want %v", status.Code(err), codes.NotFound)
+	}
+
+	list, err := client.ListUsers(ctx, &userpb.ListUsersRequest{Filter: &userpb.UserFilter{MinAge: 25}})
+	if err != nil || len(list.GetUsers()) != 1 {
+		t.Errorf("ListUsers() = %v, %v, want 1 user", list, err)
+	}
+
+	stream, err := client.StreamUsers(ctx, &userpb.ListUsersRequest{})
+	if err != nil {
+		t.Fatalf("StreamUsers() error = %v", err)
+	}
+	var streamed []int64
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("StreamUsers() Recv error = %v", err)
+		}
+		streamed = append(streamed, user.GetId())
+	}
+	if len(streamed) != 2 || streamed[0] != 1 || streamed[1] != 2 {
+		t.Errorf("StreamUsers() returned IDs %v, want [1 2]", streamed)
+	}
+
+	if _, err := client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: created.GetId()}); err != nil {
+		t.Errorf("DeleteUser() error = %v", err)
+	}
+	if _, err := client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: created.GetId()}); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteUser() twice code = %v, want %v", status.Code(err), codes.NotFound)
+	}
+
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: "userapi.v1.UserService"})
+	if err != nil || health.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Health Check() = %v, %v", health, err)
+	}
+
+	// every call but the health check is audited, on behalf of the token's actor
+	if err := auditLog.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	var entries []*audit.Entry
+	auditLog.Sink().Scan(context.Background(), audit.Filter{}, func(e *audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if len(entries) != 10 {
+		t.Fatalf("recorded %d audit entries, want 10", len(entries))
+	}
+	missing := entries[4]
+	if missing.Actor != "alice" || missing.Action != "user.get" || missing.Target != "/userapi.v1.UserService/GetUser?id=999" || missing.Status != 404 || missing.Outcome != audit.OutcomeFailure {
+		t.Errorf("entry of GetUser() for missing user = %+v", missing)
+	}
+	if streamed := entries[7]; streamed.Actor != "alice" || streamed.Action != "user.stream" || streamed.Outcome != audit.OutcomeSuccess {
+		t.Errorf("entry of StreamUsers() = %+v", streamed)
+	}
+}
+
+func TestRequestContextUnaryInterceptor(t *testing.T) {
+	interceptor := requestContextUnaryInterceptor(TokenAuthenticator(map[string]string{"secret": "alice"}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/userapi.v1.UserService/GetUser"}
+
+	tests := []struct {
+		name      string
+		md        metadata.MD
+		wantActor string
+	}{
+		{name: "bearer token", md: metadata.Pairs("authorization", "Bearer secret"), wantActor: "alice"},
+		{name: "unverified x-actor", md: metadata.Pairs("x-actor", "alice"), wantActor: anonymousActor},
+		{name: "wrong token", md: metadata.Pairs("authorization", "Bearer guess", "x-actor", "alice"), wantActor: anonymousActor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			var actor string
+			interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				actor = reqctx.Actor(ctx)
+				return nil, nil
+			})
+			if actor != tt.wantActor {
+				t.Errorf("actor = %q, want %q", actor, tt.wantActor)
+			}
+		})
+	}
+}
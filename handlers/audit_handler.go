@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"userapi/audit"
+)
+
+const (
+	// defaultAuditLimit and maxAuditLimit bound the page size of the audit log
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type AuditHandler struct {
+	sink audit.Sink
+}
+
+func NewAuditHandler(sink audit.Sink) *AuditHandler {
+	return &AuditHandler{sink: sink}
+}
+
+// AuditResponse is one page of audit entries, oldest first
+type AuditResponse struct {
+	Entries []*audit.Entry `json:"entries" xml:"entries>entry"`
+	// NextAfterSeq is the after_seq of the next page, if there is one
+	NextAfterSeq int64 `json:"next_after_seq,omitempty" xml:"next_after_seq,omitempty"`
+}
+
+// @Summary Query the audit log
+// @Description List audited accesses to user data, oldest first. Entries are hash-chained; run the verify command to check the chain.
+// @Tags audit
+// @Produce json
+// @Param actor query string false "Only entries of this actor"
+// @Param action query string false "Only entries of this action, such as user.get"
+// @Param target query string false "Only entries for this request URI, such as /users/1"
+// @Param since query string false "RFC 3339 time of the oldest entry"
+// @Param until query string false "RFC 3339 time of the newest entry"
+// @Param after_seq query int false "Only entries after this sequence number"
+// @Param limit query int false "Page size (default 100, at most 1000)"
+// @Success 200 {object} AuditResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /audit [get]
+func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		Limit:  defaultAuditLimit,
+	}
+
+	for param, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 timestamp", param))
+				return
+			}
+			*target = t
+		}
+	}
+	if value := query.Get("after_seq"); value != "" {
+		seq, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seq < 0 {
+			respondWithError(w, r, http.StatusBadRequest, "after_seq must be a non-negative integer")
+			return
+		}
+		filter.AfterSeq = seq
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			respondWithError(w, r, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		filter.Limit = min(limit, maxAuditLimit)
+	}
+
+	// one extra entry tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	entries := make([]*audit.Entry, 0, limit)
+	err := h.sink.Scan(r.Context(), filter, func(e *audit.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error querying audit log: %v", err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error querying audit log")
+		return
+	}
+
+	resp := AuditResponse{Entries: entries}
+	if len(entries) > limit {
+		resp.Entries = entries[:limit]
+		resp.NextAfterSeq = resp.Entries[limit-1].Seq
+	}
+	log.Printf("Successfully retrieved %d audit entries", len(resp.Entries))
+	respond(w, r, http.StatusOK, resp)
+}
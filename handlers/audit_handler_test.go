@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"userapi/audit"
+)
+
+func TestAuditHandler_List(t *testing.T) {
+	ctx := context.Background()
+	sink, err := audit.NewFileSink(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	defer sink.Close()
+	logger, _ := audit.NewLogger(ctx, sink, audit.Options{})
+	for _, actor := range []string{"alice", "bob", "alice", "alice"} {
+		logger.Record(ctx, &audit.Entry{Actor: actor, Action: "user.get", Target: "/users/1", Outcome: audit.OutcomeSuccess, Status: 200})
+	}
+	handler := NewAuditHandler(sink)
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantSeqs    []int64
+		wantNextSeq int64
+	}{
+		{"all", "", http.StatusOK, []int64{1, 2, 3, 4}, 0},
+		{"by actor, paged", "?actor=alice&limit=2", http.StatusOK, []int64{1, 3}, 3},
+		{"next page", "?actor=alice&limit=2&after_seq=3", http.StatusOK, []int64{4}, 0},
+		{"invalid since", "?since=yesterday", http.StatusBadRequest, nil, 0},
+		{"invalid limit", "?limit=0", http.StatusBadRequest, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.List(w, httptest.NewRequest("GET", "/audit"+tt.query, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("List() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp AuditResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			var seqs []int64
+			for _, e := range resp.Entries {
+				seqs = append(seqs, e.Seq)
+			}
+			if len(seqs) != len(tt.wantSeqs) || resp.NextAfterSeq != tt.wantNextSeq {
+				t.Fatalf("List() seqs = %v, next = %d, want %v, %d", seqs, resp.NextAfterSeq, tt.wantSeqs, tt.wantNextSeq)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.wantSeqs[i] {
+					t.Errorf("List() seqs = %v, want %v", seqs, tt.wantSeqs)
+				}
+			}
+		})
+	}
+}
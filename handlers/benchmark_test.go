@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"userapi/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// newBenchmarkRouter serves the /users routes the way the server does, from an in-memory
+// repository behind a cache, with n users already created. Log output is discarded for the
+// rest of the benchmark, as it would otherwise dominate the results.
+func newBenchmarkRouter(b *testing.B, n int) http.Handler {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(out) })
+
+	repo := repository.NewCachedUserRepository(repository.NewMemoryUserRepository(), repository.NewLRUCache(10000, time.Minute))
+	userHandler := NewUserHandler(repo)
+	router := mux.NewRouter()
+	router.HandleFunc("/users", userHandler.Create).Methods("POST")
+	router.HandleFunc("/users/search", userHandler.Search).Methods("GET")
+	router.HandleFunc("/users/{id}", userHandler.GetByID).Methods("GET")
+	router.HandleFunc("/users/{id}", userHandler.Update).Methods("PUT")
+	router.HandleFunc("/users", userHandler.List).Methods("GET")
+	router.Use(RequestContextMiddleware(TokenAuthenticator(nil)))
+
+	for i := 1; i <= n; i++ {
+		if w := serve(router, "POST", "/users", userJSON(i, "User")); w.Code != http.StatusCreated {
+			b.Fatalf("creating user %d returned %d: %s", i, w.Code, w.Body)
+		}
+	}
+	return router
+}
+
+func userJSON(i int, name string) string {
+	return fmt.Sprintf(`{"name":"%s %d","age":30,"phone_number":"+14155552671","email":"user%d@example.com"}`, name, i%100, i)
+}
+
+func serve(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func BenchmarkUserHandler_Create(b *testing.B) {
+	router := newBenchmarkRouter(b, 0)
+	b.ResetTimer()
+	for i := 1; i <= b.N; i++ {
+		if w := serve(router, "POST", "/users", userJSON(i, "User")); w.Code != http.StatusCreated {
+			b.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkUserHandler_GetByID(b *testing.B) {
+	router := newBenchmarkRouter(b, 1000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if w := serve(router, "GET", fmt.Sprintf("/users/%d", i%1000+1), ""); w.Code != http.StatusOK {
+				b.Errorf("status = %d: %s", w.Code, w.Body)
+				return
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkUserHandler_Update(b *testing.B) {
+	router := newBenchmarkRouter(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := i%1000 + 1
+		if w := serve(router, "PUT", fmt.Sprintf("/users/%d", id), userJSON(id, "Renamed")); w.Code != http.StatusOK {
+			b.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkUserHandler_List(b *testing.B) {
+	router := newBenchmarkRouter(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := serve(router, "GET", "/users?name=User+42", ""); w.Code != http.StatusOK {
+			b.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkUserHandler_Search(b *testing.B) {
+	router := newBenchmarkRouter(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := serve(router, "GET", "/users/search?q=user4&limit=20", ""); w.Code != http.StatusOK {
+			b.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"userapi/events"
+	"userapi/models"
+	"userapi/repository"
+)
+
+const (
+	// eventReplayBatch is the number of events read from the log at a time when resuming
+	eventReplayBatch = 500
+	// eventRetryMillis tells clients how long to wait before reconnecting
+	eventRetryMillis = 3000
+)
+
+type EventStreamHandler struct {
+	broadcaster *events.Broadcaster
+	eventLog    repository.OutboxRepository
+	heartbeat   time.Duration
+}
+
+// NewEventStreamHandler creates a handler that streams the events of broadcaster, resuming
+// from the published events of eventLog, with a comment sent every heartbeat to keep idle
+// connections open
+func NewEventStreamHandler(broadcaster *events.Broadcaster, eventLog repository.OutboxRepository, heartbeat time.Duration) *EventStreamHandler {
+	return &EventStreamHandler{broadcaster: broadcaster, eventLog: eventLog, heartbeat: heartbeat}
+}
+
+// eventFilter selects the events a stream is interested in
+type eventFilter struct {
+	types  map[string]bool
+	userID int64
+}
+
+func (f eventFilter) matches(e *models.UserEvent) bool {
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	return f.userID == 0 || e.UserID == f.userID
+}
+
+// @Summary Stream user events
+// @Description Stream UserCreated, UserUpdated and UserDeleted events as server-sent events. Each event carries its position in the published stream (seq) as its SSE ID, so a client that reconnects with Last-Event-ID (or last_event_id) first receives the events it missed. Without it the stream starts with the next event. A comment is sent periodically to keep the connection open.
+// @Tags users
+// @Produce text/event-stream
+// @Param type query string false "Only events of these types, comma separated"
+// @Param user_id query int false "Only events of this user"
+// @Param last_event_id query int false "Resume after this position, like the Last-Event-ID header"
+// @Param Last-Event-ID header int false "Resume after this position"
+// @Success 200 {object} models.UserEvent
+// @Failure 400 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /users/events [get]
+func (h *EventStreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var afterSeq int64 = -1
+	if lastID != "" {
+		afterSeq, err = strconv.ParseInt(lastID, 10, 64)
+		if err != nil || afterSeq < 0 {
+			respondWithError(w, r, http.StatusBadRequest, "Last-Event-ID must be an event position")
+			return
+		}
+	}
+
+	// Subscribing before reading the log means no event falls between the two. Events are
+	// broadcast after they have been numbered, in that order, so a live event at or below the
+	// last position replayed has been sent already.
+	sub := h.broadcaster.Subscribe()
+	defer sub.Close()
+
+	// Streams outlive the server's write timeout, so it is lifted for this response
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Could not clear write deadline for event stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", eventRetryMillis); err != nil {
+		return
+	}
+	flushStream(rc)
+
+	log.Printf("Event stream opened")
+	defer log.Printf("Event stream closed")
+
+	var replayedUpTo int64
+	if afterSeq >= 0 {
+		log.Printf("Replaying events after position %d", afterSeq)
+		if replayedUpTo, err = h.replay(r.Context(), w, filter, afterSeq); err != nil {
+			log.Printf("Error replaying events after position %d: %v", afterSeq, err)
+			return
+		}
+		flushStream(rc)
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.Events:
+			if !ok {
+				// the client fell behind and catches up from the log when it reconnects
+				log.Printf("Closing event stream that fell behind")
+				return
+			}
+			// events that were both replayed and broadcast are only sent once
+			if e.Seq <= replayedUpTo || !filter.matches(e) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				log.Printf("Error writing event %d: %v", e.ID, err)
+				return
+			}
+		}
+		flushStream(rc)
+	}
+}
+
+// replay writes the matching events published after position afterSeq and returns the
+// position of the last event it read
+func (h *EventStreamHandler) replay(ctx context.Context, w http.ResponseWriter, filter eventFilter, afterSeq int64) (int64, error) {
+	for {
+		batch, err := h.eventLog.PublishedEvents(ctx, afterSeq, eventReplayBatch)
+		if err != nil {
+			return afterSeq, err
+		}
+		for _, e := range batch {
+			afterSeq = e.Seq
+			if !filter.matches(e) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return afterSeq, err
+			}
+		}
+		if len(batch) < eventReplayBatch {
+			return afterSeq, nil
+		}
+	}
+}
+
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	query := r.URL.Query()
+	filter := eventFilter{types: make(map[string]bool)}
+
+	for _, value := range query["type"] {
+		for _, t := range strings.Split(value, ",") {
+			switch t = strings.TrimSpace(t); t {
+			case models.EventUserCreated, models.EventUserUpdated, models.EventUserDeleted:
+				filter.types[t] = true
+			case "":
+			default:
+				return filter, fmt.Errorf("unknown event type: %s", t)
+			}
+		}
+	}
+	if value := query.Get("user_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			return filter, fmt.Errorf("user_id must be a user ID")
+		}
+		filter.userID = id
+	}
+	return filter, nil
+}
+
+// writeEvent writes e as a server-sent event named after its type, with its position in the
+// published stream as its ID
+func writeEvent(w http.ResponseWriter, e *models.UserEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+	return err
+}
+
+func flushStream(rc *http.ResponseController) {
+	if err := rc.Flush(); err != nil && err != http.ErrNotSupported {
+		log.Printf("Error flushing event stream: %v", err)
+	}
+}
+
+// RelayUnavailable refuses requests for live events on instances that do not run the event
+// relay. Their broadcaster is never fed, so their streams would stay silent.
+func RelayUnavailable(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, http.StatusServiceUnavailable, "Live events are served by the instance that runs the event relay")
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"userapi/events"
+	"userapi/models"
+	"userapi/repository"
+)
+
+func TestEventStreamHandler_InvalidParameters(t *testing.T) {
+	repo := repository.NewMemoryUserRepository()
+	handler := NewEventStreamHandler(events.NewBroadcaster(), repo.(repository.OutboxRepository), time.Second)
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+	}{
+		{"unknown type", "?type=UserRenamed", ""},
+		{"invalid user id", "?user_id=abc", ""},
+		{"invalid last event id", "", "abc"},
+		{"negative last event id", "?last_event_id=-1", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/users/events"+tt.query, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			w := httptest.NewRecorder()
+			handler.Stream(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Stream() status = %v, want %v", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestEventStreamHandler_Stream(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	outbox := repo.(repository.OutboxRepository)
+	broadcaster := events.NewBroadcaster()
+	relay := events.NewRelay(outbox, time.Second, 100)
+	relay.AddPublishedSink(broadcaster)
+
+	john := &models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+	repo.Create(ctx, john)
+	john.Age = 31
+	repo.Update(ctx, john)
+	relay.RelayOnce(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(NewEventStreamHandler(broadcaster, outbox, time.Hour).Stream))
+	defer server.Close()
+
+	// resume after John's creation, only interested in updates
+	req, _ := http.NewRequest("GET", server.URL+"/users/events?type=UserUpdated", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /users/events error = %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		for lines.Scan() {
+			if line := lines.Text(); strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "event:") {
+				return line
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return ""
+	}
+
+	if id, event := next(), next(); id != "id: 2" || event != "event: UserUpdated" {
+		t.Fatalf("replayed %q %q, want event 2, UserUpdated", id, event)
+	}
+
+	// the stream subscribed before replaying; of the live events only the update matches
+	jane := &models.User{Name: "Jane Doe", Age: 28, PhoneNumber: "+14155552672", Email: "jane@example.com"}
+	repo.Create(ctx, jane)
+	jane.Age = 29
+	repo.Update(ctx, jane)
+	relay.RelayOnce(ctx)
+
+	if id, event := next(), next(); id != "id: 4" || event != "event: UserUpdated" {
+		t.Fatalf("streamed %q %q, want event 4, UserUpdated", id, event)
+	}
+}
+
+// TestEventStreamHandler_BlockedUser interleaves the events of two users and blocks one of
+// them, so that events are published out of ID order. Neither the live stream nor a resumed
+// one may lose the blocked user's events.
+func TestEventStreamHandler_BlockedUser(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	outbox := repo.(repository.OutboxRepository)
+	broadcaster := events.NewBroadcaster()
+
+	john := &models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+	jane := &models.User{Name: "Jane Doe", Age: 28, PhoneNumber: "+14155552672", Email: "jane@example.com"}
+	repo.Create(ctx, john)
+	repo.Create(ctx, jane)
+	john.Age = 31
+	repo.Update(ctx, john)
+	jane.Age = 29
+	repo.Update(ctx, jane)
+
+	blockJohn := true
+	relay := events.NewRelay(outbox, time.Second, 100, events.SinkFunc(func(ctx context.Context, e *models.UserEvent) error {
+		if blockJohn && e.UserID == john.ID {
+			return errors.New("receiver unavailable")
+		}
+		return nil
+	}))
+	relay.AddPublishedSink(broadcaster)
+	// Jane's events 2 and 4 are published first
+	relay.RelayOnce(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(NewEventStreamHandler(broadcaster, outbox, time.Hour).Stream))
+	// cleanups run last first, so the streams are closed before the server waits for them
+	t.Cleanup(server.Close)
+	client := &http.Client{Timeout: 5 * time.Second}
+	open := func(lastEventID string) *bufio.Scanner {
+		req, _ := http.NewRequest("GET", server.URL+"/users/events", nil)
+		req.Header.Set("Last-Event-ID", lastEventID)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET /users/events error = %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return bufio.NewScanner(resp.Body)
+	}
+	// next returns the SSE ID and the user of the next event
+	next := func(lines *bufio.Scanner) (string, int64) {
+		var id string
+		for lines.Scan() {
+			line := lines.Text()
+			if strings.HasPrefix(line, "id: ") {
+				id = strings.TrimPrefix(line, "id: ")
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var e models.UserEvent
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					t.Fatalf("event data %s: %v", data, err)
+				}
+				return id, e.UserID
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return "", 0
+	}
+
+	live := open("0")
+	for _, want := range []string{"1", "2"} {
+		if id, user := next(live); id != want || user != jane.ID {
+			t.Fatalf("replayed event %s of user %d, want event %s of Jane", id, user, want)
+		}
+	}
+
+	// John's events have lower IDs than Jane's, but are published after them
+	blockJohn = false
+	relay.RelayOnce(ctx)
+	for _, want := range []string{"3", "4"} {
+		if id, user := next(live); id != want || user != john.ID {
+			t.Fatalf("streamed event %s of user %d, want event %s of John", id, user, want)
+		}
+	}
+
+	resumed := open("2")
+	for _, want := range []string{"3", "4"} {
+		if id, user := next(resumed); id != want || user != john.ID {
+			t.Fatalf("resumed with event %s of user %d, want event %s of John", id, user, want)
+		}
+	}
+}
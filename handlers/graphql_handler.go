@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"userapi/graphqlapi"
+)
+
+// GraphQLHandler serves the GraphQL endpoint
+type GraphQLHandler struct {
+	service *graphqlapi.Service
+}
+
+// NewGraphQLHandler creates a new GraphQL handler
+func NewGraphQLHandler(service *graphqlapi.Service) *GraphQLHandler {
+	return &GraphQLHandler{service: service}
+}
+
+// GraphQLRequest is the body of a GraphQL request
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// @Summary Execute a GraphQL request
+// @Description Run a query or mutation against the user schema. GET requests take the query, variables and operationName as query parameters and can only run queries.
+// @Tags graphql
+// @Accept json
+// @Produce json
+// @Param request body GraphQLRequest true "GraphQL request"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} ErrorResponse
+// @Router /graphql [post]
+func (h *GraphQLHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				respondWithError(w, r, http.StatusBadRequest, "Invalid variables")
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding GraphQL request: %v", err)
+		respondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if req.Query == "" {
+		respondWithError(w, r, http.StatusBadRequest, "query is required")
+		return
+	}
+	if r.Method == http.MethodGet && graphqlapi.IsMutation(req.Query, req.OperationName) {
+		respondWithError(w, r, http.StatusMethodNotAllowed, "Mutations must be sent with POST")
+		return
+	}
+
+	result := h.service.Do(r.Context(), req.Query, req.Variables, req.OperationName)
+	if result.HasErrors() {
+		log.Printf("GraphQL request finished with errors: %v", result.Errors)
+	}
+
+	// GraphQL responses are always JSON, and errors are reported in the body
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"userapi/models"
+	"userapi/repository"
+
+	"github.com/gorilla/mux"
+)
+
+type HistoryHandler struct {
+	history repository.HistoryRepository
+}
+
+func NewHistoryHandler(history repository.HistoryRepository) *HistoryHandler {
+	return &HistoryHandler{history: history}
+}
+
+// HistoryResponse lists the revisions of a user, oldest first
+type HistoryResponse struct {
+	UserID    int64                  `json:"user_id" xml:"user_id"`
+	Revisions []*models.UserRevision `json:"revisions" xml:"revisions>revision"`
+}
+
+// RevertRequest names the revision to restore a user to
+type RevertRequest struct {
+	Revision int64 `json:"revision" xml:"revision"`
+}
+
+// @Summary Get the history of a user
+// @Description List every change to a user, oldest first, with who made it, in which request and which fields changed. The history of deleted users is kept.
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} HistoryResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/history [get]
+func (h *HistoryHandler) History(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing user ID: %v", err)
+		respondWithError(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	revisions, err := h.history.History(r.Context(), id)
+	if err != nil {
+		log.Printf("Error retrieving history of user with ID %d: %v", id, err)
+		respondWithStoreError(w, r, err, "Error retrieving history")
+		return
+	}
+	if len(revisions) == 0 {
+		log.Printf("No history for user with ID: %d", id)
+		respondWithError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	log.Printf("Successfully retrieved %d revisions of user with ID: %d", len(revisions), id)
+	respond(w, r, http.StatusOK, HistoryResponse{UserID: id, Revisions: revisions})
+}
+
+// @Summary Get a user as of a point in time
+// @Description Get a user as it was at the given time, which is served instead of the current user when as_of is set
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param as_of query string true "RFC 3339 timestamp"
+// @Success 200 {object} models.User
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id} [get]
+func (h *HistoryHandler) AsOf(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing user ID: %v", err)
+		respondWithError(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("as_of"))
+	if err != nil {
+		log.Printf("Error parsing as_of: %v", err)
+		respondWithError(w, r, http.StatusBadRequest, "as_of must be an RFC 3339 timestamp")
+		return
+	}
+
+	user, err := h.history.AsOf(r.Context(), id, asOf)
+	if err != nil {
+		log.Printf("Error retrieving user with ID %d as of %s: %v", id, asOf.Format(time.RFC3339), err)
+		respondWithStoreError(w, r, err, "Error retrieving user")
+		return
+	}
+	if user == nil {
+		respondWithError(w, r, http.StatusNotFound, "User not found at that time")
+		return
+	}
+
+	log.Printf("Successfully retrieved user with ID %d as of %s", id, asOf.Format(time.RFC3339))
+	respond(w, r, http.StatusOK, user)
+}
+
+// @Summary Revert a user to a revision
+// @Description Restore a user to how it was right after a revision of its history, recreating it if it has been deleted since. The revert is recorded as a new revision.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param revert body RevertRequest true "Revision to restore"
+// @Success 200 {object} models.User
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/revert [post]
+func (h *HistoryHandler) Revert(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing user ID: %v", err)
+		respondWithError(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req RevertRequest
+	if err := decodeRequest(r, &req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		respondWithDecodeError(w, r, err, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+	if req.Revision <= 0 {
+		respondWithError(w, r, http.StatusBadRequest, "revision is required")
+		return
+	}
+
+	user, err := h.history.Revert(r.Context(), id, req.Revision)
+	if err != nil {
+		log.Printf("Error reverting user with ID %d to revision %d: %v", id, req.Revision, err)
+		switch {
+		case errors.Is(err, repository.ErrRevisionNotFound):
+			respondWithError(w, r, http.StatusNotFound, "Revision not found")
+		case errors.Is(err, repository.ErrRevertToDeleted):
+			respondWithError(w, r, http.StatusConflict, "Revision deleted the user, revert to an earlier one")
+		case errors.Is(err, repository.ErrEmailTaken):
+			respondWithError(w, r, http.StatusConflict, "Email already in use")
+		default:
+			respondWithStoreError(w, r, err, "Error reverting user")
+		}
+		return
+	}
+
+	log.Printf("Successfully reverted user with ID %d to revision %d", id, req.Revision)
+	respond(w, r, http.StatusOK, user)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"userapi/models"
+	"userapi/repository"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	repo := repository.NewMemoryUserRepository()
+	handler := NewHistoryHandler(repo.(repository.HistoryRepository))
+
+	user := &models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+	repo.Create(context.Background(), user)
+	user.Age = 31
+	repo.Update(context.Background(), user)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		id         string
+		body       string
+		serve      func(http.ResponseWriter, *http.Request)
+		wantStatus int
+	}{
+		{"history", "GET", "/users/1/history", "1", "", handler.History, http.StatusOK},
+		{"history of unknown user", "GET", "/users/999/history", "999", "", handler.History, http.StatusNotFound},
+		{"history with invalid id", "GET", "/users/x/history", "x", "", handler.History, http.StatusBadRequest},
+		{"as of now", "GET", "/users/1?as_of=" + time.Now().Add(time.Minute).UTC().Format(time.RFC3339), "1", "", handler.AsOf, http.StatusOK},
+		{"as of before create", "GET", "/users/1?as_of=2000-01-01T00:00:00Z", "1", "", handler.AsOf, http.StatusNotFound},
+		{"invalid as of", "GET", "/users/1?as_of=yesterday", "1", "", handler.AsOf, http.StatusBadRequest},
+		{"revert", "POST", "/users/1/revert", "1", `{"revision":1}`, handler.Revert, http.StatusOK},
+		{"revert to unknown revision", "POST", "/users/1/revert", "1", `{"revision":999}`, handler.Revert, http.StatusNotFound},
+		{"revert without revision", "POST", "/users/1/revert", "1", `{}`, handler.Revert, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+
+			tt.serve(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	if got, _ := repo.GetByID(context.Background(), user.ID); got.Age != 30 {
+		t.Errorf("age after revert = %d, want 30", got.Age)
+	}
+
+	req := httptest.NewRequest("GET", "/users/1/history", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	handler.History(w, req)
+	var resp HistoryResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding history: %v", err)
+	}
+	if len(resp.Revisions) != 3 || resp.Revisions[2].Action != models.RevisionRevert {
+		t.Errorf("history = %+v, want create, update, revert", resp.Revisions)
+	}
+}
+
+func TestRequestContextMiddleware(t *testing.T) {
+	var actor, requestID string
+	authenticate := TokenAuthenticator(map[string]string{"secret": "alice"})
+	handler := RequestContextMiddleware(authenticate)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo := repository.NewMemoryUserRepository()
+		user := &models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+		repo.Create(r.Context(), user)
+		revisions, _ := repo.(repository.HistoryRepository).History(r.Context(), user.ID)
+		actor, requestID = revisions[0].Actor, revisions[0].RequestID
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		claimedActor  string
+		requestID     string
+		wantActor     string
+	}{
+		{name: "authenticated", authorization: "Bearer secret", wantActor: "alice"},
+		{name: "authenticated with a different claim", authorization: "Bearer secret", claimedActor: "bob", wantActor: "alice"},
+		{name: "unverified claim", claimedActor: "alice", wantActor: AnonymousActor},
+		{name: "wrong token", authorization: "Bearer guess", claimedActor: "alice", wantActor: AnonymousActor},
+		{name: "given request ID", requestID: "req-1", wantActor: AnonymousActor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/users", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.claimedActor != "" {
+				req.Header.Set(ActorHeader, tt.claimedActor)
+			}
+			if tt.requestID != "" {
+				req.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if actor != tt.wantActor {
+				t.Errorf("actor = %q, want %q", actor, tt.wantActor)
+			}
+			if requestID == "" || w.Header().Get(RequestIDHeader) != requestID || (tt.requestID != "" && requestID != tt.requestID) {
+				t.Errorf("request ID = %q, response header = %q, want %q", requestID, w.Header().Get(RequestIDHeader), tt.requestID)
+			}
+		})
+	}
+}
+
+func TestRequireActor(t *testing.T) {
+	authenticate := TokenAuthenticator(map[string]string{"secret": "alice"})
+	handler := RequestContextMiddleware(authenticate)(RequireActor(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "authenticated", authorization: "Bearer secret", wantStatus: http.StatusNoContent},
+		{name: "anonymous", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer guess", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/webhooks", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"userapi/reqctx"
+
+	"github.com/gorilla/mux"
+)
+
+// IdempotencyKeyHeader is the request header clients use to make a mutating request safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyReplayedHeader is set on responses that were replayed from the idempotency store
+const IdempotencyReplayedHeader = "Idempotency-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotencyMemory is the size above which keyed request bodies are kept in a temporary
+// file rather than in memory
+const maxIdempotencyMemory = 1 << 20
+
+var (
+	// ErrIdempotencyKeyInUse is returned when a key is reserved by a request that has not completed yet
+	ErrIdempotencyKeyInUse = errors.New("idempotency key is in use by another request")
+	// ErrIdempotencyKeyMismatch is returned when a key is reused with a different request
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key was used with a different request")
+)
+
+// StoredResponse is a response captured for replay
+type StoredResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// IdempotencyStore keeps request fingerprints and their responses for a limited time
+type IdempotencyStore interface {
+	// Reserve claims key for a request with the given fingerprint. If the key already
+	// holds a completed response for the same fingerprint, that response is returned.
+	Reserve(key, fingerprint string) (*StoredResponse, error)
+	// Complete stores the response for a reserved key
+	Complete(key string, response *StoredResponse) error
+	// Release drops a reservation so that the request can be retried
+	Release(key string) error
+}
+
+type idempotencyEntry struct {
+	key         string
+	fingerprint string
+	response    *StoredResponse
+	expiresAt   time.Time
+}
+
+// memoryIdempotencyStore keeps its entries in a map and, as they all live for the same ttl
+// from their last change, in a list in the order they expire, so that evicting them only
+// looks at the ones that have expired
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*list.Element
+	expiry  *list.List
+	now     func() time.Time
+}
+
+// NewMemoryIdempotencyStore creates an in-memory idempotency store whose entries expire after ttl
+func NewMemoryIdempotencyStore(ttl time.Duration) IdempotencyStore {
+	return &memoryIdempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		expiry:  list.New(),
+		now:     time.Now,
+	}
+}
+
+func (s *memoryIdempotencyStore) Reserve(key, fingerprint string) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.evictExpired(now)
+
+	if element, exists := s.entries[key]; exists {
+		entry := element.Value.(*idempotencyEntry)
+		if entry.fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyMismatch
+		}
+		if entry.response == nil {
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return entry.response, nil
+	}
+
+	entry := &idempotencyEntry{key: key, fingerprint: fingerprint, expiresAt: now.Add(s.ttl)}
+	s.entries[key] = s.expiry.PushBack(entry)
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(key string, response *StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, exists := s.entries[key]
+	if !exists {
+		return errors.New("idempotency key is not reserved")
+	}
+	entry := element.Value.(*idempotencyEntry)
+	entry.response = response
+	entry.expiresAt = s.now().Add(s.ttl)
+	s.expiry.MoveToBack(element)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, exists := s.entries[key]; exists {
+		s.expiry.Remove(element)
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// evictExpired drops entries from the front of the expiry list until it reaches one that
+// has not expired
+func (s *memoryIdempotencyStore) evictExpired(now time.Time) {
+	for front := s.expiry.Front(); front != nil; front = s.expiry.Front() {
+		entry := front.Value.(*idempotencyEntry)
+		if !now.After(entry.expiresAt) {
+			return
+		}
+		s.expiry.Remove(front)
+		delete(s.entries, entry.key)
+	}
+}
+
+// IdempotencyMiddleware replays the stored response for mutating requests that repeat an
+// Idempotency-Key. Requests without the header are passed through untouched. Keys belong to
+// the actor that sent them, so clients cannot replay or block each other's requests.
+//
+// Keyed requests are read in full to fingerprint them before they are handled. Their bodies
+// are limited to maxBody bytes, or to the limit routeMaxBody gives their route template, and
+// those over maxIdempotencyMemory bytes are kept in a temporary file until the request ends.
+func IdempotencyMiddleware(store IdempotencyStore, maxBody int64, routeMaxBody map[string]int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !isMutatingMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				respondWithError(w, r, http.StatusBadRequest, "Idempotency-Key is too long")
+				return
+			}
+
+			limit := maxBody
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					if routeLimit, ok := routeMaxBody[template]; ok {
+						limit = routeLimit
+					}
+				}
+			}
+
+			fingerprint := newRequestFingerprint(r)
+			body, err := spoolBody(w, r, limit, fingerprint)
+			if err != nil {
+				log.Printf("Error reading request body: %v", err)
+				respondWithReadError(w, r, err)
+				return
+			}
+			defer body.Close()
+			r.Body = body
+
+			// the actor cannot contain the separator, which is not valid in a header
+			scoped := reqctx.Actor(r.Context()) + "\x00" + key
+			stored, err := store.Reserve(scoped, hex.EncodeToString(fingerprint.Sum(nil)))
+			switch {
+			case errors.Is(err, ErrIdempotencyKeyMismatch):
+				log.Printf("Idempotency key %q reused with a different request", key)
+				respondWithError(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+				return
+			case errors.Is(err, ErrIdempotencyKeyInUse):
+				log.Printf("Idempotency key %q is still being processed", key)
+				respondWithError(w, r, http.StatusConflict, "A request with this Idempotency-Key is already in progress")
+				return
+			case err != nil:
+				log.Printf("Error reserving idempotency key %q: %v", key, err)
+				respondWithError(w, r, http.StatusInternalServerError, "Error processing request")
+				return
+			}
+
+			if stored != nil {
+				log.Printf("Replaying stored response for idempotency key %q", key)
+				replayResponse(w, stored)
+				return
+			}
+
+			// a handler that panics never completes the key, so it is released to let the
+			// client retry instead of getting 409 until the key expires
+			defer func() {
+				if p := recover(); p != nil {
+					if err := store.Release(scoped); err != nil {
+						log.Printf("Error releasing idempotency key %q: %v", key, err)
+					}
+					panic(p)
+				}
+			}()
+
+			rec := newCapturingResponseWriter(w)
+			next.ServeHTTP(rec, r)
+
+			// Server errors are not stored so that the client can retry them
+			if rec.status >= http.StatusInternalServerError {
+				if err := store.Release(scoped); err != nil {
+					log.Printf("Error releasing idempotency key %q: %v", key, err)
+				}
+				return
+			}
+
+			if err := store.Complete(scoped, rec.response()); err != nil {
+				log.Printf("Error storing response for idempotency key %q: %v", key, err)
+			}
+		})
+	}
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// newRequestFingerprint returns a hash of the method and URI of r, to which the body is
+// added as it is read
+func newRequestFingerprint(r *http.Request) hash.Hash {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.RequestURI()))
+	h.Write([]byte{0})
+	return h
+}
+
+// spoolBody reads the body of r, up to limit bytes, into h and returns it to be read again.
+// Bodies of up to maxIdempotencyMemory bytes are kept in memory and larger ones in a
+// temporary file, which is removed when the returned body is closed.
+func spoolBody(w http.ResponseWriter, r *http.Request, limit int64, h hash.Hash) (io.ReadCloser, error) {
+	body := http.MaxBytesReader(w, r.Body, limit)
+	var buf bytes.Buffer
+	_, err := io.CopyN(io.MultiWriter(&buf, h), body, maxIdempotencyMemory+1)
+	if errors.Is(err, io.EOF) {
+		return io.NopCloser(&buf), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp("", "userapi-idempotency-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	spooled := &spooledBody{File: f}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		spooled.Close()
+		return nil, fmt.Errorf("failed to spool request body: %w", err)
+	}
+	if _, err := io.Copy(io.MultiWriter(f, h), body); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, fmt.Errorf("failed to rewind request body: %w", err)
+	}
+	return spooled, nil
+}
+
+// spooledBody is a request body kept in a temporary file
+type spooledBody struct {
+	*os.File
+}
+
+func (b *spooledBody) Close() error {
+	err := b.File.Close()
+	if removeErr := os.Remove(b.Name()); removeErr != nil {
+		log.Printf("Error removing spooled request body %s: %v", b.Name(), removeErr)
+	}
+	return err
+}
+
+func replayResponse(w http.ResponseWriter, stored *StoredResponse) {
+	for name, values := range stored.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.Header().Set(IdempotencyReplayedHeader, "true")
+	w.WriteHeader(stored.StatusCode)
+	w.Write(stored.Body)
+}
+
+// capturingResponseWriter passes a response through while keeping a copy of it
+type capturingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func newCapturingResponseWriter(w http.ResponseWriter) *capturingResponseWriter {
+	return &capturingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (c *capturingResponseWriter) WriteHeader(code int) {
+	c.status = code
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *capturingResponseWriter) Write(b []byte) (int, error) {
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (c *capturingResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *capturingResponseWriter) response() *StoredResponse {
+	return &StoredResponse{
+		StatusCode: c.status,
+		Header:     c.Header().Clone(),
+		Body:       append([]byte(nil), c.body.Bytes()...),
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"userapi/models"
+	"userapi/reqctx"
+
+	"github.com/gorilla/mux"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	repo := newMockUserRepository()
+	handler := NewUserHandler(repo)
+	mw := IdempotencyMiddleware(NewMemoryIdempotencyStore(time.Hour), 1<<20, nil)(http.HandlerFunc(handler.Create))
+
+	send := func(key string, user models.User) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(user)
+		req := httptest.NewRequest("POST", "/users", bytes.NewBuffer(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+
+	user := models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+
+	first := send("key-1", user)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request status = %v, want %v", first.Code, http.StatusCreated)
+	}
+
+	retry := send("key-1", user)
+	if retry.Code != http.StatusCreated {
+		t.Errorf("retry status = %v, want %v", retry.Code, http.StatusCreated)
+	}
+	if retry.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Errorf("retry was not marked as replayed")
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("retry body = %s, want %s", retry.Body.String(), first.Body.String())
+	}
+	if len(repo.users) != 1 {
+		t.Errorf("repository has %d users, want 1", len(repo.users))
+	}
+
+	other := user
+	other.Email = "jane@example.com"
+	if w := send("key-1", other); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with different body status = %v, want %v", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	if w := send("", other); w.Code != http.StatusCreated {
+		t.Errorf("request without key status = %v, want %v", w.Code, http.StatusCreated)
+	}
+	if len(repo.users) != 2 {
+		t.Errorf("repository has %d users, want 2", len(repo.users))
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Minute).(*memoryIdempotencyStore)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	if _, err := store.Reserve("k", "a"); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if _, err := store.Reserve("k", "a"); err != ErrIdempotencyKeyInUse {
+		t.Errorf("Reserve() on in-flight key error = %v, want %v", err, ErrIdempotencyKeyInUse)
+	}
+
+	if err := store.Complete("k", &StoredResponse{StatusCode: http.StatusCreated}); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	stored, err := store.Reserve("k", "a")
+	if err != nil || stored == nil || stored.StatusCode != http.StatusCreated {
+		t.Errorf("Reserve() on completed key = %+v, %v", stored, err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	stored, err = store.Reserve("k", "b")
+	if err != nil || stored != nil {
+		t.Errorf("Reserve() after expiry = %+v, %v, want new reservation", stored, err)
+	}
+}
+
+func TestMemoryIdempotencyStore_Expiry(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Minute).(*memoryIdempotencyStore)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	store.Reserve("a", "a")
+	now = now.Add(30 * time.Second)
+	store.Reserve("b", "b")
+	store.Reserve("c", "c")
+	store.Release("c")
+	now = now.Add(20 * time.Second)
+	// completing a moves it behind b, as it now lives for another minute
+	store.Complete("a", &StoredResponse{StatusCode: http.StatusCreated})
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  []string
+	}{
+		{"none expired", 0, []string{"b", "a"}},
+		{"b expired", 41 * time.Second, []string{"a"}},
+		{"all expired", time.Minute, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.after)
+			store.mu.Lock()
+			store.evictExpired(now)
+			var keys []string
+			for e := store.expiry.Front(); e != nil; e = e.Next() {
+				keys = append(keys, e.Value.(*idempotencyEntry).key)
+			}
+			entries := len(store.entries)
+			store.mu.Unlock()
+
+			if strings.Join(keys, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expiry order = %v, want %v", keys, tt.want)
+			}
+			if entries != len(tt.want) {
+				t.Errorf("store has %d entries, want %d", entries, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddleware_Actors(t *testing.T) {
+	calls := 0
+	mw := IdempotencyMiddleware(NewMemoryIdempotencyStore(time.Hour), 1<<20, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	send := func(actor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/users", strings.NewReader("{}"))
+		req = req.WithContext(reqctx.WithActor(req.Context(), actor))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+
+	send("alice")
+	if w := send("bob"); w.Header().Get(IdempotencyReplayedHeader) != "" {
+		t.Errorf("another actor's request was replayed")
+	}
+	if w := send("alice"); w.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Errorf("the same actor's retry was not replayed")
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyMiddleware_RouteLimits(t *testing.T) {
+	var received []byte
+	router := mux.NewRouter()
+	router.Use(IdempotencyMiddleware(NewMemoryIdempotencyStore(time.Hour), 16, map[string]int64{"/imports": 4 << 20}))
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}
+	router.HandleFunc("/imports", handler).Methods("POST")
+	router.HandleFunc("/users", handler).Methods("POST")
+
+	// larger than maxIdempotencyMemory, so that it is spooled to a file
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<17)
+	tests := []struct {
+		name       string
+		path       string
+		body       []byte
+		wantStatus int
+	}{
+		{"default limit", "/users", []byte("{}"), http.StatusAccepted},
+		{"over default limit", "/users", large[:17], http.StatusRequestEntityTooLarge},
+		{"route limit", "/imports", large, http.StatusAccepted},
+		{"over route limit", "/imports", bytes.Repeat(large, 3), http.StatusRequestEntityTooLarge},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			req := httptest.NewRequest("POST", tt.path, bytes.NewReader(tt.body))
+			req.Header.Set(IdempotencyKeyHeader, fmt.Sprintf("key-%d", i))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusAccepted && !bytes.Equal(received, tt.body) {
+				t.Errorf("handler read %d bytes, want the %d sent", len(received), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddleware_Limits(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Hour)
+	panicking := true
+	mw := IdempotencyMiddleware(store, 16, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if panicking {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		func() {
+			defer func() { recover() }()
+			mw.ServeHTTP(w, req)
+		}()
+		return w
+	}
+
+	if w := send(strings.Repeat("a", 17)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body status = %v, want %v", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	send("{}")
+	panicking = false
+	if w := send("{}"); w.Code != http.StatusCreated {
+		t.Errorf("retry after a panic status = %v, want %v", w.Code, http.StatusCreated)
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"userapi/imports"
+
+	"github.com/gorilla/mux"
+)
+
+// ImportHandler handles bulk user imports
+type ImportHandler struct {
+	manager   *imports.Manager
+	maxUpload int64
+}
+
+// NewImportHandler creates a new import handler accepting uploads of up to maxUpload bytes
+func NewImportHandler(manager *imports.Manager, maxUpload int64) *ImportHandler {
+	return &ImportHandler{manager: manager, maxUpload: maxUpload}
+}
+
+// @Summary Start a bulk import
+// @Description Upload a CSV or NDJSON file of users to be imported in the background. The file can be sent as the raw body or as the "file" field of a multipart form.
+// @Tags imports
+// @Accept text/csv,application/x-ndjson,multipart/form-data
+// @Produce json
+// @Param format query string false "csv or ndjson, overrides the Content-Type"
+// @Success 202 {object} imports.Job
+// @Failure 400 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /imports [post]
+func (h *ImportHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUpload)
+
+	upload := io.Reader(r.Body)
+	contentType := r.Header.Get("Content-Type")
+	filename := ""
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			log.Printf("Error reading multipart upload: %v", err)
+			respondWithError(w, r, http.StatusBadRequest, "Invalid multipart upload")
+			return
+		}
+		part, err := nextFilePart(reader)
+		if err != nil {
+			log.Printf("Error reading multipart upload: %v", err)
+			respondWithError(w, r, http.StatusBadRequest, "A file field is required")
+			return
+		}
+		defer part.Close()
+		upload = part
+		contentType = part.Header.Get("Content-Type")
+		filename = part.FileName()
+	}
+
+	format, ok := importFormat(r.URL.Query().Get("format"), contentType, filename)
+	if !ok {
+		respondWithError(w, r, http.StatusUnsupportedMediaType, "Upload must be CSV or NDJSON")
+		return
+	}
+
+	job, err := h.manager.Start(r.Context(), format, upload)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		log.Printf("Import upload exceeds %d bytes", tooLarge.Limit)
+		respondWithReadError(w, r, tooLarge)
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting import: %v", err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error starting import")
+		return
+	}
+
+	log.Printf("Successfully started import job %s", job.ID)
+	w.Header().Set("Location", "/imports/"+job.ID)
+	respond(w, r, http.StatusAccepted, job)
+}
+
+// @Summary Get an import job
+// @Description Get the status, progress and error counts of an import job
+// @Tags imports
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} imports.Job
+// @Failure 404 {object} ErrorResponse
+// @Router /imports/{id} [get]
+func (h *ImportHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, err := h.manager.Get(id)
+	if errors.Is(err, imports.ErrJobNotFound) {
+		respondWithError(w, r, http.StatusNotFound, "Import job not found")
+		return
+	}
+
+	respond(w, r, http.StatusOK, job)
+}
+
+// @Summary Download the error report of an import job
+// @Description Get a CSV listing every rejected row with the reason it was rejected
+// @Tags imports
+// @Produce text/csv
+// @Param id path string true "Job ID"
+// @Success 200 {file} file
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /imports/{id}/errors [get]
+func (h *ImportHandler) Errors(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	report, err := h.manager.ErrorReport(id)
+	switch {
+	case errors.Is(err, imports.ErrJobNotFound):
+		respondWithError(w, r, http.StatusNotFound, "Import job not found")
+		return
+	case errors.Is(err, imports.ErrJobRunning):
+		respondWithError(w, r, http.StatusConflict, "Import job is still running")
+		return
+	case err != nil:
+		log.Printf("Error opening error report for import job %s: %v", id, err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error retrieving error report")
+		return
+	}
+	defer report.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="import-`+id+`-errors.csv"`)
+	if _, err := io.Copy(w, report); err != nil {
+		log.Printf("Error sending error report for import job %s: %v", id, err)
+	}
+}
+
+func nextFilePart(reader *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// importFormat picks the upload format from an explicit format parameter, the content
+// type or the file extension, in that order
+func importFormat(param, contentType, filename string) (imports.Format, bool) {
+	switch strings.ToLower(param) {
+	case "csv":
+		return imports.FormatCSV, true
+	case "ndjson", "jsonl":
+		return imports.FormatNDJSON, true
+	case "":
+	default:
+		return "", false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return imports.FormatCSV, true
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return imports.FormatNDJSON, true
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return imports.FormatCSV, true
+	case ".ndjson", ".jsonl":
+		return imports.FormatNDJSON, true
+	}
+	return "", false
+}
@@ -0,0 +1,728 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"userapi/imports"
+	"userapi/models"
+	"userapi/openapi"
+	"userapi/webhooks"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIInfo describes the API at the top of the generated document
+var openAPIInfo = openapi.Info{
+	Title:       "User API",
+	Description: "A simple REST API for managing users",
+	Version:     "1.0.0",
+}
+
+var (
+	int32Schema    = &openapi.Schema{Type: openapi.Types{"integer"}, Format: "int32"}
+	int64Schema    = &openapi.Schema{Type: openapi.Types{"integer"}, Format: "int64"}
+	dateTimeSchema = &openapi.Schema{Type: openapi.Types{"string"}, Format: "date-time"}
+	// textSchema is the schema of bodies that are files or streams rather than Go values
+	textSchema = &openapi.Schema{Type: openapi.Types{"string"}}
+	fileSchema = &openapi.Schema{Type: openapi.Types{"string"}, Format: "binary"}
+)
+
+func pathParam(name, description string, schema *openapi.Schema) openapi.ParamDoc {
+	return openapi.ParamDoc{Name: name, In: "path", Description: description, Schema: schema}
+}
+
+func queryParam(name, description string, schema *openapi.Schema) openapi.ParamDoc {
+	return openapi.ParamDoc{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func errorDoc(description string) openapi.ResponseDoc {
+	return openapi.ResponseDoc{Description: description, Body: []interface{}{ErrorResponse{}}}
+}
+
+func bodyDoc(description string, bodies ...interface{}) openapi.ResponseDoc {
+	return openapi.ResponseDoc{Description: description, Body: bodies}
+}
+
+// userFilterParams are the filters shared by the list and export routes
+var userFilterParams = []openapi.ParamDoc{
+	queryParam("name", "Only users whose name contains this value", nil),
+	queryParam("email", "Only the user with this email", nil),
+	queryParam("phone", "Only users with this phone number, in any format", nil),
+	queryParam("min_age", "Minimum age", int32Schema),
+	queryParam("max_age", "Maximum age", int32Schema),
+}
+
+// openAPIRoutes describes every route registered in main, keyed by method and path
+// template. Responses sent by middleware and shared helpers are added by
+// withCommonResponses.
+func openAPIRoutes() map[string]openapi.RouteDoc {
+	userID := pathParam("id", "User ID", int64Schema)
+	webhookID := pathParam("id", "Subscription ID", int64Schema)
+	deliveryID := pathParam("delivery", "Delivery ID", int64Schema)
+	jobID := pathParam("id", "Job ID", nil)
+	health, users, audit, webhookTags := []string{"health"}, []string{"users"}, []string{"audit"}, []string{"webhooks"}
+	importTags, graphql := []string{"imports"}, []string{"graphql"}
+
+	jsonOnly := []string{codecs[0].contentType()}
+	graphqlResponses := map[int]openapi.ResponseDoc{
+		http.StatusOK:               {Description: "OK", Body: []interface{}{map[string]interface{}{}}, MediaTypes: jsonOnly},
+		http.StatusBadRequest:       errorDoc("Invalid request"),
+		http.StatusMethodNotAllowed: errorDoc("A mutation was sent with GET"),
+	}
+
+	return map[string]openapi.RouteDoc{
+		"GET /ping": {
+			Summary:     "Health check endpoint",
+			Description: "Returns a simple pong message to verify the API is running",
+			Tags:        health,
+			Responses:   map[int]openapi.ResponseDoc{http.StatusOK: bodyDoc("OK", PingResponse{})},
+		},
+		"GET /ready": {
+			Summary:     "Readiness check endpoint",
+			Description: "Returns 200 once the service can serve requests, and 503 while a dependency such as the database cannot be reached",
+			Tags:        health,
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                 bodyDoc("Ready", ReadyResponse{}),
+				http.StatusServiceUnavailable: bodyDoc("Not ready", ReadyResponse{}),
+			},
+		},
+		"GET /debug/vars": {Hidden: true},
+		"GET /openapi.json": {
+			Summary:     "OpenAPI document",
+			Description: "Get the OpenAPI 3.1 document of this API, generated from its routes and types",
+			Tags:        []string{"docs"},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "OK", Body: []interface{}{&openapi.Schema{Type: openapi.Types{"object"}}}, MediaTypes: jsonOnly},
+			},
+		},
+		"POST /users": {
+			Summary:     "Create a new user",
+			Description: "Create a new user with the provided information",
+			Tags:        users,
+			Body:        models.User{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusCreated:             bodyDoc("Created", models.User{}),
+				http.StatusBadRequest:          errorDoc("Invalid user"),
+				http.StatusConflict:            errorDoc("Email already in use"),
+				http.StatusInternalServerError: errorDoc("Error creating user"),
+			},
+		},
+		"POST /users:batch": {
+			Summary:     "Create, update and delete users in bulk",
+			Description: "Apply a list of operations either atomically or on a best-effort basis. A failed atomic batch responds with the status of the operation that failed it.",
+			Tags:        users,
+			Body:        BatchRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("Results", BatchResponse{}),
+				http.StatusBadRequest:          bodyDoc("Invalid batch or operation", BatchResponse{}, ErrorResponse{}),
+				http.StatusNotFound:            bodyDoc("An atomic batch updated or deleted a missing user", BatchResponse{}),
+				http.StatusConflict:            bodyDoc("An atomic batch used an email already in use", BatchResponse{}, ErrorResponse{}),
+				http.StatusInternalServerError: errorDoc("Error running batch"),
+			},
+		},
+		"GET /users/export": {
+			Summary:     "Export users",
+			Description: "Stream all users matching the filters as CSV, NDJSON or columnar JSON. Rows are read from a consistent snapshot and written as they are read, so exports of any size use constant memory. The columnar format writes one JSON object of column arrays per block of up to 1000 users, one block per line.",
+			Tags:        users,
+			Params: append([]openapi.ParamDoc{
+				queryParam("format", "csv (default), ndjson or columnar", &openapi.Schema{Type: openapi.Types{"string"}, Enum: []interface{}{"csv", "ndjson", "columnar"}}),
+			}, userFilterParams...),
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "Users", Body: []interface{}{textSchema}, MediaTypes: []string{"text/csv", "application/x-ndjson"}},
+				http.StatusBadRequest:          errorDoc("Invalid filter or format"),
+				http.StatusInternalServerError: errorDoc("Error exporting users"),
+			},
+		},
+		"GET /users/by-email/{email}": {
+			Summary:     "Get a user by email",
+			Description: "Get a user by their email. Emails are compared case-insensitively.",
+			Tags:        users,
+			Params:      []openapi.ParamDoc{pathParam("email", "Email", nil)},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("OK", models.User{}),
+				http.StatusNotFound:            errorDoc("User not found"),
+				http.StatusInternalServerError: errorDoc("Error retrieving user"),
+			},
+		},
+		"GET /users/search": {
+			Summary:     "Search users",
+			Description: "Find users by partial name, email or phone number. Every word of the query has to match; results are ranked by relevance.",
+			Tags:        users,
+			Params: []openapi.ParamDoc{
+				{Name: "q", In: "query", Description: "Search text", Required: true},
+				queryParam("limit", "Page size (default 20, at most 100)", int32Schema),
+				queryParam("offset", "Number of results to skip", int32Schema),
+			},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("Results", SearchResponse{}),
+				http.StatusBadRequest:          errorDoc("Invalid query or page"),
+				http.StatusInternalServerError: errorDoc("Error searching users"),
+			},
+		},
+		"GET /users/events": {
+			Summary:     "Stream user events",
+			Description: "Stream UserCreated, UserUpdated and UserDeleted events as server-sent events. Each event carries its position in the published stream (seq) as its SSE ID, so a client that reconnects with Last-Event-ID (or last_event_id) first receives the events it missed. Without it the stream starts with the next event. A comment is sent periodically to keep the connection open.",
+			Tags:        users,
+			Params: []openapi.ParamDoc{
+				queryParam("type", "Only events of these types, comma separated", nil),
+				queryParam("user_id", "Only events of this user", int64Schema),
+				queryParam("last_event_id", "Resume after this position, like the Last-Event-ID header", int64Schema),
+				{Name: "Last-Event-ID", In: "header", Description: "Resume after this position", Schema: int64Schema},
+			},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                 {Description: "Server-sent events, each with a UserEvent as data", Body: []interface{}{textSchema}, MediaTypes: []string{"text/event-stream"}},
+				http.StatusBadRequest:         errorDoc("Invalid filter or event ID"),
+				http.StatusServiceUnavailable: errorDoc("This instance does not run the event relay"),
+			},
+		},
+		"GET /users/{id}/history": {
+			Summary:     "Get the history of a user",
+			Description: "List every change to a user, oldest first, with who made it, in which request and which fields changed. The history of deleted users is kept.",
+			Tags:        users,
+			Params:      []openapi.ParamDoc{userID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("OK", HistoryResponse{}),
+				http.StatusNotFound:            errorDoc("User has no history"),
+				http.StatusInternalServerError: errorDoc("Error retrieving history"),
+			},
+		},
+		"POST /users/{id}/revert": {
+			Summary:     "Revert a user to a revision",
+			Description: "Restore a user to how it was right after a revision of its history, recreating it if it has been deleted since. The revert is recorded as a new revision.",
+			Tags:        users,
+			Params:      []openapi.ParamDoc{userID},
+			Body:        RevertRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("Reverted user", models.User{}),
+				http.StatusNotFound:            errorDoc("User or revision not found"),
+				http.StatusConflict:            errorDoc("Revision deleted the user, or its email is in use"),
+				http.StatusInternalServerError: errorDoc("Error reverting user"),
+			},
+		},
+		"GET /users/{id}": {
+			Summary:     "Get a user by ID",
+			Description: "Get a user by their ID, or as it was at a point in time when as_of is set",
+			Tags:        users,
+			Params:      []openapi.ParamDoc{userID, queryParam("as_of", "RFC 3339 timestamp", dateTimeSchema)},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("OK", models.User{}),
+				http.StatusNotFound:            errorDoc("User not found"),
+				http.StatusInternalServerError: errorDoc("Error retrieving user"),
+			},
+		},
+		"PUT /users/{id}": {
+			Summary:     "Update a user",
+			Description: "Update a user's information",
+			Tags:        users,
+			Params:      []openapi.ParamDoc{userID},
+			Body:        models.User{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("Updated user", models.User{}),
+				http.StatusNotFound:            errorDoc("User not found"),
+				http.StatusConflict:            errorDoc("Email already in use"),
+				http.StatusInternalServerError: errorDoc("Error updating user"),
+			},
+		},
+		"DELETE /users/{id}": {
+			Summary:     "Delete a user",
+			Description: "Delete a user by their ID",
+			Tags:        users,
+			Params:      []openapi.ParamDoc{userID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusNoContent:           {Description: "No Content"},
+				http.StatusNotFound:            errorDoc("User not found"),
+				http.StatusInternalServerError: errorDoc("Error deleting user"),
+			},
+		},
+		"GET /users": {
+			Summary:     "List all users",
+			Description: "Get a list of all users, optionally filtered. With ids or emails it instead looks up those users, see UserLookupResponse.",
+			Tags:        users,
+			Params: append([]openapi.ParamDoc{
+				queryParam("ids", "Comma-separated user IDs to look up", nil),
+				queryParam("emails", "Comma-separated emails to look up", nil),
+			}, userFilterParams...),
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("Users, or the result of a lookup", []*models.User{}, UserLookupResponse{}),
+				http.StatusInternalServerError: errorDoc("Error retrieving users"),
+			},
+		},
+		"GET /audit": {
+			Summary:     "Query the audit log",
+			Description: "List audited accesses to user data, oldest first. Entries are hash-chained; run the verify command to check the chain.",
+			Tags:        audit,
+			Params: []openapi.ParamDoc{
+				queryParam("actor", "Only entries of this actor", nil),
+				queryParam("action", "Only entries of this action, such as user.get", nil),
+				queryParam("target", "Only entries for this request URI, such as /users/1", nil),
+				queryParam("since", "RFC 3339 time of the oldest entry", dateTimeSchema),
+				queryParam("until", "RFC 3339 time of the newest entry", dateTimeSchema),
+				queryParam("after_seq", "Only entries after this sequence number", int64Schema),
+				queryParam("limit", "Page size (default 100, at most 1000)", int32Schema),
+			},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("OK", AuditResponse{}),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error querying audit log"),
+			},
+		},
+		"POST /webhooks": {
+			Summary:     "Create a webhook subscription",
+			Description: "Subscribe a URL to user events. Deliveries are signed with the returned secret, which is not shown again.",
+			Tags:        webhookTags,
+			Body:        WebhookRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusCreated:             bodyDoc("Created", webhooks.Subscription{}),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error creating webhook"),
+			},
+		},
+		"GET /webhooks": {
+			Summary: "List webhook subscriptions",
+			Tags:    webhookTags,
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("OK", []*webhooks.Subscription{}),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error retrieving webhooks"),
+			},
+		},
+		"GET /webhooks/{id}": {
+			Summary: "Get a webhook subscription",
+			Tags:    webhookTags,
+			Params:  []openapi.ParamDoc{webhookID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("OK", webhooks.Subscription{}),
+				http.StatusNotFound:            errorDoc("Webhook not found"),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error retrieving webhook"),
+			},
+		},
+		"PUT /webhooks/{id}": {
+			Summary:     "Update a webhook subscription",
+			Description: "Change the URL, event filter or active flag of a subscription. The secret stays the same.",
+			Tags:        webhookTags,
+			Params:      []openapi.ParamDoc{webhookID},
+			Body:        WebhookRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("Updated subscription", webhooks.Subscription{}),
+				http.StatusNotFound:            errorDoc("Webhook not found"),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error updating webhook"),
+			},
+		},
+		"DELETE /webhooks/{id}": {
+			Summary:     "Delete a webhook subscription",
+			Description: "Delete a subscription together with its deliveries",
+			Tags:        webhookTags,
+			Params:      []openapi.ParamDoc{webhookID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusNoContent:           {Description: "No Content"},
+				http.StatusNotFound:            errorDoc("Webhook not found"),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error deleting webhook"),
+			},
+		},
+		"GET /webhooks/{id}/deliveries": {
+			Summary:     "List the deliveries of a webhook subscription",
+			Description: "List the latest deliveries of a subscription, newest first",
+			Tags:        webhookTags,
+			Params:      []openapi.ParamDoc{webhookID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("OK", []*webhooks.Delivery{}),
+				http.StatusNotFound:            errorDoc("Webhook not found"),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error retrieving deliveries"),
+			},
+		},
+		"GET /webhooks/{id}/deliveries/{delivery}": {
+			Summary:     "Get a webhook delivery",
+			Description: "Get a delivery with the log of its attempts",
+			Tags:        webhookTags,
+			Params:      []openapi.ParamDoc{webhookID, deliveryID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  bodyDoc("OK", webhooks.Delivery{}),
+				http.StatusNotFound:            errorDoc("Delivery not found"),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error retrieving delivery"),
+			},
+		},
+		"POST /webhooks/{id}/deliveries/{delivery}/redeliver": {
+			Summary:     "Redeliver a webhook",
+			Description: "Queue a delivery again, for example after it went dead, with a fresh set of attempts",
+			Tags:        webhookTags,
+			Params:      []openapi.ParamDoc{webhookID, deliveryID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusAccepted:            bodyDoc("Queued delivery", webhooks.Delivery{}),
+				http.StatusNotFound:            errorDoc("Delivery not found"),
+				http.StatusUnauthorized:        errorDoc("Unauthorized"),
+				http.StatusInternalServerError: errorDoc("Error redelivering webhook"),
+			},
+		},
+		"GET /ws": {
+			Summary:     "Subscribe to live user updates",
+			Description: `Upgrade to a WebSocket that pushes user events. Authenticate with a bearer token or the access_token parameter. Send {"type": "subscribe", "id": "...", "user_ids": [1, 2]} or {"type": "subscribe", "id": "...", "query": {"name": "John"}} to receive the current users and then every event about them as {"type": "event", "subscriptions": ["..."], "event": {...}}. Query subscriptions also report users that stop matching. Send {"type": "unsubscribe", "id": "..."} to stop. Clients that fall behind are disconnected with status 1013.`,
+			Tags:        users,
+			Params:      []openapi.ParamDoc{queryParam("access_token", "Token, if not sent as a bearer token", nil)},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusSwitchingProtocols: {Description: "Switching Protocols"},
+				http.StatusUnauthorized:       errorDoc("Unauthorized"),
+				http.StatusServiceUnavailable: errorDoc("This instance does not run the event relay"),
+			},
+		},
+		"GET /graphql": {
+			Summary:     "Execute a GraphQL query",
+			Description: "Run a query against the user schema. Mutations have to be sent with POST.",
+			Tags:        graphql,
+			Params: []openapi.ParamDoc{
+				{Name: "query", In: "query", Description: "GraphQL query", Required: true},
+				queryParam("variables", "Variables, as a JSON object", nil),
+				queryParam("operationName", "Operation to run, if the query has several", nil),
+			},
+			Responses: graphqlResponses,
+		},
+		"POST /graphql": {
+			Summary:        "Execute a GraphQL request",
+			Description:    "Run a query or mutation against the user schema",
+			Tags:           graphql,
+			Body:           GraphQLRequest{},
+			BodyMediaTypes: jsonOnly,
+			Responses:      graphqlResponses,
+		},
+		"POST /imports": {
+			Summary:        "Start a bulk import",
+			Description:    `Upload a CSV or NDJSON file of users to be imported in the background. The file can be sent as the raw body or as the "file" field of a multipart/form-data form.`,
+			Tags:           importTags,
+			Params:         []openapi.ParamDoc{queryParam("format", "csv or ndjson, overrides the Content-Type", nil)},
+			Body:           fileSchema,
+			BodyMediaTypes: []string{"text/csv", "application/x-ndjson"},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusAccepted:              bodyDoc("Started job", imports.Job{}),
+				http.StatusBadRequest:            errorDoc("Invalid upload"),
+				http.StatusRequestEntityTooLarge: errorDoc("Upload is too large"),
+				http.StatusUnsupportedMediaType:  errorDoc("Upload must be CSV or NDJSON"),
+				http.StatusInternalServerError:   errorDoc("Error starting import"),
+			},
+		},
+		"GET /imports/{id}": {
+			Summary:     "Get an import job",
+			Description: "Get the status, progress and error counts of an import job",
+			Tags:        importTags,
+			Params:      []openapi.ParamDoc{jobID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:       bodyDoc("OK", imports.Job{}),
+				http.StatusNotFound: errorDoc("Import job not found"),
+			},
+		},
+		"GET /imports/{id}/errors": {
+			Summary:     "Download the error report of an import job",
+			Description: "Get a CSV listing every rejected row with the reason it was rejected",
+			Tags:        importTags,
+			Params:      []openapi.ParamDoc{jobID},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "Error report", Body: []interface{}{textSchema}, MediaTypes: []string{"text/csv"}},
+				http.StatusNotFound:            errorDoc("Import job not found"),
+				http.StatusConflict:            errorDoc("Import job is still running"),
+				http.StatusInternalServerError: errorDoc("Error retrieving error report"),
+			},
+		},
+	}
+}
+
+// withCommonResponses adds the responses that do not come from a route's handler but from
+// middleware and the helpers every handler uses
+func withCommonResponses(routes map[string]openapi.RouteDoc) {
+	for key, rd := range routes {
+		if rd.Hidden {
+			continue
+		}
+		method, path, _ := strings.Cut(key, " ")
+		responses := make(map[int]openapi.ResponseDoc, len(rd.Responses))
+		for status, resp := range rd.Responses {
+			responses[status] = resp
+		}
+		add := func(status int, resp openapi.ResponseDoc) {
+			if _, ok := responses[status]; !ok {
+				responses[status] = resp
+			}
+		}
+
+		negotiated := false
+		for _, resp := range rd.Responses {
+			negotiated = negotiated || len(resp.Body) > 0 && len(resp.MediaTypes) == 0
+		}
+		if negotiated {
+			add(http.StatusNotAcceptable, openapi.ResponseDoc{
+				Description: "No acceptable media type",
+				Body:        []interface{}{ErrorResponse{}},
+				MediaTypes:  []string{codecs[0].contentType()},
+			})
+		}
+		if len(rd.Params) > 0 || rd.Body != nil {
+			add(http.StatusBadRequest, errorDoc("The request does not match this document"))
+		}
+		if rd.Body != nil {
+			add(http.StatusRequestEntityTooLarge, errorDoc("Request body too large"))
+		}
+		if rd.Body != nil && len(rd.BodyMediaTypes) == 0 {
+			add(http.StatusUnsupportedMediaType, errorDoc("Unsupported Content-Type"))
+		}
+		if isMutatingMethod(method) {
+			rd.Params = append(rd.Params[:len(rd.Params):len(rd.Params)], openapi.ParamDoc{
+				Name:        IdempotencyKeyHeader,
+				In:          "header",
+				Description: "Key under which the response is kept, so that a retried request is answered with it instead of being applied again",
+				Schema:      &openapi.Schema{Type: openapi.Types{"string"}, MaxLength: openapi.Int(maxIdempotencyKeyLength)},
+			})
+			add(http.StatusBadRequest, errorDoc("Invalid Idempotency-Key"))
+			add(http.StatusConflict, errorDoc("A request with this Idempotency-Key is in progress"))
+			add(http.StatusUnprocessableEntity, errorDoc("Idempotency-Key was used with a different request"))
+			add(http.StatusInternalServerError, errorDoc("Error processing request"))
+		}
+		if strings.HasPrefix(path, "/users") && path != "/users/events" {
+			add(http.StatusServiceUnavailable, errorDoc("The database is unavailable; retry after Retry-After seconds"))
+		}
+		rd.Responses = responses
+		routes[key] = rd
+	}
+}
+
+// openAPIRefinements add the constraints that the handlers and models check to the schemas
+// of their types
+var openAPIRefinements = map[reflect.Type]func(*openapi.Schema){
+	reflect.TypeOf(models.User{}): func(s *openapi.Schema) {
+		s.Properties["id"].ReadOnly = true
+		s.Properties["phone_number_raw"].ReadOnly = true
+		s.Properties["name"].MinLength = openapi.Int(1)
+		s.Properties["age"].Minimum = openapi.Float(1)
+		s.Properties["phone_number"].MinLength = openapi.Int(1)
+		s.Properties["phone_number"].MaxLength = openapi.Int(models.MaxPhoneNumberLength)
+		s.Properties["email"].Format = "email"
+	},
+	reflect.TypeOf(BatchRequest{}): func(s *openapi.Schema) {
+		s.Required = []string{"operations"}
+		s.Properties["mode"].Enum = []interface{}{"atomic", "best_effort"}
+		s.Properties["mode"].Description = "atomic (the default) or best_effort"
+		s.Properties["operations"].MinItems = openapi.Int(1)
+		s.Properties["operations"].MaxItems = openapi.Int(maxBatchOperations)
+	},
+	reflect.TypeOf(BatchOperationRequest{}): func(s *openapi.Schema) {
+		// an invalid operation fails only itself, which the batch reports, so operations are
+		// not checked beyond their types here
+		s.Required = nil
+		s.Properties["op"].Description = "create, update, upsert or delete"
+		s.Properties["user"] = &openapi.Schema{Type: openapi.Types{"object", "null"}, Description: "A User; an invalid one fails only its operation"}
+	},
+	reflect.TypeOf(RevertRequest{}): func(s *openapi.Schema) {
+		s.Properties["revision"].Minimum = openapi.Float(1)
+	},
+	reflect.TypeOf(WebhookRequest{}): func(s *openapi.Schema) {
+		s.Required = []string{"url"}
+		s.Properties["url"].Format = "uri"
+		events := make([]interface{}, len(webhooks.EventTypes))
+		for i, event := range webhooks.EventTypes {
+			events[i] = event
+		}
+		s.Properties["events"].Items.Enum = events
+	},
+	reflect.TypeOf(GraphQLRequest{}): func(s *openapi.Schema) {
+		s.Required = []string{"query"}
+	},
+}
+
+// GenerateOpenAPI builds the OpenAPI document of the routes of router, which must be the
+// routes registered in main
+func GenerateOpenAPI(router *mux.Router) (*openapi.Document, error) {
+	routes := openAPIRoutes()
+	withCommonResponses(routes)
+	var mediaTypes []string
+	for _, c := range codecs {
+		mediaTypes = append(mediaTypes, c.contentType())
+	}
+	doc, err := openapi.Generate(router, openapi.Options{
+		Info:       openAPIInfo,
+		Routes:     routes,
+		MediaTypes: mediaTypes,
+		Refine:     openAPIRefinements,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate OpenAPI document: %w", err)
+	}
+	return doc, nil
+}
+
+// OpenAPIHandler serves the OpenAPI document. The document is generated from the router the
+// handler is registered on, so it is set once every route has been registered.
+type OpenAPIHandler struct {
+	body []byte
+}
+
+// NewOpenAPIHandler creates a handler without a document
+func NewOpenAPIHandler() *OpenAPIHandler {
+	return &OpenAPIHandler{}
+}
+
+// SetDocument sets the document to serve
+func (h *OpenAPIHandler) SetDocument(doc *openapi.Document) error {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode OpenAPI document: %w", err)
+	}
+	h.body = body
+	return nil
+}
+
+// Serve handles the OpenAPI document request
+// @Summary OpenAPI document
+// @Description Get the OpenAPI 3.1 document of this API, generated from its routes and types
+// @Tags docs
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /openapi.json [get]
+func (h *OpenAPIHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.body)
+}
+
+// OpenAPIValidationMiddleware rejects requests whose parameters or JSON body do not match
+// doc with 400, and JSON bodies larger than maxBody bytes with 413. With validateResponses, JSON responses are checked too, and one that does
+// not match, or has a status the document does not list, is logged and replaced by a 500
+// so that drift between the handlers and the document shows up in development.
+func OpenAPIValidationMiddleware(doc *openapi.Document, maxBody int64, validateResponses bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			template, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			op := doc.Operation(r.Method, template)
+			if op == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := validateRequest(doc, op, w, r, maxBody); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					respondWithReadError(w, r, err)
+					return
+				}
+				log.Printf("Request %s %s does not match the OpenAPI document: %v", r.Method, r.URL.Path, err)
+				respondWithError(w, r, http.StatusBadRequest, "Invalid request: "+err.Error())
+				return
+			}
+			if !validateResponses || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			vw := &validatingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(vw, r)
+			vw.finish(doc, op, r)
+		})
+	}
+}
+
+// validateRequest checks the parameters of r and, if it is sent as JSON, its body, which is
+// read up to maxBody bytes. Bodies in other media types are left to the handlers.
+func validateRequest(doc *openapi.Document, op *openapi.Operation, w http.ResponseWriter, r *http.Request, maxBody int64) error {
+	if err := doc.ValidateParameters(op, r, mux.Vars(r)); err != nil {
+		return err
+	}
+
+	mediaType := codecs[0].contentType()
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ = mime.ParseMediaType(contentType)
+	}
+	schema := op.RequestSchema(mediaType)
+	if mediaType != codecs[0].contentType() || schema == nil || r.Body == nil {
+		return nil
+	}
+	body, err := readBody(w, r, maxBody)
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &openapi.ValidationError{Problems: []string{"body is required"}}
+	}
+	return doc.ValidateJSON(schema, body, openapi.InRequest)
+}
+
+// validatingResponseWriter holds back JSON responses until they have been checked. Other
+// responses, such as streams and files, are passed through and only their status is
+// checked.
+type validatingResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	buffering bool
+	buf       bytes.Buffer
+}
+
+func (w *validatingResponseWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	w.buffering = mediaType == codecs[0].contentType()
+	if !w.buffering {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *validatingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController flush streamed responses, which are not held back
+func (w *validatingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish checks the response and sends it if it was held back
+func (w *validatingResponseWriter) finish(doc *openapi.Document, op *openapi.Operation, r *http.Request) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	schema, documented := op.ResponseSchema(w.status, codecs[0].contentType())
+	var err error
+	switch {
+	case !documented:
+		err = fmt.Errorf("status %d is not documented", w.status)
+	case w.buffering && schema != nil:
+		err = doc.ValidateJSON(schema, w.buf.Bytes(), openapi.InResponse)
+	}
+	if err != nil {
+		log.Printf("Response to %s %s does not match the OpenAPI document: %v", r.Method, r.URL.Path, err)
+	}
+	if !w.buffering {
+		return
+	}
+	if err != nil {
+		w.Header().Del("Content-Length")
+		respondWithError(w.ResponseWriter, r, http.StatusInternalServerError, "Response does not match the API document: "+err.Error())
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
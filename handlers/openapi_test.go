@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"userapi/openapi"
+	"userapi/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// newOpenAPIDocument generates the document of a router with every described route, as
+// main registers them
+func newOpenAPIDocument(t *testing.T) *openapi.Document {
+	router := mux.NewRouter()
+	noop := func(http.ResponseWriter, *http.Request) {}
+	for key := range openAPIRoutes() {
+		method, path, _ := strings.Cut(key, " ")
+		router.HandleFunc(path, noop).Methods(method)
+	}
+	doc, err := GenerateOpenAPI(router)
+	if err != nil {
+		t.Fatalf("GenerateOpenAPI() error = %v", err)
+	}
+	return doc
+}
+
+func TestGenerateOpenAPI(t *testing.T) {
+	doc := newOpenAPIDocument(t)
+
+	if op := doc.Operation("GET", "/debug/vars"); op != nil {
+		t.Error("hidden route /debug/vars is documented")
+	}
+	create := doc.Operation("POST", "/users")
+	if create == nil {
+		t.Fatal("POST /users is not documented")
+	}
+	for _, status := range []string{"201", "400", "406", "409", "413", "415", "422", "500", "503"} {
+		if create.Responses[status] == nil {
+			t.Errorf("POST /users does not document status %s", status)
+		}
+	}
+	user := doc.Components.Schemas["User"]
+	if user == nil || !user.Properties["id"].ReadOnly || *user.Properties["phone_number"].MaxLength == 0 {
+		t.Errorf("User = %+v, want a read-only id and a bounded phone number", user)
+	}
+
+	handler := NewOpenAPIHandler()
+	if err := handler.SetDocument(doc); err != nil {
+		t.Fatalf("SetDocument() error = %v", err)
+	}
+	w := httptest.NewRecorder()
+	handler.Serve(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	var served map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil || served["openapi"] != openapi.Version {
+		t.Errorf("served document = %.100s, want an OpenAPI %s document", w.Body, openapi.Version)
+	}
+}
+
+func TestOpenAPIValidationMiddleware_Requests(t *testing.T) {
+	doc := newOpenAPIDocument(t)
+	userHandler := NewUserHandler(repository.NewMemoryUserRepository())
+	router := mux.NewRouter()
+	router.HandleFunc("/users", userHandler.Create).Methods("POST")
+	router.HandleFunc("/users/search", userHandler.Search).Methods("GET")
+	router.HandleFunc("/users/{id}", userHandler.GetByID).Methods("GET")
+	router.Use(OpenAPIValidationMiddleware(doc, 1<<10, true))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "valid body", method: "POST", path: "/users", body: userJSON(1, "Ann"), wantStatus: http.StatusCreated},
+		{name: "missing field", method: "POST", path: "/users", body: `{"name":"Ann","age":30,"email":"ann@example.com"}`, wantStatus: http.StatusBadRequest, wantError: "body.phone_number is required"},
+		{name: "wrong type", method: "POST", path: "/users", body: `{"name":"Ann","age":"30","phone_number":"+14155552671","email":"ann@example.com"}`, wantStatus: http.StatusBadRequest, wantError: "body.age must be of type integer"},
+		{name: "empty body", method: "POST", path: "/users", wantStatus: http.StatusBadRequest, wantError: "body is required"},
+		{name: "body too large", method: "POST", path: "/users", body: `{"name":"` + strings.Repeat("a", 1<<10) + `"}`, wantStatus: http.StatusRequestEntityTooLarge, wantError: "at most 1024 bytes"},
+		{name: "invalid path parameter", method: "GET", path: "/users/abc", wantStatus: http.StatusBadRequest, wantError: "path parameter id must be of type integer"},
+		{name: "missing query parameter", method: "GET", path: "/users/search", wantStatus: http.StatusBadRequest, wantError: "query parameter q is required"},
+		{name: "valid parameters", method: "GET", path: "/users/1", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.path, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantError != "" && !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Errorf("body = %s, want an error mentioning %q", w.Body, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestOpenAPIValidationMiddleware_Responses(t *testing.T) {
+	doc := newOpenAPIDocument(t)
+	var status int
+	var body string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		validate   bool
+		wantStatus int
+	}{
+		{name: "matching response", status: http.StatusOK, body: `{"id":1,"name":"Ann","age":30,"phone_number":"+14155552671","email":"ann@example.com"}`, validate: true, wantStatus: http.StatusOK},
+		{name: "response missing a field", status: http.StatusOK, body: `{"id":1,"name":"Ann"}`, validate: true, wantStatus: http.StatusInternalServerError},
+		{name: "undocumented status", status: http.StatusTeapot, body: `{"error":"teapot"}`, validate: true, wantStatus: http.StatusInternalServerError},
+		{name: "responses not validated", status: http.StatusOK, body: `{"id":1,"name":"Ann"}`, validate: false, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body = tt.status, tt.body
+			router := mux.NewRouter()
+			router.HandleFunc("/users/{id}", handler).Methods("GET")
+			router.Use(OpenAPIValidationMiddleware(doc, 1<<10, tt.validate))
+
+			w := serve(router, "GET", "/users/1", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == tt.status && w.Body.String() != tt.body {
+				t.Errorf("body = %s, want the handler's response %s", w.Body, tt.body)
+			}
+		})
+	}
+}
+
+// TestOpenAPIRoutes_Annotations checks that every route annotated for swag is described in
+// openAPIRoutes under the summary of one of its handlers, so that the two cannot drift apart.
+// GET /users/{id} is served by two handlers, depending on as_of.
+func TestOpenAPIRoutes_Annotations(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	summaries := map[string][]string{}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("ParseFile(%s) error = %v", file, err)
+		}
+		for _, group := range f.Comments {
+			var summary, route string
+			for _, c := range group.List {
+				line := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+				if s, ok := strings.CutPrefix(line, "@Summary "); ok {
+					summary = strings.TrimSpace(s)
+				}
+				if r, ok := strings.CutPrefix(line, "@Router "); ok {
+					route = strings.TrimSpace(r)
+				}
+			}
+			if route == "" {
+				continue
+			}
+			path, method, _ := strings.Cut(route, " ")
+			key := strings.ToUpper(strings.Trim(method, "[]")) + " " + path
+			summaries[key] = append(summaries[key], summary)
+		}
+	}
+	if len(summaries) == 0 {
+		t.Fatal("no annotated routes found")
+	}
+
+	routes := openAPIRoutes()
+	for key, annotated := range summaries {
+		rd, ok := routes[key]
+		if !ok {
+			t.Errorf("route %s is annotated but not described in openAPIRoutes", key)
+			continue
+		}
+		if !slices.Contains(annotated, rd.Summary) {
+			t.Errorf("route %s is described as %q, want one of its annotated summaries %q", key, rd.Summary, annotated)
+		}
+	}
+}
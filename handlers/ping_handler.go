@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 )
 
@@ -13,18 +12,18 @@ func NewPingHandler() *PingHandler {
 	return &PingHandler{}
 }
 
+// PingResponse is the body of a ping response
+type PingResponse struct {
+	Message string `json:"message" xml:"message"`
+}
+
 // Ping handles the ping request
 // @Summary Health check endpoint
 // @Description Returns a simple pong message to verify the API is running
 // @Tags health
 // @Produce json
-// @Success 200 {object} map[string]string
+// @Success 200 {object} PingResponse
 // @Router /ping [get]
 func (h *PingHandler) Ping(w http.ResponseWriter, r *http.Request) {
-	response := map[string]string{
-		"message": "pong",
-	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-} 
\ No newline at end of file
+	respond(w, r, http.StatusOK, PingResponse{Message: "pong"})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+)
+
+// ReadinessCheck returns nil when a dependency of the service can be used
+type ReadinessCheck func() error
+
+// ReadyHandler reports whether the service can serve requests
+type ReadyHandler struct {
+	names  []string
+	checks map[string]ReadinessCheck
+}
+
+// NewReadyHandler creates a readiness handler without checks, which is always ready
+func NewReadyHandler() *ReadyHandler {
+	return &ReadyHandler{checks: make(map[string]ReadinessCheck)}
+}
+
+// AddCheck makes readiness depend on check, reported under name
+func (h *ReadyHandler) AddCheck(name string, check ReadinessCheck) {
+	h.names = append(h.names, name)
+	h.checks[name] = check
+}
+
+// ReadyResponse is the body of a readiness response
+type ReadyResponse struct {
+	Status string `json:"status" xml:"status"`
+	// Checks maps the failing checks to why they fail
+	Checks map[string]string `json:"checks,omitempty" xml:"-"`
+}
+
+// Ready handles the readiness request
+// @Summary Readiness check endpoint
+// @Description Returns 200 once the service can serve requests, and 503 while a dependency such as the database cannot be reached
+// @Tags health
+// @Produce json
+// @Success 200 {object} ReadyResponse
+// @Failure 503 {object} ReadyResponse
+// @Router /ready [get]
+func (h *ReadyHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	resp := ReadyResponse{Status: "ready"}
+	for _, name := range h.names {
+		if err := h.checks[name](); err != nil {
+			if resp.Checks == nil {
+				resp.Checks = make(map[string]string)
+			}
+			resp.Checks[name] = err.Error()
+		}
+	}
+	if resp.Checks != nil {
+		resp.Status = "not ready"
+		log.Printf("Not ready: %v", resp.Checks)
+		respond(w, r, http.StatusServiceUnavailable, resp)
+		return
+	}
+	respond(w, r, http.StatusOK, resp)
+}
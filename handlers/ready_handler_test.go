@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyHandler(t *testing.T) {
+	var dbErr error
+	handler := NewReadyHandler()
+	handler.AddCheck("database", func() error { return dbErr })
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantBody   ReadyResponse
+	}{
+		{"ready", nil, http.StatusOK, ReadyResponse{Status: "ready"}},
+		{
+			"database down", errors.New("connection refused"), http.StatusServiceUnavailable,
+			ReadyResponse{Status: "not ready", Checks: map[string]string{"database": "connection refused"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbErr = tt.err
+			w := httptest.NewRecorder()
+			handler.Ready(w, httptest.NewRequest("GET", "/ready", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			var got ReadyResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("Could not decode response body: %v", err)
+			}
+			if got.Status != tt.wantBody.Status || got.Checks["database"] != tt.wantBody.Checks["database"] {
+				t.Errorf("body = %+v, want %+v", got, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"userapi/recording"
+)
+
+// RecordingMiddleware writes every request and its response to w, sanitized by s, for the
+// replay command to send again. Bodies longer than maxBody bytes are left out. WebSocket
+// connections and event streams are not recorded, as they cannot be replayed as a request
+// and a response.
+func RecordingMiddleware(w *recording.Writer, s *recording.Sanitizer, maxBody int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			start := time.Now()
+			body := &recordingBody{ReadCloser: r.Body, limit: maxBody}
+			if r.Body == nil || r.Body == http.NoBody {
+				body.eof = true
+			} else {
+				r.Body = body
+			}
+			rec := &recordingResponseWriter{ResponseWriter: rw, status: http.StatusOK, limit: maxBody}
+			next.ServeHTTP(rec, r)
+
+			contentType := rec.Header().Get("Content-Type")
+			if strings.HasPrefix(contentType, "text/event-stream") {
+				return
+			}
+
+			e := &recording.Exchange{
+				Time:     start.UTC(),
+				Duration: float64(time.Since(start).Microseconds()) / 1000,
+				Request: recording.Request{
+					Method: r.Method,
+					URI:    s.URI(r.URL.RequestURI()),
+					Header: s.Header(r.Header),
+				},
+				Response: recording.Response{
+					Status: rec.status,
+					Header: s.Header(rec.Header()),
+				},
+			}
+			// decoders stop at the end of the value without reading to the end of the body,
+			// so the rest is read here, as the server would to reuse the connection
+			if !body.eof && !body.truncated {
+				io.Copy(io.Discard, io.LimitReader(body, int64(maxBody)+1))
+			}
+			if body.eof && !body.truncated {
+				e.Request.Body, e.Request.BodyOmitted = sanitizedBody(s, r.Header.Get("Content-Type"), body.buf.Bytes())
+			} else {
+				e.Request.BodyOmitted = true
+			}
+			if !rec.truncated {
+				e.Response.Body, e.Response.BodyOmitted = sanitizedBody(s, contentType, rec.buf.Bytes())
+			} else {
+				e.Response.BodyOmitted = true
+			}
+
+			if err := w.Write(e); err != nil {
+				log.Printf("Error recording %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		})
+	}
+}
+
+// sanitizedBody returns the sanitized body, or whether it had to be left out
+func sanitizedBody(s *recording.Sanitizer, contentType string, body []byte) (string, bool) {
+	sanitized, ok := s.Body(contentType, body)
+	return sanitized, !ok
+}
+
+// recordingBody keeps a copy of the first bytes of a request body as the handler reads it
+type recordingBody struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+	eof       bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.keep(p[:n])
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *recordingBody) keep(p []byte) {
+	if b.truncated {
+		return
+	}
+	if b.buf.Len()+len(p) > b.limit {
+		b.truncated = true
+		b.buf.Reset()
+		return
+	}
+	b.buf.Write(p)
+}
+
+// recordingResponseWriter passes a response through while keeping a copy of its first bytes
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingResponseWriter) Write(p []byte) (int, error) {
+	if !w.truncated {
+		if w.buf.Len()+len(p) > w.limit {
+			w.truncated = true
+			w.buf.Reset()
+		} else {
+			w.buf.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController flush event streams through the recorder
+func (w *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"userapi/recording"
+	"userapi/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// newRecordingRouter serves the /users routes from an in-memory repository, recording them
+// to w if it is set
+func newRecordingRouter(w *recording.Writer, maxBody int) *mux.Router {
+	userHandler := NewUserHandler(repository.NewMemoryUserRepository())
+	router := mux.NewRouter()
+	router.HandleFunc("/users", userHandler.Create).Methods("POST")
+	router.HandleFunc("/users/by-email/{email}", userHandler.GetByEmail).Methods("GET")
+	router.HandleFunc("/users/{id}", userHandler.GetByID).Methods("GET")
+	router.HandleFunc("/users/{id}", userHandler.Update).Methods("PUT")
+	router.HandleFunc("/users/{id}", userHandler.Delete).Methods("DELETE")
+	router.Use(RequestContextMiddleware(TokenAuthenticator(nil)))
+	if w != nil {
+		router.Use(RecordingMiddleware(w, recording.NewSanitizer([]byte("key")), maxBody))
+	}
+	return router
+}
+
+func readRecording(t *testing.T, path string) []recording.Exchange {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open recording: %v", err)
+	}
+	defer f.Close()
+	var exchanges []recording.Exchange
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e recording.Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid exchange %s: %v", scanner.Text(), err)
+		}
+		exchanges = append(exchanges, e)
+	}
+	return exchanges
+}
+
+func TestRecordingMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.ndjson")
+	w, err := recording.Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	router := newRecordingRouter(w, 1024)
+
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(userJSON(1, "Ann")))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	serve(router, "GET", "/users/by-email/user1@example.com", "")
+	serve(router, "PUT", "/users/1", `{"name":"`+strings.Repeat("a", 2000)+`"}`)
+	w.Close()
+
+	exchanges := readRecording(t, path)
+	if len(exchanges) != 3 {
+		t.Fatalf("recorded %d exchanges, want 3", len(exchanges))
+	}
+	raw, _ := os.ReadFile(path)
+	for _, leaked := range []string{"Ann 1", "user1@example.com", "Bearer secret", "2671"} {
+		if strings.Contains(string(raw), leaked) {
+			t.Errorf("recording contains %q", leaked)
+		}
+	}
+
+	create := exchanges[0]
+	if create.Request.Method != "POST" || create.Response.Status != http.StatusCreated || create.Request.Body == "" || create.Response.Body == "" {
+		t.Errorf("create = %+v, want the request and its 201 response", create)
+	}
+	if got := create.Response.Header.Get(RequestIDHeader); got == "" {
+		t.Error("response headers were not recorded")
+	}
+	if lookup := exchanges[1]; !strings.HasPrefix(lookup.Request.URI, "/users/by-email/user-") || lookup.Response.Status != http.StatusOK {
+		t.Errorf("lookup = %s %d, want a pseudonymous email found", lookup.Request.URI, lookup.Response.Status)
+	}
+	if update := exchanges[2]; !update.Request.BodyOmitted || update.Request.Body != "" {
+		t.Errorf("update body omitted = %v, want a body over the limit left out", update.Request.BodyOmitted)
+	}
+}
+
+func TestRecordingMiddleware_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.ndjson")
+	w, err := recording.Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	router := newRecordingRouter(w, 64<<10)
+	serve(router, "POST", "/users", userJSON(1, "Ann"))
+	serve(router, "POST", "/users", userJSON(2, "Bob"))
+	serve(router, "GET", "/users/2", "")
+	serve(router, "GET", "/users/by-email/user1@example.com", "")
+	serve(router, "PUT", "/users/1", userJSON(3, "Carol"))
+	serve(router, "DELETE", "/users/2", "")
+	serve(router, "GET", "/users/2", "")
+	serve(router, "POST", "/users", userJSON(1, "Ann"))
+	w.Close()
+
+	// the target already has a user, so the replayed users get other IDs
+	target := newRecordingRouter(nil, 0)
+	serve(target, "POST", "/users", userJSON(99, "Existing"))
+	server := httptest.NewServer(target)
+	defer server.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open recording: %v", err)
+	}
+	defer f.Close()
+	report, err := recording.Replay(context.Background(), f, recording.ReplayOptions{Target: server.URL})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if report.Matched != 8 || report.Mismatched != 0 {
+		t.Errorf("report = %+v, want all 8 exchanges to match", report)
+	}
+}
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"userapi/csvcell"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// errUnsupportedMediaType is returned by decodeRequest for bodies it cannot decode
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// codec encodes responses and decodes request bodies for one family of media types
+type codec struct {
+	// mediaTypes lists the accepted media types, the first one is used in responses
+	mediaTypes []string
+	encode     func(w io.Writer, v interface{}) error
+	decode     func(r io.Reader, v interface{}) error
+}
+
+// codecs are listed in order of preference for wildcard Accept headers
+var codecs = []*codec{
+	{
+		mediaTypes: []string{"application/json"},
+		encode:     func(w io.Writer, v interface{}) error { return json.NewEncoder(w).Encode(v) },
+		decode:     func(r io.Reader, v interface{}) error { return json.NewDecoder(r).Decode(v) },
+	},
+	{
+		mediaTypes: []string{"application/xml", "text/xml"},
+		encode:     encodeXML,
+		decode:     func(r io.Reader, v interface{}) error { return xml.NewDecoder(r).Decode(v) },
+	},
+	{
+		mediaTypes: []string{"text/csv", "application/csv"},
+		encode:     encodeCSV,
+		decode:     decodeCSV,
+	},
+	{
+		mediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode: func(w io.Writer, v interface{}) error {
+			enc := msgpack.NewEncoder(w)
+			enc.SetCustomStructTag("json")
+			enc.SetOmitEmpty(false)
+			return enc.Encode(v)
+		},
+		decode: func(r io.Reader, v interface{}) error {
+			dec := msgpack.NewDecoder(r)
+			dec.SetCustomStructTag("json")
+			return dec.Decode(v)
+		},
+	},
+}
+
+func (c *codec) contentType() string {
+	return c.mediaTypes[0]
+}
+
+func (c *codec) handles(mediaType string) bool {
+	for _, t := range c.mediaTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate picks the codec and media type for the response from the Accept header.
+// A missing header accepts anything, which yields JSON. Each media type takes the quality of
+// the most specific range that matches it, so "application/json;q=0, */*" excludes JSON.
+// The type with the highest quality wins, then the one whose range comes first in the
+// header, then the one of the codec listed first.
+func negotiate(r *http.Request) (*codec, string, bool) {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return codecs[0], codecs[0].contentType(), true
+	}
+
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []acceptRange
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+			ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+		}
+	}
+
+	var best *codec
+	bestType, bestQ, bestRange := "", 0.0, len(ranges)
+	for _, c := range codecs {
+		for _, t := range c.mediaTypes {
+			match, specificity := -1, -1
+			for i, ar := range ranges {
+				s := rangeSpecificity(ar.mediaType, t)
+				if s > specificity {
+					match, specificity = i, s
+				}
+			}
+			if match < 0 || ranges[match].q <= 0 {
+				continue
+			}
+			if q := ranges[match].q; q > bestQ || (q == bestQ && match < bestRange) {
+				best, bestType, bestQ, bestRange = c, t, q, match
+			}
+		}
+	}
+	return best, bestType, best != nil
+}
+
+// rangeSpecificity returns how specifically the media range accepts mediaType: 2 for the
+// type itself, 1 for its type/* and 0 for */*, or -1 if it does not match
+func rangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	case mediaRange == "*/*":
+		return 0
+	}
+	return -1
+}
+
+// acceptable responds with 406 and returns false when no supported media type is acceptable.
+// Handlers with side effects call it before doing any work.
+func acceptable(w http.ResponseWriter, r *http.Request) bool {
+	if _, _, ok := negotiate(r); ok {
+		return true
+	}
+	log.Printf("No acceptable media type for Accept: %q", r.Header.Get("Accept"))
+	respondWithError(w, r, http.StatusNotAcceptable, "Acceptable media types are "+strings.Join(supportedMediaTypes(), ", "))
+	return false
+}
+
+func supportedMediaTypes() []string {
+	var types []string
+	for _, c := range codecs {
+		types = append(types, c.mediaTypes...)
+	}
+	return types
+}
+
+// decodeRequest decodes the request body according to its Content-Type. Bodies without a
+// Content-Type are treated as JSON. Unknown media types yield errUnsupportedMediaType.
+func decodeRequest(r *http.Request, v interface{}) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return codecs[0].decode(r.Body, v)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errUnsupportedMediaType, contentType)
+	}
+	for _, c := range codecs {
+		if c.handles(mediaType) {
+			return c.decode(r.Body, v)
+		}
+	}
+	return fmt.Errorf("%w: %s", errUnsupportedMediaType, mediaType)
+}
+
+// respondWithDecodeError reports a failure of decodeRequest
+func respondWithDecodeError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, errUnsupportedMediaType) {
+		respondWithError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be one of "+strings.Join(supportedMediaTypes(), ", "))
+		return
+	}
+	respondWithError(w, r, http.StatusBadRequest, message)
+}
+
+// readBody reads the whole request body, failing with a *http.MaxBytesError once it is
+// longer than limit bytes
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+}
+
+// respondWithReadError reports a failure of readBody, with 413 for bodies over the limit
+func respondWithReadError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondWithError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must be at most %d bytes", tooLarge.Limit))
+		return
+	}
+	respondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+}
+
+// respond encodes payload with the codec negotiated from the Accept header. If no
+// supported media type is acceptable it responds with 406 in JSON instead.
+func respond(w http.ResponseWriter, r *http.Request, code int, payload interface{}) {
+	c, mediaType, ok := negotiate(r)
+	if !ok {
+		log.Printf("No acceptable media type for Accept: %q", r.Header.Get("Accept"))
+		c, mediaType = codecs[0], codecs[0].contentType()
+		code = http.StatusNotAcceptable
+		payload = ErrorResponse{Error: "Acceptable media types are " + strings.Join(supportedMediaTypes(), ", ")}
+	}
+
+	var buf bytes.Buffer
+	if err := c.encode(&buf, payload); err != nil {
+		log.Printf("Error encoding %s response: %v", mediaType, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// xmlList wraps slices, which have no root element of their own
+type xmlList struct {
+	XMLName xml.Name      `xml:"items"`
+	Items   []interface{} `xml:"item"`
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		list := xmlList{Items: make([]interface{}, rv.Len())}
+		for i := range list.Items {
+			list.Items[i] = rv.Index(i).Interface()
+		}
+		v = list
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// encodeCSV writes a struct as a header and a single row, and a slice of structs as a
+// header and one row per element. Columns are named after the JSON field names; nested
+// values are written as JSON, and values that a spreadsheet would run as a formula are
+// escaped.
+func encodeCSV(w io.Writer, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	var rows []reflect.Value
+	elemType := rv.Type()
+	if rv.Kind() == reflect.Slice {
+		elemType = rv.Type().Elem()
+		for i := 0; i < rv.Len(); i++ {
+			rows = append(rows, rv.Index(i))
+		}
+	} else {
+		rows = append(rows, rv)
+	}
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot encode %s as CSV", elemType)
+	}
+
+	fields := csvFields(elemType)
+	cw := csv.NewWriter(w)
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(fields))
+	for _, row := range rows {
+		row = reflect.Indirect(row)
+		if !row.IsValid() {
+			continue
+		}
+		for i, f := range fields {
+			cell, err := csvCell(row.Field(f.index))
+			if err != nil {
+				return err
+			}
+			record[i] = csvcell.Escape(cell)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// decodeCSV reads a header and a single row into a struct of scalar fields
+func decodeCSV(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: CSV cannot be decoded into %T", errUnsupportedMediaType, v)
+	}
+	rv = rv.Elem()
+
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) != 2 {
+		return fmt.Errorf("CSV must contain a header and exactly one row, got %d rows", len(records))
+	}
+
+	columns := make(map[string]string, len(records[0]))
+	for i, name := range records[0] {
+		if i < len(records[1]) {
+			columns[strings.TrimSpace(name)] = csvcell.Unescape(records[1][i])
+		}
+	}
+
+	for _, f := range csvFields(rv.Type()) {
+		value, ok := columns[f.name]
+		if !ok {
+			continue
+		}
+		field := rv.Field(f.index)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %q", f.name, value)
+			}
+			field.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %q", f.name, value)
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("%w: CSV cannot be decoded into %T", errUnsupportedMediaType, v)
+		}
+	}
+	return nil
+}
+
+type csvField struct {
+	name  string
+	index int
+}
+
+func csvFields(t reflect.Type) []csvField {
+	var fields []csvField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.Type == reflect.TypeOf(xml.Name{}) {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, csvField{name: name, index: i})
+	}
+	return fields
+}
+
+func csvCell(v reflect.Value) (string, error) {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "", nil
+		}
+	}
+	b, err := json.Marshal(v.Interface())
+	return string(b), err
+}
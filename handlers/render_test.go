@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"userapi/models"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+		wantOK bool
+	}{
+		{name: "no header", accept: "", want: "application/json", wantOK: true},
+		{name: "wildcard", accept: "*/*", want: "application/json", wantOK: true},
+		{name: "xml", accept: "application/xml", want: "application/xml", wantOK: true},
+		{name: "quality order", accept: "application/json;q=0.5, text/csv", want: "text/csv", wantOK: true},
+		{name: "type wildcard", accept: "text/*", want: "text/xml", wantOK: true},
+		{name: "unsupported", accept: "image/png", wantOK: false},
+		{name: "excluded", accept: "application/json;q=0", wantOK: false},
+		{name: "excluded from wildcard", accept: "application/json;q=0, */*", want: "application/xml", wantOK: true},
+		{name: "excluded type wildcard", accept: "text/*;q=0, */*;q=0.5", want: "application/json", wantOK: true},
+		{name: "specific range allowed", accept: "application/*;q=0, application/msgpack", want: "application/msgpack", wantOK: true},
+		{name: "header order breaks ties", accept: "text/csv, application/xml", want: "text/csv", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/users", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			_, mediaType, ok := negotiate(req)
+			if ok != tt.wantOK {
+				t.Fatalf("negotiate() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if mediaType != tt.want {
+				t.Errorf("negotiate() = %v, want %v", mediaType, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserHandler_ContentNegotiation(t *testing.T) {
+	repo := newMockUserRepository()
+	handler := NewUserHandler(repo)
+	user := models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+
+	t.Run("msgpack request and xml response", func(t *testing.T) {
+		body, _ := msgpack.Marshal(map[string]interface{}{
+			"name": user.Name, "age": user.Age, "phone_number": user.PhoneNumber, "email": user.Email,
+		})
+		req := httptest.NewRequest("POST", "/users", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/msgpack")
+		req.Header.Set("Accept", "application/xml")
+		w := httptest.NewRecorder()
+
+		handler.Create(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Create() status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		var got models.User
+		if err := xml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Could not decode XML response: %v", err)
+		}
+		if got.Email != user.Email || got.ID == 0 {
+			t.Errorf("Create() returned %+v", got)
+		}
+	})
+
+	t.Run("csv list", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("Accept", "text/csv")
+		w := httptest.NewRecorder()
+
+		handler.List(w, req)
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Could not decode CSV response: %v", err)
+		}
+		if len(records) != 2 || records[0][0] != "id" || records[1][5] != user.Email {
+			t.Errorf("List() returned CSV %v", records)
+		}
+		if records[1][3] != "'"+user.PhoneNumber {
+			t.Errorf("List() phone number = %q, want it escaped", records[1][4])
+		}
+	})
+
+	t.Run("unsupported accept", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/users", bytes.NewBufferString(`{}`))
+		req.Header.Set("Accept", "image/png")
+		w := httptest.NewRecorder()
+
+		handler.Create(w, req)
+
+		if w.Code != http.StatusNotAcceptable {
+			t.Errorf("Create() status = %v, want %v", w.Code, http.StatusNotAcceptable)
+		}
+		if len(repo.users) != 1 {
+			t.Errorf("repository has %d users, want 1", len(repo.users))
+		}
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/users", bytes.NewBufferString(`name=John`))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		handler.Create(w, req)
+
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Create() status = %v, want %v", w.Code, http.StatusUnsupportedMediaType)
+		}
+	})
+
+	t.Run("csv request with escaped cells", func(t *testing.T) {
+		body := "name,age,phone_number,email\nJane Doe,28,'+14155552672,jane@example.com\n"
+		req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		w := httptest.NewRecorder()
+
+		handler.Create(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Create() status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		var got models.User
+		json.Unmarshal(w.Body.Bytes(), &got)
+		if got.PhoneNumber != "+14155552672" {
+			t.Errorf("Create() phone number = %q, want the quote removed", got.PhoneNumber)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"userapi/reqctx"
+)
+
+// ActorHeader names who a request claims to be made by. It is not verified, so it is only
+// logged; the actor recorded in the history is the one the request authenticates as.
+const ActorHeader = "X-Actor"
+
+// AnonymousActor is recorded for requests that do not authenticate
+const AnonymousActor = reqctx.AnonymousActor
+
+// RequestIDHeader identifies a request across services. One is generated for requests that
+// come without it, and it is echoed on every response.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestContextValueLength = 255
+
+// RequestContextMiddleware stores the actor and request ID of every request in its context.
+// The actor is who the request authenticates as, or AnonymousActor. Requests are not
+// refused for lacking credentials; routes that need them check for themselves.
+func RequestContextMiddleware(authenticate Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := headerValue(r, RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			actor, ok := authenticate(r)
+			if !ok {
+				actor = AnonymousActor
+				if claimed := headerValue(r, ActorHeader); claimed != "" {
+					log.Printf("Request %s claims to be made by %q without authenticating, recording it as %s", id, claimed, actor)
+				}
+			}
+			if len(actor) > maxRequestContextValueLength {
+				actor = actor[:maxRequestContextValueLength]
+			}
+
+			ctx := reqctx.WithActor(r.Context(), actor)
+			ctx = reqctx.WithRequestID(ctx, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireActor refuses anonymous requests to next with 401. It relies on
+// RequestContextMiddleware having authenticated the request.
+func RequireActor(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if actor := reqctx.Actor(r.Context()); actor == "" || actor == AnonymousActor {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="userapi"`)
+			respondWithError(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// headerValue returns the trimmed header, truncated to what the history can store
+func headerValue(r *http.Request, name string) string {
+	value := strings.TrimSpace(r.Header.Get(name))
+	if len(value) > maxRequestContextValueLength {
+		value = value[:maxRequestContextValueLength]
+	}
+	return value
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
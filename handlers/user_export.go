@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"userapi/csvcell"
+	"userapi/models"
+)
+
+// exportFlushInterval is the number of rows written between flushes of the response
+const exportFlushInterval = 1000
+
+// userEncoder writes users to an export stream
+type userEncoder interface {
+	// Begin writes anything that precedes the first user
+	Begin() error
+	Encode(user *models.User) error
+	// End writes anything buffered or trailing
+	End() error
+}
+
+// @Summary Export users
+// @Description Stream all users matching the filters as CSV, NDJSON or columnar JSON. Rows are read from a consistent snapshot and written as they are read, so exports of any size use constant memory. The columnar format writes one JSON object of column arrays per block of up to 1000 users, one block per line.
+// @Tags users
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "csv (default), ndjson or columnar"
+// @Param name query string false "Only users whose name contains this value"
+// @Param email query string false "Only the user with this email"
+// @Param phone query string false "Only users with this phone number, in any format"
+// @Param min_age query int false "Minimum age"
+// @Param max_age query int false "Maximum age"
+// @Success 200 {file} file
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/export [get]
+func (h *UserHandler) Export(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		log.Printf("Error parsing user filter: %v", err)
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	var enc userEncoder
+	var contentType string
+	switch format {
+	case "csv":
+		enc, contentType = &csvUserEncoder{w: csv.NewWriter(w)}, "text/csv"
+	case "ndjson":
+		enc, contentType = &ndjsonUserEncoder{enc: json.NewEncoder(w)}, "application/x-ndjson"
+	case "columnar":
+		enc, contentType = &columnarUserEncoder{enc: json.NewEncoder(w)}, "application/x-ndjson"
+	default:
+		respondWithError(w, r, http.StatusBadRequest, "format must be csv, ndjson or columnar")
+		return
+	}
+
+	// Exports outlive the server's write timeout, so it is lifted for this response
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Could not clear write deadline for export: %v", err)
+	}
+
+	// The response is only started with the first row, so that errors that happen before
+	// anything was written can still be reported properly
+	started := false
+	begin := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+		return enc.Begin()
+	}
+
+	var count int64
+	err = h.repo.Stream(r.Context(), filter, func(user *models.User) error {
+		if err := begin(); err != nil {
+			return err
+		}
+		if err := enc.Encode(user); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushInterval == 0 {
+			return flushExport(enc, rc)
+		}
+		return nil
+	})
+	if err == nil {
+		err = begin()
+	}
+	if err != nil {
+		log.Printf("Error exporting users after %d rows: %v", count, err)
+		if !started {
+			respondWithStoreError(w, r, err, "Error exporting users")
+		}
+		return
+	}
+
+	if err := enc.End(); err != nil {
+		log.Printf("Error finishing user export: %v", err)
+		return
+	}
+	log.Printf("Successfully exported %d users as %s", count, format)
+}
+
+func flushExport(enc userEncoder, rc *http.ResponseController) error {
+	if f, ok := enc.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := rc.Flush(); err != nil && err != http.ErrNotSupported {
+		return err
+	}
+	return nil
+}
+
+type csvUserEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvUserEncoder) Begin() error {
+	return e.w.Write([]string{"id", "name", "age", "phone_number", "email"})
+}
+
+func (e *csvUserEncoder) Encode(user *models.User) error {
+	return e.w.Write(csvcell.Record([]string{
+		strconv.FormatInt(user.ID, 10),
+		user.Name,
+		strconv.Itoa(user.Age),
+		user.PhoneNumber,
+		user.Email,
+	}))
+}
+
+func (e *csvUserEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvUserEncoder) End() error {
+	return e.Flush()
+}
+
+type ndjsonUserEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonUserEncoder) Begin() error { return nil }
+
+func (e *ndjsonUserEncoder) Encode(user *models.User) error {
+	return e.enc.Encode(user)
+}
+
+func (e *ndjsonUserEncoder) End() error { return nil }
+
+// userColumns is one block of the columnar export
+type userColumns struct {
+	ID          []int64  `json:"id"`
+	Name        []string `json:"name"`
+	Age         []int    `json:"age"`
+	PhoneNumber []string `json:"phone_number"`
+	Email       []string `json:"email"`
+}
+
+// columnarUserEncoder buffers at most one block of users, which is written on every flush
+type columnarUserEncoder struct {
+	enc   *json.Encoder
+	block userColumns
+}
+
+func (e *columnarUserEncoder) Begin() error { return nil }
+
+func (e *columnarUserEncoder) Encode(user *models.User) error {
+	e.block.ID = append(e.block.ID, user.ID)
+	e.block.Name = append(e.block.Name, user.Name)
+	e.block.Age = append(e.block.Age, user.Age)
+	e.block.PhoneNumber = append(e.block.PhoneNumber, user.PhoneNumber)
+	e.block.Email = append(e.block.Email, user.Email)
+	return nil
+}
+
+func (e *columnarUserEncoder) Flush() error {
+	if len(e.block.ID) == 0 {
+		return nil
+	}
+	if err := e.enc.Encode(e.block); err != nil {
+		return err
+	}
+	e.block = userColumns{
+		ID:          e.block.ID[:0],
+		Name:        e.block.Name[:0],
+		Age:         e.block.Age[:0],
+		PhoneNumber: e.block.PhoneNumber[:0],
+		Email:       e.block.Email[:0],
+	}
+	return nil
+}
+
+func (e *columnarUserEncoder) End() error {
+	return e.Flush()
+}
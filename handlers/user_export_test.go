@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"userapi/models"
+)
+
+func TestUserHandler_Export(t *testing.T) {
+	repo := newMockUserRepository()
+	for i := 0; i < 1500; i++ {
+		repo.Create(context.Background(), &models.User{
+			Name:        fmt.Sprintf("User %d", i),
+			Age:         30,
+			PhoneNumber: "+14155552671",
+			Email:       fmt.Sprintf("user%d@example.com", i),
+		})
+	}
+	handler := NewUserHandler(repo)
+
+	export := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/users/export"+query, nil)
+		w := httptest.NewRecorder()
+		handler.Export(w, req)
+		return w
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		w := export("?format=csv")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Export() status = %v, want %v", w.Code, http.StatusOK)
+		}
+		records, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Could not parse CSV: %v", err)
+		}
+		if len(records) != 1501 {
+			t.Errorf("CSV has %d records, want 1501", len(records))
+		}
+		if records[1][4] != "user0@example.com" {
+			t.Errorf("first row email = %q, want user0@example.com", records[1][4])
+		}
+		if records[1][3] != "'+14155552671" {
+			t.Errorf("first row phone number = %q, want it escaped as '+14155552671", records[1][3])
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		w := export("?format=ndjson")
+		if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+		}
+		scanner := bufio.NewScanner(w.Body)
+		lines := 0
+		for scanner.Scan() {
+			var user models.User
+			if err := json.Unmarshal(scanner.Bytes(), &user); err != nil {
+				t.Fatalf("Could not decode line %d: %v", lines, err)
+			}
+			lines++
+		}
+		if lines != 1500 {
+			t.Errorf("NDJSON has %d lines, want 1500", lines)
+		}
+	})
+
+	t.Run("columnar", func(t *testing.T) {
+		w := export("?format=columnar")
+		blocks := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(blocks) != 2 {
+			t.Fatalf("columnar export has %d blocks, want 2", len(blocks))
+		}
+		var block userColumns
+		if err := json.Unmarshal([]byte(blocks[1]), &block); err != nil {
+			t.Fatalf("Could not decode block: %v", err)
+		}
+		if len(block.ID) != 500 || len(block.Email) != 500 {
+			t.Errorf("second block has %d ids and %d emails, want 500", len(block.ID), len(block.Email))
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		if w := export("?format=xlsx"); w.Code != http.StatusBadRequest {
+			t.Errorf("Export() status = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		if w := export("?min_age=old"); w.Code != http.StatusBadRequest {
+			t.Errorf("Export() status = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -1,11 +1,13 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"userapi/models"
 	"userapi/repository"
 
@@ -31,29 +33,35 @@ func NewUserHandler(repo repository.UserRepository) *UserHandler {
 // @Failure 500 {object} ErrorResponse
 // @Router /users [post]
 func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r) {
+		return
+	}
+
 	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	if err := decodeRequest(r, &user); err != nil {
 		log.Printf("Error decoding request body: %v", err)
-		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request payload: %v", err))
+		respondWithDecodeError(w, r, err, fmt.Sprintf("Invalid request payload: %v", err))
 		return
 	}
 
 	if err := user.Validate(); err != nil {
 		log.Printf("Validation error for user: %v", err)
-		respondWithError(w, http.StatusBadRequest, err.Error())
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	if err := h.repo.Create(r.Context(), &user); err != nil {
 		log.Printf("Error creating user: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Error creating user")
+		if errors.Is(err, repository.ErrEmailTaken) {
+			respondWithError(w, r, http.StatusConflict, "Email already in use")
+			return
+		}
+		respondWithStoreError(w, r, err, "Error creating user")
 		return
 	}
 
 	log.Printf("Successfully created user with ID: %d", user.ID)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
+	respond(w, r, http.StatusCreated, user)
 }
 
 // @Summary Get a user by ID
@@ -70,25 +78,52 @@ func (h *UserHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
 		log.Printf("Error parsing user ID: %v", err)
-		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		respondWithError(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	user, err := h.repo.GetByID(r.Context(), id)
 	if err != nil {
 		log.Printf("Error retrieving user with ID %d: %v", id, err)
-		respondWithError(w, http.StatusInternalServerError, "Error retrieving user")
+		respondWithStoreError(w, r, err, "Error retrieving user")
 		return
 	}
 	if user == nil {
 		log.Printf("User not found with ID: %d", id)
-		respondWithError(w, http.StatusNotFound, "User not found")
+		respondWithError(w, r, http.StatusNotFound, "User not found")
 		return
 	}
 
 	log.Printf("Successfully retrieved user with ID: %d", id)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	respond(w, r, http.StatusOK, user)
+}
+
+// @Summary Get a user by email
+// @Description Get a user by their email. Emails are compared case-insensitively.
+// @Tags users
+// @Produce json
+// @Param email path string true "Email"
+// @Success 200 {object} models.User
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/by-email/{email} [get]
+func (h *UserHandler) GetByEmail(w http.ResponseWriter, r *http.Request) {
+	email := mux.Vars(r)["email"]
+
+	user, err := h.repo.GetByEmail(r.Context(), email)
+	if err != nil {
+		log.Printf("Error retrieving user with email %s: %v", email, err)
+		respondWithStoreError(w, r, err, "Error retrieving user")
+		return
+	}
+	if user == nil {
+		log.Printf("User not found with email: %s", email)
+		respondWithError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	log.Printf("Successfully retrieved user with email: %s", email)
+	respond(w, r, http.StatusOK, user)
 }
 
 // @Summary Update a user
@@ -104,41 +139,48 @@ func (h *UserHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {object} ErrorResponse
 // @Router /users/{id} [put]
 func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r) {
+		return
+	}
+
 	vars := mux.Vars(r)
 	id, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
 		log.Printf("Error parsing user ID: %v", err)
-		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		respondWithError(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	if err := decodeRequest(r, &user); err != nil {
 		log.Printf("Error decoding request body: %v", err)
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		respondWithDecodeError(w, r, err, "Invalid request payload")
 		return
 	}
 
 	user.ID = id
 	if err := user.Validate(); err != nil {
 		log.Printf("Validation error for user update: %v", err)
-		respondWithError(w, http.StatusBadRequest, err.Error())
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	if err := h.repo.Update(r.Context(), &user); err != nil {
 		log.Printf("Error updating user with ID %d: %v", id, err)
-		if err.Error() == fmt.Sprintf("user not found with ID: %d", id) {
-			respondWithError(w, http.StatusNotFound, "User not found")
+		if errors.Is(err, repository.ErrUserNotFound) {
+			respondWithError(w, r, http.StatusNotFound, "User not found")
+			return
+		}
+		if errors.Is(err, repository.ErrEmailTaken) {
+			respondWithError(w, r, http.StatusConflict, "Email already in use")
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, "Error updating user")
+		respondWithStoreError(w, r, err, "Error updating user")
 		return
 	}
 
 	log.Printf("Successfully updated user with ID: %d", id)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	respond(w, r, http.StatusOK, user)
 }
 
 // @Summary Delete a user
@@ -154,17 +196,17 @@ func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
 		log.Printf("Error parsing user ID: %v", err)
-		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		respondWithError(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	if err := h.repo.Delete(r.Context(), id); err != nil {
 		log.Printf("Error deleting user with ID %d: %v", id, err)
-		if err.Error() == fmt.Sprintf("user not found with ID: %d", id) {
-			respondWithError(w, http.StatusNotFound, "User not found")
+		if errors.Is(err, repository.ErrUserNotFound) {
+			respondWithError(w, r, http.StatusNotFound, "User not found")
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, "Error deleting user")
+		respondWithStoreError(w, r, err, "Error deleting user")
 		return
 	}
 
@@ -173,43 +215,350 @@ func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary List all users
-// @Description Get a list of all users
+// @Description Get a list of all users, optionally filtered. With ids or emails it instead looks up those users, see UserLookupResponse.
 // @Tags users
 // @Produce json
+// @Param ids query string false "Comma-separated user IDs to look up"
+// @Param emails query string false "Comma-separated emails to look up"
+// @Param name query string false "Only users whose name contains this value"
+// @Param email query string false "Only the user with this email"
+// @Param phone query string false "Only users with this phone number, in any format"
+// @Param min_age query int false "Minimum age"
+// @Param max_age query int false "Maximum age"
 // @Success 200 {array} models.User
+// @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /users [get]
 func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
-	users, err := h.repo.List(r.Context())
+	query := r.URL.Query()
+	if query.Has("ids") || query.Has("emails") {
+		h.lookup(w, r)
+		return
+	}
+
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		log.Printf("Error parsing user filter: %v", err)
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, err := h.repo.List(r.Context(), filter)
 	if err != nil {
 		log.Printf("Error retrieving users list: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Error retrieving users")
+		respondWithStoreError(w, r, err, "Error retrieving users")
 		return
 	}
 
 	log.Printf("Successfully retrieved %d users", len(users))
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	respond(w, r, http.StatusOK, users)
+}
+
+// maxLookupValues bounds the number of IDs or emails in a single lookup
+const maxLookupValues = 100
+
+// UserLookupResponse lists the users found by a lookup in the order they were requested,
+// followed by the requested IDs or emails that do not exist
+type UserLookupResponse struct {
+	Users         []*models.User `json:"users" xml:"users>user"`
+	MissingIDs    []int64        `json:"missing_ids,omitempty" xml:"missing_ids>id,omitempty"`
+	MissingEmails []string       `json:"missing_emails,omitempty" xml:"missing_emails>email,omitempty"`
+}
+
+// lookup fetches the users listed in the ids or emails parameter with a single query
+func (h *UserHandler) lookup(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("ids") && query.Has("emails") {
+		respondWithError(w, r, http.StatusBadRequest, "ids and emails cannot be combined")
+		return
+	}
+
+	param := "ids"
+	if query.Has("emails") {
+		param = "emails"
+	}
+	var values []string
+	for _, v := range strings.Split(query.Get(param), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		respondWithError(w, r, http.StatusBadRequest, param+" must not be empty")
+		return
+	}
+	if len(values) > maxLookupValues {
+		respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("at most %d %s are allowed", maxLookupValues, param))
+		return
+	}
+
+	resp := UserLookupResponse{Users: make([]*models.User, 0, len(values))}
+	if param == "ids" {
+		ids := make([]int64, len(values))
+		for i, v := range values {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid user ID: %s", v))
+				return
+			}
+			ids[i] = id
+		}
+
+		users, err := h.repo.GetByIDs(r.Context(), ids)
+		if err != nil {
+			log.Printf("Error retrieving users by ID: %v", err)
+			respondWithStoreError(w, r, err, "Error retrieving users")
+			return
+		}
+		byID := make(map[int64]*models.User, len(users))
+		for _, user := range users {
+			byID[user.ID] = user
+		}
+		for _, id := range ids {
+			if user, ok := byID[id]; ok {
+				resp.Users = append(resp.Users, user)
+			} else {
+				resp.MissingIDs = append(resp.MissingIDs, id)
+			}
+		}
+	} else {
+		users, err := h.repo.GetByEmails(r.Context(), values)
+		if err != nil {
+			log.Printf("Error retrieving users by email: %v", err)
+			respondWithStoreError(w, r, err, "Error retrieving users")
+			return
+		}
+		byEmail := make(map[string]*models.User, len(users))
+		for _, user := range users {
+			if key, err := models.NormalizeEmail(user.Email); err == nil {
+				byEmail[key] = user
+			}
+		}
+		for _, email := range values {
+			key, _ := models.NormalizeEmail(email)
+			if user, ok := byEmail[key]; ok {
+				resp.Users = append(resp.Users, user)
+			} else {
+				resp.MissingEmails = append(resp.MissingEmails, email)
+			}
+		}
+	}
+
+	log.Printf("Successfully looked up %d of %d users", len(resp.Users), len(values))
+	respond(w, r, http.StatusOK, resp)
+}
+
+// parseUserFilter reads the filters shared by the list and export endpoints
+func parseUserFilter(r *http.Request) (repository.UserFilter, error) {
+	query := r.URL.Query()
+	filter := repository.UserFilter{
+		Name:  query.Get("name"),
+		Email: query.Get("email"),
+		Phone: query.Get("phone"),
+	}
+
+	if filter.Phone != "" {
+		if _, err := models.NormalizePhoneNumber(filter.Phone, models.DefaultPhoneRegion); err != nil {
+			return filter, fmt.Errorf("invalid phone: %w", err)
+		}
+	}
+
+	for param, target := range map[string]*int{"min_age": &filter.MinAge, "max_age": &filter.MaxAge} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("%s must be a non-negative integer", param)
+		}
+		*target = n
+	}
+	return filter, nil
+}
+
+// maxBatchOperations bounds the number of operations accepted in a single batch request
+const maxBatchOperations = 1000
+
+// BatchRequest is the payload of a batch request
+type BatchRequest struct {
+	// Mode is either "atomic" (all or nothing) or "best_effort"
+	Mode       string                  `json:"mode" xml:"mode"`
+	Operations []BatchOperationRequest `json:"operations" xml:"operations>operation"`
+}
+
+// BatchOperationRequest is a single create, update, upsert or delete operation
+type BatchOperationRequest struct {
+	Op   string       `json:"op" xml:"op"`
+	ID   int64        `json:"id,omitempty" xml:"id,omitempty"`
+	User *models.User `json:"user,omitempty" xml:"user,omitempty"`
+}
+
+// BatchResponse holds one result per requested operation, in request order
+type BatchResponse struct {
+	Mode    string              `json:"mode" xml:"mode"`
+	Results []BatchItemResponse `json:"results" xml:"results>result"`
+}
+
+// BatchItemResponse is the outcome of a single batch operation
+type BatchItemResponse struct {
+	Status int    `json:"status" xml:"status"`
+	ID     int64  `json:"id,omitempty" xml:"id,omitempty"`
+	Error  string `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// @Summary Create, update and delete users in bulk
+// @Description Apply a list of operations either atomically or on a best-effort basis
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param batch body BatchRequest true "Batch operations"
+// @Success 200 {object} BatchResponse
+// @Failure 400 {object} BatchResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users:batch [post]
+func (h *UserHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r) {
+		return
+	}
+
+	var req BatchRequest
+	if err := decodeRequest(r, &req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		respondWithDecodeError(w, r, err, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = "atomic"
+	}
+	if req.Mode != "atomic" && req.Mode != "best_effort" {
+		respondWithError(w, r, http.StatusBadRequest, "mode must be either atomic or best_effort")
+		return
+	}
+	if len(req.Operations) == 0 {
+		respondWithError(w, r, http.StatusBadRequest, "operations are required")
+		return
+	}
+	if len(req.Operations) > maxBatchOperations {
+		respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("at most %d operations are allowed per batch", maxBatchOperations))
+		return
+	}
+	atomic := req.Mode == "atomic"
+
+	// Operations that fail validation never reach the repository
+	results := make([]BatchItemResponse, len(req.Operations))
+	var ops []repository.BatchOperation
+	var opIndex []int
+	for i, item := range req.Operations {
+		op, err := toBatchOperation(item)
+		if err != nil {
+			log.Printf("Validation error for batch operation %d: %v", i, err)
+			results[i] = BatchItemResponse{Status: http.StatusBadRequest, ID: item.ID, Error: err.Error()}
+			continue
+		}
+		ops = append(ops, op)
+		opIndex = append(opIndex, i)
+	}
+
+	if atomic && len(ops) != len(req.Operations) {
+		for i := range results {
+			if results[i].Status == 0 {
+				results[i] = BatchItemResponse{Status: http.StatusFailedDependency, ID: req.Operations[i].ID, Error: repository.ErrBatchAborted.Error()}
+			}
+		}
+		respond(w, r, http.StatusBadRequest, BatchResponse{Mode: req.Mode, Results: results})
+		return
+	}
+
+	if len(ops) > 0 {
+		repoResults, err := h.repo.Batch(r.Context(), ops, atomic)
+		if err != nil {
+			log.Printf("Error running batch: %v", err)
+			respondWithStoreError(w, r, err, "Error running batch")
+			return
+		}
+		for j, res := range repoResults {
+			if res.ID == 0 {
+				res.ID = ops[j].ID
+			}
+			results[opIndex[j]] = batchItemResponse(ops[j].Type, res)
+		}
+	}
+
+	status := http.StatusOK
+	if atomic {
+		// A failed atomic batch reports the status of the operation that caused it
+		for _, res := range results {
+			if res.Status >= http.StatusBadRequest && res.Status != http.StatusFailedDependency {
+				status = res.Status
+				break
+			}
+		}
+	}
+
+	log.Printf("Successfully processed batch of %d operations", len(req.Operations))
+	respond(w, r, status, BatchResponse{Mode: req.Mode, Results: results})
+}
+
+func toBatchOperation(item BatchOperationRequest) (repository.BatchOperation, error) {
+	op := repository.BatchOperation{Type: repository.BatchOpType(item.Op), ID: item.ID, User: item.User}
+	switch op.Type {
+	case repository.BatchCreate, repository.BatchUpdate, repository.BatchUpsert:
+		if op.User == nil {
+			return op, errors.New("user is required")
+		}
+		if op.Type == repository.BatchUpdate && op.ID <= 0 {
+			return op, errors.New("id is required")
+		}
+		return op, op.User.Validate()
+	case repository.BatchDelete:
+		if op.ID <= 0 {
+			return op, errors.New("id is required")
+		}
+		return op, nil
+	default:
+		return op, fmt.Errorf("unknown op: %q", item.Op)
+	}
+}
+
+func batchItemResponse(opType repository.BatchOpType, res repository.BatchResult) BatchItemResponse {
+	switch {
+	case res.Err == nil && opType == repository.BatchCreate:
+		return BatchItemResponse{Status: http.StatusCreated, ID: res.ID}
+	case res.Err == nil && opType == repository.BatchDelete:
+		return BatchItemResponse{Status: http.StatusNoContent, ID: res.ID}
+	case res.Err == nil:
+		return BatchItemResponse{Status: http.StatusOK, ID: res.ID}
+	case errors.Is(res.Err, repository.ErrBatchAborted):
+		return BatchItemResponse{Status: http.StatusFailedDependency, ID: res.ID, Error: res.Err.Error()}
+	case errors.Is(res.Err, repository.ErrUserNotFound):
+		return BatchItemResponse{Status: http.StatusNotFound, ID: res.ID, Error: "User not found"}
+	case errors.Is(res.Err, repository.ErrEmailTaken):
+		return BatchItemResponse{Status: http.StatusConflict, ID: res.ID, Error: "Email already in use"}
+	default:
+		log.Printf("Batch operation on user %d failed: %v", res.ID, res.Err)
+		return BatchItemResponse{Status: http.StatusInternalServerError, ID: res.ID, Error: "Error processing operation"}
+	}
 }
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error string `json:"error" xml:"error"`
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
+func respondWithError(w http.ResponseWriter, r *http.Request, code int, message string) {
 	log.Printf("Responding with error: %s (status code: %d)", message, code)
-	respondWithJSON(w, code, ErrorResponse{Error: message})
+	respond(w, r, code, ErrorResponse{Error: message})
 }
 
-func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	response, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Error marshaling JSON response: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+// respondWithStoreError responds to a failed repository call: with 503 and Retry-After
+// while the database is considered down, and with 500 otherwise
+func respondWithStoreError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var open *repository.CircuitOpenError
+	if errors.As(err, &open) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.RetryAfter.Seconds()))))
+		respondWithError(w, r, http.StatusServiceUnavailable, "Service temporarily unavailable")
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	w.Write(response)
-} 
\ No newline at end of file
+	respondWithError(w, r, http.StatusInternalServerError, message)
+}
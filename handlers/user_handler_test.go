@@ -4,13 +4,22 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 	"userapi/models"
 	"userapi/repository"
+
+	"github.com/gorilla/mux"
 )
 
+var _ repository.UserRepository = (*mockUserRepository)(nil)
+
 type mockUserRepository struct {
 	users map[int64]*models.User
 }
@@ -34,6 +43,37 @@ func (m *mockUserRepository) GetByID(ctx context.Context, id int64) (*models.Use
 	return nil, nil
 }
 
+func (m *mockUserRepository) GetByIDs(ctx context.Context, ids []int64) ([]*models.User, error) {
+	var users []*models.User
+	for _, id := range ids {
+		if user, exists := m.users[id]; exists {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (m *mockUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	users, err := m.GetByEmails(ctx, []string{email})
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+	return users[0], nil
+}
+
+func (m *mockUserRepository) GetByEmails(ctx context.Context, emails []string) ([]*models.User, error) {
+	var users []*models.User
+	for _, user := range m.users {
+		key, _ := models.NormalizeEmail(user.Email)
+		for _, email := range emails {
+			if normalized, err := models.NormalizeEmail(email); err == nil && normalized == key {
+				users = append(users, user)
+			}
+		}
+	}
+	return users, nil
+}
+
 func (m *mockUserRepository) Update(ctx context.Context, user *models.User) error {
 	if _, exists := m.users[user.ID]; !exists {
 		return nil
@@ -47,7 +87,7 @@ func (m *mockUserRepository) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
-func (m *mockUserRepository) List(ctx context.Context) ([]*models.User, error) {
+func (m *mockUserRepository) List(ctx context.Context, filter repository.UserFilter) ([]*models.User, error) {
 	users := make([]*models.User, 0, len(m.users))
 	for _, user := range m.users {
 		users = append(users, user)
@@ -55,6 +95,59 @@ func (m *mockUserRepository) List(ctx context.Context) ([]*models.User, error) {
 	return users, nil
 }
 
+func (m *mockUserRepository) Stream(ctx context.Context, filter repository.UserFilter, fn func(*models.User) error) error {
+	for id := int64(1); id <= int64(len(m.users)); id++ {
+		if user, exists := m.users[id]; exists {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *mockUserRepository) Search(ctx context.Context, query repository.SearchQuery) ([]repository.SearchResult, error) {
+	results := []repository.SearchResult{}
+	for _, user := range m.users {
+		if strings.Contains(strings.ToLower(user.Name), strings.ToLower(query.Text)) {
+			results = append(results, repository.SearchResult{User: user, Score: 1})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].User.ID < results[j].User.ID })
+	if query.Offset >= len(results) {
+		return nil, nil
+	}
+	results = results[query.Offset:]
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
+
+func (m *mockUserRepository) Batch(ctx context.Context, ops []repository.BatchOperation, atomic bool) ([]repository.BatchResult, error) {
+	results := make([]repository.BatchResult, len(ops))
+	for i, op := range ops {
+		switch op.Type {
+		case repository.BatchCreate, repository.BatchUpsert:
+			m.Create(ctx, op.User)
+			results[i].ID = op.User.ID
+		case repository.BatchUpdate, repository.BatchDelete:
+			results[i].ID = op.ID
+			if _, exists := m.users[op.ID]; !exists {
+				results[i].Err = repository.ErrUserNotFound
+				continue
+			}
+			if op.Type == repository.BatchDelete {
+				delete(m.users, op.ID)
+			} else {
+				op.User.ID = op.ID
+				m.users[op.ID] = op.User
+			}
+		}
+	}
+	return results, nil
+}
+
 func TestUserHandler_Create(t *testing.T) {
 	repo := newMockUserRepository()
 	handler := NewUserHandler(repo)
@@ -69,7 +162,7 @@ func TestUserHandler_Create(t *testing.T) {
 			payload: models.User{
 				Name:        "John Doe",
 				Age:         30,
-				PhoneNumber: "+1234567890",
+				PhoneNumber: "+14155552671",
 				Email:       "john@example.com",
 			},
 			wantStatus: http.StatusCreated,
@@ -79,7 +172,7 @@ func TestUserHandler_Create(t *testing.T) {
 			payload: models.User{
 				Name:        "John Doe",
 				Age:         30,
-				PhoneNumber: "+1234567890",
+				PhoneNumber: "+14155552671",
 				Email:       "invalid-email",
 			},
 			wantStatus: http.StatusBadRequest,
@@ -109,7 +202,7 @@ func TestUserHandler_GetByID(t *testing.T) {
 	user := &models.User{
 		Name:        "John Doe",
 		Age:         30,
-		PhoneNumber: "+1234567890",
+		PhoneNumber: "+14155552671",
 		Email:       "john@example.com",
 	}
 	repo.Create(context.Background(), user)
@@ -139,6 +232,7 @@ func TestUserHandler_GetByID(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest("GET", "/users/"+tt.id, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.id})
 			w := httptest.NewRecorder()
 
 			handler.GetByID(w, req)
@@ -148,4 +242,243 @@ func TestUserHandler_GetByID(t *testing.T) {
 			}
 		})
 	}
-} 
\ No newline at end of file
+} 
+func TestUserHandler_Batch(t *testing.T) {
+	valid := `{"name":"John Doe","age":30,"phone_number":"+14155552671","email":"john@example.com"}`
+
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantStatuses []int
+	}{
+		{
+			name:         "best effort with missing user",
+			body:         `{"mode":"best_effort","operations":[{"op":"create","user":` + valid + `},{"op":"delete","id":999}]}`,
+			wantStatus:   http.StatusOK,
+			wantStatuses: []int{http.StatusCreated, http.StatusNotFound},
+		},
+		{
+			name:         "atomic with invalid operation",
+			body:         `{"mode":"atomic","operations":[{"op":"create","user":` + valid + `},{"op":"update","id":1}]}`,
+			wantStatus:   http.StatusBadRequest,
+			wantStatuses: []int{http.StatusFailedDependency, http.StatusBadRequest},
+		},
+		{
+			name:       "unknown mode",
+			body:       `{"mode":"sometimes","operations":[{"op":"delete","id":1}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewUserHandler(newMockUserRepository())
+			req := httptest.NewRequest("POST", "/users:batch", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handler.Batch(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Batch() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatuses == nil {
+				return
+			}
+			var resp BatchResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Could not decode response body: %v", err)
+			}
+			if len(resp.Results) != len(tt.wantStatuses) {
+				t.Fatalf("Batch() returned %d results, want %d", len(resp.Results), len(tt.wantStatuses))
+			}
+			for i, want := range tt.wantStatuses {
+				if resp.Results[i].Status != want {
+					t.Errorf("result %d status = %v, want %v", i, resp.Results[i].Status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUserHandler_Lookup(t *testing.T) {
+	repo := newMockUserRepository()
+	repo.users[1] = &models.User{ID: 1, Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+	repo.users[2] = &models.User{ID: 2, Name: "Jane Doe", Age: 28, PhoneNumber: "+14155552671", Email: "jane@example.com"}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantIDs     []int64
+		wantMissing int
+	}{
+		{name: "ids in request order", query: "ids=2,3,1", wantStatus: http.StatusOK, wantIDs: []int64{2, 1}, wantMissing: 1},
+		{name: "emails", query: "emails=jane@example.com,nobody@example.com", wantStatus: http.StatusOK, wantIDs: []int64{2}, wantMissing: 1},
+		{name: "invalid id", query: "ids=1,abc", wantStatus: http.StatusBadRequest},
+		{name: "empty ids", query: "ids=", wantStatus: http.StatusBadRequest},
+		{name: "ids and emails", query: "ids=1&emails=john@example.com", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewUserHandler(repo)
+			req := httptest.NewRequest("GET", "/users?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.List(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("List() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp UserLookupResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Could not decode response body: %v", err)
+			}
+			if len(resp.Users) != len(tt.wantIDs) {
+				t.Fatalf("List() returned %d users, want %d", len(resp.Users), len(tt.wantIDs))
+			}
+			for i, id := range tt.wantIDs {
+				if resp.Users[i].ID != id {
+					t.Errorf("user %d ID = %v, want %v", i, resp.Users[i].ID, id)
+				}
+			}
+			if missing := len(resp.MissingIDs) + len(resp.MissingEmails); missing != tt.wantMissing {
+				t.Errorf("List() reported %d missing, want %d", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestUserHandler_GetByEmail(t *testing.T) {
+	repo := newMockUserRepository()
+	repo.users[1] = &models.User{ID: 1, Name: "Bob", Age: 30, PhoneNumber: "+14155552671", Email: "Bob@example.com"}
+
+	tests := []struct {
+		name       string
+		email      string
+		wantStatus int
+	}{
+		{name: "exact match", email: "Bob@example.com", wantStatus: http.StatusOK},
+		{name: "different case", email: "bob@EXAMPLE.com", wantStatus: http.StatusOK},
+		{name: "unknown email", email: "alice@example.com", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewUserHandler(repo)
+			req := httptest.NewRequest("GET", "/users/by-email/"+tt.email, nil)
+			req = mux.SetURLVars(req, map[string]string{"email": tt.email})
+			w := httptest.NewRecorder()
+
+			handler.GetByEmail(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("GetByEmail() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestUserHandler_Search(t *testing.T) {
+	repo := newMockUserRepository()
+	for i, name := range []string{"John Doe", "Jane Doe", "Johnny Smith"} {
+		repo.users[int64(i+1)] = &models.User{ID: int64(i + 1), Name: name, Age: 30, PhoneNumber: "+14155552671", Email: "user@example.com"}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int64
+		wantNext   int
+	}{
+		{name: "first page", query: "q=john&limit=1", wantStatus: http.StatusOK, wantIDs: []int64{1}, wantNext: 1},
+		{name: "last page", query: "q=john&limit=1&offset=1", wantStatus: http.StatusOK, wantIDs: []int64{3}},
+		{name: "no matches", query: "q=alice", wantStatus: http.StatusOK, wantIDs: []int64{}},
+		{name: "missing query", query: "q=+", wantStatus: http.StatusBadRequest},
+		{name: "limit too large", query: "q=john&limit=1000", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewUserHandler(repo)
+			req := httptest.NewRequest("GET", "/users/search?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.Search(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Search() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp SearchResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Could not decode response body: %v", err)
+			}
+			if len(resp.Results) != len(tt.wantIDs) {
+				t.Fatalf("Search() returned %d results, want %d", len(resp.Results), len(tt.wantIDs))
+			}
+			for i, id := range tt.wantIDs {
+				if resp.Results[i].User.ID != id {
+					t.Errorf("result %d ID = %v, want %v", i, resp.Results[i].User.ID, id)
+				}
+			}
+			next := 0
+			if resp.NextOffset != nil {
+				next = *resp.NextOffset
+			}
+			if next != tt.wantNext {
+				t.Errorf("Search() next_offset = %v, want %v", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+// unavailableRepository fails every lookup as if the database were down
+type unavailableRepository struct {
+	*mockUserRepository
+}
+
+func (m unavailableRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	return nil, &repository.CircuitOpenError{RetryAfter: 2500 * time.Millisecond}
+}
+
+func (m unavailableRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return nil, fmt.Errorf("failed to query user: %w", errors.New("bad connection"))
+}
+
+func TestUserHandler_StoreUnavailable(t *testing.T) {
+	handler := NewUserHandler(unavailableRepository{newMockUserRepository()})
+	router := mux.NewRouter()
+	router.HandleFunc("/users/by-email/{email}", handler.GetByEmail).Methods("GET")
+	router.HandleFunc("/users/{id}", handler.GetByID).Methods("GET")
+
+	tests := []struct {
+		name           string
+		path           string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"circuit open", "/users/1", http.StatusServiceUnavailable, "3"},
+		{"other failure", "/users/by-email/john@example.com", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tt.path, nil))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"userapi/models"
+	"userapi/repository"
+)
+
+const (
+	// defaultSearchLimit and maxSearchLimit bound the page size of a search
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchResponse is one page of search results, best matches first
+type SearchResponse struct {
+	Results []SearchHit `json:"results" xml:"results>result"`
+	// NextOffset is the offset of the next page, if there is one
+	NextOffset *int `json:"next_offset,omitempty" xml:"next_offset,omitempty"`
+}
+
+// SearchHit is a user found by a search with its relevance. Scores are only comparable
+// within one search.
+type SearchHit struct {
+	User  *models.User `json:"user" xml:"user"`
+	Score float64      `json:"score" xml:"score"`
+}
+
+// @Summary Search users
+// @Description Find users by partial name, email or phone number. Every word of the query has to match; results are ranked by relevance.
+// @Tags users
+// @Produce json
+// @Param q query string true "Search text"
+// @Param limit query int false "Page size (default 20, at most 100)"
+// @Param offset query int false "Number of results to skip"
+// @Success 200 {object} SearchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/search [get]
+func (h *UserHandler) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	text := strings.TrimSpace(query.Get("q"))
+	if text == "" {
+		respondWithError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit, offset := defaultSearchLimit, 0
+	for param, target := range map[string]*int{"limit": &limit, "offset": &offset} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("%s must be a non-negative integer", param))
+			return
+		}
+		*target = n
+	}
+	if limit == 0 || limit > maxSearchLimit {
+		respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+		return
+	}
+
+	// one extra result tells whether there is a next page
+	results, err := h.repo.Search(r.Context(), repository.SearchQuery{Text: text, Limit: limit + 1, Offset: offset})
+	if err != nil {
+		log.Printf("Error searching users for %q: %v", text, err)
+		respondWithStoreError(w, r, err, "Error searching users")
+		return
+	}
+
+	resp := SearchResponse{Results: make([]SearchHit, 0, len(results))}
+	if len(results) > limit {
+		results = results[:limit]
+		next := offset + limit
+		resp.NextOffset = &next
+	}
+	for _, result := range results {
+		resp.Results = append(resp.Results, SearchHit{User: result.User, Score: result.Score})
+	}
+
+	log.Printf("Search for %q returned %d users", text, len(resp.Results))
+	respond(w, r, http.StatusOK, resp)
+}
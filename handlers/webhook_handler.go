@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"userapi/webhooks"
+
+	"github.com/gorilla/mux"
+)
+
+// maxWebhookDeliveries bounds the delivery log returned for a subscription
+const maxWebhookDeliveries = 100
+
+type WebhookHandler struct {
+	store webhooks.Store
+}
+
+func NewWebhookHandler(store webhooks.Store) *WebhookHandler {
+	return &WebhookHandler{store: store}
+}
+
+// WebhookRequest creates or changes a subscription. Active defaults to true.
+type WebhookRequest struct {
+	URL    string   `json:"url" xml:"url"`
+	Events []string `json:"events" xml:"events>event"`
+	Active *bool    `json:"active,omitempty" xml:"active,omitempty"`
+}
+
+// @Summary Create a webhook subscription
+// @Description Subscribe a URL to user events. Deliveries are signed with the returned secret, which is not shown again.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param webhook body WebhookRequest true "Subscription"
+// @Success 201 {object} webhooks.Subscription
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks [post]
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r) {
+		return
+	}
+
+	var req WebhookRequest
+	if err := decodeRequest(r, &req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		respondWithDecodeError(w, r, err, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+
+	now := time.Now().UTC()
+	s := &webhooks.Subscription{URL: req.URL, Events: req.Events, Active: req.Active == nil || *req.Active, CreatedAt: now, UpdatedAt: now}
+	if s.Events == nil {
+		s.Events = []string{}
+	}
+	if err := s.Validate(); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		log.Printf("Error generating webhook secret: %v", err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error creating webhook")
+		return
+	}
+	s.Secret = secret
+
+	if err := h.store.CreateSubscription(r.Context(), s); err != nil {
+		log.Printf("Error creating webhook subscription: %v", err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error creating webhook")
+		return
+	}
+
+	log.Printf("Successfully created webhook subscription with ID: %d", s.ID)
+	w.Header().Set("Location", fmt.Sprintf("/webhooks/%d", s.ID))
+	respond(w, r, http.StatusCreated, s)
+}
+
+// @Summary List webhook subscriptions
+// @Tags webhooks
+// @Produce json
+// @Success 200 {array} webhooks.Subscription
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks [get]
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.store.ListSubscriptions(r.Context())
+	if err != nil {
+		log.Printf("Error retrieving webhook subscriptions: %v", err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error retrieving webhooks")
+		return
+	}
+	for _, s := range subscriptions {
+		s.Secret = ""
+	}
+	respond(w, r, http.StatusOK, subscriptions)
+}
+
+// @Summary Get a webhook subscription
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Success 200 {object} webhooks.Subscription
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [get]
+func (h *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s, err := h.store.GetSubscription(r.Context(), id)
+	if err != nil {
+		log.Printf("Error retrieving webhook subscription %d: %v", id, err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error retrieving webhook")
+		return
+	}
+	if s == nil {
+		respondWithError(w, r, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	s.Secret = ""
+	respond(w, r, http.StatusOK, s)
+}
+
+// @Summary Update a webhook subscription
+// @Description Change the URL, event filter or active flag of a subscription. The secret stays the same.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Param webhook body WebhookRequest true "Subscription"
+// @Success 200 {object} webhooks.Subscription
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [put]
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r) {
+		return
+	}
+	id, ok := webhookID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req WebhookRequest
+	if err := decodeRequest(r, &req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		respondWithDecodeError(w, r, err, fmt.Sprintf("Invalid request payload: %v", err))
+		return
+	}
+
+	s := &webhooks.Subscription{ID: id, URL: req.URL, Events: req.Events, Active: req.Active == nil || *req.Active, UpdatedAt: time.Now().UTC()}
+	if s.Events == nil {
+		s.Events = []string{}
+	}
+	if err := s.Validate(); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.store.UpdateSubscription(r.Context(), s); err != nil {
+		log.Printf("Error updating webhook subscription %d: %v", id, err)
+		if errors.Is(err, webhooks.ErrSubscriptionNotFound) {
+			respondWithError(w, r, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, "Error updating webhook")
+		return
+	}
+
+	log.Printf("Successfully updated webhook subscription with ID: %d", id)
+	h.Get(w, r)
+}
+
+// @Summary Delete a webhook subscription
+// @Description Delete a subscription together with its deliveries
+// @Tags webhooks
+// @Param id path int true "Subscription ID"
+// @Success 204 "No Content"
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [delete]
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.store.DeleteSubscription(r.Context(), id); err != nil {
+		log.Printf("Error deleting webhook subscription %d: %v", id, err)
+		if errors.Is(err, webhooks.ErrSubscriptionNotFound) {
+			respondWithError(w, r, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, "Error deleting webhook")
+		return
+	}
+
+	log.Printf("Successfully deleted webhook subscription with ID: %d", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary List the deliveries of a webhook subscription
+// @Description List the latest deliveries of a subscription, newest first
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Success 200 {array} webhooks.Delivery
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	s, err := h.store.GetSubscription(r.Context(), id)
+	if err == nil && s == nil {
+		respondWithError(w, r, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	var deliveries []*webhooks.Delivery
+	if err == nil {
+		deliveries, err = h.store.ListDeliveries(r.Context(), id, maxWebhookDeliveries)
+	}
+	if err != nil {
+		log.Printf("Error retrieving deliveries of webhook %d: %v", id, err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error retrieving deliveries")
+		return
+	}
+	respond(w, r, http.StatusOK, deliveries)
+}
+
+// @Summary Get a webhook delivery
+// @Description Get a delivery with the log of its attempts
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Param delivery path int true "Delivery ID"
+// @Success 200 {object} webhooks.Delivery
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id}/deliveries/{delivery} [get]
+func (h *WebhookHandler) Delivery(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r, "id")
+	if !ok {
+		return
+	}
+	deliveryID, ok := webhookID(w, r, "delivery")
+	if !ok {
+		return
+	}
+
+	d, err := h.store.GetDelivery(r.Context(), id, deliveryID)
+	if err != nil {
+		log.Printf("Error retrieving webhook delivery %d: %v", deliveryID, err)
+		respondWithError(w, r, http.StatusInternalServerError, "Error retrieving delivery")
+		return
+	}
+	if d == nil {
+		respondWithError(w, r, http.StatusNotFound, "Delivery not found")
+		return
+	}
+	respond(w, r, http.StatusOK, d)
+}
+
+// @Summary Redeliver a webhook
+// @Description Queue a delivery again, for example after it went dead, with a fresh set of attempts
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Param delivery path int true "Delivery ID"
+// @Success 202 {object} webhooks.Delivery
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id}/deliveries/{delivery}/redeliver [post]
+func (h *WebhookHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r, "id")
+	if !ok {
+		return
+	}
+	deliveryID, ok := webhookID(w, r, "delivery")
+	if !ok {
+		return
+	}
+
+	d, err := h.store.Redeliver(r.Context(), id, deliveryID)
+	if err != nil {
+		log.Printf("Error redelivering webhook delivery %d: %v", deliveryID, err)
+		if errors.Is(err, webhooks.ErrDeliveryNotFound) {
+			respondWithError(w, r, http.StatusNotFound, "Delivery not found")
+			return
+		}
+		respondWithError(w, r, http.StatusInternalServerError, "Error redelivering webhook")
+		return
+	}
+
+	log.Printf("Queued webhook delivery %d for redelivery", deliveryID)
+	respond(w, r, http.StatusAccepted, d)
+}
+
+// webhookID parses the named path variable, responding with 400 if it is not an ID
+func webhookID(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing %s: %v", name, err)
+		what := name
+		if name == "id" {
+			what = "webhook"
+		}
+		respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid %s ID", what))
+		return 0, false
+	}
+	return id, true
+}
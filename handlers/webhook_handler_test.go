@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"userapi/webhooks"
+
+	"github.com/gorilla/mux"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	handler := NewWebhookHandler(webhooks.NewMemoryStore())
+
+	tests := []struct {
+		name       string
+		method     string
+		vars       map[string]string
+		body       string
+		serve      func(http.ResponseWriter, *http.Request)
+		wantStatus int
+		wantSecret bool
+	}{
+		{"create", "POST", nil, `{"url":"https://partner.example.com/hooks","events":["UserCreated"]}`, handler.Create, http.StatusCreated, true},
+		{"create with unknown event", "POST", nil, `{"url":"https://partner.example.com/hooks","events":["UserMoved"]}`, handler.Create, http.StatusBadRequest, false},
+		{"create with relative url", "POST", nil, `{"url":"/hooks"}`, handler.Create, http.StatusBadRequest, false},
+		{"get", "GET", map[string]string{"id": "1"}, "", handler.Get, http.StatusOK, false},
+		{"get unknown", "GET", map[string]string{"id": "9"}, "", handler.Get, http.StatusNotFound, false},
+		{"update", "PUT", map[string]string{"id": "1"}, `{"url":"https://partner.example.com/v2","active":false}`, handler.Update, http.StatusOK, false},
+		{"deliveries", "GET", map[string]string{"id": "1"}, "", handler.Deliveries, http.StatusOK, false},
+		{"redeliver unknown", "POST", map[string]string{"id": "1", "delivery": "9"}, "", handler.Redeliver, http.StatusNotFound, false},
+		{"delete", "DELETE", map[string]string{"id": "1"}, "", handler.Delete, http.StatusNoContent, false},
+		{"delete again", "DELETE", map[string]string{"id": "1"}, "", handler.Delete, http.StatusNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/webhooks", strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.vars != nil {
+				req = mux.SetURLVars(req, tt.vars)
+			}
+			w := httptest.NewRecorder()
+
+			tt.serve(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code == http.StatusOK || w.Code == http.StatusCreated {
+				var s webhooks.Subscription
+				json.NewDecoder(w.Body).Decode(&s)
+				if (s.Secret != "") != tt.wantSecret {
+					t.Errorf("secret = %q, want it only on create", s.Secret)
+				}
+			}
+		})
+	}
+}
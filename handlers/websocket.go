@@ -0,0 +1,527 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"userapi/audit"
+	"userapi/events"
+	"userapi/models"
+	"userapi/repository"
+	"userapi/reqctx"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait bounds writing a single message
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a client may stay silent before it is considered gone
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod leaves the client time to answer a ping before wsPongWait runs out
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsMaxMessageSize bounds the messages clients may send
+	wsMaxMessageSize = 8 << 10
+	// wsSendBuffer is how many messages a client may fall behind before it is disconnected
+	wsSendBuffer = 256
+	// wsMaxSubscriptions bounds the subscriptions of a connection
+	wsMaxSubscriptions = 100
+	// wsMaxUserIDs bounds the users of a single subscription
+	wsMaxUserIDs = 1000
+	// wsMaxQueryUsers bounds how many users a query subscription may match when it is made
+	wsMaxQueryUsers = 1000
+)
+
+// Message types of the WebSocket protocol
+const (
+	WSSubscribe    = "subscribe"
+	WSUnsubscribe  = "unsubscribe"
+	WSSubscribed   = "subscribed"
+	WSUnsubscribed = "unsubscribed"
+	WSEvent        = "event"
+	WSError        = "error"
+)
+
+// Authenticator checks the credentials of a request and returns who is making it
+type Authenticator func(r *http.Request) (actor string, ok bool)
+
+// TokenAuthenticator accepts requests that carry one of tokens, which map to their actors,
+// as a bearer token or in the access_token query parameter. Browsers cannot set headers on
+// WebSocket requests, hence the parameter.
+func TokenAuthenticator(tokens map[string]string) Authenticator {
+	return func(r *http.Request) (string, bool) {
+		token := r.URL.Query().Get("access_token")
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token = strings.TrimPrefix(header, "Bearer ")
+		}
+		if token == "" {
+			return "", false
+		}
+		for known, actor := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				return actor, true
+			}
+		}
+		return "", false
+	}
+}
+
+// WSRequest is a message from a client. A subscription names either users or a query.
+type WSRequest struct {
+	Type string `json:"type"`
+	// ID names the subscription in later messages. It is chosen by the client.
+	ID      string   `json:"id"`
+	UserIDs []int64  `json:"user_ids,omitempty"`
+	Query   *WSQuery `json:"query,omitempty"`
+	// Events limits the subscription to these event types
+	Events []string `json:"events,omitempty"`
+}
+
+// WSQuery selects users like the filters of GET /users
+type WSQuery struct {
+	Name   string `json:"name,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Phone  string `json:"phone,omitempty"`
+	MinAge int    `json:"min_age,omitempty"`
+	MaxAge int    `json:"max_age,omitempty"`
+}
+
+// WSMessage is a message to a client
+type WSMessage struct {
+	Type string `json:"type"`
+	// ID is the subscription a subscribed, unsubscribed or error message is about
+	ID string `json:"id,omitempty"`
+	// Users are the users a subscription covers when it is made
+	Users []*models.User `json:"users,omitempty"`
+	// Subscriptions are the subscriptions an event matched
+	Subscriptions []string          `json:"subscriptions,omitempty"`
+	Event         *models.UserEvent `json:"event,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+type WebSocketHandler struct {
+	repo         repository.UserRepository
+	broadcaster  *events.Broadcaster
+	authenticate Authenticator
+	auditLog     *audit.Logger
+	upgrader     websocket.Upgrader
+}
+
+// NewWebSocketHandler creates a handler that pushes the events of broadcaster to the
+// subscriptions of its clients. Upgrades are only accepted from authenticated clients and,
+// if allowedOrigins is empty, from pages of the same origin. Subscriptions are recorded in
+// auditLog.
+func NewWebSocketHandler(repo repository.UserRepository, broadcaster *events.Broadcaster, authenticate Authenticator, auditLog *audit.Logger, allowedOrigins []string) *WebSocketHandler {
+	h := &WebSocketHandler{
+		repo:         repo,
+		broadcaster:  broadcaster,
+		authenticate: authenticate,
+		auditLog:     auditLog,
+		upgrader:     websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096},
+	}
+	if len(allowedOrigins) > 0 {
+		h.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			for _, allowed := range allowedOrigins {
+				if origin == "" || strings.EqualFold(origin, allowed) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return h
+}
+
+// wsClient is a connected client with its subscriptions
+type wsClient struct {
+	conn  *websocket.Conn
+	actor string
+	// send queues messages for the writer; a client that lets it fill up is disconnected
+	send      chan *WSMessage
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	mu            sync.Mutex
+	subscriptions map[string]*wsSubscription
+}
+
+// wsSubscription selects the events a client asked for
+type wsSubscription struct {
+	userIDs map[int64]bool
+	matches func(*models.User) bool
+	types   map[string]bool
+	// members are the users of a query subscription the client knows to match, so that
+	// it hears about them leaving the query or being deleted
+	members map[int64]bool
+}
+
+// @Summary Subscribe to live user updates
+// @Description Upgrade to a WebSocket that pushes user events. Authenticate with a bearer token or the access_token parameter. Send {"type": "subscribe", "id": "...", "user_ids": [1, 2]} or {"type": "subscribe", "id": "...", "query": {"name": "John"}} to receive the current users and then every event about them as {"type": "event", "subscriptions": ["..."], "event": {...}}. Query subscriptions also report users that stop matching. Send {"type": "unsubscribe", "id": "..."} to stop. Clients that fall behind are disconnected with status 1013.
+// @Tags users
+// @Param access_token query string false "Token, if not sent as a bearer token"
+// @Success 101 "Switching Protocols"
+// @Failure 401 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /ws [get]
+func (h *WebSocketHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.authenticate(r)
+	if !ok {
+		log.Printf("Rejecting unauthenticated WebSocket upgrade from %s", r.RemoteAddr)
+		h.record(r.Context(), AnonymousActor, r.URL.Path, http.StatusUnauthorized)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userapi"`)
+		respondWithError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already responded
+		log.Printf("Error upgrading to WebSocket: %v", err)
+		return
+	}
+	log.Printf("WebSocket opened by %s", actor)
+	defer log.Printf("WebSocket of %s closed", actor)
+
+	c := &wsClient{
+		conn:          conn,
+		actor:         actor,
+		send:          make(chan *WSMessage, wsSendBuffer),
+		closing:       make(chan struct{}),
+		subscriptions: make(map[string]*wsSubscription),
+	}
+	sub := h.broadcaster.Subscribe()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.writeLoop()
+	}()
+	go func() {
+		defer wg.Done()
+		c.eventLoop(sub)
+	}()
+
+	// the connection outlives the request, but keeps its actor and request ID for the audit log
+	ctx := reqctx.WithActor(reqctx.Detach(r.Context()), actor)
+	h.readLoop(ctx, c)
+
+	c.close(websocket.CloseNormalClosure, "")
+	sub.Close()
+	wg.Wait()
+	conn.Close()
+}
+
+// readLoop handles the messages of a client until it disconnects or is disconnected
+func (h *WebSocketHandler) readLoop(ctx context.Context, c *wsClient) {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.extendReadDeadline()
+	c.conn.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				log.Printf("Error reading from WebSocket of %s: %v", c.actor, err)
+			}
+			return
+		}
+		c.extendReadDeadline()
+
+		var req WSRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.enqueue(&WSMessage{Type: WSError, Error: fmt.Sprintf("Invalid message: %v", err)})
+			continue
+		}
+		switch req.Type {
+		case WSSubscribe:
+			h.subscribe(ctx, c, &req)
+		case WSUnsubscribe:
+			c.mu.Lock()
+			_, ok := c.subscriptions[req.ID]
+			delete(c.subscriptions, req.ID)
+			c.mu.Unlock()
+			if !ok {
+				c.enqueue(&WSMessage{Type: WSError, ID: req.ID, Error: "Unknown subscription"})
+				continue
+			}
+			c.enqueue(&WSMessage{Type: WSUnsubscribed, ID: req.ID})
+		default:
+			c.enqueue(&WSMessage{Type: WSError, ID: req.ID, Error: fmt.Sprintf("Unknown message type: %q", req.Type)})
+		}
+	}
+}
+
+// subscribe adds a subscription and sends the users it covers
+func (h *WebSocketHandler) subscribe(ctx context.Context, c *wsClient, req *WSRequest) {
+	s, err := newWSSubscription(req)
+	if err != nil {
+		c.enqueue(&WSMessage{Type: WSError, ID: req.ID, Error: err.Error()})
+		return
+	}
+
+	c.mu.Lock()
+	_, taken := c.subscriptions[req.ID]
+	full := len(c.subscriptions) >= wsMaxSubscriptions
+	if !taken && !full {
+		// registered before loading the users, so that no change between the two is missed
+		c.subscriptions[req.ID] = s
+	}
+	c.mu.Unlock()
+	switch {
+	case taken:
+		c.enqueue(&WSMessage{Type: WSError, ID: req.ID, Error: "Subscription ID already in use"})
+		return
+	case full:
+		c.enqueue(&WSMessage{Type: WSError, ID: req.ID, Error: fmt.Sprintf("At most %d subscriptions per connection", wsMaxSubscriptions)})
+		return
+	}
+
+	var users []*models.User
+	if s.matches != nil {
+		filter := req.Query.filter()
+		filter.Limit = wsMaxQueryUsers + 1
+		users, err = h.repo.List(ctx, filter)
+		if err == nil && len(users) > wsMaxQueryUsers {
+			err = fmt.Errorf("query matches more than %d users, narrow it down", wsMaxQueryUsers)
+		}
+	} else {
+		users, err = h.repo.GetByIDs(ctx, req.UserIDs)
+	}
+
+	target := "/ws?" + req.target()
+	if err != nil {
+		log.Printf("Error loading users of WebSocket subscription %s: %v", target, err)
+		c.mu.Lock()
+		delete(c.subscriptions, req.ID)
+		c.mu.Unlock()
+		h.record(ctx, c.actor, target, http.StatusInternalServerError)
+		c.enqueue(&WSMessage{Type: WSError, ID: req.ID, Error: "Error subscribing: " + err.Error()})
+		return
+	}
+
+	if s.members != nil {
+		c.mu.Lock()
+		for _, user := range users {
+			s.members[user.ID] = true
+		}
+		c.mu.Unlock()
+	}
+	h.record(ctx, c.actor, target, http.StatusOK)
+	log.Printf("WebSocket subscription %q of %s covers %d users", req.ID, c.actor, len(users))
+	c.enqueue(&WSMessage{Type: WSSubscribed, ID: req.ID, Users: users})
+}
+
+// record adds a subscription, or a refused attempt to make one, to the audit log
+func (h *WebSocketHandler) record(ctx context.Context, actor, target string, status int) {
+	if h.auditLog == nil {
+		return
+	}
+	if len(target) > 2048 {
+		target = target[:2048]
+	}
+	e := &audit.Entry{
+		Actor:     actor,
+		Action:    "user.subscribe",
+		Target:    target,
+		Outcome:   audit.Outcome(status),
+		Status:    status,
+		RequestID: reqctx.RequestID(ctx),
+	}
+	h.auditLog.Enqueue(e)
+}
+
+func newWSSubscription(req *WSRequest) (*wsSubscription, error) {
+	switch {
+	case req.ID == "" || len(req.ID) > 64:
+		return nil, fmt.Errorf("id must be between 1 and 64 characters")
+	case (len(req.UserIDs) > 0) == (req.Query != nil):
+		return nil, fmt.Errorf("subscribe to either user_ids or a query")
+	case len(req.UserIDs) > wsMaxUserIDs:
+		return nil, fmt.Errorf("at most %d user_ids per subscription", wsMaxUserIDs)
+	}
+
+	s := &wsSubscription{types: make(map[string]bool)}
+	for _, t := range req.Events {
+		switch t {
+		case models.EventUserCreated, models.EventUserUpdated, models.EventUserDeleted:
+			s.types[t] = true
+		default:
+			return nil, fmt.Errorf("unknown event type: %s", t)
+		}
+	}
+
+	if req.Query != nil {
+		q := req.Query
+		if q.MinAge < 0 || q.MaxAge < 0 {
+			return nil, fmt.Errorf("min_age and max_age must be non-negative")
+		}
+		if q.Phone != "" {
+			if _, err := models.NormalizePhoneNumber(q.Phone, models.DefaultPhoneRegion); err != nil {
+				return nil, fmt.Errorf("invalid phone: %w", err)
+			}
+		}
+		s.matches = q.filter().Matcher()
+		s.members = make(map[int64]bool)
+		return s, nil
+	}
+
+	s.userIDs = make(map[int64]bool, len(req.UserIDs))
+	for _, id := range req.UserIDs {
+		if id <= 0 {
+			return nil, fmt.Errorf("invalid user ID: %d", id)
+		}
+		s.userIDs[id] = true
+	}
+	return s, nil
+}
+
+// wants reports whether e belongs to the subscription and, for query subscriptions,
+// updates which users match. Callers hold the lock of the client.
+func (s *wsSubscription) wants(e *models.UserEvent) bool {
+	var relevant bool
+	if s.matches == nil {
+		relevant = s.userIDs[e.UserID]
+	} else {
+		// a user that stops matching or is deleted is reported once more
+		matches := e.User != nil && s.matches(e.User)
+		relevant = matches || s.members[e.UserID]
+		if matches {
+			s.members[e.UserID] = true
+		} else {
+			delete(s.members, e.UserID)
+		}
+	}
+	return relevant && (len(s.types) == 0 || s.types[e.Type])
+}
+
+func (q *WSQuery) filter() repository.UserFilter {
+	return repository.UserFilter{Name: q.Name, Email: q.Email, Phone: q.Phone, MinAge: q.MinAge, MaxAge: q.MaxAge}
+}
+
+// target describes a subscription in the audit log like the query string of a request
+func (req *WSRequest) target() string {
+	var params []string
+	if len(req.UserIDs) > 0 {
+		ids := make([]string, len(req.UserIDs))
+		for i, id := range req.UserIDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		params = append(params, "user_ids="+strings.Join(ids, ","))
+	}
+	if q := req.Query; q != nil {
+		for _, param := range [][2]string{{"name", q.Name}, {"email", q.Email}, {"phone", q.Phone}} {
+			if param[1] != "" {
+				params = append(params, param[0]+"="+param[1])
+			}
+		}
+		if q.MinAge > 0 {
+			params = append(params, fmt.Sprintf("min_age=%d", q.MinAge))
+		}
+		if q.MaxAge > 0 {
+			params = append(params, fmt.Sprintf("max_age=%d", q.MaxAge))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// eventLoop queues the events the client subscribed to
+func (c *wsClient) eventLoop(sub *events.Subscription) {
+	for {
+		select {
+		case <-c.closing:
+			return
+		case e, ok := <-sub.Events:
+			if !ok {
+				c.close(websocket.CloseTryAgainLater, "Fell behind, reconnect and subscribe again")
+				return
+			}
+			c.mu.Lock()
+			var matched []string
+			for id, s := range c.subscriptions {
+				if s.wants(e) {
+					matched = append(matched, id)
+				}
+			}
+			c.mu.Unlock()
+			if len(matched) > 0 && !c.enqueue(&WSMessage{Type: WSEvent, Subscriptions: matched, Event: e}) {
+				return
+			}
+		}
+	}
+}
+
+// writeLoop writes queued messages and pings the client until the connection is closing
+func (c *wsClient) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closing:
+			return
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				log.Printf("Error writing to WebSocket of %s: %v", c.actor, err)
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// enqueue queues msg for the writer. A client whose queue is full is too slow to keep up
+// and is disconnected, so that it cannot hold up the others or grow without bound.
+func (c *wsClient) enqueue(msg *WSMessage) bool {
+	select {
+	case <-c.closing:
+		return false
+	default:
+	}
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		log.Printf("Disconnecting WebSocket of %s that is %d messages behind", c.actor, wsSendBuffer)
+		c.close(websocket.CloseTryAgainLater, "Too slow, reconnect and subscribe again")
+		return false
+	}
+}
+
+// extendReadDeadline gives the client another wsPongWait to show it is alive, unless the
+// connection is already closing
+func (c *wsClient) extendReadDeadline() {
+	select {
+	case <-c.closing:
+	default:
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	}
+}
+
+// close starts the closing handshake with code and stops reading once the client had time
+// to answer it
+func (c *wsClient) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		deadline := time.Now().Add(wsWriteWait)
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+		c.conn.SetReadDeadline(deadline)
+		close(c.closing)
+	})
+}
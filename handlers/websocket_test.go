@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"userapi/events"
+	"userapi/models"
+	"userapi/repository"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	authenticate := TokenAuthenticator(map[string]string{"secret": "alice"})
+
+	tests := []struct {
+		name      string
+		header    string
+		query     string
+		wantActor string
+		wantOK    bool
+	}{
+		{"bearer token", "Bearer secret", "", "alice", true},
+		{"query parameter", "", "?access_token=secret", "alice", true},
+		{"wrong token", "Bearer guess", "", "", false},
+		{"no token", "", "", "", false},
+		{"basic auth", "Basic c2VjcmV0", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/ws"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			actor, ok := authenticate(req)
+			if actor != tt.wantActor || ok != tt.wantOK {
+				t.Errorf("authenticate() = %q, %v, want %q, %v", actor, ok, tt.wantActor, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	broadcaster := events.NewBroadcaster()
+	relay := events.NewRelay(repo.(repository.OutboxRepository), time.Second, 100)
+	relay.AddPublishedSink(broadcaster)
+
+	john := &models.User{Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+	jane := &models.User{Name: "Jane Doe", Age: 28, PhoneNumber: "+14155552672", Email: "jane@example.com"}
+	repo.Create(ctx, john)
+	repo.Create(ctx, jane)
+	relay.RelayOnce(ctx)
+
+	handler := NewWebSocketHandler(repo, broadcaster, TokenAuthenticator(map[string]string{"secret": "alice"}), nil, nil)
+	server := httptest.NewServer(http.HandlerFunc(handler.Serve))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Dial() without token = %v, want 401", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer secret"}})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	exchange := func(req WSRequest) WSMessage {
+		if err := conn.WriteJSON(req); err != nil {
+			t.Fatalf("WriteJSON() error = %v", err)
+		}
+		var msg WSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		return msg
+	}
+
+	if msg := exchange(WSRequest{Type: WSSubscribe, ID: "john", UserIDs: []int64{john.ID}}); msg.Type != WSSubscribed || len(msg.Users) != 1 || msg.Users[0].ID != john.ID {
+		t.Fatalf("subscribe by ID = %+v, want John", msg)
+	}
+	if msg := exchange(WSRequest{Type: WSSubscribe, ID: "thirties", Query: &WSQuery{MinAge: 30}}); msg.Type != WSSubscribed || len(msg.Users) != 1 {
+		t.Fatalf("subscribe by query = %+v, want John", msg)
+	}
+	if msg := exchange(WSRequest{Type: WSSubscribe, ID: "john", UserIDs: []int64{jane.ID}}); msg.Type != WSError {
+		t.Fatalf("subscribe with ID in use = %+v, want an error", msg)
+	}
+	if msg := exchange(WSRequest{Type: WSSubscribe, ID: "both", UserIDs: []int64{jane.ID}, Query: &WSQuery{}}); msg.Type != WSError {
+		t.Fatalf("subscribe to IDs and a query = %+v, want an error", msg)
+	}
+
+	// Jane joins the query, then John leaves it
+	jane.Age = 31
+	repo.Update(ctx, jane)
+	john.Age = 29
+	repo.Update(ctx, john)
+	relay.RelayOnce(ctx)
+
+	var msg WSMessage
+	for _, want := range []struct {
+		userID        int64
+		subscriptions string
+	}{{jane.ID, "thirties"}, {john.ID, "john,thirties"}} {
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		got := append([]string{}, msg.Subscriptions...)
+		if len(got) == 2 && got[0] > got[1] {
+			got[0], got[1] = got[1], got[0]
+		}
+		if msg.Type != WSEvent || msg.Event.UserID != want.userID || strings.Join(got, ",") != want.subscriptions {
+			t.Fatalf("event = %+v, want user %d for %s", msg, want.userID, want.subscriptions)
+		}
+	}
+
+	if msg := exchange(WSRequest{Type: WSUnsubscribe, ID: "thirties"}); msg.Type != WSUnsubscribed {
+		t.Fatalf("unsubscribe = %+v", msg)
+	}
+	// John no longer matches the query, so only his own subscription hears of this
+	repo.Delete(ctx, john.ID)
+	relay.RelayOnce(ctx)
+	if err := conn.ReadJSON(&msg); err != nil || msg.Event.Type != models.EventUserDeleted || len(msg.Subscriptions) != 1 {
+		t.Fatalf("event = %+v, %v, want John deleted for one subscription", msg, err)
+	}
+}
@@ -0,0 +1,52 @@
+package imports
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Format identifies the encoding of an uploaded file
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+// Status is the lifecycle state of an import job
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+// Job reports the progress of an import
+type Job struct {
+	ID           string     `json:"id" xml:"id"`
+	Format       Format     `json:"format" xml:"format"`
+	Status       Status     `json:"status" xml:"status"`
+	RowsRead     int64      `json:"rows_read" xml:"rows_read"`
+	RowsImported int64      `json:"rows_imported" xml:"rows_imported"`
+	RowsRejected int64      `json:"rows_rejected" xml:"rows_rejected"`
+	Error        string     `json:"error,omitempty" xml:"error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at" xml:"created_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty" xml:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty" xml:"finished_at,omitempty"`
+}
+
+// Done reports whether the job has stopped running
+func (j Job) Done() bool {
+	return j.Status == StatusCompleted || j.Status == StatusFailed
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
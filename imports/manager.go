@@ -0,0 +1,329 @@
+package imports
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"userapi/csvcell"
+	"userapi/repository"
+	"userapi/reqctx"
+)
+
+var (
+	// ErrJobNotFound is returned for unknown job IDs
+	ErrJobNotFound = errors.New("import job not found")
+	// ErrJobRunning is returned when the error report is requested before the job finished
+	ErrJobRunning = errors.New("import job is still running")
+)
+
+// reapInterval is how often Run looks for jobs past their retention
+const reapInterval = time.Minute
+
+// Manager runs import jobs in the background and keeps track of their progress
+type Manager struct {
+	repo      repository.UserRepository
+	dir       string
+	batchSize int
+	retention time.Duration
+
+	mu   sync.RWMutex
+	jobs map[string]*jobState
+}
+
+type jobState struct {
+	job        Job
+	uploadPath string
+	errorsPath string
+}
+
+// NewManager creates a manager that spools uploads and error reports to dir and upserts
+// users in batches of batchSize. Finished jobs and their error reports are kept for
+// retention, see Run.
+func NewManager(repo repository.UserRepository, dir string, batchSize int, retention time.Duration) (*Manager, error) {
+	// uploads and error reports hold the rows as uploaded, personal data included
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create import directory: %w", err)
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	return &Manager{
+		repo:      repo,
+		dir:       dir,
+		batchSize: batchSize,
+		retention: retention,
+		jobs:      make(map[string]*jobState),
+	}, nil
+}
+
+// Start copies the upload to disk and processes it asynchronously. The returned job
+// can be polled with Get. The import outlives ctx, but its changes are attributed to the
+// actor and request of ctx.
+func (m *Manager) Start(ctx context.Context, format Format, upload io.Reader) (Job, error) {
+	if format != FormatCSV && format != FormatNDJSON {
+		return Job{}, fmt.Errorf("unsupported import format: %q", format)
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to generate job ID: %w", err)
+	}
+
+	state := &jobState{
+		job:        Job{ID: id, Format: format, Status: StatusPending, CreatedAt: time.Now().UTC()},
+		uploadPath: filepath.Join(m.dir, id+".upload"),
+		errorsPath: filepath.Join(m.dir, id+".errors.csv"),
+	}
+
+	f, err := createPrivate(state.uploadPath)
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	n, err := io.Copy(f, upload)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(state.uploadPath)
+		return Job{}, fmt.Errorf("failed to store upload: %w", err)
+	}
+	log.Printf("Stored %d byte %s upload for import job %s", n, format, id)
+
+	m.mu.Lock()
+	m.jobs[id] = state
+	m.mu.Unlock()
+
+	go m.run(reqctx.Detach(ctx), state)
+	return state.job, nil
+}
+
+// Get returns a snapshot of the job
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	state, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return state.job, nil
+}
+
+// ErrorReport opens the CSV report of rejected rows. It is only available once the
+// job has finished.
+func (m *Manager) ErrorReport(id string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	state, ok := m.jobs[id]
+	var done bool
+	if ok {
+		done = state.job.Done()
+	}
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if !done {
+		return nil, ErrJobRunning
+	}
+	return os.Open(state.errorsPath)
+}
+
+// Run removes jobs that finished more than the retention ago, with their files, until ctx
+// is done
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := m.Reap(now); n > 0 {
+				log.Printf("Removed %d expired import jobs", n)
+			}
+		}
+	}
+}
+
+// Reap removes the jobs that finished more than the retention before now, and returns how
+// many it removed. Files in the directory that belong to no job, such as those of jobs
+// of an earlier run of the service, are removed once they are as old.
+func (m *Manager) Reap(now time.Time) int {
+	cutoff := now.Add(-m.retention)
+	m.mu.Lock()
+	var expired []*jobState
+	for id, state := range m.jobs {
+		if state.job.FinishedAt != nil && state.job.FinishedAt.Before(cutoff) {
+			expired = append(expired, state)
+			delete(m.jobs, id)
+		}
+	}
+	known := make(map[string]bool, 2*len(m.jobs))
+	for _, state := range m.jobs {
+		known[state.uploadPath] = true
+		known[state.errorsPath] = true
+	}
+	m.mu.Unlock()
+
+	for _, state := range expired {
+		removeFile(state.uploadPath)
+		removeFile(state.errorsPath)
+	}
+
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		log.Printf("Error listing import directory: %v", err)
+		return len(expired)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(m.dir, entry.Name())
+		if entry.IsDir() || known[path] {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			removeFile(path)
+		}
+	}
+	return len(expired)
+}
+
+// createPrivate creates a file only the service can read
+func createPrivate(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+}
+
+func removeFile(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error removing import file %s: %v", path, err)
+	}
+}
+
+func (m *Manager) update(state *jobState, fn func(job *Job)) {
+	m.mu.Lock()
+	fn(&state.job)
+	m.mu.Unlock()
+}
+
+func (m *Manager) run(ctx context.Context, state *jobState) {
+	id := state.job.ID
+	log.Printf("Starting import job %s", id)
+	started := time.Now().UTC()
+	m.update(state, func(job *Job) {
+		job.Status = StatusRunning
+		job.StartedAt = &started
+	})
+
+	err := m.process(ctx, state)
+
+	if rmErr := os.Remove(state.uploadPath); rmErr != nil {
+		log.Printf("Error removing upload for import job %s: %v", id, rmErr)
+	}
+
+	finished := time.Now().UTC()
+	m.update(state, func(job *Job) {
+		job.FinishedAt = &finished
+		if err != nil {
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			return
+		}
+		job.Status = StatusCompleted
+	})
+
+	job, _ := m.Get(id)
+	if err != nil {
+		log.Printf("Import job %s failed after %d rows: %v", id, job.RowsRead, err)
+		return
+	}
+	log.Printf("Import job %s completed: %d imported, %d rejected", id, job.RowsImported, job.RowsRejected)
+}
+
+func (m *Manager) process(ctx context.Context, state *jobState) error {
+	upload, err := os.Open(state.uploadPath)
+	if err != nil {
+		return fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer upload.Close()
+
+	errorsFile, err := createPrivate(state.errorsPath)
+	if err != nil {
+		return fmt.Errorf("failed to create error report: %w", err)
+	}
+	defer errorsFile.Close()
+	report := csv.NewWriter(errorsFile)
+	defer report.Flush()
+	report.Write([]string{"row", "error", "record"})
+
+	reject := func(r *row, err error) {
+		report.Write(csvcell.Record([]string{strconv.FormatInt(r.Number, 10), err.Error(), r.Raw}))
+		m.update(state, func(job *Job) { job.RowsRejected++ })
+	}
+
+	reader, err := newRowReader(state.job.Format, upload)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]*row, 0, m.batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ops := make([]repository.BatchOperation, len(batch))
+		for i, r := range batch {
+			ops[i] = repository.BatchOperation{Type: repository.BatchUpsert, User: r.User}
+		}
+		results, err := m.repo.Batch(ctx, ops, false)
+		if err != nil {
+			return fmt.Errorf("failed to upsert batch: %w", err)
+		}
+
+		var imported int64
+		for i, res := range results {
+			if res.Err != nil {
+				reject(batch[i], res.Err)
+				continue
+			}
+			imported++
+		}
+		m.update(state, func(job *Job) { job.RowsImported += imported })
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		r, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read upload: %w", err)
+		}
+		m.update(state, func(job *Job) { job.RowsRead++ })
+
+		if r.Err == nil {
+			r.Err = r.User.Validate()
+		}
+		if r.Err != nil {
+			reject(r, r.Err)
+			continue
+		}
+
+		batch = append(batch, r)
+		if len(batch) == m.batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
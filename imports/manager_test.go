@@ -0,0 +1,172 @@
+package imports
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"userapi/models"
+	"userapi/repository"
+)
+
+type fakeUserRepository struct {
+	repository.UserRepository
+	byEmail map[string]*models.User
+}
+
+func (f *fakeUserRepository) Batch(ctx context.Context, ops []repository.BatchOperation, atomic bool) ([]repository.BatchResult, error) {
+	results := make([]repository.BatchResult, len(ops))
+	for i, op := range ops {
+		if existing, ok := f.byEmail[op.User.Email]; ok {
+			op.User.ID = existing.ID
+		} else {
+			op.User.ID = int64(len(f.byEmail) + 1)
+		}
+		f.byEmail[op.User.Email] = op.User
+		results[i].ID = op.User.ID
+	}
+	return results, nil
+}
+
+func waitForJob(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if job.Done() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("import job %s did not finish", id)
+	return Job{}
+}
+
+func TestManager_Import(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       Format
+		upload       string
+		wantStatus   Status
+		wantImported int64
+		wantRejected int64
+	}{
+		{
+			name:   "csv",
+			format: FormatCSV,
+			upload: "email,name,age,phone_number\n" +
+				"john@example.com,John Doe,30,+14155552671\n" +
+				"jane@example.com,Jane Doe,abc,+14155552671\n" +
+				"john@example.com,John Doe,31,'+14155552671\n" +
+				"bad-email,Bob,40,+14155552671\n",
+			wantStatus:   StatusCompleted,
+			wantImported: 2,
+			wantRejected: 2,
+		},
+		{
+			name:   "ndjson",
+			format: FormatNDJSON,
+			upload: `{"name":"John Doe","age":30,"phone_number":"+14155552671","email":"john@example.com"}` + "\n\n" +
+				`{"name":"Jane Doe"` + "\n",
+			wantStatus:   StatusCompleted,
+			wantImported: 1,
+			wantRejected: 1,
+		},
+		{
+			name:       "csv missing column",
+			format:     FormatCSV,
+			upload:     "name,age\nJohn,30\n",
+			wantStatus: StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeUserRepository{byEmail: make(map[string]*models.User)}
+			m, err := NewManager(repo, t.TempDir(), 2, time.Hour)
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+
+			started, err := m.Start(context.Background(), tt.format, strings.NewReader(tt.upload))
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			job := waitForJob(t, m, started.ID)
+
+			if job.Status != tt.wantStatus {
+				t.Fatalf("job status = %v, want %v (error: %s)", job.Status, tt.wantStatus, job.Error)
+			}
+			if job.RowsImported != tt.wantImported || job.RowsRejected != tt.wantRejected {
+				t.Errorf("job imported/rejected = %d/%d, want %d/%d", job.RowsImported, job.RowsRejected, tt.wantImported, tt.wantRejected)
+			}
+			if tt.wantStatus != StatusCompleted {
+				return
+			}
+
+			report, err := m.ErrorReport(job.ID)
+			if err != nil {
+				t.Fatalf("ErrorReport() error = %v", err)
+			}
+			defer report.Close()
+			records, err := csv.NewReader(report).ReadAll()
+			if err != nil {
+				t.Fatalf("Could not read error report: %v", err)
+			}
+			if int64(len(records)-1) != tt.wantRejected {
+				t.Errorf("error report has %d rows, want %d", len(records)-1, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestManager_Reap(t *testing.T) {
+	dir := t.TempDir()
+	repo := &fakeUserRepository{byEmail: make(map[string]*models.User)}
+	m, err := NewManager(repo, dir, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	orphan := filepath.Join(dir, "orphan.errors.csv")
+	if err := os.WriteFile(orphan, nil, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(orphan, old, old)
+
+	started, err := m.Start(context.Background(), FormatCSV, strings.NewReader("email,name,age,phone_number\nbad,Bob,40,+14155552671\n"))
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	job := waitForJob(t, m, started.ID)
+	report := filepath.Join(dir, job.ID+".errors.csv")
+	info, err := os.Stat(report)
+	if err != nil {
+		t.Fatalf("error report missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("error report permissions = %v, want 0600", perm)
+	}
+
+	if n := m.Reap(time.Now()); n != 0 {
+		t.Errorf("Reap() before the retention removed %d jobs, want 0", n)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphaned file was kept: %v", err)
+	}
+	if n := m.Reap(time.Now().Add(2 * time.Hour)); n != 1 {
+		t.Errorf("Reap() after the retention removed %d jobs, want 1", n)
+	}
+	if _, err := m.Get(job.ID); err != ErrJobNotFound {
+		t.Errorf("Get() after reaping error = %v, want %v", err, ErrJobNotFound)
+	}
+	if _, err := os.Stat(report); !os.IsNotExist(err) {
+		t.Errorf("error report was kept: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package imports
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"userapi/csvcell"
+	"userapi/models"
+)
+
+// maxLineLength bounds a single NDJSON line
+const maxLineLength = 1 << 20
+
+// row is a single record read from an upload. Err is set when the record could not be
+// turned into a user; Raw holds the original record for the error report.
+type row struct {
+	Number int64
+	User   *models.User
+	Raw    string
+	Err    error
+}
+
+// rowReader reads one record at a time so that uploads are never held in memory
+type rowReader interface {
+	Next() (*row, error)
+}
+
+func newRowReader(format Format, r io.Reader) (rowReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+		return &ndjsonReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unsupported import format: %q", format)
+	}
+}
+
+var csvColumns = []string{"name", "age", "phone_number", "email"}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+	number  int64
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header is missing column %q", name)
+		}
+	}
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+func (c *csvReader) Next() (*row, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	c.number++
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return &row{Number: c.number, Raw: strings.Join(record, ","), Err: err}, nil
+		}
+		return nil, err
+	}
+
+	r := &row{Number: c.number, Raw: joinCSV(record)}
+	field := func(name string) string {
+		if i := c.columns[name]; i < len(record) {
+			return csvcell.Unescape(strings.TrimSpace(record[i]))
+		}
+		return ""
+	}
+
+	age, err := strconv.Atoi(field("age"))
+	if err != nil {
+		r.Err = fmt.Errorf("invalid age: %q", field("age"))
+		return r, nil
+	}
+	r.User = &models.User{
+		Name:        field("name"),
+		Age:         age,
+		PhoneNumber: field("phone_number"),
+		Email:       field("email"),
+	}
+	return r, nil
+}
+
+func joinCSV(record []string) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(record)
+	w.Flush()
+	return strings.TrimRight(buf.String(), "\r\n")
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	number  int64
+}
+
+func (n *ndjsonReader) Next() (*row, error) {
+	for n.scanner.Scan() {
+		line := bytes.TrimSpace(n.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		n.number++
+
+		r := &row{Number: n.number, Raw: string(line)}
+		var user models.User
+		if err := json.Unmarshal(line, &user); err != nil {
+			r.Err = fmt.Errorf("invalid JSON: %w", err)
+			return r, nil
+		}
+		r.User = &user
+		return r, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
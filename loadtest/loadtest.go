@@ -0,0 +1,355 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"userapi/models"
+	"userapi/seed"
+)
+
+// Operation is a kind of request the load test sends
+type Operation string
+
+// Operations on the /users routes
+const (
+	OpCreate Operation = "create"
+	OpGet    Operation = "get"
+	OpList   Operation = "list"
+	OpSearch Operation = "search"
+	OpUpdate Operation = "update"
+	OpDelete Operation = "delete"
+)
+
+// DefaultMix is a read-heavy mix of operations, by weight
+var DefaultMix = map[Operation]int{OpGet: 70, OpList: 5, OpSearch: 5, OpCreate: 10, OpUpdate: 8, OpDelete: 2}
+
+// ParseMix parses a comma separated list of operation=weight pairs, such as "get=80,create=20"
+func ParseMix(s string) (map[Operation]int, error) {
+	mix := make(map[Operation]int)
+	for _, pair := range strings.Split(s, ",") {
+		op, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mix entry %q, want operation=weight", pair)
+		}
+		switch Operation(op) {
+		case OpCreate, OpGet, OpList, OpSearch, OpUpdate, OpDelete:
+		default:
+			return nil, fmt.Errorf("unknown operation %q in mix", op)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight %q for %s", weight, op)
+		}
+		mix[Operation(op)] = w
+	}
+	return mix, nil
+}
+
+// Config describes a load test
+type Config struct {
+	// Target is the base URL of the service, such as http://localhost:8080
+	Target string
+	// Rate is the number of requests started per second, whether or not earlier ones finished
+	Rate float64
+	// Duration is how long requests are started for
+	Duration time.Duration
+	// Mix weighs the operations
+	Mix map[Operation]int
+	// Poisson spaces requests randomly, like independent clients do, instead of evenly
+	Poisson bool
+	// MaxInFlight bounds the requests waiting for a response. Requests due while it is
+	// reached are not sent and count as errors of the client.
+	MaxInFlight int
+	// Timeout bounds a request
+	Timeout time.Duration
+	// Seed makes the generated users and the order of operations repeatable
+	Seed int64
+	// Prepare is the number of users created before measuring, for the other operations to use
+	Prepare int
+	// Token is sent as a bearer token, so that the changes are attributed to its actor
+	// instead of anonymous
+	Token string
+}
+
+// Run drives the target with cfg and reports how it responded. Requests are started on a
+// schedule regardless of how long earlier ones take, and latencies are measured from when
+// a request was due, so a slow service shows in the latencies instead of lowering the rate.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Rate <= 0 || cfg.Duration <= 0 {
+		return nil, errors.New("rate and duration must be positive")
+	}
+	if cfg.Mix == nil {
+		cfg.Mix = DefaultMix
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 10000
+	}
+	gen, err := seed.NewGenerator(cfg.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &runner{
+		cfg:     cfg,
+		target:  strings.TrimRight(cfg.Target, "/"),
+		gen:     gen,
+		rng:     rand.New(rand.NewSource(cfg.Seed)),
+		results: make(map[Operation]*recorder),
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConns:        cfg.MaxInFlight,
+				MaxIdleConnsPerHost: cfg.MaxInFlight,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+	defer r.client.CloseIdleConnections()
+	for op, weight := range cfg.Mix {
+		if weight > 0 {
+			r.ops = append(r.ops, op)
+			r.results[op] = &recorder{errors: make(map[string]int)}
+		}
+	}
+	if len(r.ops) == 0 {
+		return nil, errors.New("mix has no operation with a positive weight")
+	}
+	sort.Slice(r.ops, func(i, j int) bool { return r.ops[i] < r.ops[j] })
+	for _, op := range r.ops {
+		r.totalWeight += cfg.Mix[op]
+	}
+
+	for i := 0; i < cfg.Prepare; i++ {
+		if _, err := r.create(ctx); err != nil {
+			return nil, fmt.Errorf("failed to prepare users: %w", err)
+		}
+	}
+
+	return r.report(r.run(ctx)), nil
+}
+
+// runner holds the state of a load test
+type runner struct {
+	cfg    Config
+	target string
+	client *http.Client
+
+	mu          sync.Mutex // guards gen, rng and ids
+	gen         *seed.Generator
+	rng         *rand.Rand
+	ids         []int64
+	ops         []Operation
+	totalWeight int
+
+	results map[Operation]*recorder
+}
+
+// run starts requests on schedule until the duration is over, then waits for them. It
+// returns how long it started requests for.
+func (r *runner) run(ctx context.Context) time.Duration {
+	start := time.Now()
+	end := start.Add(r.cfg.Duration)
+	inFlight := make(chan struct{}, r.cfg.MaxInFlight)
+	var wg sync.WaitGroup
+
+	due := start
+	for due.Before(end) && ctx.Err() == nil {
+		// start everything due by now, then sleep until the next request is
+		now := time.Now()
+		for ; !due.After(now) && due.Before(end); due = due.Add(r.interval()) {
+			op := r.pick()
+			select {
+			case inFlight <- struct{}{}:
+			default:
+				r.results[op].record(0, "client: too many requests in flight")
+				continue
+			}
+			wg.Add(1)
+			go func(op Operation, due time.Time) {
+				defer wg.Done()
+				defer func() { <-inFlight }()
+				r.do(ctx, op, due)
+			}(op, due)
+		}
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+	}
+	sending := time.Since(start)
+	wg.Wait()
+	return sending
+}
+
+// interval returns the time until the next request is due
+func (r *runner) interval() time.Duration {
+	mean := float64(time.Second) / r.cfg.Rate
+	if !r.cfg.Poisson {
+		return time.Duration(mean)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.rng.ExpFloat64() * mean)
+}
+
+// pick chooses an operation by weight
+func (r *runner) pick() Operation {
+	r.mu.Lock()
+	n := r.rng.Intn(r.totalWeight)
+	r.mu.Unlock()
+	for _, op := range r.ops {
+		if n -= r.cfg.Mix[op]; n < 0 {
+			return op
+		}
+	}
+	return r.ops[len(r.ops)-1]
+}
+
+// do sends one request and records its outcome
+func (r *runner) do(ctx context.Context, op Operation, due time.Time) {
+	var err error
+	switch op {
+	case OpCreate:
+		_, err = r.create(ctx)
+	case OpGet:
+		err = r.send(ctx, http.MethodGet, "/users/"+r.existingID(false), nil, nil)
+	case OpList:
+		err = r.send(ctx, http.MethodGet, "/users?name="+url.QueryEscape(r.firstName()), nil, nil)
+	case OpSearch:
+		err = r.send(ctx, http.MethodGet, "/users/search?limit=20&q="+url.QueryEscape(r.firstName()), nil, nil)
+	case OpUpdate:
+		err = r.send(ctx, http.MethodPut, "/users/"+r.existingID(false), r.newUser(), nil)
+	case OpDelete:
+		err = r.send(ctx, http.MethodDelete, "/users/"+r.existingID(true), nil, nil)
+	}
+	r.results[op].record(time.Since(due), errorKind(err))
+}
+
+// create creates a generated user and keeps its ID for the other operations
+func (r *runner) create(ctx context.Context) (int64, error) {
+	var created models.User
+	if err := r.send(ctx, http.MethodPost, "/users", r.newUser(), &created); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	r.ids = append(r.ids, created.ID)
+	r.mu.Unlock()
+	return created.ID, nil
+}
+
+func (r *runner) newUser() *models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gen.Next()
+}
+
+// firstName returns the first name of a generated user, which other users are likely to share
+func (r *runner) firstName() string {
+	name := r.newUser().Name
+	if first, _, ok := strings.Cut(name, " "); ok {
+		return first
+	}
+	return name
+}
+
+// existingID returns the ID of a created user, removing it from the pool if it is about to
+// be deleted. Without any, it returns an ID that does not exist.
+func (r *runner) existingID(remove bool) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.ids) == 0 {
+		return "0"
+	}
+	i := r.rng.Intn(len(r.ids))
+	id := r.ids[i]
+	if remove {
+		r.ids[i] = r.ids[len(r.ids)-1]
+		r.ids = r.ids[:len(r.ids)-1]
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// send sends a request with body encoded as JSON and decodes the response into out. Responses
+// other than 2xx are returned as a *statusError.
+func (r *runner) send(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.target+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if r.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return &statusError{code: resp.StatusCode}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// statusError is returned for responses with a status other than 2xx
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d", e.code)
+}
+
+// errorKind groups errors for the report, or returns "" for success
+func errorKind(err error) string {
+	var status *statusError
+	var netErr interface{ Timeout() bool }
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &status):
+		return fmt.Sprintf("status %d %s", status.code, http.StatusText(status.code))
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection reset"
+	default:
+		return "transport error"
+	}
+}
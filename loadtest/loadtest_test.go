@@ -0,0 +1,133 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"userapi/handlers"
+	"userapi/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestServer serves the /users routes from an in-memory repository
+func newTestServer() *httptest.Server {
+	userHandler := handlers.NewUserHandler(repository.NewMemoryUserRepository())
+	router := mux.NewRouter()
+	router.HandleFunc("/users", userHandler.Create).Methods("POST")
+	router.HandleFunc("/users/search", userHandler.Search).Methods("GET")
+	router.HandleFunc("/users/{id}", userHandler.GetByID).Methods("GET")
+	router.HandleFunc("/users/{id}", userHandler.Update).Methods("PUT")
+	router.HandleFunc("/users/{id}", userHandler.Delete).Methods("DELETE")
+	router.HandleFunc("/users", userHandler.List).Methods("GET")
+	return httptest.NewServer(router)
+}
+
+func TestRun(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	report, err := Run(context.Background(), Config{
+		Target:   server.URL,
+		Rate:     500,
+		Duration: 200 * time.Millisecond,
+		Mix:      map[Operation]int{OpCreate: 1, OpGet: 1, OpUpdate: 1, OpList: 1},
+		Timeout:  time.Second,
+		Seed:     1,
+		Prepare:  20,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if report.Total.Requests < 80 || report.Total.Requests > 120 {
+		t.Errorf("requests = %d, want about 100 at 500 per second for 200ms", report.Total.Requests)
+	}
+	if len(report.Operations) != 4 {
+		t.Errorf("operations = %d, want 4", len(report.Operations))
+	}
+	if report.Total.Succeeded != report.Total.Requests {
+		t.Errorf("succeeded = %d of %d, errors %v", report.Total.Succeeded, report.Total.Requests, report.Total.Errors)
+	}
+	if l := report.Total.Latency; l.P50 <= 0 || l.P50 > l.P99 || l.P99 > l.Max {
+		t.Errorf("latencies = %+v, want increasing percentiles", l)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	report, err := Run(context.Background(), Config{
+		Target:   server.URL,
+		Rate:     200,
+		Duration: 50 * time.Millisecond,
+		Mix:      map[Operation]int{OpGet: 1},
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if n := report.Total.Errors["status 503 Service Unavailable"]; n == 0 || n != report.Total.Requests {
+		t.Errorf("errors = %v for %d requests, want all 503", report.Total.Errors, report.Total.Requests)
+	}
+
+	// preparing users against a failing target fails the run
+	if _, err := Run(context.Background(), Config{Target: server.URL, Rate: 1, Duration: time.Second, Prepare: 1}); err == nil {
+		t.Error("Run() with failing preparation error = nil, want an error")
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[Operation]int
+		wantErr bool
+	}{
+		{"get=80, create=20", map[Operation]int{OpGet: 80, OpCreate: 20}, false},
+		{"get", nil, true},
+		{"fetch=1", nil, true},
+		{"get=-1", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMix(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMix(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		for op, w := range tt.want {
+			if got[op] != w {
+				t.Errorf("ParseMix(%q)[%s] = %d, want %d", tt.in, op, got[op], w)
+			}
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 1000; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.5, 500 * time.Millisecond},
+		{0.99, 990 * time.Millisecond},
+		{0.999, 999 * time.Millisecond},
+		{1, time.Second},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", got)
+	}
+}
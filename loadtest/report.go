@@ -0,0 +1,160 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// recorder collects the outcomes of one operation
+type recorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration // of successful requests
+	requests  int
+	errors    map[string]int
+}
+
+// record counts a request, adding its latency if kind is "", or its error kind otherwise
+func (rec *recorder) record(latency time.Duration, kind string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.requests++
+	if kind != "" {
+		rec.errors[kind]++
+		return
+	}
+	rec.latencies = append(rec.latencies, latency)
+}
+
+// Latencies are percentiles of the latencies of successful requests
+type Latencies struct {
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// OperationReport summarizes the requests of one operation, or of all of them
+type OperationReport struct {
+	Operation string         `json:"operation"`
+	Requests  int            `json:"requests"`
+	Succeeded int            `json:"succeeded"`
+	RPS       float64        `json:"rps"`
+	Latency   Latencies      `json:"latency"`
+	Errors    map[string]int `json:"errors,omitempty"`
+}
+
+// Report summarizes a load test
+type Report struct {
+	// Duration is how long requests were started for; rates are per second of it
+	Duration   time.Duration     `json:"duration"`
+	Operations []OperationReport `json:"operations"`
+	Total      OperationReport   `json:"total"`
+}
+
+func (r *runner) report(elapsed time.Duration) *Report {
+	report := &Report{Duration: elapsed}
+	var all []time.Duration
+	total := OperationReport{Operation: "total", Errors: make(map[string]int)}
+	for _, op := range r.ops {
+		rec := r.results[op]
+		rec.mu.Lock()
+		report.Operations = append(report.Operations, summarize(string(op), rec.requests, rec.latencies, rec.errors, elapsed))
+		all = append(all, rec.latencies...)
+		total.Requests += rec.requests
+		for kind, n := range rec.errors {
+			total.Errors[kind] += n
+		}
+		rec.mu.Unlock()
+	}
+	report.Total = summarize("total", total.Requests, all, total.Errors, elapsed)
+	return report
+}
+
+func summarize(name string, requests int, latencies []time.Duration, errs map[string]int, elapsed time.Duration) OperationReport {
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	report := OperationReport{
+		Operation: name,
+		Requests:  requests,
+		Succeeded: len(sorted),
+		RPS:       float64(requests) / elapsed.Seconds(),
+		Latency: Latencies{
+			P50:  percentile(sorted, 0.50),
+			P90:  percentile(sorted, 0.90),
+			P99:  percentile(sorted, 0.99),
+			P999: percentile(sorted, 0.999),
+		},
+	}
+	if len(sorted) > 0 {
+		report.Latency.Max = sorted[len(sorted)-1]
+	}
+	if len(errs) > 0 {
+		report.Errors = make(map[string]int, len(errs))
+		for kind, n := range errs {
+			report.Errors[kind] = n
+		}
+	}
+	return report
+}
+
+// percentile returns the latency that a fraction p of sorted is at or below
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Print writes the report as tables of latencies and errors
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "duration %v, %d requests, %.1f requests/s\n\n", r.Duration.Round(time.Millisecond), r.Total.Requests, r.Total.RPS)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "operation\trequests\tok\trps\tp50\tp90\tp99\tp99.9\tmax\t")
+	for _, op := range append(r.Operations, r.Total) {
+		l := op.Latency
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%v\t%v\t%v\t%v\t%v\t\n", op.Operation, op.Requests, op.Succeeded, op.RPS,
+			round(l.P50), round(l.P90), round(l.P99), round(l.P999), round(l.Max))
+	}
+	tw.Flush()
+
+	if len(r.Total.Errors) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nerrors")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, op := range r.Operations {
+		kinds := make([]string, 0, len(op.Errors))
+		for kind := range op.Errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\n", op.Operation, kind, op.Errors[kind])
+		}
+	}
+	tw.Flush()
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
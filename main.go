@@ -1,17 +1,33 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"expvar"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"userapi/audit"
+	"userapi/events"
+	"userapi/graphqlapi"
+	"userapi/grpcserver"
 	"userapi/handlers"
+	"userapi/imports"
+	"userapi/models"
+	"userapi/recording"
 	"userapi/repository"
+	"userapi/webhooks"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
+	"github.com/nyaruka/phonenumbers"
 )
 
 // @title User API
@@ -22,78 +38,218 @@ import (
 func main() {
 	// Configure logging
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("Starting User API service...")
 
-	// Get database configuration from environment variables
-	dbHost := getEnv("DB_HOST", "localhost")
-	dbUser := getEnv("DB_USER", "root")
-	dbPassword := getEnv("DB_PASSWORD", "root")
-	dbName := getEnv("DB_NAME", "userdb")
-	dbPort := getEnv("DB_PORT", "3306")
+	// Anything after the binary name is a maintenance command instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 
-	log.Printf("Database configuration: host=%s, port=%s, user=%s, database=%s", dbHost, dbPort, dbUser, dbName)
+	log.Println("Starting User API service...")
 
-	// Create database connection string
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", dbUser, dbPassword, dbHost, dbPort, dbName)
+	// Phone numbers without a country code are parsed as numbers of this region
+	models.DefaultPhoneRegion = strings.ToUpper(getEnv("DEFAULT_PHONE_REGION", models.DefaultPhoneRegion))
+	if phonenumbers.GetCountryCodeForRegion(models.DefaultPhoneRegion) == 0 {
+		log.Fatalf("Unknown DEFAULT_PHONE_REGION: %s", models.DefaultPhoneRegion)
+	}
+	log.Printf("Default phone region: %s", models.DefaultPhoneRegion)
 
-	// Connect to database with retry logic
+	// Choose where users are stored
+	var userRepo repository.UserRepository
 	var db *sql.DB
-	var err error
-	maxRetries := 10
-	for i := 0; i < maxRetries; i++ {
-		log.Printf("Attempting to connect to database (attempt %d/%d)...", i+1, maxRetries)
-		db, err = sql.Open("mysql", dsn)
-		if err != nil {
-			log.Printf("Failed to open database connection: %v", err)
-			time.Sleep(2 * time.Second)
-			continue
-		}
-
-		// Test the connection
-		err = db.Ping()
-		if err != nil {
-			log.Printf("Failed to ping database: %v", err)
+	readyHandler := handlers.NewReadyHandler()
+	switch storage := getEnv("STORAGE", "mysql"); storage {
+	case "mysql":
+		db = openDatabase()
+		defer func() {
+			log.Println("Closing database connection...")
 			db.Close()
-			time.Sleep(2 * time.Second)
-			continue
+		}()
+		// Start serving right away and report ready once the database can be reached
+		connectCtx, stopConnecting := context.WithCancel(context.Background())
+		defer stopConnecting()
+		readyHandler.AddCheck("database", connectDatabase(connectCtx, db).Ready)
+		replicas := openReplicas()
+		defer func() {
+			for _, replica := range replicas {
+				replica.Close()
+			}
+		}()
+		userRepo = repository.NewMySQLUserRepository(db, replicas...)
+	case "memory":
+		log.Println("Using in-memory storage, users are lost when the service stops")
+		userRepo = repository.NewMemoryUserRepository()
+	default:
+		log.Fatalf("Unknown STORAGE: %s", storage)
+	}
+	// The outbox is read from the store itself, behind any decorators
+	outbox := userRepo.(repository.OutboxRepository)
+
+	// Retry transient database failures and stop calling the database while it is down
+	if db != nil {
+		opts := repository.ResilienceOptions{
+			MaxAttempts:      int(getEnvInt64("DB_RETRY_ATTEMPTS", int64(repository.DefaultResilienceOptions.MaxAttempts))),
+			BaseBackoff:      getEnvDuration("DB_RETRY_BACKOFF", repository.DefaultResilienceOptions.BaseBackoff),
+			MaxBackoff:       getEnvDuration("DB_RETRY_MAX_BACKOFF", repository.DefaultResilienceOptions.MaxBackoff),
+			ReadTimeout:      getEnvDuration("DB_READ_TIMEOUT", repository.DefaultResilienceOptions.ReadTimeout),
+			WriteTimeout:     getEnvDuration("DB_WRITE_TIMEOUT", repository.DefaultResilienceOptions.WriteTimeout),
+			FailureThreshold: int(getEnvInt64("DB_BREAKER_THRESHOLD", int64(repository.DefaultResilienceOptions.FailureThreshold))),
+			OpenFor:          getEnvDuration("DB_BREAKER_COOLDOWN", repository.DefaultResilienceOptions.OpenFor),
 		}
+		resilientRepo := repository.NewResilientUserRepository(userRepo, opts)
+		expvar.Publish("database_circuit", expvar.Func(func() interface{} { return resilientRepo.BreakerState() }))
+		userRepo = resilientRepo
+	}
 
-		log.Println("Successfully connected to database")
-		break
+	// Serve hot users from a cache in front of the store
+	switch cache := getEnv("USER_CACHE", "memory"); cache {
+	case "memory":
+		size, ttl := getEnvInt64("USER_CACHE_SIZE", 10000), getEnvDuration("USER_CACHE_TTL", time.Minute)
+		log.Printf("Caching up to %d users for %v", size, ttl)
+		cachedRepo := repository.NewCachedUserRepository(userRepo, repository.NewLRUCache(int(size), ttl))
+		expvar.Publish("user_cache", expvar.Func(func() interface{} { return cachedRepo.Stats() }))
+		userRepo = cachedRepo
+	case "none":
+	default:
+		log.Fatalf("Unknown USER_CACHE: %s", cache)
 	}
 
+	// Record every access to user data in the audit log
+	auditSink, err := openAuditSink(db)
 	if err != nil {
-		log.Fatalf("Could not connect to database after %d attempts: %v", maxRetries, err)
+		log.Fatalf("Could not open audit log: %v", err)
 	}
-	defer func() {
-		log.Println("Closing database connection...")
-		db.Close()
-	}()
+	auditOpts, err := auditOptions()
+	if err != nil {
+		log.Fatalf("Could not configure audit log: %v", err)
+	}
+	var auditLog *audit.Logger
+	if _, ok := auditSink.(*audit.SQLSink); ok {
+		// the database may not be up yet
+		auditLog = audit.NewDeferredLogger(auditSink, auditOpts)
+	} else if auditLog, err = audit.NewLogger(context.Background(), auditSink, auditOpts); err != nil {
+		log.Fatalf("Could not create audit logger: %v", err)
+	}
+	expvar.Publish("audit_failures", expvar.Func(func() interface{} { return auditLog.Failures() }))
 
-	// Configure database connection pool
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	// Publish user events from the outbox
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	relay := events.NewRelay(outbox, getEnvDuration("EVENT_RELAY_INTERVAL", time.Second), 100)
+	for _, name := range strings.Split(getEnv("EVENT_SINKS", "log"), ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "log":
+			relay.AddSink(events.LogSink)
+		case "", "none":
+		default:
+			log.Fatalf("Unknown event sink in EVENT_SINKS: %s", name)
+		}
+	}
+
+	// Deliver events to webhook subscribers
+	var webhookStore webhooks.Store
+	if db != nil {
+		webhookStore = webhooks.NewMySQLStore(db)
+	} else {
+		webhookStore = webhooks.NewMemoryStore()
+	}
+	webhookStore = webhooks.NewCachedStore(webhookStore, getEnvDuration("WEBHOOK_SUBSCRIPTION_CACHE_TTL", 10*time.Second))
+	webhookOptions := webhooks.DefaultOptions
+	webhookOptions.Workers = int(getEnvInt64("WEBHOOK_WORKERS", int64(webhookOptions.Workers)))
+	webhookOptions.AllowPrivateNetworks = getEnv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "false") == "true"
+	webhookDispatcher := webhooks.NewDispatcher(webhookStore, webhookOptions)
+	relay.AddSink(webhookDispatcher)
+
+	// Stream events to clients of /users/events and /ws. Only one relay may run against an
+	// outbox, so replicas of the service disable theirs, and with it their streams.
+	runRelay := getEnv("EVENT_RELAY", "true") == "true"
+	broadcaster := events.NewBroadcaster()
+	relay.AddPublishedSink(broadcaster)
+
+	// Requests are made on behalf of the actor their bearer token belongs to
+	tokens := apiTokens()
+	authenticator := handlers.TokenAuthenticator(tokens)
 
 	// Create handlers
-	userRepo := repository.NewMySQLUserRepository(db)
 	userHandler := handlers.NewUserHandler(userRepo)
+	historyHandler := handlers.NewHistoryHandler(userRepo.(repository.HistoryRepository))
 	pingHandler := handlers.NewPingHandler()
+	auditHandler := handlers.NewAuditHandler(auditSink)
+	webhookHandler := handlers.NewWebhookHandler(webhookStore)
+	webSocketHandler := handlers.NewWebSocketHandler(userRepo, broadcaster, authenticator, auditLog, splitList(getEnv("WS_ALLOWED_ORIGINS", "")))
+	eventStreamHandler := handlers.NewEventStreamHandler(broadcaster, outbox, getEnvDuration("EVENT_HEARTBEAT_INTERVAL", 15*time.Second))
+
+	importManager, err := imports.NewManager(userRepo, getEnv("IMPORT_DIR", filepath.Join(os.TempDir(), "userapi-imports")), 500, getEnvDuration("IMPORT_RETENTION", 24*time.Hour))
+	if err != nil {
+		log.Fatalf("Could not create import manager: %v", err)
+	}
+	importMaxBytes := getEnvInt64("IMPORT_MAX_BYTES", 100<<20)
+	importHandler := handlers.NewImportHandler(importManager, importMaxBytes)
+
+	graphqlService, err := graphqlapi.NewService(userRepo)
+	if err != nil {
+		log.Fatalf("Could not create GraphQL service: %v", err)
+	}
+	graphqlHandler := handlers.NewGraphQLHandler(graphqlService)
+	openAPIHandler := handlers.NewOpenAPIHandler()
 
 	// Create router
 	router := mux.NewRouter()
 
 	// Register routes
-	router.HandleFunc("/ping", pingHandler.Ping).Methods("GET")
-	router.HandleFunc("/users", userHandler.Create).Methods("POST")
-	router.HandleFunc("/users/{id}", userHandler.GetByID).Methods("GET")
-	router.HandleFunc("/users/{id}", userHandler.Update).Methods("PUT")
-	router.HandleFunc("/users/{id}", userHandler.Delete).Methods("DELETE")
-	router.HandleFunc("/users", userHandler.List).Methods("GET")
+	registerRoutes(router, apiHandlers{
+		ping:        pingHandler,
+		ready:       readyHandler,
+		user:        userHandler,
+		history:     historyHandler,
+		audit:       auditHandler,
+		webhook:     webhookHandler,
+		webSocket:   webSocketHandler,
+		eventStream: eventStreamHandler,
+		graphql:     graphqlHandler,
+		imports:     importHandler,
+		openAPI:     openAPIHandler,
+	}, auditLog, runRelay)
+
+	// Generate the OpenAPI document from the routes, which fails if a route is not described
+	apiDoc, err := handlers.GenerateOpenAPI(router)
+	if err != nil {
+		log.Fatalf("Could not generate OpenAPI document: %v", err)
+	}
+	if err := openAPIHandler.SetDocument(apiDoc); err != nil {
+		log.Fatalf("Could not serve OpenAPI document: %v", err)
+	}
 
 	// Add logging middleware
 	router.Use(loggingMiddleware)
 
+	// Record who made each request and under which ID, for the user history
+	router.Use(handlers.RequestContextMiddleware(authenticator))
+
+	// Record sanitized traffic for the replay command when RECORD_FILE is set
+	if path := getEnv("RECORD_FILE", ""); path != "" {
+		recorder, err := recording.Create(path)
+		if err != nil {
+			log.Fatalf("Could not record traffic: %v", err)
+		}
+		defer recorder.Close()
+		sanitizer := recording.NewSanitizer(recordingKey())
+		router.Use(handlers.RecordingMiddleware(recorder, sanitizer, int(getEnvInt64("RECORD_MAX_BODY", 64<<10))))
+		log.Printf("Recording traffic to %s", path)
+	}
+
+	// Reject requests that do not match the OpenAPI document, and in development also
+	// responses, so that the handlers and the document cannot drift apart unnoticed. JSON
+	// bodies are read up to MAX_BODY_BYTES, larger ones are refused with 413.
+	development := getEnv("APP_ENV", "production") == "development"
+	router.Use(handlers.OpenAPIValidationMiddleware(apiDoc, getEnvInt64("MAX_BODY_BYTES", 10<<20), development))
+
+	// Replay responses for retried requests that carry an Idempotency-Key. Keyed bodies are
+	// read up to IDEMPOTENCY_MAX_BODY, except uploads, which keep their own limit.
+	idempotencyTTL := getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+	idempotencyMaxBody := getEnvInt64("IDEMPOTENCY_MAX_BODY", 10<<20)
+	idempotencyRouteMaxBody := map[string]int64{"/imports": importMaxBytes}
+	router.Use(handlers.IdempotencyMiddleware(handlers.NewMemoryIdempotencyStore(idempotencyTTL), idempotencyMaxBody, idempotencyRouteMaxBody))
+
 	// Serve static documentation
 	router.PathPrefix("/docs/").Handler(http.StripPrefix("/docs/", http.FileServer(http.Dir("docs"))))
 
@@ -102,7 +258,32 @@ func main() {
 	log.Printf("Server starting on port %s", port)
 	log.Printf("API documentation available at http://localhost:%s/docs/", port)
 	log.Printf("Health check available at http://localhost:%s/ping", port)
+	log.Printf("Readiness check available at http://localhost:%s/ready", port)
 	
+	// Serve the gRPC API on its own port
+	grpcPort := getEnv("GRPC_PORT", "9090")
+	grpcListener, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		log.Fatalf("Could not listen on gRPC port %s: %v", grpcPort, err)
+	}
+	grpcServer := grpcserver.NewServer(userRepo, grpcserver.TokenAuthenticator(tokens), auditLog)
+	go func() {
+		log.Printf("gRPC server starting on port %s", grpcPort)
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+	defer grpcServer.GracefulStop()
+
+	if runRelay {
+		go relay.Run(relayCtx)
+	} else {
+		log.Println("Event relay disabled, /users/events and /ws answer 503")
+	}
+	// Every instance posts due webhooks; each delivery is claimed by one of them at a time
+	go webhookDispatcher.Run(relayCtx)
+	go importManager.Run(relayCtx)
+
 	server := &http.Server{
 		Addr:         ":" + port,
 		Handler:      router,
@@ -114,6 +295,175 @@ func main() {
 	log.Fatal(server.ListenAndServe())
 }
 
+// openDatabase opens the MySQL database configured by the environment. No connection is made
+// until the database is used; see connectDatabase.
+func openDatabase() *sql.DB {
+	// Get database configuration from environment variables
+	dbHost := getEnv("DB_HOST", "localhost")
+	dbPort := getEnv("DB_PORT", "3306")
+
+	log.Printf("Database configuration: host=%s, port=%s, user=%s, database=%s", dbHost, dbPort, getEnv("DB_USER", "root"), getEnv("DB_NAME", "userdb"))
+
+	db, err := sql.Open("mysql", databaseDSN(dbHost, dbPort))
+	if err != nil {
+		log.Fatalf("Could not open database: %v", err)
+	}
+
+	// Configure database connection pool
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(25)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	return db
+}
+
+// connectDatabase keeps connecting to db in the background until ctx is done, backing off
+// between attempts while the database is starting up or away
+func connectDatabase(ctx context.Context, db *sql.DB) *repository.Connector {
+	connector := repository.NewConnector(db, repository.ConnectorOptions{
+		BaseBackoff:   getEnvDuration("DB_CONNECT_BACKOFF", repository.DefaultConnectorOptions.BaseBackoff),
+		MaxBackoff:    getEnvDuration("DB_CONNECT_MAX_BACKOFF", repository.DefaultConnectorOptions.MaxBackoff),
+		CheckInterval: getEnvDuration("DB_HEALTH_INTERVAL", repository.DefaultConnectorOptions.CheckInterval),
+		PingTimeout:   repository.DefaultConnectorOptions.PingTimeout,
+	})
+	go connector.Run(ctx)
+	return connector
+}
+
+// openReplicas opens the read replicas listed in DB_REPLICAS as host:port pairs, with the
+// credentials of the primary. Replicas that cannot be reached stay out of rotation until
+// they can, so they are not waited for.
+func openReplicas() []*sql.DB {
+	repository.ReadYourWritesWindow = getEnvDuration("DB_READ_YOUR_WRITES_WINDOW", repository.ReadYourWritesWindow)
+	repository.MaxReplicaLag = getEnvDuration("DB_MAX_REPLICA_LAG", repository.MaxReplicaLag)
+
+	var replicas []*sql.DB
+	for _, address := range splitList(getEnv("DB_REPLICAS", "")) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			host, port = address, "3306"
+		}
+		db, err := sql.Open("mysql", databaseDSN(host, port))
+		if err != nil {
+			log.Fatalf("Could not open replica %s: %v", address, err)
+		}
+		db.SetMaxOpenConns(25)
+		db.SetMaxIdleConns(25)
+		db.SetConnMaxLifetime(5 * time.Minute)
+		log.Printf("Reading from replica %s", address)
+		replicas = append(replicas, db)
+	}
+	if len(replicas) > 0 && getEnv("API_TOKENS", "") == "" && getEnv("WS_TOKENS", "") == "" {
+		log.Println("Replicas will not serve any reads, as every request is anonymous and read from the primary; set API_TOKENS to use them")
+	}
+	return replicas
+}
+
+// databaseDSN returns the connection string for the database at host and port
+func databaseDSN(host, port string) string {
+	user := getEnv("DB_USER", "root")
+	password := getEnv("DB_PASSWORD", "root")
+	name := getEnv("DB_NAME", "userdb")
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", user, password, host, port, name)
+}
+
+// openAuditSink opens the audit sink chosen by AUDIT_SINK. It defaults to the audit_log table
+// when db is set and to files in AUDIT_DIR otherwise.
+func openAuditSink(db *sql.DB) (audit.Sink, error) {
+	defaultSink := "file"
+	if db != nil {
+		defaultSink = "db"
+	}
+
+	switch sink := getEnv("AUDIT_SINK", defaultSink); sink {
+	case "db":
+		if db == nil {
+			return nil, fmt.Errorf("AUDIT_SINK=db requires STORAGE=mysql")
+		}
+		log.Println("Writing audit log to the audit_log table")
+		return audit.NewSQLSink(db), nil
+	case "file":
+		dir := getEnv("AUDIT_DIR", "audit-log")
+		log.Printf("Writing audit log to %s", dir)
+		return audit.NewFileSink(dir, getEnvInt64("AUDIT_MAX_BYTES", 64<<20))
+	default:
+		return nil, fmt.Errorf("unknown AUDIT_SINK: %s", sink)
+	}
+}
+
+// auditOptions reads the key of the audit hashes from AUDIT_HMAC_KEY and the file the head
+// of the chain is anchored in from AUDIT_ANCHOR_FILE. The key may only be left out in
+// development, where the hashes are then keyed with nothing.
+func auditOptions() (audit.Options, error) {
+	var opts audit.Options
+	if key := getEnv("AUDIT_HMAC_KEY", ""); key != "" {
+		opts.Key = []byte(key)
+	} else if getEnv("APP_ENV", "production") == "development" {
+		log.Println("AUDIT_HMAC_KEY is not set, so anyone who can write the audit log can rewrite its chain")
+	} else {
+		return opts, fmt.Errorf("AUDIT_HMAC_KEY must be set outside development")
+	}
+	if path := getEnv("AUDIT_ANCHOR_FILE", ""); path != "" {
+		opts.Anchor = audit.NewFileAnchor(path)
+	} else {
+		log.Println("AUDIT_ANCHOR_FILE is not set, so entries removed from the end of the audit log go unnoticed")
+	}
+	return opts, nil
+}
+
+// recordingKey returns the key pseudonyms in recordings are derived with. Without RECORD_KEY,
+// a random one is used, so pseudonyms only match within a run of the server.
+func recordingKey() []byte {
+	if key := getEnv("RECORD_KEY", ""); key != "" {
+		return []byte(key)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Could not generate a recording key: %v", err)
+	}
+	return key
+}
+
+// apiTokens reads API_TOKENS, a comma separated list of token:actor pairs, and WS_TOKENS, its
+// older name. Requests that carry one of the tokens are made on behalf of its actor; without
+// any, every request is anonymous and the WebSocket and webhook APIs refuse every client.
+func apiTokens() map[string]string {
+	tokens := make(map[string]string)
+	for _, name := range []string{"API_TOKENS", "WS_TOKENS"} {
+		for _, pair := range splitList(getEnv(name, "")) {
+			token, actor, ok := strings.Cut(pair, ":")
+			if !ok || token == "" || actor == "" {
+				log.Fatalf("Invalid entry in %s, want token:actor", name)
+			}
+			tokens[token] = actor
+		}
+	}
+	if len(tokens) == 0 {
+		log.Println("Every request is anonymous and the WebSocket and webhook APIs disabled, set API_TOKENS to enable authentication")
+	}
+	return tokens
+}
+
+// liveEvents returns handler on the instance that runs the event relay, and a handler that
+// refuses with 503 on the others, whose streams would never receive an event
+func liveEvents(runRelay bool, handler http.HandlerFunc) http.HandlerFunc {
+	if runRelay {
+		return handler
+	}
+	return handlers.RelayUnavailable
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -122,6 +472,34 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	// every duration setting is a wait, timeout or interval; zero would spin the loops that
+	// wait for it and a negative one panics in rand.Int63n and time.NewTicker
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid duration for %s=%q, want a positive duration, using default %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+func getEnvInt64(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid number for %s=%q, using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
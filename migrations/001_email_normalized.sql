@@ -0,0 +1,17 @@
+-- Moves the unique email index to a normalized (trimmed, lowercased) copy of the email,
+-- so that Bob@example.com and bob@example.com can no longer both exist.
+--
+-- Emails were restricted to ASCII before this migration, so LOWER(TRIM(email)) matches
+-- models.NormalizeEmail for every existing row. Find users that would collide first:
+--
+--   SELECT LOWER(TRIM(email)) AS email_normalized, GROUP_CONCAT(id)
+--   FROM users GROUP BY email_normalized HAVING COUNT(*) > 1;
+
+ALTER TABLE users ADD COLUMN email_normalized VARCHAR(255) NULL AFTER email;
+
+UPDATE users SET email_normalized = LOWER(TRIM(email));
+
+ALTER TABLE users
+    MODIFY email_normalized VARCHAR(255) NOT NULL,
+    DROP INDEX unique_email,
+    ADD UNIQUE KEY unique_email_normalized (email_normalized);
@@ -0,0 +1,16 @@
+-- Keeps the phone number as entered next to the E.164 form in phone_number, and indexes
+-- phone_number for searches by number.
+--
+-- Existing numbers cannot be converted to E.164 in SQL. They are copied to phone_number_raw
+-- as they are; convert phone_number right after this migration with
+--
+--   go run . backfill-phones
+--
+-- Until then searches by phone number do not find users created before the migration. The
+-- command can be run again at any time and only rewrites numbers that are not in E.164 yet.
+
+ALTER TABLE users
+    ADD COLUMN phone_number_raw VARCHAR(64) NOT NULL DEFAULT '' AFTER phone_number,
+    ADD KEY idx_phone_number (phone_number);
+
+UPDATE users SET phone_number_raw = phone_number;
@@ -0,0 +1,4 @@
+-- Adds the FULLTEXT index used by GET /users/search. Adding the first FULLTEXT index
+-- rebuilds the table and blocks writes while it runs, so apply it during low traffic.
+
+ALTER TABLE users ADD FULLTEXT KEY ft_users_search (name, email, phone_number_raw);
@@ -0,0 +1,33 @@
+-- Adds the history of every user. Revisions are written in the same transaction as the
+-- change they describe and are kept when the user is deleted, so user_id has no foreign key.
+--
+-- Existing users get a baseline "create" revision with their current state, so that
+-- GET /users/{id}/history and reverts work for them too. Their earlier changes are unknown.
+
+CREATE TABLE IF NOT EXISTS user_revisions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    changes JSON NOT NULL,
+    snapshot JSON NULL,
+    revert_of BIGINT NULL,
+    created_at DATETIME(6) NOT NULL,
+    KEY idx_user_revisions_user (user_id, id)
+);
+
+INSERT INTO user_revisions (user_id, action, actor, changes, snapshot, created_at)
+SELECT id, 'create', 'migration',
+    JSON_ARRAY(
+        JSON_OBJECT('field', 'name', 'from', NULL, 'to', name),
+        JSON_OBJECT('field', 'age', 'from', NULL, 'to', age),
+        JSON_OBJECT('field', 'phone_number', 'from', NULL, 'to', phone_number),
+        JSON_OBJECT('field', 'phone_number_raw', 'from', NULL, 'to', phone_number_raw),
+        JSON_OBJECT('field', 'email', 'from', NULL, 'to', email)),
+    JSON_OBJECT('id', id, 'name', name, 'age', age, 'phone_number', phone_number,
+        'phone_number_raw', phone_number_raw, 'email', email),
+    -- the service writes revision times in UTC
+    CONVERT_TZ(COALESCE(updated_at, created_at, CURRENT_TIMESTAMP), @@session.time_zone, '+00:00')
+FROM users
+WHERE id NOT IN (SELECT user_id FROM user_revisions);
@@ -0,0 +1,20 @@
+-- Adds the audit trail of access to user data, written by the service when AUDIT_SINK=db.
+-- Entries are hash-chained by seq, so the table must only ever be appended to. Consider
+-- revoking UPDATE and DELETE on it from the service's database user:
+--
+--   REVOKE UPDATE, DELETE ON userdb.audit_log FROM 'userapi'@'%';
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    seq BIGINT PRIMARY KEY,
+    created_at DATETIME(6) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    target VARCHAR(2048) NOT NULL,
+    outcome VARCHAR(16) NOT NULL,
+    status INT NOT NULL,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    KEY idx_audit_log_actor (actor, seq),
+    KEY idx_audit_log_created_at (created_at)
+);
@@ -0,0 +1,19 @@
+-- Adds the transactional outbox. Every user change adds its UserCreated, UserUpdated or
+-- UserDeleted event here in the same transaction, and the relay in the service publishes
+-- pending events (published_at IS NULL) in id order.
+--
+-- Published events are kept. Trim them once downstream systems no longer need to replay:
+--
+--   DELETE FROM outbox WHERE published_at < NOW() - INTERVAL 30 DAY;
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    aggregate_id BIGINT NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    published_at DATETIME(6) NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error VARCHAR(1024) NOT NULL DEFAULT '',
+    KEY idx_outbox_pending (published_at, id)
+);
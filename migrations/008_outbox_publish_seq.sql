@@ -0,0 +1,13 @@
+-- Orders the published events of the outbox. Outbox IDs are assigned when a change is
+-- inserted, not when it commits, so a transaction that commits late can add an event with
+-- a lower ID than events that have already been published. publish_seq is assigned by the
+-- relay when it marks events published, so it only grows as subscribers see events, and
+-- /users/events resumes from it.
+--
+-- Events published before this migration keep their ID as their position.
+
+ALTER TABLE outbox
+    ADD COLUMN publish_seq BIGINT NULL AFTER published_at,
+    ADD UNIQUE KEY idx_outbox_publish_seq (publish_seq);
+
+UPDATE outbox SET publish_seq = id WHERE published_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Lets the webhook dispatcher claim the due deliveries of one subscription. Without it the
+-- claiming SELECT ... FOR UPDATE would lock the due deliveries of every subscription.
+
+ALTER TABLE webhook_deliveries
+    ADD KEY idx_webhook_deliveries_subscription_due (subscription_id, status, next_attempt_at);
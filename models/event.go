@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// Event types
+const (
+	EventUserCreated = "UserCreated"
+	EventUserUpdated = "UserUpdated"
+	EventUserDeleted = "UserDeleted"
+)
+
+// UserEvent tells downstream systems that a user changed. Events of one user are published
+// in the order the changes were made; IDs increase in that order. Across users, IDs follow
+// the order of the inserts rather than the commits, so the order in which events were
+// published is given by Seq.
+type UserEvent struct {
+	ID int64 `json:"id" xml:"id"`
+	// Seq is the position of the event in the published stream, set once it is published
+	Seq    int64  `json:"seq,omitempty" xml:"seq,omitempty"`
+	Type   string `json:"type" xml:"type"`
+	UserID int64  `json:"user_id" xml:"user_id"`
+	// User is the user after the change, or nil if it was deleted
+	User      *User         `json:"user,omitempty" xml:"user,omitempty"`
+	Changes   []FieldChange `json:"changes" xml:"changes>change"`
+	Actor     string        `json:"actor" xml:"actor"`
+	RequestID string        `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	// Revision is the history revision that recorded the change
+	Revision   int64     `json:"revision" xml:"revision"`
+	OccurredAt time.Time `json:"occurred_at" xml:"occurred_at"`
+}
+
+// EventFromRevision returns the event announcing the change that rev recorded. A revert
+// that brings a deleted user back is announced as a creation.
+func EventFromRevision(rev *UserRevision) *UserEvent {
+	eventType := EventUserUpdated
+	switch {
+	case rev.Action == RevisionCreate:
+		eventType = EventUserCreated
+	case rev.Action == RevisionDelete:
+		eventType = EventUserDeleted
+	case rev.Action == RevisionRevert && len(rev.Changes) > 0 && rev.Changes[0].From == nil:
+		// only changes to a user that did not exist have no old values
+		eventType = EventUserCreated
+	}
+
+	e := &UserEvent{
+		Type:       eventType,
+		UserID:     rev.UserID,
+		Changes:    rev.Changes,
+		Actor:      rev.Actor,
+		RequestID:  rev.RequestID,
+		Revision:   rev.ID,
+		OccurredAt: rev.CreatedAt,
+	}
+	if rev.Snapshot != nil {
+		user := *rev.Snapshot
+		e.User = &user
+	}
+	return e
+}
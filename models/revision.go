@@ -0,0 +1,65 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Revision actions
+const (
+	RevisionCreate = "create"
+	RevisionUpdate = "update"
+	RevisionDelete = "delete"
+	RevisionRevert = "revert"
+)
+
+// UserRevision records a single change to a user: who made it, in which request, which
+// fields changed and what the user looked like afterwards
+type UserRevision struct {
+	ID        int64         `json:"id" xml:"id"`
+	UserID    int64         `json:"user_id" xml:"user_id"`
+	Action    string        `json:"action" xml:"action"`
+	Actor     string        `json:"actor" xml:"actor"`
+	RequestID string        `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	Changes   []FieldChange `json:"changes" xml:"changes>change"`
+	// Snapshot is the user after the change, or nil if the change deleted it
+	Snapshot *User `json:"snapshot,omitempty" xml:"snapshot,omitempty"`
+	// RevertOf is the revision a revert restored
+	RevertOf  int64     `json:"revert_of,omitempty" xml:"revert_of,omitempty"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// FieldChange is the old and new value of one field. From is nil for created users and To
+// is nil for deleted ones.
+type FieldChange struct {
+	Field string      `json:"field" xml:"field"`
+	From  interface{} `json:"from" xml:"from,omitempty"`
+	To    interface{} `json:"to" xml:"to,omitempty"`
+}
+
+// DiffUsers lists the fields that differ between before and after by their JSON names,
+// in declaration order. Either user may be nil. The ID is not compared.
+func DiffUsers(before, after *User) []FieldChange {
+	t := reflect.TypeOf(User{})
+	changes := []FieldChange{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "id" {
+			continue
+		}
+
+		var from, to interface{}
+		if before != nil {
+			from = reflect.ValueOf(before).Elem().Field(i).Interface()
+		}
+		if after != nil {
+			to = reflect.ValueOf(after).Elem().Field(i).Interface()
+		}
+		if before != nil && after != nil && from == to {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: name, From: from, To: to})
+	}
+	return changes
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffUsers(t *testing.T) {
+	john := &User{ID: 1, Name: "John Doe", Age: 30, PhoneNumber: "+14155552671", Email: "john@example.com"}
+	older := *john
+	older.Age = 31
+	moved := *john
+	moved.ID = 2
+
+	tests := []struct {
+		name   string
+		before *User
+		after  *User
+		want   []FieldChange
+	}{
+		{"created", nil, john, []FieldChange{
+			{Field: "name", To: "John Doe"},
+			{Field: "age", To: 30},
+			{Field: "phone_number", To: "+14155552671"},
+			{Field: "phone_number_raw", To: ""},
+			{Field: "email", To: "john@example.com"},
+		}},
+		{"one field changed", john, &older, []FieldChange{{Field: "age", From: 30, To: 31}}},
+		{"only the ID differs", john, &moved, []FieldChange{}},
+		{"deleted", john, nil, []FieldChange{
+			{Field: "name", From: "John Doe"},
+			{Field: "age", From: 30},
+			{Field: "phone_number", From: "+14155552671"},
+			{Field: "phone_number_raw", From: ""},
+			{Field: "email", From: "john@example.com"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffUsers(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffUsers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
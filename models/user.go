@@ -5,18 +5,93 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+	"golang.org/x/net/idna"
 )
 
 // User represents a user in the system
 type User struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	Age         int    `json:"age"`
-	PhoneNumber string `json:"phone_number"`
-	Email       string `json:"email"`
+	ID          int64  `json:"id" xml:"id"`
+	Name        string `json:"name" xml:"name"`
+	Age         int    `json:"age" xml:"age"`
+	PhoneNumber string `json:"phone_number" xml:"phone_number"`
+	// PhoneNumberRaw is the phone number as it was entered, before normalization to E.164
+	PhoneNumberRaw string `json:"phone_number_raw,omitempty" xml:"phone_number_raw,omitempty"`
+	Email          string `json:"email" xml:"email"`
 }
 
-var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+// MaxPhoneNumberLength is the size of the phone_number_raw column, in bytes
+const MaxPhoneNumberLength = 64
+
+// DefaultPhoneRegion is the ISO 3166-1 region used to parse phone numbers that are not in
+// international format
+var DefaultPhoneRegion = "US"
+
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.([a-zA-Z]{2,}|xn--[a-zA-Z0-9-]+)$`)
+
+// NormalizeEmail returns the canonical form of email used to compare addresses: trimmed,
+// lowercased, and with an internationalized domain converted to punycode. Two emails that
+// normalize to the same value belong to the same user.
+func NormalizeEmail(email string) (string, error) {
+	local, domain, err := splitEmail(email)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(local) + "@" + domain, nil
+}
+
+// Normalize trims the user's fields, converts the phone number to E.164 and lowercases the
+// domain of the email, converting it to punycode if needed. The local part keeps its case,
+// as entered by the user. The phone number as entered is kept in PhoneNumberRaw.
+func (u *User) Normalize() error {
+	u.Name = strings.TrimSpace(u.Name)
+
+	phone, err := NormalizePhoneNumber(u.PhoneNumber, DefaultPhoneRegion)
+	if err != nil {
+		return err
+	}
+	u.PhoneNumberRaw = strings.TrimSpace(u.PhoneNumber)
+	u.PhoneNumber = phone
+
+	local, domain, err := splitEmail(u.Email)
+	if err != nil {
+		return err
+	}
+	u.Email = local + "@" + domain
+	return nil
+}
+
+// NormalizePhoneNumber parses phone, using region for numbers without a country code, and
+// returns it in E.164 format. Numbers that are impossible under the numbering plan of their
+// country are rejected.
+func NormalizePhoneNumber(phone, region string) (string, error) {
+	num, err := phonenumbers.Parse(strings.TrimSpace(phone), strings.ToUpper(region))
+	if err != nil {
+		return "", fmt.Errorf("invalid phone number %q: %w", phone, err)
+	}
+	if !phonenumbers.IsValidNumber(num) {
+		return "", fmt.Errorf("invalid phone number %q for region %s", phone, phonenumbers.GetRegionCodeForNumber(num))
+	}
+	return phonenumbers.Format(num, phonenumbers.E164), nil
+}
+
+// splitEmail splits a trimmed email at its last @ and converts the domain to its lowercase
+// ASCII form
+func splitEmail(email string) (string, string, error) {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", "", fmt.Errorf("invalid email format: %s", email)
+	}
+
+	domain, err := idna.Lookup.ToASCII(email[at+1:])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid email domain: %s: %w", email, err)
+	}
+	return email[:at], domain, nil
+}
 
 // Validate validates the user data
 func (u *User) Validate() error {
@@ -40,13 +115,29 @@ func (u *User) Validate() error {
 		return err
 	}
 
+	if len(u.PhoneNumber) > MaxPhoneNumberLength {
+		err := fmt.Errorf("phone number must be at most %d characters", MaxPhoneNumberLength)
+		log.Printf("Validation error: %v", err)
+		return err
+	}
+
+	if _, err := NormalizePhoneNumber(u.PhoneNumber, DefaultPhoneRegion); err != nil {
+		log.Printf("Validation error: %v", err)
+		return err
+	}
+
 	if u.Email == "" {
 		err := errors.New("email is required")
 		log.Printf("Validation error: %v", err)
 		return err
 	}
 
-	if !emailRegex.MatchString(u.Email) {
+	normalized, err := NormalizeEmail(u.Email)
+	if err != nil {
+		log.Printf("Validation error: %v", err)
+		return err
+	}
+	if !emailRegex.MatchString(normalized) {
 		err := fmt.Errorf("invalid email format: %s", u.Email)
 		log.Printf("Validation error: %v", err)
 		return err
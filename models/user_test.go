@@ -15,7 +15,7 @@ func TestUser_Validate(t *testing.T) {
 			user: User{
 				Name:        "John Doe",
 				Age:         30,
-				PhoneNumber: "+1234567890",
+				PhoneNumber: "+14155552671",
 				Email:       "john@example.com",
 			},
 			wantErr: false,
@@ -25,17 +25,47 @@ func TestUser_Validate(t *testing.T) {
 			user: User{
 				Name:        "John Doe",
 				Age:         30,
-				PhoneNumber: "+1234567890",
+				PhoneNumber: "+14155552671",
 				Email:       "invalid-email",
 			},
 			wantErr: true,
 		},
+		{
+			name: "internationalized domain",
+			user: User{
+				Name:        "John Doe",
+				Age:         30,
+				PhoneNumber: "+14155552671",
+				Email:       " John@Bücher.example ",
+			},
+			wantErr: false,
+		},
+		{
+			name: "national phone number in default region",
+			user: User{
+				Name:        "John Doe",
+				Age:         30,
+				PhoneNumber: "(415) 555-2671",
+				Email:       "john@example.com",
+			},
+			wantErr: false,
+		},
+		{
+			name: "impossible phone number",
+			user: User{
+				Name:        "John Doe",
+				Age:         30,
+				PhoneNumber: "+1234567890",
+				Email:       "john@example.com",
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid age",
 			user: User{
 				Name:        "John Doe",
 				Age:         -1,
-				PhoneNumber: "+1234567890",
+				PhoneNumber: "+14155552671",
 				Email:       "john@example.com",
 			},
 			wantErr: true,
@@ -50,4 +80,78 @@ func TestUser_Validate(t *testing.T) {
 			}
 		})
 	}
-} 
\ No newline at end of file
+} 
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		want    string
+		wantErr bool
+	}{
+		{name: "already normalized", email: "bob@example.com", want: "bob@example.com"},
+		{name: "mixed case and whitespace", email: "  Bob@Example.COM\t", want: "bob@example.com"},
+		{name: "internationalized domain", email: "bob@Bücher.example", want: "bob@xn--bcher-kva.example"},
+		{name: "missing domain", email: "bob@", wantErr: true},
+		{name: "missing at", email: "bob", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeEmail(tt.email)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeEmail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeEmail() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUser_Normalize(t *testing.T) {
+	user := User{Name: " Bob ", PhoneNumber: " (415) 555-2671 ", Email: " Bob@Bücher.Example "}
+	if err := user.Normalize(); err != nil {
+		t.Fatalf("User.Normalize() error = %v", err)
+	}
+	if user.Name != "Bob" {
+		t.Errorf("Name = %q, want %q", user.Name, "Bob")
+	}
+	if want := "+14155552671"; user.PhoneNumber != want {
+		t.Errorf("PhoneNumber = %q, want %q", user.PhoneNumber, want)
+	}
+	if want := "(415) 555-2671"; user.PhoneNumberRaw != want {
+		t.Errorf("PhoneNumberRaw = %q, want %q", user.PhoneNumberRaw, want)
+	}
+	if want := "Bob@xn--bcher-kva.example"; user.Email != want {
+		t.Errorf("Email = %q, want %q", user.Email, want)
+	}
+}
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		phone   string
+		region  string
+		want    string
+		wantErr bool
+	}{
+		{name: "already E.164", phone: "+14155552671", region: "US", want: "+14155552671"},
+		{name: "national format", phone: "(415) 555-2671", region: "US", want: "+14155552671"},
+		{name: "international format ignores region", phone: "+44 20 7946 0958", region: "US", want: "+442079460958"},
+		{name: "national format in another region", phone: "020 7946 0958", region: "gb", want: "+442079460958"},
+		{name: "too short for the plan", phone: "+1 415 555", region: "US", wantErr: true},
+		{name: "not a number", phone: "call me", region: "US", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePhoneNumber(tt.phone, tt.region)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizePhoneNumber() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizePhoneNumber() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteDoc describes what a route does, for the parts of the document that cannot be read
+// from the route table and the Go types
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Hidden leaves the route out of the document, for routes that are not part of the API
+	Hidden bool
+	Params []ParamDoc
+	// Body is a value of the type of the request body, or nil if the route takes none
+	Body interface{}
+	// BodyMediaTypes are the media types the body can be sent as, by default those of Options
+	BodyMediaTypes []string
+	Responses      map[int]ResponseDoc
+}
+
+// ParamDoc describes a parameter. Every variable of a route's path has to be described.
+type ParamDoc struct {
+	Name string
+	// In is path, query or header
+	In          string
+	Description string
+	Required    bool
+	// Schema is the schema of the value; a string without bounds if nil
+	Schema *Schema
+}
+
+// ResponseDoc describes a response
+type ResponseDoc struct {
+	Description string
+	// Body is a value of the type of the response body, or nil if the response has none.
+	// With several values, the body is any one of them.
+	Body []interface{}
+	// MediaTypes are the media types the body can be sent as, by default those of Options
+	MediaTypes []string
+}
+
+// Options configure the generated document
+type Options struct {
+	Info Info
+	// Routes describe the routes by method and path template, as in "GET /users/{id}"
+	Routes map[string]RouteDoc
+	// MediaTypes are the media types of bodies that do not list their own
+	MediaTypes []string
+	// Refine adjusts the schemas of struct types, for constraints the types do not tell
+	Refine map[reflect.Type]func(*Schema)
+}
+
+// pathVariable matches a variable in a mux path template, with its pattern if it has one
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+// Generate builds the document of the routes of router. Every route with methods has to
+// be described in opts.Routes, so that the document cannot miss a route; routes without
+// methods, such as static files, are left out.
+func Generate(router *mux.Router, opts Options) (*Document, error) {
+	doc := &Document{OpenAPI: Version, Info: opts.Info, Paths: make(map[string]*PathItem)}
+	g := &generator{opts: opts, schemas: newSchemas(opts.Refine)}
+	described := make(map[string]bool)
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := pathVariable.ReplaceAllString(template, "{$1}")
+		for _, method := range methods {
+			key := method + " " + path
+			if described[key] {
+				// another route for the same operation, such as one matching on a query
+				continue
+			}
+			described[key] = true
+
+			rd, ok := opts.Routes[key]
+			if !ok {
+				return fmt.Errorf("route %s is not described", key)
+			}
+			if rd.Hidden {
+				continue
+			}
+			op, err := g.operation(method, path, rd)
+			if err != nil {
+				return fmt.Errorf("route %s: %w", key, err)
+			}
+			item := doc.Paths[path]
+			if item == nil {
+				item = &PathItem{}
+				doc.Paths[path] = item
+			}
+			(*item)[strings.ToLower(method)] = op
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range sortedKeys(opts.Routes) {
+		if !described[key] {
+			return nil, fmt.Errorf("described route %s does not exist", key)
+		}
+	}
+	doc.Components.Schemas = g.schemas.components
+	return doc, nil
+}
+
+type generator struct {
+	opts    Options
+	schemas *schemas
+}
+
+func (g *generator) operation(method, path string, rd RouteDoc) (*Operation, error) {
+	op := &Operation{
+		OperationID: operationID(method, path),
+		Summary:     rd.Summary,
+		Description: rd.Description,
+		Tags:        rd.Tags,
+		Responses:   make(map[string]*Response),
+	}
+
+	variables := make(map[string]bool)
+	for _, m := range pathVariable.FindAllStringSubmatch(path, -1) {
+		variables[m[1]] = true
+	}
+	for _, p := range rd.Params {
+		if p.In == "path" && !variables[p.Name] {
+			return nil, fmt.Errorf("path parameter %s is not in the path", p.Name)
+		}
+		delete(variables, p.Name)
+		schema := p.Schema
+		if schema == nil {
+			schema = &Schema{Type: Types{"string"}}
+		}
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required || p.In == "path",
+			Schema:      schema,
+		})
+	}
+	if missing := sortedKeys(variables); len(missing) > 0 {
+		return nil, fmt.Errorf("path parameter %s is not described", missing[0])
+	}
+
+	if rd.Body != nil {
+		op.RequestBody = &RequestBody{Required: true, Content: g.content(rd.BodyMediaTypes, []interface{}{rd.Body})}
+	}
+	for status, resp := range rd.Responses {
+		description := resp.Description
+		if description == "" {
+			description = http.StatusText(status)
+		}
+		r := &Response{Description: description}
+		if len(resp.Body) > 0 {
+			r.Content = g.content(resp.MediaTypes, resp.Body)
+		}
+		op.Responses[strconv.Itoa(status)] = r
+	}
+	return op, nil
+}
+
+// content gives each media type the schema of the bodies
+func (g *generator) content(mediaTypes []string, bodies []interface{}) map[string]*MediaType {
+	if len(mediaTypes) == 0 {
+		mediaTypes = g.opts.MediaTypes
+	}
+	var schema *Schema
+	if len(bodies) == 1 {
+		schema = g.bodySchema(bodies[0])
+	} else {
+		schema = &Schema{}
+		for _, body := range bodies {
+			schema.AnyOf = append(schema.AnyOf, g.bodySchema(body))
+		}
+	}
+	content := make(map[string]*MediaType, len(mediaTypes))
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = &MediaType{Schema: schema}
+	}
+	return content
+}
+
+// bodySchema returns the schema of body, which is either a value of the body's type or a
+// *Schema for bodies that are not Go values, such as files
+func (g *generator) bodySchema(body interface{}) *Schema {
+	if schema, ok := body.(*Schema); ok {
+		return schema
+	}
+	t := reflect.TypeOf(body)
+	if t.Kind() == reflect.Slice {
+		// lists are encoded as arrays even when empty
+		return &Schema{Type: Types{"array"}, Items: g.schemas.of(t.Elem())}
+	}
+	return g.schemas.of(t)
+}
+
+// operationID names an operation after its method and path, as in getUsersById
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '{')
+	}) {
+		if strings.HasPrefix(word, "{") {
+			b.WriteString("By")
+			word = word[1:]
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
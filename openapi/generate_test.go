@@ -0,0 +1,159 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type testAddress struct {
+	City string `json:"city"`
+}
+
+type testBase struct {
+	ID int64 `json:"id"`
+}
+
+type testItem struct {
+	testBase
+	Name      string            `json:"name"`
+	Tags      []string          `json:"tags,omitempty"`
+	Address   *testAddress      `json:"address,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Secret    string            `json:"-"`
+	internal  string
+}
+
+func noop(http.ResponseWriter, *http.Request) {}
+
+func TestGenerate(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/items", noop).Methods("POST")
+	router.HandleFunc("/items/{id:[0-9]+}", noop).Methods("GET").Queries("as_of", "{as_of}")
+	router.HandleFunc("/items/{id:[0-9]+}", noop).Methods("GET")
+	router.PathPrefix("/static/").Handler(http.NotFoundHandler())
+
+	doc, err := Generate(router, Options{
+		Info:       Info{Title: "Test", Version: "1"},
+		MediaTypes: []string{"application/json"},
+		Routes: map[string]RouteDoc{
+			"POST /items": {
+				Body:      testItem{},
+				Responses: map[int]ResponseDoc{http.StatusCreated: {Body: []interface{}{testItem{}}}},
+			},
+			"GET /items/{id}": {
+				Params:    []ParamDoc{{Name: "id", In: "path", Schema: &Schema{Type: Types{"integer"}}}},
+				Responses: map[int]ResponseDoc{http.StatusOK: {Body: []interface{}{[]testItem{}}}},
+			},
+		},
+		Refine: map[reflect.Type]func(*Schema){
+			reflect.TypeOf(testItem{}): func(s *Schema) { s.Properties["name"].MinLength = Int(1) },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if len(doc.Paths) != 2 {
+		t.Errorf("paths = %v, want /items and /items/{id}", sortedKeys(doc.Paths))
+	}
+	get := doc.Operation("GET", "/items/{id:[0-9]+}")
+	if get == nil || get.OperationID != "getItemsById" || !get.Parameters[0].Required {
+		t.Fatalf("GET operation = %+v, want getItemsById with a required id", get)
+	}
+	if schema, _ := get.ResponseSchema(http.StatusOK, "application/json"); !reflect.DeepEqual(schema.Type, Types{"array"}) {
+		t.Errorf("list response type = %v, want a non-null array", schema.Type)
+	}
+	if resp := doc.Operation("POST", "/items").Responses["201"]; resp.Description != "Created" {
+		t.Errorf("description = %q, want the status text", resp.Description)
+	}
+
+	item := doc.Components.Schemas["testItem"]
+	if item == nil {
+		t.Fatalf("components = %v, want testItem", sortedKeys(doc.Components.Schemas))
+	}
+	if got, want := sortedKeys(item.Properties), []string{"address", "created_at", "id", "labels", "name", "tags"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("properties = %v, want %v", got, want)
+	}
+	if got, want := item.Required, []string{"id", "name", "created_at"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("required = %v, want %v", got, want)
+	}
+	if p := item.Properties["created_at"]; p.Format != "date-time" {
+		t.Errorf("created_at = %+v, want a date-time", p)
+	}
+	if p := item.Properties["address"]; len(p.AnyOf) != 2 || p.AnyOf[0].Ref != "#/components/schemas/testAddress" {
+		t.Errorf("address = %+v, want a nullable reference", p)
+	}
+	if p := item.Properties["tags"]; !reflect.DeepEqual(p.Type, Types{"array", "null"}) || p.Items.Type[0] != "string" {
+		t.Errorf("tags = %+v, want a nullable array of strings", p)
+	}
+	if p := item.Properties["name"]; p.MinLength == nil || *p.MinLength != 1 {
+		t.Errorf("name = %+v, want it refined", p)
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/items/{id}", noop).Methods("GET")
+	idParam := ParamDoc{Name: "id", In: "path"}
+
+	tests := []struct {
+		name   string
+		routes map[string]RouteDoc
+		want   string
+	}{
+		{
+			name:   "undescribed route",
+			routes: map[string]RouteDoc{},
+			want:   "route GET /items/{id} is not described",
+		},
+		{
+			name:   "route that does not exist",
+			routes: map[string]RouteDoc{"GET /items/{id}": {Params: []ParamDoc{idParam}}, "DELETE /items/{id}": {}},
+			want:   "described route DELETE /items/{id} does not exist",
+		},
+		{
+			name:   "undescribed path parameter",
+			routes: map[string]RouteDoc{"GET /items/{id}": {}},
+			want:   "path parameter id is not described",
+		},
+		{
+			name:   "path parameter not in the path",
+			routes: map[string]RouteDoc{"GET /items/{id}": {Params: []ParamDoc{idParam, {Name: "item", In: "path"}}}},
+			want:   "path parameter item is not in the path",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Generate(router, Options{Routes: tt.routes})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Generate() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperationID(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/users", "getUsers"},
+		{"POST", "/users:batch", "postUsersBatch"},
+		{"GET", "/users/by-email/{email}", "getUsersByEmailByEmail"},
+		{"POST", "/webhooks/{id}/deliveries/{delivery}/redeliver", "postWebhooksByIdDeliveriesByDeliveryRedeliver"},
+	}
+
+	for _, tt := range tests {
+		if got := operationID(tt.method, tt.path); got != tt.want {
+			t.Errorf("operationID(%s, %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
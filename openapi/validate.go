@@ -0,0 +1,261 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxProblems bounds the problems reported for one request or response
+const maxProblems = 10
+
+// Direction tells whether a value is sent in a request or in a response, which decides
+// whether read-only and write-only properties are required
+type Direction int
+
+// Directions of validated values
+const (
+	InRequest Direction = iota
+	InResponse
+)
+
+// ValidationError lists the ways a request or response differs from the document
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Operation returns the operation for method on the path template of a mux route, or nil
+// if the document has none
+func (d *Document) Operation(method, template string) *Operation {
+	item := d.Paths[pathVariable.ReplaceAllString(template, "{$1}")]
+	if item == nil {
+		return nil
+	}
+	return (*item)[strings.ToLower(method)]
+}
+
+// RequestSchema returns the schema of request bodies sent as mediaType, or nil if the
+// operation takes no such body
+func (op *Operation) RequestSchema(mediaType string) *Schema {
+	if op.RequestBody == nil || op.RequestBody.Content[mediaType] == nil {
+		return nil
+	}
+	return op.RequestBody.Content[mediaType].Schema
+}
+
+// ResponseSchema returns the schema of responses with status sent as mediaType. It returns
+// false if the operation does not respond with status.
+func (op *Operation) ResponseSchema(status int, mediaType string) (*Schema, bool) {
+	resp := op.Responses[strconv.Itoa(status)]
+	if resp == nil {
+		return nil, false
+	}
+	if resp.Content[mediaType] == nil {
+		return nil, true
+	}
+	return resp.Content[mediaType].Schema, true
+}
+
+// ValidateParameters checks the path, query and header parameters of r against op. vars
+// holds the variables of the path. Empty query parameters count as missing, as the
+// handlers treat them.
+func (d *Document) ValidateParameters(op *Operation, r *http.Request, vars map[string]string) error {
+	v := &validator{doc: d, dir: InRequest}
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var value string
+		switch p.In {
+		case "path":
+			value = vars[p.Name]
+		case "query":
+			value = query.Get(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+		}
+		where := p.In + " parameter " + p.Name
+		if value == "" {
+			if p.Required {
+				v.problem(where, "is required")
+			}
+			continue
+		}
+		v.check(p.Schema, parameterValue(p.Schema, value), where)
+	}
+	return v.err()
+}
+
+// parameterValue converts the text of a parameter to the JSON value its schema describes
+func parameterValue(schema *Schema, value string) interface{} {
+	switch {
+	case schema.Type.has("integer"), schema.Type.has("number"):
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case schema.Type.has("boolean"):
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// ValidateJSON checks a JSON body against schema
+func (d *Document) ValidateJSON(schema *Schema, body []byte, dir Direction) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return &ValidationError{Problems: []string{"body is not valid JSON"}}
+	}
+	v := &validator{doc: d, dir: dir}
+	v.check(schema, value, "body")
+	return v.err()
+}
+
+type validator struct {
+	doc      *Document
+	dir      Direction
+	problems []string
+}
+
+func (v *validator) problem(path, format string, args ...interface{}) {
+	if len(v.problems) < maxProblems {
+		v.problems = append(v.problems, path+" "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+// resolve follows a reference to a component
+func (v *validator) resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = v.doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// check adds a problem for every way value, found at path, does not match schema
+func (v *validator) check(schema *Schema, value interface{}, path string) {
+	schema = v.resolve(schema)
+	if schema == nil {
+		return
+	}
+
+	if len(schema.AnyOf) > 0 {
+		for _, alternative := range schema.AnyOf {
+			sub := &validator{doc: v.doc, dir: v.dir}
+			sub.check(alternative, value, path)
+			if len(sub.problems) == 0 {
+				return
+			}
+		}
+		v.problem(path, "matches none of the allowed schemas")
+		return
+	}
+
+	typ := jsonType(value)
+	if len(schema.Type) > 0 && !schema.Type.has(typ) && !(typ == "integer" && schema.Type.has("number")) {
+		v.problem(path, "must be of type %s, not %s", strings.Join(schema.Type, " or "), typ)
+		return
+	}
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		v.problem(path, "must be one of %v", schema.Enum)
+		return
+	}
+
+	switch value := value.(type) {
+	case json.Number:
+		n, _ := value.Float64()
+		if schema.Minimum != nil && n < *schema.Minimum {
+			v.problem(path, "must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			v.problem(path, "must be at most %v", *schema.Maximum)
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			v.problem(path, "must be at least %d characters long", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			v.problem(path, "must be at most %d characters long", *schema.MaxLength)
+		}
+	case []interface{}:
+		if schema.MinItems != nil && len(value) < *schema.MinItems {
+			v.problem(path, "must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(value) > *schema.MaxItems {
+			v.problem(path, "must have at most %d items", *schema.MaxItems)
+		}
+		for i, item := range value {
+			v.check(schema.Items, item, path+"["+strconv.Itoa(i)+"]")
+		}
+	case map[string]interface{}:
+		v.checkObject(schema, value, path)
+	}
+}
+
+func (v *validator) checkObject(schema *Schema, value map[string]interface{}, path string) {
+	for _, name := range schema.Required {
+		if _, ok := value[name]; ok {
+			continue
+		}
+		if property := schema.Properties[name]; property != nil {
+			if v.dir == InRequest && property.ReadOnly || v.dir == InResponse && property.WriteOnly {
+				continue
+			}
+		}
+		v.problem(path+"."+name, "is required")
+	}
+	for _, name := range sortedKeys(value) {
+		if property, ok := schema.Properties[name]; ok {
+			v.check(property, value[name], path+"."+name)
+		} else if schema.AdditionalProperties != nil {
+			v.check(schema.AdditionalProperties, value[name], path+"."+name)
+		}
+	}
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		// like encoding/json, which does not decode 1.0 into an int
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package openapi
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testDocument() *Document {
+	return &Document{Components: Components{Schemas: map[string]*Schema{
+		"User": {
+			Type: Types{"object"},
+			Properties: map[string]*Schema{
+				"id":       {Type: Types{"integer"}, ReadOnly: true},
+				"name":     {Type: Types{"string"}, MinLength: Int(1)},
+				"age":      {Type: Types{"integer"}, Minimum: Float(1)},
+				"password": {Type: Types{"string"}, WriteOnly: true},
+				"tags":     {Type: Types{"array", "null"}, Items: &Schema{Type: Types{"string"}, Enum: []interface{}{"a", "b"}}, MaxItems: Int(2)},
+				"manager":  {AnyOf: []*Schema{{Ref: "#/components/schemas/User"}, {Type: Types{"null"}}}},
+				"score":    {Type: Types{"number"}},
+			},
+			Required: []string{"id", "name", "age", "password"},
+		},
+	}}}
+}
+
+func TestValidateJSON(t *testing.T) {
+	doc := testDocument()
+	user := &Schema{Ref: "#/components/schemas/User"}
+
+	tests := []struct {
+		name string
+		body string
+		dir  Direction
+		want []string
+	}{
+		{name: "valid request", body: `{"name":"Ann","age":30,"password":"x"}`, dir: InRequest},
+		{name: "valid response", body: `{"id":1,"name":"Ann","age":30}`, dir: InResponse},
+		{name: "missing read-only field in response", body: `{"name":"Ann","age":30}`, dir: InResponse, want: []string{"body.id is required"}},
+		{name: "missing fields", body: `{}`, dir: InRequest, want: []string{"body.name is required", "body.age is required", "body.password is required"}},
+		{name: "wrong type", body: `{"name":"Ann","age":"30","password":"x"}`, dir: InRequest, want: []string{"body.age must be of type integer, not string"}},
+		{name: "fraction for integer", body: `{"name":"Ann","age":1.5,"password":"x"}`, dir: InRequest, want: []string{"body.age must be of type integer, not number"}},
+		{name: "integer for number", body: `{"name":"Ann","age":30,"password":"x","score":3}`, dir: InRequest},
+		{name: "bounds", body: `{"name":"","age":0,"password":"x"}`, dir: InRequest, want: []string{"body.age must be at least 1", "body.name must be at least 1 characters long"}},
+		{name: "array items", body: `{"name":"Ann","age":30,"password":"x","tags":["a","c","b"]}`, dir: InRequest, want: []string{"body.tags must have at most 2 items", "body.tags[1] must be one of [a b]"}},
+		{name: "null array", body: `{"name":"Ann","age":30,"password":"x","tags":null}`, dir: InRequest},
+		{name: "nested reference", body: `{"name":"Ann","age":30,"password":"x","manager":{"name":"Bob"}}`, dir: InRequest, want: []string{"body.manager matches none of the allowed schemas"}},
+		{name: "null reference", body: `{"name":"Ann","age":30,"password":"x","manager":null}`, dir: InRequest},
+		{name: "not an object", body: `[]`, dir: InRequest, want: []string{"body must be of type object, not array"}},
+		{name: "invalid JSON", body: `{"name":`, dir: InRequest, want: []string{"body is not valid JSON"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := doc.ValidateJSON(user, []byte(tt.body), tt.dir)
+			var got []string
+			if err != nil {
+				got = err.(*ValidationError).Problems
+			}
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("ValidateJSON() problems = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	doc := testDocument()
+	op := &Operation{Parameters: []*Parameter{
+		{Name: "id", In: "path", Required: true, Schema: &Schema{Type: Types{"integer"}}},
+		{Name: "q", In: "query", Required: true, Schema: &Schema{Type: Types{"string"}}},
+		{Name: "limit", In: "query", Schema: &Schema{Type: Types{"integer"}, Maximum: Float(100)}},
+		{Name: "X-Debug", In: "header", Schema: &Schema{Type: Types{"boolean"}}},
+	}}
+
+	tests := []struct {
+		name   string
+		id     string
+		query  string
+		header string
+		want   string
+	}{
+		{name: "valid", id: "1", query: "q=ann&limit=10", header: "true"},
+		{name: "invalid path parameter", id: "abc", query: "q=ann", want: "path parameter id must be of type integer, not string"},
+		{name: "missing required parameter", id: "1", query: "limit=10", want: "query parameter q is required"},
+		{name: "empty required parameter", id: "1", query: "q=", want: "query parameter q is required"},
+		{name: "out of bounds", id: "1", query: "q=ann&limit=500", want: "query parameter limit must be at most 100"},
+		{name: "invalid header", id: "1", query: "q=ann", header: "maybe", want: "header parameter X-Debug must be of type boolean, not string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/items/"+tt.id+"?"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Debug", tt.header)
+			}
+			err := doc.ValidateParameters(op, r, map[string]string{"id": tt.id})
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("ValidateParameters() error = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
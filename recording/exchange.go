@@ -0,0 +1,74 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Exchange is a request and the response it got, as recorded
+type Exchange struct {
+	Time     time.Time `json:"time"`
+	Duration float64   `json:"duration_ms"`
+	Request  Request   `json:"request"`
+	Response Response  `json:"response"`
+}
+
+// Request is a recorded request
+type Request struct {
+	Method string      `json:"method"`
+	URI    string      `json:"uri"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// BodyOmitted is set when the body could not be sanitized and was left out
+	BodyOmitted bool `json:"body_omitted,omitempty"`
+}
+
+// Response is a recorded response
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// BodyOmitted is set when the body could not be sanitized or was longer than the
+	// recorder keeps, and was left out
+	BodyOmitted bool `json:"body_omitted,omitempty"`
+}
+
+// Writer appends exchanges to an NDJSON file, one per line
+type Writer struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Create opens path for appending exchanges, creating it if needed
+func Create(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	return &Writer{f: f}, nil
+}
+
+// Write appends e
+func (w *Writer) Write(e *Exchange) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode exchange: %w", err)
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.f.Write(line); err != nil {
+		return fmt.Errorf("failed to write exchange: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file
+func (w *Writer) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,398 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxDifferences bounds the differences reported for one exchange
+const maxDifferences = 10
+
+// skippedHeaders are not sent again: they were redacted, describe the recorded connection,
+// or would tie the replayed request to the recorded one
+var skippedHeaders = []string{"Content-Length", "Accept-Encoding", "Connection", "X-Request-Id"}
+
+// ReplayOptions describe a replay
+type ReplayOptions struct {
+	// Target is the base URL of the service, such as http://localhost:8080
+	Target string
+	// Ignore names JSON fields left out of the comparison, in addition to the volatile ones
+	Ignore []string
+	// Client sends the requests; http.DefaultClient is used if nil
+	Client *http.Client
+}
+
+// Difference is an exchange whose replay got a different response than was recorded
+type Difference struct {
+	// Line is the line of the exchange in the recording
+	Line     int      `json:"line"`
+	Method   string   `json:"method"`
+	URI      string   `json:"uri"`
+	Recorded int      `json:"recorded_status"`
+	Replayed int      `json:"replayed_status"`
+	Fields   []string `json:"fields,omitempty"`
+}
+
+// ReplayReport summarizes a replay
+type ReplayReport struct {
+	Matched     int          `json:"matched"`
+	Mismatched  int          `json:"mismatched"`
+	Skipped     int          `json:"skipped"`
+	Differences []Difference `json:"differences,omitempty"`
+}
+
+// Replay sends the exchanges recorded in r to the target one after another, in the recorded
+// order, and compares the responses with the recorded ones. Fields whose values differ from
+// run to run, such as IDs and timestamps, are not compared. IDs the target assigns are
+// mapped to the recorded ones, so that a recorded request for a user created earlier in the
+// recording is sent for the user created by the replay.
+func Replay(ctx context.Context, r io.Reader, opts ReplayOptions) (*ReplayReport, error) {
+	rp := &replayer{
+		target: strings.TrimRight(opts.Target, "/"),
+		client: opts.Client,
+		ignore: make(map[string]bool, len(opts.Ignore)),
+		ids:    make(map[string]map[string]string),
+	}
+	if rp.client == nil {
+		rp.client = http.DefaultClient
+	}
+	for _, field := range opts.Ignore {
+		rp.ignore[field] = true
+	}
+
+	report := &ReplayReport{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return report, fmt.Errorf("failed to decode exchange on line %d: %w", line, err)
+		}
+		if e.Request.BodyOmitted {
+			report.Skipped++
+			continue
+		}
+
+		diff, err := rp.replay(ctx, &e)
+		if err != nil {
+			return report, fmt.Errorf("failed to replay line %d: %w", line, err)
+		}
+		if diff == nil {
+			report.Matched++
+			continue
+		}
+		diff.Line = line
+		report.Mismatched++
+		report.Differences = append(report.Differences, *diff)
+	}
+	if err := scanner.Err(); err != nil {
+		return report, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return report, nil
+}
+
+// replayer holds the state of a replay
+type replayer struct {
+	target string
+	client *http.Client
+	ignore map[string]bool
+	// ids maps recorded IDs to replayed ones, per resource
+	ids map[string]map[string]string
+}
+
+// replay sends e's request and returns how its response differs from the recorded one
+func (rp *replayer) replay(ctx context.Context, e *Exchange) (*Difference, error) {
+	uri := rp.rewriteURI(e.Request.URI)
+	body := e.Request.Body
+	if body != "" {
+		body = rp.rewriteBody(resourceOf(uri), e.Request.Header.Get("Content-Type"), body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, e.Request.Method, rp.target+uri, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range e.Request.Header {
+		if len(values) > 0 && values[0] == redacted {
+			continue
+		}
+		req.Header[name] = values
+	}
+	for _, name := range skippedHeaders {
+		req.Header.Del(name)
+	}
+
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	replayed, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	diff := &Difference{Method: e.Request.Method, URI: e.Request.URI, Recorded: e.Response.Status, Replayed: resp.StatusCode}
+	recorded, replayedValues, ok := decodeBodies(e.Response, resp.Header.Get("Content-Type"), replayed)
+	if ok && learnsIDs(uri) {
+		for i := range recorded {
+			rp.learn(resourceOf(uri), "", recorded[i], replayedValues[i])
+		}
+	}
+	if resp.StatusCode != e.Response.Status {
+		return diff, nil
+	}
+	switch {
+	case e.Response.BodyOmitted:
+		// only the status can be compared
+	case ok && len(recorded) == 1:
+		diff.Fields = rp.compare("", recorded[0], replayedValues[0], nil)
+	case ok:
+		diff.Fields = rp.compare("", recorded, replayedValues, nil)
+	case e.Response.Body != string(replayed):
+		diff.Fields = []string{"body"}
+	}
+	if len(diff.Fields) == 0 {
+		return nil, nil
+	}
+	return diff, nil
+}
+
+// decodeBodies decodes the recorded and the replayed response bodies as JSON or NDJSON. It
+// returns false if either is not, or if they have a different number of values.
+func decodeBodies(recorded Response, contentType string, replayed []byte) ([]interface{}, []interface{}, bool) {
+	if recorded.BodyOmitted || !strings.Contains(contentType, "json") {
+		return nil, nil, false
+	}
+	a, err := decodeJSON(recorded.Body)
+	if err != nil {
+		return nil, nil, false
+	}
+	b, err := decodeJSON(string(replayed))
+	if err != nil || len(a) != len(b) {
+		return nil, nil, false
+	}
+	return a, b, true
+}
+
+// decodeJSON decodes the values of a JSON or NDJSON body
+func decodeJSON(body string) ([]interface{}, error) {
+	var values []interface{}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+}
+
+// resourceOf returns the collection a request URI is about, such as "users" for /users/7
+func resourceOf(uri string) string {
+	path, _, _ := strings.Cut(uri, "?")
+	first, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	first, _, _ = strings.Cut(first, ":")
+	return first
+}
+
+// learnsIDs reports whether the IDs in the response to uri are those of its resource, rather
+// than of something nested under it, such as the revisions in /users/7/history
+func learnsIDs(uri string) bool {
+	path, _, _ := strings.Cut(uri, "?")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	return len(segments) <= 2 || segments[1] == "by-email"
+}
+
+// learn walks a recorded and a replayed response together and maps the IDs found in the
+// same places
+func (rp *replayer) learn(resource, field string, recorded, replayed interface{}) {
+	switch a := recorded.(type) {
+	case map[string]interface{}:
+		b, ok := replayed.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k, v := range a {
+			rp.learn(resource, k, v, b[k])
+		}
+	case []interface{}:
+		b, ok := replayed.([]interface{})
+		if !ok || len(a) != len(b) {
+			return
+		}
+		for i := range a {
+			rp.learn(resource, field, a[i], b[i])
+		}
+	default:
+		if field != "id" || recorded == nil || replayed == nil {
+			return
+		}
+		if rp.ids[resource] == nil {
+			rp.ids[resource] = make(map[string]string)
+		}
+		rp.ids[resource][fmt.Sprint(recorded)] = fmt.Sprint(replayed)
+	}
+}
+
+// mapID returns the replayed ID for a recorded one, or id itself if it is not known
+func (rp *replayer) mapID(resource, id string) string {
+	if mapped, ok := rp.ids[resource][id]; ok {
+		return mapped
+	}
+	return id
+}
+
+// rewriteURI replaces the recorded IDs in the path and the ids query parameter of uri
+func (rp *replayer) rewriteURI(uri string) string {
+	path, query, hasQuery := strings.Cut(uri, "?")
+	resource := resourceOf(uri)
+	segments := strings.Split(path, "/")
+	if len(segments) > 2 {
+		if mapped, ok := rp.ids[resource][segments[2]]; ok {
+			segments[2] = url.PathEscape(mapped)
+		}
+	}
+	path = strings.Join(segments, "/")
+	if !hasQuery {
+		return path
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil || values.Get("ids") == "" {
+		return path + "?" + query
+	}
+	ids := strings.Split(values.Get("ids"), ",")
+	for i, id := range ids {
+		ids[i] = rp.mapID(resource, strings.TrimSpace(id))
+	}
+	values.Set("ids", strings.Join(ids, ","))
+	return path + "?" + values.Encode()
+}
+
+// rewriteBody replaces the recorded IDs in a JSON or NDJSON request body
+func (rp *replayer) rewriteBody(resource, contentType, body string) string {
+	if !strings.Contains(contentType, "json") {
+		return body
+	}
+	values, err := decodeJSON(body)
+	if err != nil {
+		return body
+	}
+	var out bytes.Buffer
+	for i, v := range values {
+		b, _ := json.Marshal(rp.rewriteIDs(resource, "", v))
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		out.Write(b)
+	}
+	return out.String()
+}
+
+func (rp *replayer) rewriteIDs(resource, field string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = rp.rewriteIDs(resource, k, child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = rp.rewriteIDs(resource, field, child)
+		}
+	case json.Number:
+		if field == "id" {
+			return json.Number(rp.mapID(resource, v.String()))
+		}
+	case string:
+		if field == "id" {
+			return rp.mapID(resource, v)
+		}
+	}
+	return v
+}
+
+// volatile reports whether field holds a value that differs from run to run
+func (rp *replayer) volatile(field string) bool {
+	switch {
+	case rp.ignore[field]:
+		return true
+	case field == "id", strings.HasSuffix(field, "_id"), field == "at", strings.HasSuffix(field, "_at"):
+		return true
+	}
+	switch field {
+	case "time", "seq", "hash", "prev_hash", "secret", "duration_ms":
+		return true
+	}
+	return false
+}
+
+// compare returns the paths at which a and b differ, leaving out volatile fields
+func (rp *replayer) compare(path string, a, b interface{}, diffs []string) []string {
+	if len(diffs) >= maxDifferences {
+		return diffs
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			return append(diffs, pathOrRoot(path))
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if rp.volatile(k) {
+				continue
+			}
+			diffs = rp.compare(path+"."+k, a[k], b[k], diffs)
+		}
+		return diffs
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return append(diffs, pathOrRoot(path))
+		}
+		for i := range a {
+			diffs = rp.compare(path+"["+strconv.Itoa(i)+"]", a[i], b[i], diffs)
+		}
+		return diffs
+	default:
+		if !reflect.DeepEqual(a, b) {
+			return append(diffs, pathOrRoot(path))
+		}
+		return diffs
+	}
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
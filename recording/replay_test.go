@@ -0,0 +1,138 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newNotesServer serves a tiny notes API whose IDs start at firstID
+func newNotesServer(firstID int) *httptest.Server {
+	var mu sync.Mutex
+	notes := make(map[string]map[string]interface{})
+	next := firstID
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/notes":
+			var note map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&note)
+			note["id"] = next
+			note["created_at"] = time.Now().Format(time.RFC3339Nano)
+			notes[fmt.Sprint(next)] = note
+			next++
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(note)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/notes/"):
+			note, ok := notes[strings.TrimPrefix(r.URL.Path, "/notes/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+				return
+			}
+			json.NewEncoder(w).Encode(note)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+// record sends requests to server and returns them recorded as NDJSON
+func record(t *testing.T, server *httptest.Server, requests [][2]string) string {
+	var out strings.Builder
+	for _, req := range requests {
+		method, uri, body := req[0], req[1], ""
+		if method == http.MethodPost {
+			uri, body, _ = strings.Cut(uri, " ")
+		}
+		httpReq, _ := http.NewRequest(method, server.URL+uri, strings.NewReader(body))
+		httpReq.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			t.Fatalf("failed to record %s %s: %v", method, uri, err)
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		e := Exchange{
+			Request:  Request{Method: method, URI: uri, Header: httpReq.Header, Body: body},
+			Response: Response{Status: resp.StatusCode, Header: resp.Header, Body: string(respBody)},
+		}
+		line, _ := json.Marshal(e)
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.String()
+}
+
+func TestReplay_MapsIDs(t *testing.T) {
+	recorded := newNotesServer(1)
+	defer recorded.Close()
+	recording := record(t, recorded, [][2]string{
+		{http.MethodPost, `/notes {"text":"first"}`},
+		{http.MethodPost, `/notes {"text":"second"}`},
+		{http.MethodGet, "/notes/2"},
+		{http.MethodGet, "/notes/1"},
+		{http.MethodGet, "/notes/99"},
+	})
+
+	// the target assigns other IDs and timestamps
+	target := newNotesServer(500)
+	defer target.Close()
+	report, err := Replay(context.Background(), strings.NewReader(recording), ReplayOptions{Target: target.URL})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if report.Matched != 5 || report.Mismatched != 0 {
+		t.Errorf("report = %+v, want all 5 exchanges to match", report)
+	}
+}
+
+func TestReplay_Differences(t *testing.T) {
+	recording := strings.Join([]string{
+		`{"request":{"method":"POST","uri":"/notes","header":{"Content-Type":["application/json"]},"body":"{\"text\":\"a\"}"},"response":{"status":201,"body":"{\"id\":1,\"text\":\"b\"}"}}`,
+		`{"request":{"method":"GET","uri":"/notes/1"},"response":{"status":200,"body":"{\"id\":1,\"text\":\"a\"}"}}`,
+		`{"request":{"method":"GET","uri":"/notes/1"},"response":{"status":404,"body":"{}"}}`,
+		`{"request":{"method":"POST","uri":"/notes","body_omitted":true},"response":{"status":201}}`,
+		`{"request":{"method":"GET","uri":"/notes/1"},"response":{"status":200,"body_omitted":true}}`,
+	}, "\n")
+
+	target := newNotesServer(1)
+	defer target.Close()
+	report, err := Replay(context.Background(), strings.NewReader(recording), ReplayOptions{Target: target.URL})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	if report.Matched != 2 || report.Mismatched != 2 || report.Skipped != 1 {
+		t.Fatalf("report = %+v, want 2 matched, 2 mismatched and 1 skipped", report)
+	}
+	if d := report.Differences[0]; d.Line != 1 || len(d.Fields) != 1 || d.Fields[0] != ".text" {
+		t.Errorf("first difference = %+v, want .text on line 1", d)
+	}
+	if d := report.Differences[1]; d.Line != 3 || d.Recorded != 404 || d.Replayed != 200 {
+		t.Errorf("second difference = %+v, want status 404 replayed as 200 on line 3", d)
+	}
+}
+
+func TestReplay_Ignore(t *testing.T) {
+	recording := `{"request":{"method":"POST","uri":"/notes","header":{"Content-Type":["application/json"]},"body":"{\"text\":\"a\"}"},"response":{"status":201,"body":"{\"id\":1,\"text\":\"b\"}"}}`
+
+	target := newNotesServer(1)
+	defer target.Close()
+	report, err := Replay(context.Background(), strings.NewReader(recording), ReplayOptions{Target: target.URL, Ignore: []string{"text"}})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if report.Matched != 1 {
+		t.Errorf("report = %+v, want the ignored difference to match", report)
+	}
+}
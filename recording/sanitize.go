@@ -0,0 +1,222 @@
+package recording
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"userapi/models"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveHeaders carry credentials and are never recorded
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Kinds of personal data that get a pseudonym
+const (
+	kindName  = "name"
+	kindEmail = "email"
+	kindPhone = "phone"
+	kindURI   = "uri"
+	kindText  = "text"
+)
+
+// Patterns of personal data that error messages echo from invalid input
+var (
+	textEmail  = regexp.MustCompile(`[^\s"':]+@[^\s"':]+`)
+	textQuoted = regexp.MustCompile(`"[^"]*"`)
+)
+
+// fieldKinds maps JSON fields and query parameters holding personal data to their kind;
+// fields mapped to "" hold credentials and are redacted
+var fieldKinds = map[string]string{
+	"name":             kindName,
+	"q":                kindName,
+	"email":            kindEmail,
+	"emails":           kindEmail,
+	"phone":            kindPhone,
+	"phone_number":     kindPhone,
+	"phone_number_raw": kindPhone,
+	"phoneNumber":      kindPhone,
+	"target":           kindURI,
+	"error":            kindText,
+	"message":          kindText,
+	"password":         "",
+	"secret":           "",
+	"token":            "",
+	"access_token":     "",
+}
+
+// Sanitizer removes credentials from recorded traffic and replaces names, emails and phone
+// numbers with pseudonyms. A value gets the same pseudonym wherever it appears, so that a
+// replay of a recording stays coherent: a user created under a pseudonymous email can be
+// looked up by it. Pseudonyms are still valid, so requests that passed validation still do.
+// They are keyed, so that they cannot be reversed by hashing guesses without the key.
+type Sanitizer struct {
+	key []byte
+}
+
+// NewSanitizer creates a sanitizer whose pseudonyms are derived with key
+func NewSanitizer(key []byte) *Sanitizer {
+	return &Sanitizer{key: key}
+}
+
+// Header returns a copy of h without credentials
+func (s *Sanitizer) Header(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range sensitiveHeaders {
+		if h.Get(name) != "" {
+			h.Set(name, redacted)
+		}
+	}
+	return h
+}
+
+// URI returns uri with personal data in the query and in /users/by-email/ paths replaced
+func (s *Sanitizer) URI(uri string) string {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return redacted
+	}
+	if email, ok := strings.CutPrefix(u.Path, "/users/by-email/"); ok {
+		u.Path = "/users/by-email/" + s.pseudonym(kindEmail, email)
+		u.RawPath = ""
+	}
+	query := u.Query()
+	for param, values := range query {
+		kind, ok := fieldKinds[param]
+		if !ok {
+			continue
+		}
+		for i, value := range values {
+			if param == "emails" {
+				parts := strings.Split(value, ",")
+				for j, part := range parts {
+					parts[j] = s.pseudonym(kind, part)
+				}
+				values[i] = strings.Join(parts, ",")
+			} else {
+				values[i] = s.pseudonym(kind, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
+// Body returns body with personal data replaced. JSON and NDJSON bodies are rewritten field
+// by field; other bodies, such as CSV uploads, cannot be told apart from personal data and
+// are dropped.
+func (s *Sanitizer) Body(contentType string, body []byte) (string, bool) {
+	if len(body) == 0 {
+		return "", true
+	}
+	var lines [][]byte
+	switch {
+	case strings.Contains(contentType, "ndjson"):
+		lines = bytes.Split(bytes.TrimRight(body, "\n"), []byte("\n"))
+	case strings.Contains(contentType, "json"):
+		lines = [][]byte{body}
+	default:
+		return "", false
+	}
+
+	var out bytes.Buffer
+	for i, line := range lines {
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return "", false
+		}
+		sanitized, _ := json.Marshal(s.value("", v))
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		out.Write(sanitized)
+	}
+	return out.String(), true
+}
+
+// value replaces personal data in v, which is held by the JSON field named field
+func (s *Sanitizer) value(field string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// the values of a field change, as in the history, are of the kind of the field
+		changed, _ := v["field"].(string)
+		for k, child := range v {
+			if k == "from" || k == "to" {
+				v[k] = s.value(changed, child)
+			} else {
+				v[k] = s.value(k, child)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = s.value(field, child)
+		}
+		return v
+	case string:
+		if kind, ok := fieldKinds[field]; ok {
+			return s.pseudonym(kind, v)
+		}
+	}
+	return v
+}
+
+// pseudonym returns the stand-in for value. Values that are the same to the service, such
+// as emails differing in case, get the same pseudonym.
+func (s *Sanitizer) pseudonym(kind, value string) string {
+	switch kind {
+	case kindName:
+		// words are replaced one by one, so that searches for a word still find the names
+		// that have it
+		words := strings.Fields(value)
+		for i, word := range words {
+			words[i] = "N" + s.hash(kind, strings.ToLower(word))[:8]
+		}
+		return strings.Join(words, " ")
+	case kindEmail:
+		normalized, err := models.NormalizeEmail(value)
+		if err != nil {
+			return "invalid"
+		}
+		return "user-" + s.hash(kind, normalized)[:12] + "@example.com"
+	case kindPhone:
+		// only the country of a number is kept, as its validity depends on it
+		num, err := phonenumbers.Parse(strings.TrimSpace(value), models.DefaultPhoneRegion)
+		if err != nil || !phonenumbers.IsValidNumber(num) {
+			return "invalid"
+		}
+		region := phonenumbers.GetRegionCodeForNumber(num)
+		example := phonenumbers.GetExampleNumberForType(region, phonenumbers.GetNumberType(num))
+		if example == nil {
+			example = phonenumbers.GetExampleNumber(region)
+		}
+		return phonenumbers.Format(example, phonenumbers.E164)
+	case kindURI:
+		return s.URI(value)
+	case kindText:
+		value = textEmail.ReplaceAllStringFunc(value, func(email string) string { return s.pseudonym(kindEmail, email) })
+		return textQuoted.ReplaceAllString(value, `"`+redacted+`"`)
+	default:
+		return redacted
+	}
+}
+
+func (s *Sanitizer) hash(kind, value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,167 @@
+package recording
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSanitizer_Header(t *testing.T) {
+	s := NewSanitizer([]byte("key"))
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Cookie", "session=abc")
+	h.Set("Accept", "application/json")
+
+	got := s.Header(h)
+	if got.Get("Authorization") != redacted || got.Get("Cookie") != redacted {
+		t.Errorf("credentials = %q, %q, want them redacted", got.Get("Authorization"), got.Get("Cookie"))
+	}
+	if got.Get("Accept") != "application/json" {
+		t.Errorf("Accept = %q, want it kept", got.Get("Accept"))
+	}
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Error("Header() modified the original header")
+	}
+}
+
+func TestSanitizer_URI(t *testing.T) {
+	s := NewSanitizer([]byte("key"))
+	email := s.pseudonym(kindEmail, "ann@example.org")
+
+	tests := []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{name: "no personal data", uri: "/users/7?limit=10", want: "/users/7?limit=10"},
+		{name: "email path", uri: "/users/by-email/ann@example.org", want: "/users/by-email/" + email},
+		{name: "emails differing in case", uri: "/users/by-email/Ann@Example.org", want: "/users/by-email/" + email},
+		{name: "email list", uri: "/users?emails=ann@example.org,ANN@example.org", want: "/users?emails=" + url.QueryEscape(email+","+email)},
+		{name: "invalid email", uri: "/users?email=nope", want: "/users?email=invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.URI(tt.uri); got != tt.want {
+				t.Errorf("URI(%q) = %q, want %q", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizer_Body(t *testing.T) {
+	s := NewSanitizer([]byte("key"))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantOK      bool
+		keep        []string
+		remove      []string
+	}{
+		{
+			name:        "user",
+			contentType: "application/json",
+			body:        `{"id":12345678901234567,"name":"Ann Smith","email":"ann@example.org","phone_number":"+49 30 901820","age":30}`,
+			wantOK:      true,
+			keep:        []string{`"id":12345678901234567`, `"age":30`, `"phone_number":"+49`},
+			remove:      []string{"Ann Smith", "ann@example.org", "901820"},
+		},
+		{
+			name:        "nested users",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"operations":[{"op":"create","user":{"name":"Bob Jones","email":"bob@example.org"}}]}`,
+			wantOK:      true,
+			keep:        []string{`"op":"create"`},
+			remove:      []string{"Bob Jones", "bob@example.org"},
+		},
+		{
+			name:        "history",
+			contentType: "application/json",
+			body:        `{"revisions":[{"changes":[{"field":"name","from":"Ann Smith","to":"Ann Jones"},{"field":"age","from":30,"to":31}]}]}`,
+			wantOK:      true,
+			keep:        []string{`"from":30`, `"to":31`},
+			remove:      []string{"Ann Smith", "Ann Jones"},
+		},
+		{
+			name:        "audit",
+			contentType: "application/json",
+			body:        `{"entries":[{"action":"user.get_by_email","target":"/users/by-email/ann@example.org"}]}`,
+			wantOK:      true,
+			keep:        []string{`"target":"/users/by-email/user-`},
+			remove:      []string{"ann@example.org"},
+		},
+		{
+			name:        "error message",
+			contentType: "application/json",
+			body:        `{"error":"invalid phone number \"+1 555\": too short; invalid email format: ann@nowhere"}`,
+			wantOK:      true,
+			keep:        []string{"invalid phone number", "REDACTED"},
+			remove:      []string{"555", "ann@nowhere"},
+		},
+		{
+			name:        "credentials",
+			contentType: "application/json",
+			body:        `{"url":"https://hooks.example.org","secret":"s3cret"}`,
+			wantOK:      true,
+			keep:        []string{`"secret":"REDACTED"`, "hooks.example.org"},
+			remove:      []string{"s3cret"},
+		},
+		{
+			name:        "ndjson",
+			contentType: "application/x-ndjson",
+			body:        "{\"name\":\"Ann Smith\"}\n{\"name\":\"Bob Jones\"}\n",
+			wantOK:      true,
+			keep:        []string{"\n"},
+			remove:      []string{"Ann Smith", "Bob Jones"},
+		},
+		{name: "csv", contentType: "text/csv", body: "name,email\nAnn,ann@example.org\n"},
+		{name: "invalid json", contentType: "application/json", body: `{"name":`},
+		{name: "empty", contentType: "text/csv", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := s.Body(tt.contentType, []byte(tt.body))
+			if ok != tt.wantOK {
+				t.Fatalf("Body() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok && got != "" {
+				t.Errorf("Body() = %q, want nothing for a body that cannot be sanitized", got)
+			}
+			for _, want := range tt.keep {
+				if !strings.Contains(got, want) {
+					t.Errorf("Body() = %s, want it to contain %q", got, want)
+				}
+			}
+			for _, unwanted := range tt.remove {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("Body() = %s, want %q removed", got, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizer_Pseudonyms(t *testing.T) {
+	s := NewSanitizer([]byte("key"))
+
+	if a, b := s.pseudonym(kindName, "Ann Smith"), s.pseudonym(kindName, "Ann Smith"); a != b {
+		t.Errorf("pseudonyms of the same name = %q, %q, want them equal", a, b)
+	}
+	if full, word := s.pseudonym(kindName, "Ann Smith"), s.pseudonym(kindName, "ann"); !strings.HasPrefix(full, word+" ") {
+		t.Errorf("pseudonyms of a name and its first word = %q, %q, want the name to start with the word", full, word)
+	}
+	if a, b := s.pseudonym(kindName, "Ann Smith"), NewSanitizer([]byte("other")).pseudonym(kindName, "Ann Smith"); a == b {
+		t.Errorf("pseudonyms under different keys = %q, want them to differ", a)
+	}
+
+	// a pseudonymous phone number is still valid for the same country
+	if got := s.pseudonym(kindPhone, "+49 30 901820"); !strings.HasPrefix(got, "+49") || got == "+4930901820" {
+		t.Errorf("pseudonym of a German number = %q, want another German number", got)
+	}
+	if got := s.pseudonym(kindPhone, "12"); got != "invalid" {
+		t.Errorf("pseudonym of an invalid number = %q, want invalid", got)
+	}
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+	"userapi/models"
+)
+
+// quietLogs discards log output for the rest of the benchmark, which would otherwise
+// dominate the results
+func quietLogs(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(out) })
+}
+
+// seedRepository creates n users in repo and returns their IDs
+func seedRepository(b *testing.B, repo UserRepository, n int) []int64 {
+	ctx := context.Background()
+	ids := make([]int64, n)
+	for i := range ids {
+		user := newTestUser(fmt.Sprintf("User %d", i%100), fmt.Sprintf("user%d@example.com", i))
+		if err := repo.Create(ctx, user); err != nil {
+			b.Fatalf("Create() error = %v", err)
+		}
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+func BenchmarkMemoryUserRepository_Create(b *testing.B) {
+	quietLogs(b)
+	ctx := context.Background()
+	repo := NewMemoryUserRepository()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := repo.Create(ctx, newTestUser("John Doe", fmt.Sprintf("john%d@example.com", i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryUserRepository_GetByID(b *testing.B) {
+	quietLogs(b)
+	repo := NewMemoryUserRepository()
+	benchmarkGetByID(b, repo, seedRepository(b, repo, 10000))
+}
+
+func BenchmarkMemoryUserRepository_List(b *testing.B) {
+	quietLogs(b)
+	ctx := context.Background()
+	repo := NewMemoryUserRepository()
+	seedRepository(b, repo, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.List(ctx, UserFilter{Name: "User 42"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryUserRepository_Update(b *testing.B) {
+	quietLogs(b)
+	ctx := context.Background()
+	repo := NewMemoryUserRepository()
+	ids := seedRepository(b, repo, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := ids[i%len(ids)]
+		user := &models.User{ID: id, Name: fmt.Sprintf("Renamed %d", i), Age: 31, PhoneNumber: "+14155552671", Email: fmt.Sprintf("user%d@example.com", i%len(ids))}
+		if err := repo.Update(ctx, user); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCachedUserRepository_GetByID(b *testing.B) {
+	quietLogs(b)
+	repo := NewCachedUserRepository(NewMemoryUserRepository(), NewLRUCache(10000, time.Minute))
+	benchmarkGetByID(b, repo, seedRepository(b, repo, 10000))
+}
+
+func BenchmarkResilientUserRepository_GetByID(b *testing.B) {
+	quietLogs(b)
+	repo := NewResilientUserRepository(NewMemoryUserRepository(), DefaultResilienceOptions)
+	benchmarkGetByID(b, repo, seedRepository(b, repo, 10000))
+}
+
+// benchmarkGetByID looks up the users with ids from parallel goroutines
+func benchmarkGetByID(b *testing.B, repo UserRepository, ids []int64) {
+	ctx := context.Background()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := repo.GetByID(ctx, ids[i%len(ids)]); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"userapi/models"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheStats counts how the cached repository served lookups by ID
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Collapsed are misses that waited for a load already in flight for the same user
+	Collapsed     int64 `json:"collapsed"`
+	Invalidations int64 `json:"invalidations"`
+	// Entries and Evictions are only known for in-process caches
+	Entries   int   `json:"entries,omitempty"`
+	Evictions int64 `json:"evictions,omitempty"`
+}
+
+// loadTimeout bounds a load of missing users. Loads are shared by the requests waiting for
+// them, so they do not stop when the request that started them goes away.
+const loadTimeout = 5 * time.Second
+
+// CachedUserRepository is a UserRepository that serves GetByID and GetByIDs from a cache,
+// loading misses from the repository it wraps. Updates, deletes, batches and reverts made
+// through it remove the users they touch from the cache. Changes made elsewhere, such as by
+// other instances sharing the database, show once the cached users expire. Misses are read
+// from the primary, as a user read from a lagging replica would stay stale until it expires.
+type CachedUserRepository struct {
+	UserRepository
+	cache UserCache
+	loads singleflight.Group
+
+	// loading holds the users being loaded, so that a load that raced with an invalidation
+	// of its user does not cache what it read before the change
+	mu      sync.Mutex
+	loading map[int64]*loadState
+
+	hits, misses, collapsed, invalidations atomic.Int64
+}
+
+var _ HistoryRepository = (*CachedUserRepository)(nil)
+
+// NewCachedUserRepository wraps repo with cache
+func NewCachedUserRepository(repo UserRepository, cache UserCache) *CachedUserRepository {
+	return &CachedUserRepository{UserRepository: repo, cache: cache, loading: make(map[int64]*loadState)}
+}
+
+// loadState counts the loads of a user in flight and its invalidations while they are
+type loadState struct {
+	loads      int
+	generation uint64
+}
+
+func (r *CachedUserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	if user, ok := r.cache.Get(ctx, id); ok {
+		r.hits.Add(1)
+		return user, nil
+	}
+	r.misses.Add(1)
+
+	// concurrent misses for the same user share one load, which each of them stops waiting
+	// for when its own ctx is done
+	loads := r.loads.DoChan(strconv.FormatInt(id, 10), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+
+		generations := r.beginLoad(id)
+		user, err := r.UserRepository.GetByID(withPrimaryReads(ctx), id)
+		var loaded []*models.User
+		if user != nil {
+			loaded = append(loaded, user)
+		}
+		r.endLoad(ctx, generations, loaded)
+		return user, err
+	})
+
+	var res singleflight.Result
+	select {
+	case res = <-loads:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.Shared {
+		r.collapsed.Add(1)
+	}
+	if res.Err != nil || res.Val.(*models.User) == nil {
+		return nil, res.Err
+	}
+	// callers may modify the user, so every one of them gets a copy
+	user := *res.Val.(*models.User)
+	return &user, nil
+}
+
+func (r *CachedUserRepository) GetByIDs(ctx context.Context, ids []int64) ([]*models.User, error) {
+	users := make([]*models.User, 0, len(ids))
+	var missing []int64
+	for _, id := range ids {
+		if user, ok := r.cache.Get(ctx, id); ok {
+			r.hits.Add(1)
+			users = append(users, user)
+		} else {
+			r.misses.Add(1)
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return users, nil
+	}
+
+	generations := r.beginLoad(missing...)
+	loaded, err := r.UserRepository.GetByIDs(withPrimaryReads(ctx), missing)
+	if err != nil {
+		r.endLoad(ctx, generations, nil)
+		return nil, err
+	}
+	r.endLoad(ctx, generations, loaded)
+	return append(users, loaded...), nil
+}
+
+func (r *CachedUserRepository) Update(ctx context.Context, user *models.User) error {
+	defer r.invalidate(ctx, user.ID)
+	return r.UserRepository.Update(ctx, user)
+}
+
+func (r *CachedUserRepository) Delete(ctx context.Context, id int64) error {
+	defer r.invalidate(ctx, id)
+	return r.UserRepository.Delete(ctx, id)
+}
+
+func (r *CachedUserRepository) Batch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	results, err := r.UserRepository.Batch(ctx, ops, atomic)
+
+	ids := make([]int64, 0, len(ops))
+	for _, op := range ops {
+		if op.ID != 0 {
+			ids = append(ids, op.ID)
+		}
+	}
+	for _, result := range results {
+		if result.ID != 0 {
+			ids = append(ids, result.ID)
+		}
+	}
+	r.invalidate(ctx, ids...)
+	return results, err
+}
+
+func (r *CachedUserRepository) History(ctx context.Context, userID int64) ([]*models.UserRevision, error) {
+	history, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+	return history.History(ctx, userID)
+}
+
+func (r *CachedUserRepository) AsOf(ctx context.Context, userID int64, t time.Time) (*models.User, error) {
+	history, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+	return history.AsOf(ctx, userID, t)
+}
+
+func (r *CachedUserRepository) Revert(ctx context.Context, userID, revision int64) (*models.User, error) {
+	history, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+	defer r.invalidate(ctx, userID)
+	return history.Revert(ctx, userID, revision)
+}
+
+// Stats returns the lookup counters
+func (r *CachedUserRepository) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:          r.hits.Load(),
+		Misses:        r.misses.Load(),
+		Collapsed:     r.collapsed.Load(),
+		Invalidations: r.invalidations.Load(),
+	}
+	if c, ok := r.cache.(*lruCache); ok {
+		stats.Entries = c.Len()
+		stats.Evictions = c.Evictions()
+	}
+	return stats
+}
+
+// invalidate removes users from the cache once they have been changed. It also runs when
+// the change failed, as it may have been committed all the same.
+func (r *CachedUserRepository) invalidate(ctx context.Context, ids ...int64) {
+	r.mu.Lock()
+	for _, id := range ids {
+		if state, ok := r.loading[id]; ok {
+			state.generation++
+		}
+	}
+	r.mu.Unlock()
+
+	r.invalidations.Add(int64(len(ids)))
+	r.cache.Delete(ctx, ids...)
+}
+
+// beginLoad registers loads of the given users and returns their generations, to be passed
+// to endLoad once they are read
+func (r *CachedUserRepository) beginLoad(ids ...int64) map[int64]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	generations := make(map[int64]uint64, len(ids))
+	for _, id := range ids {
+		state, ok := r.loading[id]
+		if !ok {
+			state = &loadState{}
+			r.loading[id] = state
+		}
+		state.loads++
+		generations[id] = state.generation
+	}
+	return generations
+}
+
+// endLoad caches the loaded users that were not invalidated since beginLoad and ends the
+// loads. The users are cached under the lock, as otherwise an invalidation could come
+// between the check and the Set and leave the old user cached.
+func (r *CachedUserRepository) endLoad(ctx context.Context, generations map[int64]uint64, loaded []*models.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range loaded {
+		if generation, ok := generations[user.ID]; ok && r.loading[user.ID].generation == generation {
+			r.cache.Set(ctx, user)
+		}
+	}
+	for id := range generations {
+		state := r.loading[id]
+		if state.loads--; state.loads == 0 {
+			delete(r.loading, id)
+		}
+	}
+}
+
+func (r *CachedUserRepository) history() (HistoryRepository, error) {
+	history, ok := r.UserRepository.(HistoryRepository)
+	if !ok {
+		return nil, fmt.Errorf("%T does not keep history", r.UserRepository)
+	}
+	return history, nil
+}
@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"userapi/models"
+)
+
+// countingRepository counts the lookups by ID that reach the wrapped repository and can
+// hold them up until release is closed or their ctx is done
+type countingRepository struct {
+	UserRepository
+	HistoryRepository
+	lookups atomic.Int64
+	release chan struct{}
+	// replicaReads counts lookups that a replica would have been allowed to serve
+	replicaReads atomic.Int64
+}
+
+func newCountingRepository() *countingRepository {
+	repo := NewMemoryUserRepository()
+	return &countingRepository{UserRepository: repo, HistoryRepository: repo.(HistoryRepository)}
+}
+
+func (r *countingRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	r.lookups.Add(1)
+	if !needsPrimary(ctx) {
+		r.replicaReads.Add(1)
+	}
+	if r.release != nil {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return r.UserRepository.GetByID(ctx, id)
+}
+
+func TestCachedUserRepository(t *testing.T) {
+	ctx := context.Background()
+	inner := newCountingRepository()
+	repo := NewCachedUserRepository(inner, NewLRUCache(100, time.Minute))
+
+	user := newTestUser("John Doe", "john@example.com")
+	repo.Create(ctx, user)
+
+	for i := 0; i < 3; i++ {
+		got, err := repo.GetByID(ctx, user.ID)
+		if err != nil || got.Name != "John Doe" {
+			t.Fatalf("GetByID() = %v, %v", got, err)
+		}
+		got.Name = "Changed by caller"
+	}
+	if n := inner.lookups.Load(); n != 1 {
+		t.Errorf("repeated GetByID() reached the repository %d times, want 1", n)
+	}
+	if n := inner.replicaReads.Load(); n != 0 {
+		t.Errorf("%d lookups that fill the cache could be served by a replica, want none", n)
+	}
+
+	user.Name = "John Smith"
+	if err := repo.Update(ctx, user); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, _ := repo.GetByID(ctx, user.ID); got.Name != "John Smith" {
+		t.Errorf("GetByID() after Update() = %q, want the new name", got.Name)
+	}
+
+	if _, err := repo.Revert(ctx, user.ID, 1); err != nil {
+		t.Fatalf("Revert() error = %v", err)
+	}
+	if got, _ := repo.GetByID(ctx, user.ID); got.Name != "John Doe" {
+		t.Errorf("GetByID() after Revert() = %q, want the old name", got.Name)
+	}
+
+	repo.Delete(ctx, user.ID)
+	if got, err := repo.GetByID(ctx, user.ID); got != nil || err != nil {
+		t.Errorf("GetByID() after Delete() = %v, %v, want nil", got, err)
+	}
+
+	stats := repo.Stats()
+	if stats.Hits != 2 || stats.Misses != 4 || stats.Invalidations != 3 {
+		t.Errorf("Stats() = %+v, want 2 hits, 4 misses and 3 invalidations", stats)
+	}
+}
+
+func TestCachedUserRepository_CollapsesMisses(t *testing.T) {
+	ctx := context.Background()
+	inner := newCountingRepository()
+	inner.release = make(chan struct{})
+	repo := NewCachedUserRepository(inner, NewLRUCache(100, time.Minute))
+	user := newTestUser("John Doe", "john@example.com")
+	repo.Create(ctx, user)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := repo.GetByID(ctx, user.ID); err != nil || got == nil {
+				t.Errorf("GetByID() = %v, %v", got, err)
+			}
+		}()
+	}
+	// let the goroutines pile up on the first lookup before it completes
+	for repo.Stats().Misses < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(inner.release)
+	wg.Wait()
+
+	if n := inner.lookups.Load(); n != 1 {
+		t.Errorf("concurrent misses reached the repository %d times, want 1", n)
+	}
+}
+
+func TestCachedUserRepository_GetByIDs(t *testing.T) {
+	ctx := context.Background()
+	repo := NewCachedUserRepository(NewMemoryUserRepository(), NewLRUCache(100, time.Minute))
+	john := newTestUser("John Doe", "john@example.com")
+	jane := newTestUser("Jane Doe", "jane@example.com")
+	repo.Create(ctx, john)
+	repo.Create(ctx, jane)
+
+	repo.GetByID(ctx, john.ID)
+	users, err := repo.GetByIDs(ctx, []int64{john.ID, jane.ID, 999})
+	if err != nil || len(users) != 2 {
+		t.Fatalf("GetByIDs() = %v, %v, want both users", users, err)
+	}
+	if stats := repo.Stats(); stats.Hits != 1 || stats.Entries != 2 {
+		t.Errorf("Stats() = %+v, want 1 hit and 2 entries", stats)
+	}
+}
+
+func TestCachedUserRepository_CancelledCaller(t *testing.T) {
+	inner := newCountingRepository()
+	inner.release = make(chan struct{})
+	repo := NewCachedUserRepository(inner, NewLRUCache(100, time.Minute))
+	user := newTestUser("John Doe", "john@example.com")
+	repo.Create(context.Background(), user)
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := repo.GetByID(first, user.ID)
+		firstErr <- err
+	}()
+	// the cancelled caller starts the load and the other one waits for it
+	for inner.lookups.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	waiter := make(chan *models.User, 1)
+	go func() {
+		got, _ := repo.GetByID(context.Background(), user.ID)
+		waiter <- got
+	}()
+	for repo.Stats().Misses < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-firstErr; err != context.Canceled {
+		t.Errorf("GetByID() of the cancelled caller error = %v, want %v", err, context.Canceled)
+	}
+	close(inner.release)
+	if got := <-waiter; got == nil || got.Name != "John Doe" {
+		t.Errorf("GetByID() of the waiting caller = %v, want the user", got)
+	}
+	if _, ok := repo.cache.Get(context.Background(), user.ID); !ok {
+		t.Errorf("the load started by the cancelled caller was not cached")
+	}
+}
+
+func TestCachedUserRepository_InvalidatedDuringLoad(t *testing.T) {
+	ctx := context.Background()
+	inner := newCountingRepository()
+	repo := NewCachedUserRepository(inner, NewLRUCache(100, time.Minute))
+	john := newTestUser("John Doe", "john@example.com")
+	jane := newTestUser("Jane Doe", "jane@example.com")
+	repo.Create(ctx, john)
+	repo.Create(ctx, jane)
+
+	tests := []struct {
+		name        string
+		invalidated int64
+		wantCached  bool
+	}{
+		{"same user", john.ID, false},
+		{"other user", jane.ID, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo.cache.Delete(ctx, john.ID)
+			inner.release = make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				repo.GetByID(ctx, john.ID)
+			}()
+			for {
+				repo.mu.Lock()
+				_, loading := repo.loading[john.ID]
+				repo.mu.Unlock()
+				if loading {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			repo.invalidate(ctx, tt.invalidated)
+			close(inner.release)
+			<-done
+
+			if _, ok := repo.cache.Get(ctx, john.ID); ok != tt.wantCached {
+				t.Errorf("user cached = %v, want %v", ok, tt.wantCached)
+			}
+			if n := len(repo.loading); n != 0 {
+				t.Errorf("%d loads left registered, want none", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// errNotConnected is reported until the connector reaches the database for the first time
+var errNotConnected = errors.New("not connected to the database yet")
+
+// ConnectorOptions tune how the connector reaches the database
+type ConnectorOptions struct {
+	// BaseBackoff is the longest wait before the second attempt; it doubles with every further one
+	BaseBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	// CheckInterval is how often the connection is checked once established
+	CheckInterval time.Duration
+	// PingTimeout bounds an attempt
+	PingTimeout time.Duration
+}
+
+// DefaultConnectorOptions retry quickly at first and at least every 30 seconds
+var DefaultConnectorOptions = ConnectorOptions{
+	BaseBackoff:   500 * time.Millisecond,
+	MaxBackoff:    30 * time.Second,
+	CheckInterval: 5 * time.Second,
+	PingTimeout:   2 * time.Second,
+}
+
+// Connector connects to the database in the background, so that the service can start
+// before the database does. Until it succeeds, and whenever the database goes away after,
+// it reports the service not ready and tries again with jittered exponential backoff.
+// database/sql opens connections on demand, so calls made while it is not ready fail
+// rather than block.
+type Connector struct {
+	opts ConnectorOptions
+	ping func(ctx context.Context) error
+
+	mu        sync.Mutex
+	err       error
+	connected chan struct{} // closed once the database was first reached
+}
+
+// NewConnector creates a connector for db. It does nothing until Run.
+func NewConnector(db *sql.DB, opts ConnectorOptions) *Connector {
+	return &Connector{
+		opts:      opts,
+		ping:      db.PingContext,
+		err:       errNotConnected,
+		connected: make(chan struct{}),
+	}
+}
+
+// Run connects and then watches the connection until ctx is done
+func (c *Connector) Run(ctx context.Context) {
+	for attempt := 1; ; {
+		var wait time.Duration
+		if err := c.check(ctx); err != nil {
+			wait = jitteredBackoff(c.opts.BaseBackoff, c.opts.MaxBackoff, attempt)
+			log.Printf("Could not reach database (attempt %d), retrying in %v: %v", attempt, wait.Round(time.Millisecond), err)
+			attempt++
+		} else {
+			wait = c.opts.CheckInterval
+			attempt = 1
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// check pings the database and records whether it could be reached
+func (c *Connector) check(ctx context.Context) error {
+	pingCtx, cancel := context.WithTimeout(ctx, c.opts.PingTimeout)
+	defer cancel()
+	err := c.ping(pingCtx)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case err != nil && c.err == nil:
+		log.Printf("Lost connection to database: %v", err)
+	case err == nil && c.err != nil:
+		log.Println("Successfully connected to database")
+		select {
+		case <-c.connected:
+		default:
+			close(c.connected)
+		}
+	}
+	c.err = err
+	return err
+}
+
+// Ready returns nil while the database can be reached, and why it cannot otherwise
+func (c *Connector) Ready() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return fmt.Errorf("database unavailable: %w", c.err)
+	}
+	return nil
+}
+
+// Wait blocks until the database was reached once, or ctx is done
+func (c *Connector) Wait(ctx context.Context) error {
+	select {
+	case <-c.connected:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to connect to database: %w", c.Ready())
+	}
+}
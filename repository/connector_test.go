@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnector(t *testing.T) {
+	var mu sync.Mutex
+	down := true
+	checked := make(chan struct{})
+	c := &Connector{
+		opts: ConnectorOptions{
+			BaseBackoff:   time.Millisecond,
+			MaxBackoff:    2 * time.Millisecond,
+			CheckInterval: time.Millisecond,
+			PingTimeout:   time.Second,
+		},
+		ping: func(ctx context.Context) error {
+			select {
+			case checked <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if down {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+		err:       errNotConnected,
+		connected: make(chan struct{}),
+	}
+	setDown := func(d bool) {
+		mu.Lock()
+		down = d
+		mu.Unlock()
+		// the ping let through sees the new state, and it has been recorded once the next
+		// one starts
+		<-checked
+		<-checked
+	}
+
+	if c.Ready() == nil {
+		t.Fatal("Ready() before connecting = nil, want an error")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, 10*time.Millisecond)
+	if err := c.Wait(waitCtx); err == nil {
+		t.Error("Wait() while the database is down = nil, want an error")
+	}
+	cancelWait()
+
+	setDown(false)
+	if err := c.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if err := c.Ready(); err != nil {
+		t.Errorf("Ready() after connecting = %v, want nil", err)
+	}
+
+	setDown(true)
+	if c.Ready() == nil {
+		t.Error("Ready() after losing the database = nil, want an error")
+	}
+
+	setDown(false)
+	if err := c.Ready(); err != nil {
+		t.Errorf("Ready() after reconnecting = %v, want nil", err)
+	}
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"userapi/models"
+	"userapi/reqctx"
+)
+
+var (
+	// ErrRevisionNotFound is returned when a revision does not exist or belongs to
+	// another user
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrRevertToDeleted is returned when reverting to a revision that deleted the user
+	ErrRevertToDeleted = errors.New("revision deleted the user")
+)
+
+// systemActor is recorded for changes made outside of a request, such as by migrations
+const systemActor = "system"
+
+// HistoryRepository gives access to the revisions that every create, update and delete of
+// a UserRepository records in the same transaction
+type HistoryRepository interface {
+	// History returns the revisions of a user, oldest first
+	History(ctx context.Context, userID int64) ([]*models.UserRevision, error)
+	// AsOf returns the user as it was at t, or nil if it did not exist then
+	AsOf(ctx context.Context, userID int64, t time.Time) (*models.User, error)
+	// Revert restores the user to how it was right after revision, recreating it if it
+	// has been deleted since. The revert is recorded as a revision of its own.
+	Revert(ctx context.Context, userID, revision int64) (*models.User, error)
+}
+
+// newRevision describes the change from before to after made on behalf of the request in
+// ctx. Either user may be nil. It returns nil for updates that change nothing.
+func newRevision(ctx context.Context, action string, before, after *models.User) *models.UserRevision {
+	changes := models.DiffUsers(before, after)
+	if action == models.RevisionUpdate && len(changes) == 0 {
+		return nil
+	}
+
+	rev := &models.UserRevision{
+		Action:    action,
+		Actor:     reqctx.Actor(ctx),
+		RequestID: reqctx.RequestID(ctx),
+		Changes:   changes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if rev.Actor == "" {
+		rev.Actor = systemActor
+	}
+	if after != nil {
+		snapshot := *after
+		rev.UserID = after.ID
+		rev.Snapshot = &snapshot
+	} else {
+		rev.UserID = before.ID
+	}
+	return rev
+}
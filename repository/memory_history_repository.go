@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+	"userapi/models"
+)
+
+var _ HistoryRepository = (*memoryUserRepository)(nil)
+
+func (r *memoryUserRepository) History(ctx context.Context, userID int64) ([]*models.UserRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := []*models.UserRevision{}
+	for _, rev := range r.revisions {
+		if rev.UserID == userID {
+			revisions = append(revisions, copyRevision(rev))
+		}
+	}
+	log.Printf("Successfully fetched %d revisions of user with ID: %d", len(revisions), userID)
+	return revisions, nil
+}
+
+func (r *memoryUserRepository) AsOf(ctx context.Context, userID int64, t time.Time) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var latest *models.UserRevision
+	for _, rev := range r.revisions {
+		if rev.UserID == userID && !rev.CreatedAt.After(t) {
+			latest = rev
+		}
+	}
+	if latest == nil || latest.Snapshot == nil {
+		log.Printf("User with ID %d did not exist at %s", userID, t.Format(time.RFC3339))
+		return nil, nil
+	}
+	user := *latest.Snapshot
+	return &user, nil
+}
+
+func (r *memoryUserRepository) Revert(ctx context.Context, userID, revision int64) (*models.User, error) {
+	log.Printf("Reverting user with ID %d to revision %d", userID, revision)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var target *models.UserRevision
+	for _, rev := range r.revisions {
+		if rev.ID == revision && rev.UserID == userID {
+			target = rev
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("%w: %d of user %d", ErrRevisionNotFound, revision, userID)
+	}
+	if target.Action == models.RevisionDelete || target.Snapshot == nil {
+		return nil, fmt.Errorf("%w: %d", ErrRevertToDeleted, revision)
+	}
+
+	user := *target.Snapshot
+	user.ID = userID
+	emailKey, err := models.NormalizeEmail(user.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize email: %w", err)
+	}
+	if id, taken := r.emails[emailKey]; taken && id != userID {
+		return nil, fmt.Errorf("failed to revert user: %w: %s", ErrEmailTaken, user.Email)
+	}
+
+	// a deleted user comes back with its old ID
+	before := r.users[userID]
+	if before != nil {
+		oldKey, _ := models.NormalizeEmail(before.Email)
+		delete(r.emails, oldKey)
+	}
+	r.store(&user, emailKey)
+
+	rev := newRevision(ctx, models.RevisionRevert, before, &user)
+	rev.RevertOf = revision
+	r.record(rev)
+
+	log.Printf("Successfully reverted user with ID %d to revision %d", userID, revision)
+	copied := user
+	return &copied, nil
+}
+
+// copyRevision returns a copy of rev that callers can modify without affecting the history
+func copyRevision(rev *models.UserRevision) *models.UserRevision {
+	copied := *rev
+	copied.Changes = append([]models.FieldChange{}, rev.Changes...)
+	if rev.Snapshot != nil {
+		snapshot := *rev.Snapshot
+		copied.Snapshot = &snapshot
+	}
+	return &copied
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"userapi/models"
+	"userapi/reqctx"
+)
+
+func TestMemoryUserRepository_History(t *testing.T) {
+	ctx := reqctx.WithRequestID(reqctx.WithActor(context.Background(), "alice"), "req-1")
+	repo := NewMemoryUserRepository()
+	history := repo.(HistoryRepository)
+
+	user := newTestUser("John Doe", "john@example.com")
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	created := time.Now()
+
+	user.Name = "John Smith"
+	if err := repo.Update(ctx, user); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := repo.Update(context.Background(), user); err != nil {
+		t.Fatalf("Update() without changes error = %v", err)
+	}
+	if err := repo.Delete(context.Background(), user.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	revisions, err := history.History(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	var actions []string
+	for _, rev := range revisions {
+		actions = append(actions, rev.Action)
+	}
+	if len(revisions) != 3 || actions[0] != models.RevisionCreate || actions[1] != models.RevisionUpdate || actions[2] != models.RevisionDelete {
+		t.Fatalf("History() actions = %v, want create, update, delete", actions)
+	}
+	update := revisions[1]
+	if update.Actor != "alice" || update.RequestID != "req-1" {
+		t.Errorf("update revision actor = %q, request ID = %q", update.Actor, update.RequestID)
+	}
+	if len(update.Changes) != 1 || update.Changes[0] != (models.FieldChange{Field: "name", From: "John Doe", To: "John Smith"}) {
+		t.Errorf("update revision changes = %+v", update.Changes)
+	}
+	if revisions[2].Actor != systemActor || revisions[2].Snapshot != nil {
+		t.Errorf("delete revision = %+v", revisions[2])
+	}
+
+	if got, _ := history.AsOf(ctx, user.ID, created); got == nil || got.Name != "John Doe" {
+		t.Errorf("AsOf() after create = %+v, want John Doe", got)
+	}
+	if got, _ := history.AsOf(ctx, user.ID, time.Now()); got != nil {
+		t.Errorf("AsOf() after delete = %+v, want nil", got)
+	}
+	if got, _ := history.AsOf(ctx, user.ID, created.Add(-time.Hour)); got != nil {
+		t.Errorf("AsOf() before create = %+v, want nil", got)
+	}
+
+	if _, err := history.Revert(ctx, user.ID, revisions[2].ID); !errors.Is(err, ErrRevertToDeleted) {
+		t.Errorf("Revert() to delete error = %v, want %v", err, ErrRevertToDeleted)
+	}
+	if _, err := history.Revert(ctx, user.ID+1, revisions[0].ID); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Revert() of another user error = %v, want %v", err, ErrRevisionNotFound)
+	}
+
+	reverted, err := history.Revert(ctx, user.ID, revisions[0].ID)
+	if err != nil {
+		t.Fatalf("Revert() error = %v", err)
+	}
+	if stored, _ := repo.GetByID(ctx, user.ID); stored == nil || stored.Name != "John Doe" || reverted.Name != "John Doe" {
+		t.Errorf("GetByID() after revert = %+v", stored)
+	}
+	revisions, _ = history.History(ctx, user.ID)
+	if last := revisions[len(revisions)-1]; last.Action != models.RevisionRevert || last.RevertOf != revisions[0].ID {
+		t.Errorf("revert revision = %+v", last)
+	}
+
+	other := newTestUser("Jane Doe", "jane@example.com")
+	if err := repo.Create(ctx, other); err != nil || other.ID == user.ID {
+		t.Errorf("Create() after revert gave ID %d, error = %v", other.ID, err)
+	}
+}
+
+func TestMemoryUserRepository_HistoryOfAbortedBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryUserRepository()
+
+	ops := []BatchOperation{
+		{Type: BatchCreate, User: newTestUser("Jane Doe", "jane@example.com")},
+		{Type: BatchDelete, ID: 999},
+	}
+	if _, err := repo.Batch(ctx, ops, true); err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if revisions, _ := repo.(HistoryRepository).History(ctx, 1); len(revisions) != 0 {
+		t.Errorf("History() after aborted batch = %d revisions, want 0", len(revisions))
+	}
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"userapi/models"
+)
+
+var _ OutboxRepository = (*memoryUserRepository)(nil)
+
+type outboxEntry struct {
+	event     *models.UserEvent
+	published bool
+	attempts  int
+	lastError string
+}
+
+func (r *memoryUserRepository) PendingEvents(ctx context.Context, limit int) ([]*models.UserEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []*models.UserEvent{}
+	for _, entry := range r.outbox {
+		if len(events) == limit {
+			break
+		}
+		if !entry.published {
+			events = append(events, copyEvent(entry.event))
+		}
+	}
+	return events, nil
+}
+
+func (r *memoryUserRepository) PublishedEvents(ctx context.Context, afterSeq int64, limit int) ([]*models.UserEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// published holds the events in Seq order, starting at 1
+	start := int(afterSeq)
+	if start < 0 {
+		start = 0
+	}
+	events := []*models.UserEvent{}
+	for i := start; i < len(r.published) && len(events) < limit; i++ {
+		events = append(events, copyEvent(r.published[i].event))
+	}
+	return events, nil
+}
+
+func (r *memoryUserRepository) MarkPublished(ctx context.Context, events ...*models.UserEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range events {
+		entry := r.outboxEntry(e.ID)
+		if entry == nil || entry.published {
+			continue
+		}
+		entry.published = true
+		entry.attempts++
+		entry.lastError = ""
+		r.published = append(r.published, entry)
+		entry.event.Seq = int64(len(r.published))
+		e.Seq = entry.event.Seq
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) MarkFailed(ctx context.Context, id int64, cause error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry := r.outboxEntry(id); entry != nil {
+		entry.attempts++
+		entry.lastError = cause.Error()
+	}
+	return nil
+}
+
+// outboxEntry finds an event by ID. IDs are assigned in order without gaps, starting at the
+// ID of the first entry.
+func (r *memoryUserRepository) outboxEntry(id int64) *outboxEntry {
+	if len(r.outbox) == 0 {
+		return nil
+	}
+	i := id - r.outbox[0].event.ID
+	if i < 0 || i >= int64(len(r.outbox)) {
+		return nil
+	}
+	return r.outbox[i]
+}
+
+// copyEvent returns a copy of e that callers can modify without affecting the outbox
+func copyEvent(e *models.UserEvent) *models.UserEvent {
+	copied := *e
+	copied.Changes = append([]models.FieldChange{}, e.Changes...)
+	if e.User != nil {
+		user := *e.User
+		copied.User = &user
+	}
+	return &copied
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"userapi/models"
+)
+
+func TestMemoryUserRepository_Outbox(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryUserRepository()
+	outbox := repo.(OutboxRepository)
+
+	user := newTestUser("John Doe", "john@example.com")
+	repo.Create(ctx, user)
+	repo.Update(ctx, user)
+	repo.Delete(ctx, user.ID)
+	repo.(HistoryRepository).Revert(ctx, user.ID, 1)
+	repo.Batch(ctx, []BatchOperation{
+		{Type: BatchCreate, User: newTestUser("Jane Doe", "jane@example.com")},
+		{Type: BatchDelete, ID: 999},
+	}, true)
+
+	pending, err := outbox.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("PendingEvents() error = %v", err)
+	}
+	var types []string
+	for _, e := range pending {
+		types = append(types, e.Type)
+	}
+	want := []string{models.EventUserCreated, models.EventUserDeleted, models.EventUserCreated}
+	if len(types) != len(want) || types[0] != want[0] || types[1] != want[1] || types[2] != want[2] {
+		t.Fatalf("PendingEvents() types = %v, want %v: unchanged updates and aborted batches have no events, reverts of deleted users create them", types, want)
+	}
+
+	outbox.MarkFailed(ctx, pending[0].ID, errors.New("receiver unavailable"))
+	// events are published in the order the relay marks them, not in ID order
+	outbox.MarkPublished(ctx, pending[1], pending[0])
+	if pending[1].Seq != 1 || pending[0].Seq != 2 {
+		t.Errorf("MarkPublished() set Seq %d and %d, want 1 and 2 in the order given", pending[1].Seq, pending[0].Seq)
+	}
+	if pending, _ := outbox.PendingEvents(ctx, 10); len(pending) != 1 || pending[0].ID != 3 {
+		t.Errorf("PendingEvents() after publishing = %+v, want event 3", pending)
+	}
+	if published, _ := outbox.PublishedEvents(ctx, 0, 10); len(published) != 2 || published[0].ID != 2 || published[1].ID != 1 {
+		t.Errorf("PublishedEvents(0) = %+v, want events 2 and 1", published)
+	}
+	if published, _ := outbox.PublishedEvents(ctx, 1, 10); len(published) != 1 || published[0].ID != 1 || published[0].Seq != 2 {
+		t.Errorf("PublishedEvents(1) = %+v, want event 1 at position 2", published)
+	}
+}
@@ -0,0 +1,388 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"userapi/models"
+)
+
+type memoryUserRepository struct {
+	mu     sync.RWMutex
+	nextID int64
+	users  map[int64]*models.User
+	// emails maps normalized emails to user IDs, like the unique index in MySQL
+	emails map[string]int64
+	index  *trigramIndex
+	// revisions is append-only, in ID order
+	revisions []*models.UserRevision
+	nextRevID int64
+	// outbox holds the events of the revisions, in ID order
+	outbox      []*outboxEntry
+	nextEventID int64
+	// published holds the published events of the outbox, in the order they were published.
+	// Publishing is not part of a batch, so it is not rolled back with one.
+	published []*outboxEntry
+}
+
+// NewMemoryUserRepository creates a user repository that keeps users in process memory.
+// It is meant for development and tests; everything is lost when the process exits.
+func NewMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{
+		nextID: 1,
+		users:  make(map[int64]*models.User),
+		emails: make(map[string]int64),
+		index:  newTrigramIndex(),
+		// revision IDs are unique across users, like the AUTO_INCREMENT in MySQL
+		nextRevID:   1,
+		nextEventID: 1,
+	}
+}
+
+func (r *memoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	log.Printf("Creating user with email: %s", user.Email)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.create(ctx, user); err != nil {
+		log.Printf("Error creating user: %v", err)
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	log.Printf("Successfully created user with ID: %d", user.ID)
+	return nil
+}
+
+func (r *memoryUserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		log.Printf("User not found with ID: %d", id)
+		return nil, nil
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *memoryUserRepository) GetByIDs(ctx context.Context, ids []int64) ([]*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]*models.User, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if user, ok := r.users[id]; ok && !seen[id] {
+			seen[id] = true
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	users, err := r.GetByEmails(ctx, []string{email})
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+	return users[0], nil
+}
+
+func (r *memoryUserRepository) GetByEmails(ctx context.Context, emails []string) ([]*models.User, error) {
+	r.mu.RLock()
+	ids := make([]int64, 0, len(emails))
+	for _, email := range emails {
+		if emailKey, err := models.NormalizeEmail(email); err == nil {
+			if id, ok := r.emails[emailKey]; ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	return r.GetByIDs(ctx, ids)
+}
+
+func (r *memoryUserRepository) Update(ctx context.Context, user *models.User) error {
+	log.Printf("Updating user with ID: %d", user.ID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.update(ctx, user); err != nil {
+		log.Printf("Error updating user with ID %d: %v", user.ID, err)
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	log.Printf("Successfully updated user with ID: %d", user.ID)
+	return nil
+}
+
+func (r *memoryUserRepository) Delete(ctx context.Context, id int64) error {
+	log.Printf("Deleting user with ID: %d", id)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.delete(ctx, id); err != nil {
+		log.Printf("Error deleting user with ID %d: %v", id, err)
+		return err
+	}
+
+	log.Printf("Successfully deleted user with ID: %d", id)
+	return nil
+}
+
+func (r *memoryUserRepository) List(ctx context.Context, filter UserFilter) ([]*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := r.filter(filter)
+	log.Printf("Successfully fetched %d users", len(users))
+	return users, nil
+}
+
+func (r *memoryUserRepository) Stream(ctx context.Context, filter UserFilter, fn func(*models.User) error) error {
+	r.mu.RLock()
+	users := r.filter(filter)
+	r.mu.RUnlock()
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("failed to stream users: %w", err)
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) Batch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	log.Printf("Running batch of %d operations (atomic: %t)", len(ops), atomic)
+	results := make([]BatchResult, len(ops))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// an atomic batch is rolled back by restoring a copy of the state it started from
+	var snapshot *memoryUserRepository
+	if atomic {
+		snapshot = r.snapshot()
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.Type {
+		case BatchCreate:
+			err = r.create(ctx, op.User)
+			results[i].ID = op.User.ID
+		case BatchUpdate:
+			op.User.ID = op.ID
+			err = r.update(ctx, op.User)
+			results[i].ID = op.ID
+		case BatchDelete:
+			err = r.delete(ctx, op.ID)
+			results[i].ID = op.ID
+		case BatchUpsert:
+			err = r.upsert(ctx, op.User)
+			results[i].ID = op.User.ID
+		default:
+			err = fmt.Errorf("unknown batch operation: %q", op.Type)
+		}
+		if err == nil {
+			continue
+		}
+
+		results[i].Err = err
+		if atomic {
+			r.restore(snapshot)
+			for j := range results {
+				if j != i {
+					results[j] = BatchResult{Err: ErrBatchAborted}
+				}
+			}
+			log.Printf("Rolled back atomic batch of %d operations", len(ops))
+			return results, nil
+		}
+	}
+
+	log.Printf("Successfully ran batch of %d operations", len(ops))
+	return results, nil
+}
+
+func (r *memoryUserRepository) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matches := r.index.search(query.Text)
+	if query.Offset >= len(matches) {
+		return []SearchResult{}, nil
+	}
+	matches = matches[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(matches) {
+		matches = matches[:query.Limit]
+	}
+
+	results := make([]SearchResult, len(matches))
+	for i, match := range matches {
+		copied := *r.users[match.id]
+		results[i] = SearchResult{User: &copied, Score: match.score}
+	}
+
+	log.Printf("Search for %q found %d users", query.Text, len(results))
+	return results, nil
+}
+
+func (r *memoryUserRepository) create(ctx context.Context, user *models.User) error {
+	emailKey, err := normalizeUser(user)
+	if err != nil {
+		return err
+	}
+	if _, taken := r.emails[emailKey]; taken {
+		return fmt.Errorf("%w: %s", ErrEmailTaken, user.Email)
+	}
+
+	user.ID = r.nextID
+	r.nextID++
+	r.store(user, emailKey)
+	r.record(newRevision(ctx, models.RevisionCreate, nil, user))
+	return nil
+}
+
+func (r *memoryUserRepository) update(ctx context.Context, user *models.User) error {
+	existing, ok := r.users[user.ID]
+	if !ok {
+		return fmt.Errorf("%w with ID: %d", ErrUserNotFound, user.ID)
+	}
+	emailKey, err := normalizeUser(user)
+	if err != nil {
+		return err
+	}
+	if id, taken := r.emails[emailKey]; taken && id != user.ID {
+		return fmt.Errorf("%w: %s", ErrEmailTaken, user.Email)
+	}
+
+	oldKey, _ := models.NormalizeEmail(existing.Email)
+	delete(r.emails, oldKey)
+	r.store(user, emailKey)
+	r.record(newRevision(ctx, models.RevisionUpdate, existing, user))
+	return nil
+}
+
+func (r *memoryUserRepository) upsert(ctx context.Context, user *models.User) error {
+	emailKey, err := models.NormalizeEmail(user.Email)
+	if err != nil {
+		return err
+	}
+	if id, ok := r.emails[emailKey]; ok {
+		user.ID = id
+		return r.update(ctx, user)
+	}
+	return r.create(ctx, user)
+}
+
+func (r *memoryUserRepository) delete(ctx context.Context, id int64) error {
+	user, ok := r.users[id]
+	if !ok {
+		return fmt.Errorf("%w with ID: %d", ErrUserNotFound, id)
+	}
+	emailKey, _ := models.NormalizeEmail(user.Email)
+	delete(r.emails, emailKey)
+	delete(r.users, id)
+	r.index.remove(id)
+	r.record(newRevision(ctx, models.RevisionDelete, user, nil))
+	return nil
+}
+
+// record appends rev to the history and its event to the outbox, unless rev is nil because
+// nothing changed
+func (r *memoryUserRepository) record(rev *models.UserRevision) {
+	if rev == nil {
+		return
+	}
+	rev.ID = r.nextRevID
+	r.nextRevID++
+	r.revisions = append(r.revisions, rev)
+
+	event := models.EventFromRevision(rev)
+	event.ID = r.nextEventID
+	r.nextEventID++
+	r.outbox = append(r.outbox, &outboxEntry{event: event})
+}
+
+// store saves a copy of user, so callers cannot change stored users behind the lock
+func (r *memoryUserRepository) store(user *models.User, emailKey string) {
+	copied := *user
+	r.users[user.ID] = &copied
+	r.emails[emailKey] = user.ID
+	r.index.add(&copied)
+}
+
+// filter returns copies of the users matching filter, ordered by ID, with the same
+// semantics as the WHERE clause of the MySQL repository
+func (r *memoryUserRepository) filter(filter UserFilter) []*models.User {
+	ids := make([]int64, 0, len(r.users))
+	for id := range r.users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	matches := filter.Matcher()
+	users := make([]*models.User, 0)
+	for _, id := range ids {
+		user := r.users[id]
+		if id <= filter.AfterID || !matches(user) {
+			continue
+		}
+
+		copied := *user
+		users = append(users, &copied)
+		if filter.Limit > 0 && len(users) == filter.Limit {
+			break
+		}
+	}
+	return users
+}
+
+// snapshot copies the state of the repository. Stored users are never modified in place
+// and revisions and events are only appended while a batch holds the lock, so copying the
+// maps and the slice headers is enough.
+func (r *memoryUserRepository) snapshot() *memoryUserRepository {
+	s := &memoryUserRepository{
+		nextID:      r.nextID,
+		revisions:   r.revisions,
+		nextRevID:   r.nextRevID,
+		outbox:      r.outbox,
+		nextEventID: r.nextEventID,
+		users:       make(map[int64]*models.User, len(r.users)),
+		emails:      make(map[string]int64, len(r.emails)),
+	}
+	for id, user := range r.users {
+		s.users[id] = user
+	}
+	for email, id := range r.emails {
+		s.emails[email] = id
+	}
+	return s
+}
+
+// restore rolls the repository back to a snapshot and rebuilds the search index
+func (r *memoryUserRepository) restore(s *memoryUserRepository) {
+	r.nextID = s.nextID
+	r.users = s.users
+	r.emails = s.emails
+	r.revisions = s.revisions
+	r.nextRevID = s.nextRevID
+	r.outbox = s.outbox
+	r.nextEventID = s.nextEventID
+	r.index = newTrigramIndex()
+	for _, user := range r.users {
+		r.index.add(user)
+	}
+}
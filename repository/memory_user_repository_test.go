@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"userapi/models"
+)
+
+func newTestUser(name, email string) *models.User {
+	return &models.User{Name: name, Age: 30, PhoneNumber: "+14155552671", Email: email}
+}
+
+func TestMemoryUserRepository_CRUD(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryUserRepository()
+
+	user := newTestUser("John Doe", "John@Example.com")
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if user.ID != 1 || user.Email != "John@example.com" {
+		t.Fatalf("Create() stored %+v", user)
+	}
+
+	if err := repo.Create(ctx, newTestUser("Other John", "john@example.com")); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("Create() with taken email error = %v, want %v", err, ErrEmailTaken)
+	}
+
+	got, err := repo.GetByEmail(ctx, "JOHN@example.com")
+	if err != nil || got == nil || got.ID != user.ID {
+		t.Fatalf("GetByEmail() = %v, %v", got, err)
+	}
+	got.Name = "Changed"
+	if stored, _ := repo.GetByID(ctx, user.ID); stored.Name != "John Doe" {
+		t.Errorf("changing a returned user changed the stored one")
+	}
+
+	user.Email = "johnny@example.com"
+	if err := repo.Update(ctx, user); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got, _ := repo.GetByEmail(ctx, "john@example.com"); got != nil {
+		t.Errorf("GetByEmail() with old email = %v, want nil", got)
+	}
+
+	if err := repo.Delete(ctx, user.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := repo.Delete(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Delete() twice error = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestMemoryUserRepository_Batch(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryUserRepository()
+	if err := repo.Create(ctx, newTestUser("John Doe", "john@example.com")); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	ops := []BatchOperation{
+		{Type: BatchCreate, User: newTestUser("Jane Doe", "jane@example.com")},
+		{Type: BatchDelete, ID: 999},
+	}
+	results, err := repo.Batch(ctx, ops, true)
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if !errors.Is(results[0].Err, ErrBatchAborted) || !errors.Is(results[1].Err, ErrUserNotFound) {
+		t.Errorf("Batch() results = %+v", results)
+	}
+	if users, _ := repo.List(ctx, UserFilter{}); len(users) != 1 {
+		t.Errorf("List() after aborted batch returned %d users, want 1", len(users))
+	}
+
+	ops = []BatchOperation{
+		{Type: BatchUpsert, User: newTestUser("John Smith", "JOHN@example.com")},
+		{Type: BatchCreate, User: newTestUser("Jane Doe", "jane@example.com")},
+	}
+	results, err = repo.Batch(ctx, ops, false)
+	if err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if results[0].Err != nil || results[0].ID != 1 || results[1].Err != nil {
+		t.Errorf("Batch() results = %+v", results)
+	}
+	if found, _ := repo.Search(ctx, SearchQuery{Text: "smith"}); len(found) != 1 || found[0].User.ID != 1 {
+		t.Errorf("Search() after upsert = %+v", found)
+	}
+}
+
+func TestMemoryUserRepository_Search(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryUserRepository()
+	for _, user := range []*models.User{
+		newTestUser("Jonathan Smith", "jon@example.com"),
+		newTestUser("John Doe", "john.doe@example.com"),
+		newTestUser("Maria Garcia", "maria@example.org"),
+		{Name: "Bob Stone", Age: 40, PhoneNumber: "+442079460958", Email: "bob@example.co.uk"},
+	} {
+		if err := repo.Create(ctx, user); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   SearchQuery
+		wantIDs []int64
+	}{
+		{name: "exact name ranks first", query: SearchQuery{Text: "john"}, wantIDs: []int64{2, 1}},
+		{name: "typo", query: SearchQuery{Text: "jhon doe"}, wantIDs: []int64{2}},
+		{name: "partial email", query: SearchQuery{Text: "maria@example"}, wantIDs: []int64{3}},
+		{name: "phone number in national format", query: SearchQuery{Text: "020 7946 0958"}, wantIDs: []int64{4}},
+		{name: "partial phone number", query: SearchQuery{Text: "7946"}, wantIDs: []int64{4}},
+		{name: "every term has to match", query: SearchQuery{Text: "maria smith"}, wantIDs: nil},
+		{name: "paginated", query: SearchQuery{Text: "john", Limit: 1, Offset: 1}, wantIDs: []int64{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := repo.Search(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			if len(results) != len(tt.wantIDs) {
+				t.Fatalf("Search() returned %d results, want %d: %+v", len(results), len(tt.wantIDs), results)
+			}
+			for i, id := range tt.wantIDs {
+				if results[i].User.ID != id {
+					t.Errorf("result %d ID = %v, want %v", i, results[i].User.ID, id)
+				}
+			}
+		})
+	}
+}
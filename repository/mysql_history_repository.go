@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"userapi/models"
+)
+
+var _ HistoryRepository = (*mysqlUserRepository)(nil)
+
+func (r *mysqlUserRepository) History(ctx context.Context, userID int64) ([]*models.UserRevision, error) {
+	query := `SELECT id, user_id, action, actor, request_id, changes, snapshot, revert_of, created_at
+		FROM user_revisions WHERE user_id = ? ORDER BY id`
+	log.Printf("Fetching history of user with ID: %d", userID)
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		log.Printf("Error fetching history of user with ID %d: %v", userID, err)
+		return nil, fmt.Errorf("failed to fetch revisions: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	revisions := []*models.UserRevision{}
+	for rows.Next() {
+		rev := &models.UserRevision{}
+		var changes, snapshot []byte
+		var revertOf sql.NullInt64
+		if err := rows.Scan(&rev.ID, &rev.UserID, &rev.Action, &rev.Actor, &rev.RequestID, &changes, &snapshot, &revertOf, &rev.CreatedAt); err != nil {
+			log.Printf("Error scanning revision row: %v", err)
+			return nil, fmt.Errorf("failed to scan revision: %w", err)
+		}
+		if err := json.Unmarshal(changes, &rev.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode changes of revision %d: %w", rev.ID, err)
+		}
+		if snapshot != nil {
+			if err := json.Unmarshal(snapshot, &rev.Snapshot); err != nil {
+				return nil, fmt.Errorf("failed to decode snapshot of revision %d: %w", rev.ID, err)
+			}
+		}
+		rev.RevertOf = revertOf.Int64
+		revisions = append(revisions, rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating revision rows: %v", err)
+		return nil, fmt.Errorf("failed to iterate revisions: %w", err)
+	}
+
+	log.Printf("Successfully fetched %d revisions of user with ID: %d", len(revisions), userID)
+	return revisions, nil
+}
+
+func (r *mysqlUserRepository) AsOf(ctx context.Context, userID int64, t time.Time) (*models.User, error) {
+	query := `SELECT snapshot FROM user_revisions WHERE user_id = ? AND created_at <= ? ORDER BY id DESC LIMIT 1`
+	log.Printf("Fetching user with ID %d as of %s", userID, t.Format(time.RFC3339))
+
+	var snapshot []byte
+	err := r.db.QueryRowContext(ctx, query, userID, t.UTC()).Scan(&snapshot)
+	if err == sql.ErrNoRows || (err == nil && snapshot == nil) {
+		log.Printf("User with ID %d did not exist at %s", userID, t.Format(time.RFC3339))
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error fetching user with ID %d as of %s: %v", userID, t.Format(time.RFC3339), err)
+		return nil, fmt.Errorf("failed to fetch revision: %w", err)
+	}
+
+	user := &models.User{}
+	if err := json.Unmarshal(snapshot, user); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	return user, nil
+}
+
+func (r *mysqlUserRepository) Revert(ctx context.Context, userID, revision int64) (*models.User, error) {
+	defer r.replicas.wrote(ctx)
+
+	log.Printf("Reverting user with ID %d to revision %d", userID, revision)
+
+	var target *models.User
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var action string
+		var snapshot []byte
+		err := tx.QueryRowContext(ctx, `SELECT action, snapshot FROM user_revisions WHERE id = ? AND user_id = ?`, revision, userID).
+			Scan(&action, &snapshot)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d of user %d", ErrRevisionNotFound, revision, userID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch revision: %w", err)
+		}
+		if action == models.RevisionDelete || snapshot == nil {
+			return fmt.Errorf("%w: %d", ErrRevertToDeleted, revision)
+		}
+		target = &models.User{}
+		if err := json.Unmarshal(snapshot, target); err != nil {
+			return fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		target.ID = userID
+		emailKey, err := models.NormalizeEmail(target.Email)
+		if err != nil {
+			return fmt.Errorf("failed to normalize email: %w", err)
+		}
+
+		current, err := lockUsers(ctx, tx, "id", []interface{}{userID})
+		if err != nil {
+			return err
+		}
+		var before *models.User
+		if len(current) > 0 {
+			before = current[0]
+			_, err = tx.ExecContext(ctx, `UPDATE users SET name = ?, age = ?, phone_number = ?, phone_number_raw = ?, email = ?, email_normalized = ? WHERE id = ?`,
+				target.Name, target.Age, target.PhoneNumber, target.PhoneNumberRaw, target.Email, emailKey, userID)
+		} else {
+			// a deleted user comes back with its old ID
+			_, err = tx.ExecContext(ctx, `INSERT INTO users (id, name, age, phone_number, phone_number_raw, email, email_normalized) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				userID, target.Name, target.Age, target.PhoneNumber, target.PhoneNumberRaw, target.Email, emailKey)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to revert user: %w", duplicateEmailError(err, target.Email))
+		}
+
+		rev := newRevision(ctx, models.RevisionRevert, before, target)
+		rev.RevertOf = revision
+		return recordChanges(ctx, tx, rev)
+	})
+	if err != nil {
+		log.Printf("Error reverting user with ID %d to revision %d: %v", userID, revision, err)
+		return nil, err
+	}
+
+	log.Printf("Successfully reverted user with ID %d to revision %d", userID, revision)
+	return target, nil
+}
+
+// insertRevisions records revisions and sets their IDs. Nil revisions, for updates that
+// changed nothing, are skipped. Each revision is inserted on its own, since only then is
+// LastInsertId its ID: the rows of a multi-row insert need not get consecutive IDs.
+func insertRevisions(ctx context.Context, ex execQuerier, revisions ...*models.UserRevision) error {
+	query := `INSERT INTO user_revisions (user_id, action, actor, request_id, changes, snapshot, revert_of, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, rev := range revisions {
+		if rev == nil {
+			continue
+		}
+		changes, err := json.Marshal(rev.Changes)
+		if err != nil {
+			return fmt.Errorf("failed to encode changes: %w", err)
+		}
+		// JSON columns reject binary strings, so the documents are sent as text
+		var snapshot interface{}
+		if rev.Snapshot != nil {
+			encoded, err := json.Marshal(rev.Snapshot)
+			if err != nil {
+				return fmt.Errorf("failed to encode snapshot: %w", err)
+			}
+			snapshot = string(encoded)
+		}
+		var revertOf interface{}
+		if rev.RevertOf != 0 {
+			revertOf = rev.RevertOf
+		}
+
+		result, err := ex.ExecContext(ctx, query, rev.UserID, rev.Action, rev.Actor, rev.RequestID, string(changes), snapshot, revertOf, rev.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record revision: %w", err)
+		}
+		if rev.ID, err = result.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to get revision ID: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"userapi/models"
+)
+
+func TestInsertRevisions_IDs(t *testing.T) {
+	// IDs go up in steps of 5, as with an auto_increment_increment of 5
+	next := int64(1)
+	rows := map[int64]int64{}
+	db := openFakeSQL(t, &fakeSQL{
+		exec: func(query string, args []driver.Value) (driver.Result, error) {
+			if !strings.HasPrefix(query, "INSERT INTO user_revisions") || len(args) != 8 {
+				return nil, fmt.Errorf("unexpected statement %s with %d arguments", query, len(args))
+			}
+			id := next
+			next += 5
+			rows[id] = args[0].(int64)
+			return fakeResult{lastInsertID: id, rowsAffected: 1}, nil
+		},
+	})
+
+	now := time.Now()
+	revisions := []*models.UserRevision{
+		{UserID: 7, Action: models.RevisionCreate, CreatedAt: now},
+		nil,
+		{UserID: 8, Action: models.RevisionCreate, CreatedAt: now},
+		{UserID: 7, Action: models.RevisionDelete, CreatedAt: now},
+	}
+	if err := insertRevisions(context.Background(), db, revisions...); err != nil {
+		t.Fatalf("insertRevisions() error = %v", err)
+	}
+	for i, rev := range revisions {
+		if rev == nil {
+			continue
+		}
+		if rows[rev.ID] != rev.UserID {
+			t.Errorf("revision %d of user %d got ID %d, which is the row of user %d", i, rev.UserID, rev.ID, rows[rev.ID])
+		}
+	}
+	if len(rows) != 3 {
+		t.Errorf("inserted %d rows, want 3", len(rows))
+	}
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"userapi/models"
+)
+
+// maxOutboxErrorLength is the size of the last_error column of the outbox table
+const maxOutboxErrorLength = 1024
+
+var _ OutboxRepository = (*mysqlUserRepository)(nil)
+
+func (r *mysqlUserRepository) PendingEvents(ctx context.Context, limit int) ([]*models.UserEvent, error) {
+	events, err := r.queryEvents(ctx, `WHERE published_at IS NULL ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		log.Printf("Error fetching pending events: %v", err)
+		return nil, fmt.Errorf("failed to fetch pending events: %w", err)
+	}
+	return events, nil
+}
+
+func (r *mysqlUserRepository) PublishedEvents(ctx context.Context, afterSeq int64, limit int) ([]*models.UserEvent, error) {
+	events, err := r.queryEvents(ctx, `WHERE publish_seq > ? ORDER BY publish_seq LIMIT ?`, afterSeq, limit)
+	if err != nil {
+		log.Printf("Error fetching events published after %d: %v", afterSeq, err)
+		return nil, fmt.Errorf("failed to fetch published events: %w", err)
+	}
+	return events, nil
+}
+
+func (r *mysqlUserRepository) queryEvents(ctx context.Context, clauses string, args ...interface{}) ([]*models.UserEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, publish_seq, payload FROM outbox `+clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	events := []*models.UserEvent{}
+	for rows.Next() {
+		var id int64
+		var seq sql.NullInt64
+		var payload []byte
+		if err := rows.Scan(&id, &seq, &payload); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		e := &models.UserEvent{}
+		if err := json.Unmarshal(payload, e); err != nil {
+			return nil, fmt.Errorf("failed to decode event %d: %w", id, err)
+		}
+		e.ID = id
+		e.Seq = seq.Int64
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate events: %w", err)
+	}
+	return events, nil
+}
+
+// MarkPublished numbers the events after the highest publish_seq, which it locks until the
+// transaction commits, so that positions are taken in the order they become visible.
+// Events that are already published keep their position.
+func (r *mysqlUserRepository) MarkPublished(ctx context.Context, events ...*models.UserEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	seqs := make([]int64, len(events))
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		var last int64
+		err := tx.QueryRowContext(ctx, `SELECT publish_seq FROM outbox WHERE publish_seq IS NOT NULL ORDER BY publish_seq DESC LIMIT 1 FOR UPDATE`).Scan(&last)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to read last published position: %w", err)
+		}
+
+		now := time.Now().UTC()
+		query := `UPDATE outbox SET published_at = ?, publish_seq = ?, attempts = attempts + 1, last_error = ''
+			WHERE id = ? AND publish_seq IS NULL`
+		for i, e := range events {
+			result, err := tx.ExecContext(ctx, query, now, last+1, e.ID)
+			if err != nil {
+				return fmt.Errorf("failed to mark event %d published: %w", e.ID, err)
+			}
+			if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+				last++
+				seqs[i] = last
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error marking %d events published: %v", len(events), err)
+		return fmt.Errorf("failed to mark events published: %w", err)
+	}
+	for i, e := range events {
+		if seqs[i] != 0 {
+			e.Seq = seqs[i]
+		}
+	}
+	return nil
+}
+
+func (r *mysqlUserRepository) MarkFailed(ctx context.Context, id int64, cause error) error {
+	message := cause.Error()
+	if len(message) > maxOutboxErrorLength {
+		message = message[:maxOutboxErrorLength]
+	}
+
+	query := `UPDATE outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?`
+	if _, err := r.db.ExecContext(ctx, query, message, id); err != nil {
+		log.Printf("Error marking event %d failed: %v", id, err)
+		return fmt.Errorf("failed to mark event failed: %w", err)
+	}
+	return nil
+}
+
+// recordChanges records revisions in the history and their events in the outbox. It is
+// called in the transaction that made the changes.
+func recordChanges(ctx context.Context, ex execQuerier, revisions ...*models.UserRevision) error {
+	if err := insertRevisions(ctx, ex, revisions...); err != nil {
+		return err
+	}
+	return insertEvents(ctx, ex, eventsFromRevisions(revisions))
+}
+
+// insertEvents adds events to the outbox and sets their IDs. Each event is inserted on its
+// own, as the rows of a multi-row insert need not get consecutive IDs.
+func insertEvents(ctx context.Context, ex execQuerier, events []*models.UserEvent) error {
+	query := `INSERT INTO outbox (aggregate_id, event_type, payload, created_at) VALUES (?, ?, ?, ?)`
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		// JSON columns reject binary strings, so the payload is sent as text
+		result, err := ex.ExecContext(ctx, query, e.UserID, e.Type, string(payload), e.OccurredAt)
+		if err != nil {
+			return fmt.Errorf("failed to add event to outbox: %w", err)
+		}
+		if e.ID, err = result.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to get event ID: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"userapi/models"
+)
+
+// fakeOutboxTable answers the statements of insertEvents and MarkPublished. IDs go up in
+// steps of 3, as with an auto_increment_increment of 3.
+type fakeOutboxTable struct {
+	nextID int64
+	// users maps event IDs to the users the inserted rows belong to
+	users map[int64]int64
+	// seqs maps event IDs to their publish_seq
+	seqs map[int64]int64
+}
+
+func (o *fakeOutboxTable) fakeSQL() *fakeSQL {
+	return &fakeSQL{
+		exec: func(query string, args []driver.Value) (driver.Result, error) {
+			switch {
+			case strings.HasPrefix(query, "INSERT INTO outbox") && len(args) == 4:
+				id := o.nextID
+				o.nextID += 3
+				o.users[id] = args[0].(int64)
+				return fakeResult{lastInsertID: id, rowsAffected: 1}, nil
+			case strings.HasPrefix(query, "UPDATE outbox SET published_at"):
+				id := args[2].(int64)
+				if _, ok := o.seqs[id]; ok {
+					return fakeResult{}, nil
+				}
+				o.seqs[id] = args[1].(int64)
+				return fakeResult{rowsAffected: 1}, nil
+			}
+			return nil, fmt.Errorf("unexpected statement %s with %d arguments", query, len(args))
+		},
+		query: func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+			if !strings.HasPrefix(query, "SELECT publish_seq FROM outbox") || !strings.HasSuffix(query, "FOR UPDATE") {
+				return nil, nil, fmt.Errorf("unexpected query %s", query)
+			}
+			var last int64
+			for _, seq := range o.seqs {
+				if seq > last {
+					last = seq
+				}
+			}
+			if last == 0 {
+				return []string{"publish_seq"}, nil, nil
+			}
+			return []string{"publish_seq"}, [][]driver.Value{{last}}, nil
+		},
+	}
+}
+
+func TestMySQLOutbox_InsertAndPublish(t *testing.T) {
+	table := &fakeOutboxTable{nextID: 1, users: map[int64]int64{}, seqs: map[int64]int64{}}
+	db := openFakeSQL(t, table.fakeSQL())
+	r := &mysqlUserRepository{db: db}
+	ctx := context.Background()
+
+	events := []*models.UserEvent{
+		{UserID: 7, Type: models.EventUserCreated},
+		{UserID: 8, Type: models.EventUserCreated},
+		{UserID: 7, Type: models.EventUserUpdated},
+	}
+	if err := insertEvents(ctx, db, events); err != nil {
+		t.Fatalf("insertEvents() error = %v", err)
+	}
+	for i, e := range events {
+		if table.users[e.ID] != e.UserID {
+			t.Errorf("event %d of user %d got ID %d, which is the row of user %d", i, e.UserID, e.ID, table.users[e.ID])
+		}
+	}
+
+	// the event of user 8 is published first, as it would be if user 7 were blocked
+	if err := r.MarkPublished(ctx, events[1]); err != nil {
+		t.Fatalf("MarkPublished() error = %v", err)
+	}
+	if err := r.MarkPublished(ctx, events[0], events[1], events[2]); err != nil {
+		t.Fatalf("MarkPublished() error = %v", err)
+	}
+	for i, want := range []int64{2, 1, 3} {
+		if events[i].Seq != want || table.seqs[events[i].ID] != want {
+			t.Errorf("event %d has Seq %d (stored %d), want %d", i, events[i].Seq, table.seqs[events[i].ID], want)
+		}
+	}
+}
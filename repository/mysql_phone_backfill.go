@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"userapi/models"
+)
+
+// PhoneBackfillResult counts the users seen by BackfillPhoneNumbers
+type PhoneBackfillResult struct {
+	Checked   int
+	Converted int
+	Invalid   int
+}
+
+// BackfillPhoneNumbers converts the phone numbers of users stored before migration 002 to
+// E.164, reading numbers without a country code as numbers of region. The number as entered
+// stays in phone_number_raw. Numbers that are not valid are left as they are and logged.
+// Users are read in pages of batchSize, so the command can be stopped and run again.
+func BackfillPhoneNumbers(ctx context.Context, db *sql.DB, region string, batchSize int) (PhoneBackfillResult, error) {
+	var result PhoneBackfillResult
+	var lastID int64
+	for {
+		numbers, err := phoneNumbersAfter(ctx, db, lastID, batchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(numbers) == 0 {
+			return result, nil
+		}
+
+		for _, n := range numbers {
+			lastID = n.id
+			result.Checked++
+			phone, err := models.NormalizePhoneNumber(n.phone, region)
+			if err != nil {
+				log.Printf("Leaving phone number of user %d as it is: %v", n.id, err)
+				result.Invalid++
+				continue
+			}
+			if phone == n.phone {
+				continue
+			}
+			// only rewrite the number if nobody saved the user in the meantime
+			res, err := db.ExecContext(ctx, `UPDATE users SET phone_number = ? WHERE id = ? AND phone_number = ?`, phone, n.id, n.phone)
+			if err != nil {
+				return result, fmt.Errorf("failed to update phone number of user %d: %w", n.id, err)
+			}
+			if rows, err := res.RowsAffected(); err == nil && rows > 0 {
+				result.Converted++
+			}
+		}
+	}
+}
+
+type storedPhoneNumber struct {
+	id    int64
+	phone string
+}
+
+func phoneNumbersAfter(ctx context.Context, db *sql.DB, afterID int64, limit int) ([]storedPhoneNumber, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, phone_number FROM users WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query phone numbers: %w", err)
+	}
+	defer rows.Close()
+
+	var numbers []storedPhoneNumber
+	for rows.Next() {
+		var n storedPhoneNumber
+		if err := rows.Scan(&n.id, &n.phone); err != nil {
+			return nil, fmt.Errorf("failed to scan phone number: %w", err)
+		}
+		numbers = append(numbers, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read phone numbers: %w", err)
+	}
+	return numbers, nil
+}
@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"userapi/reqctx"
+)
+
+var (
+	// ReadYourWritesWindow is how long reads on behalf of an authenticated actor go to the
+	// primary after it wrote, so that it does not miss its own changes while they replicate
+	ReadYourWritesWindow = 5 * time.Second
+	// ReplicaCheckInterval is how often the health of a replica is checked
+	ReplicaCheckInterval = 5 * time.Second
+	// MaxReplicaLag is how far a replica may fall behind the primary before it is taken out
+	// of rotation
+	MaxReplicaLag = 30 * time.Second
+)
+
+// replicaCheckTimeout bounds a health check
+const replicaCheckTimeout = 2 * time.Second
+
+// errReplicationStopped is returned for replicas that do not replicate from their source
+var errReplicationStopped = errors.New("replication is not running")
+
+// replica is a read-only copy of the database that serves reads while it is healthy
+type replica struct {
+	db        *sql.DB
+	name      string
+	healthy   atomic.Bool
+	checking  atomic.Bool
+	lastCheck atomic.Int64 // UnixNano of the last health check
+	lagWarned atomic.Bool
+}
+
+// replicaSet spreads reads over the healthy replicas and remembers who wrote recently. The
+// writes are remembered by this process only, so read-your-writes holds for an actor whose
+// requests all reach the same instance. Behind a load balancer that spreads them over several,
+// a read on another instance can still be served by a replica that has not caught up yet.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+
+	mu     sync.Mutex
+	writes map[string]time.Time
+	now    func() time.Time
+}
+
+func newReplicaSet(dbs []*sql.DB) *replicaSet {
+	s := &replicaSet{writes: make(map[string]time.Time), now: time.Now}
+	for i, db := range dbs {
+		rep := &replica{db: db, name: "replica " + strconv.Itoa(i+1)}
+		rep.healthy.Store(true)
+		s.replicas = append(s.replicas, rep)
+	}
+	return s
+}
+
+// pick returns the replica that should serve a read on behalf of ctx, or nil if it should
+// go to the primary because there is no healthy replica, ctx wrote recently or could have
+// without anyone knowing, or the read fills a cache. Replicas are used in turn.
+func (s *replicaSet) pick(ctx context.Context) *replica {
+	if len(s.replicas) == 0 || needsPrimary(ctx) || s.wroteRecently(ctx) {
+		return nil
+	}
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		rep := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		rep.checkIfDue(s.now())
+		if rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// wrote records that ctx has just written, so that its reads go to the primary for the
+// next ReadYourWritesWindow
+func (s *replicaSet) wrote(ctx context.Context) {
+	key := writerKey(ctx)
+	if len(s.replicas) == 0 || key == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.writes[key] = now
+	if len(s.writes) > 1000 {
+		for k, t := range s.writes {
+			if now.Sub(t) >= ReadYourWritesWindow {
+				delete(s.writes, k)
+			}
+		}
+	}
+}
+
+func (s *replicaSet) wroteRecently(ctx context.Context) bool {
+	key := writerKey(ctx)
+	if key == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.writes[key]
+	return ok && s.now().Sub(t) < ReadYourWritesWindow
+}
+
+// writerKey identifies the authenticated actor ctx acts for, or is "" for anonymous requests
+// and work outside of requests
+func writerKey(ctx context.Context) string {
+	if actor := reqctx.Actor(ctx); actor != "" && actor != reqctx.AnonymousActor {
+		return "actor:" + actor
+	}
+	return ""
+}
+
+type primaryReadsKey struct{}
+
+// withPrimaryReads returns a context whose reads go to the primary, for reads whose result
+// outlives the request, like users put into a cache
+func withPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// needsPrimary reports whether reads on behalf of ctx must not be served by a replica: reads
+// that fill a cache, and anonymous reads, whose writes cannot be told apart from anyone
+// else's, so read-your-writes cannot be kept for them on a replica. Without API_TOKENS every
+// request is anonymous, so replicas only serve requests made with a token.
+func needsPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return primary || reqctx.Actor(ctx) == reqctx.AnonymousActor
+}
+
+// markDown takes the replica out of rotation until its next successful health check
+func (rep *replica) markDown(err error) {
+	if rep.healthy.Swap(false) {
+		log.Printf("Taking %s out of rotation: %v", rep.name, err)
+	}
+}
+
+// checkIfDue starts a health check in the background when the last one is old enough
+func (rep *replica) checkIfDue(now time.Time) {
+	if now.UnixNano()-rep.lastCheck.Load() < int64(ReplicaCheckInterval) || !rep.checking.CompareAndSwap(false, true) {
+		return
+	}
+	rep.lastCheck.Store(now.UnixNano())
+	go func() {
+		defer rep.checking.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+		defer cancel()
+
+		if err := rep.check(ctx); err != nil {
+			rep.markDown(err)
+		} else if !rep.healthy.Swap(true) {
+			log.Printf("Returning %s to rotation", rep.name)
+		}
+	}()
+}
+
+// check pings the replica and makes sure it is replicating without too much lag
+func (rep *replica) check(ctx context.Context) error {
+	if err := rep.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping: %w", err)
+	}
+
+	lag, err := replicationLag(ctx, rep.db)
+	if errors.Is(err, errReplicationStopped) {
+		return err
+	}
+	if err != nil {
+		// without the REPLICATION CLIENT privilege only reachability can be checked
+		if !rep.lagWarned.Swap(true) {
+			log.Printf("Cannot check replication lag of %s, checking reachability only: %v", rep.name, err)
+		}
+		return nil
+	}
+	if lag > MaxReplicaLag {
+		return fmt.Errorf("replication lag of %v exceeds %v", lag, MaxReplicaLag)
+	}
+	return nil
+}
+
+// replicationLag returns how far db is behind its source. A database that is not a replica
+// has no lag; for one whose replication is stopped it returns errReplicationStopped.
+func replicationLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, `SHOW REPLICA STATUS`)
+	if err != nil {
+		// MySQL before 8.0.22
+		if rows, err = db.QueryContext(ctx, `SHOW SLAVE STATUS`); err != nil {
+			return 0, err
+		}
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column != "Seconds_Behind_Source" && column != "Seconds_Behind_Master" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, errReplicationStopped
+		}
+		seconds, err := strconv.ParseInt(string(values[i]), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s: %w", column, err)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, fmt.Errorf("replica status has no lag column")
+}
+
+// queryRead runs a read-only query on a replica if one may serve ctx and on the primary
+// otherwise. A replica that fails the query is taken out of rotation and the query is
+// run on the primary instead.
+func (r *mysqlUserRepository) queryRead(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if rep := r.replicas.pick(ctx); rep != nil {
+		rows, err := rep.db.QueryContext(ctx, query, args...)
+		if err == nil || ctx.Err() != nil {
+			return rows, err
+		}
+		rep.markDown(err)
+	}
+	return r.db.QueryContext(ctx, query, args...)
+}
+
+// beginRead is like queryRead, but starts a read-only transaction
+func (r *mysqlUserRepository) beginRead(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if rep := r.replicas.pick(ctx); rep != nil {
+		tx, err := rep.db.BeginTx(ctx, opts)
+		if err == nil || ctx.Err() != nil {
+			return tx, err
+		}
+		rep.markDown(err)
+	}
+	return r.db.BeginTx(ctx, opts)
+}
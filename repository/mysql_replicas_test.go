@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+	"userapi/reqctx"
+)
+
+func TestReplicaSet_Pick(t *testing.T) {
+	now := time.Now()
+	s := newReplicaSet([]*sql.DB{{}, {}})
+	s.now = func() time.Time { return now }
+	for _, rep := range s.replicas {
+		// no health checks, the databases are not connected
+		rep.lastCheck.Store(now.Add(time.Hour).UnixNano())
+	}
+
+	alice := reqctx.WithActor(context.Background(), "alice")
+	bob := reqctx.WithActor(context.Background(), "bob")
+	anonymous := reqctx.WithRequestID(reqctx.WithActor(context.Background(), reqctx.AnonymousActor), "abc")
+
+	if first, second := s.pick(alice), s.pick(alice); first == nil || second == nil || first == second {
+		t.Fatalf("pick() = %v, %v, want both replicas in turn", first, second)
+	}
+	if s.pick(anonymous) != nil {
+		t.Errorf("pick() for an anonymous request chose a replica, want the primary")
+	}
+	if s.pick(withPrimaryReads(bob)) != nil {
+		t.Errorf("pick() for a cache fill chose a replica, want the primary")
+	}
+
+	s.wrote(alice)
+	s.wrote(anonymous)
+	if s.pick(alice) != nil {
+		t.Errorf("pick() right after a write chose a replica, want the primary")
+	}
+	if len(s.writes) != 1 {
+		t.Errorf("writes = %v, want only alice's", s.writes)
+	}
+	if s.pick(bob) == nil {
+		t.Errorf("pick() for another actor chose the primary, want a replica")
+	}
+	now = now.Add(ReadYourWritesWindow)
+	if s.pick(alice) == nil {
+		t.Errorf("pick() after the window chose the primary, want a replica")
+	}
+
+	s.replicas[0].markDown(errors.New("gone"))
+	for i := 0; i < 3; i++ {
+		if rep := s.pick(bob); rep != s.replicas[1] {
+			t.Fatalf("pick() with one replica down = %v, want the other", rep)
+		}
+	}
+	s.replicas[1].markDown(errors.New("gone"))
+	if s.pick(bob) != nil {
+		t.Errorf("pick() with every replica down chose a replica, want the primary")
+	}
+}
+
+func TestReplica_CheckUnreachable(t *testing.T) {
+	db, err := sql.Open("mysql", "user:password@tcp(127.0.0.1:1)/userdb?timeout=100ms")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	s := newReplicaSet([]*sql.DB{db})
+	s.pick(context.Background())
+	deadline := time.Now().Add(5 * time.Second)
+	for s.replicas[0].healthy.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.replicas[0].healthy.Load() {
+		t.Errorf("unreachable replica is still in rotation")
+	}
+}
@@ -3,52 +3,104 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"userapi/models"
+
+	"github.com/go-sql-driver/mysql"
 )
 
+// mysqlErrDuplicateEntry is the MySQL error number for unique key violations
+const mysqlErrDuplicateEntry = 1062
+
+// maxRowsPerStatement bounds the number of rows in a single multi-row statement
+const maxRowsPerStatement = 500
+
+// queryFunc runs a query, like the QueryContext method of *sql.DB
+type queryFunc func(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+
+// execQuerier is satisfied by both *sql.DB and *sql.Tx
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
 type mysqlUserRepository struct {
-	db *sql.DB
+	// db is the primary, which takes every write
+	db       *sql.DB
+	replicas *replicaSet
 }
 
-// NewMySQLUserRepository creates a new MySQL user repository
-func NewMySQLUserRepository(db *sql.DB) UserRepository {
-	return &mysqlUserRepository{db: db}
+// NewMySQLUserRepository creates a new MySQL user repository. Lookups by ID, lists, exports
+// and searches are spread over the healthy replicas, everything else goes to the primary.
+func NewMySQLUserRepository(primary *sql.DB, replicas ...*sql.DB) UserRepository {
+	return &mysqlUserRepository{db: primary, replicas: newReplicaSet(replicas)}
 }
 
 func (r *mysqlUserRepository) Create(ctx context.Context, user *models.User) error {
-	query := `INSERT INTO users (name, age, phone_number, email) VALUES (?, ?, ?, ?)`
+	defer r.replicas.wrote(ctx)
+
+	query := `INSERT INTO users (name, age, phone_number, phone_number_raw, email, email_normalized) VALUES (?, ?, ?, ?, ?, ?)`
 	log.Printf("Creating user with email: %s", user.Email)
 
-	result, err := r.db.ExecContext(ctx, query, user.Name, user.Age, user.PhoneNumber, user.Email)
+	emailKey, err := normalizeUser(user)
 	if err != nil {
-		log.Printf("Error creating user: %v", err)
-		return fmt.Errorf("failed to create user: %w", err)
+		log.Printf("Error normalizing user: %v", err)
+		return fmt.Errorf("failed to normalize user: %w", err)
 	}
 
-	id, err := result.LastInsertId()
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, user.Name, user.Age, user.PhoneNumber, user.PhoneNumberRaw, user.Email, emailKey)
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", duplicateEmailError(err, user.Email))
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get last insert ID: %w", err)
+		}
+		user.ID = id
+
+		return recordChanges(ctx, tx, newRevision(ctx, models.RevisionCreate, nil, user))
+	})
 	if err != nil {
-		log.Printf("Error getting last insert ID: %v", err)
-		return fmt.Errorf("failed to get last insert ID: %w", err)
+		log.Printf("Error creating user: %v", err)
+		user.ID = 0
+		return err
 	}
 
-	user.ID = id
-	log.Printf("Successfully created user with ID: %d", id)
+	log.Printf("Successfully created user with ID: %d", user.ID)
 	return nil
 }
 
 func (r *mysqlUserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
-	query := `SELECT id, name, age, phone_number, email FROM users WHERE id = ?`
+	query := `SELECT id, name, age, phone_number, phone_number_raw, email FROM users WHERE id = ?`
 	log.Printf("Fetching user with ID: %d", id)
 
-	user := &models.User{}
-	err := r.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.Email)
-	if err == sql.ErrNoRows {
+	rows, err := r.queryRead(ctx, query, id)
+	if err != nil {
+		log.Printf("Error fetching user with ID %d: %v", id, err)
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			log.Printf("Error fetching user with ID %d: %v", id, err)
+			return nil, fmt.Errorf("failed to fetch user: %w", err)
+		}
 		log.Printf("User not found with ID: %d", id)
 		return nil, nil
 	}
-	if err != nil {
+	user := &models.User{}
+	if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.PhoneNumberRaw, &user.Email); err != nil {
 		log.Printf("Error fetching user with ID %d: %v", id, err)
 		return nil, fmt.Errorf("failed to fetch user: %w", err)
 	}
@@ -57,25 +109,122 @@ func (r *mysqlUserRepository) GetByID(ctx context.Context, id int64) (*models.Us
 	return user, nil
 }
 
-func (r *mysqlUserRepository) Update(ctx context.Context, user *models.User) error {
-	query := `UPDATE users SET name = ?, age = ?, phone_number = ?, email = ? WHERE id = ?`
-	log.Printf("Updating user with ID: %d", user.ID)
+func (r *mysqlUserRepository) GetByIDs(ctx context.Context, ids []int64) ([]*models.User, error) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	log.Printf("Fetching %d users by ID", len(ids))
+	return r.getByColumn(ctx, r.queryRead, "id", args)
+}
 
-	result, err := r.db.ExecContext(ctx, query, user.Name, user.Age, user.PhoneNumber, user.Email, user.ID)
+func (r *mysqlUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	query := `SELECT id, name, age, phone_number, phone_number_raw, email FROM users WHERE email_normalized = ?`
+	log.Printf("Fetching user with email: %s", email)
+
+	emailKey, err := models.NormalizeEmail(email)
 	if err != nil {
-		log.Printf("Error updating user with ID %d: %v", user.ID, err)
-		return fmt.Errorf("failed to update user: %w", err)
+		log.Printf("User not found with unparseable email %q: %v", email, err)
+		return nil, nil
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	user := &models.User{}
+	err = r.db.QueryRowContext(ctx, query, emailKey).Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.PhoneNumberRaw, &user.Email)
+	if err == sql.ErrNoRows {
+		log.Printf("User not found with email: %s", emailKey)
+		return nil, nil
+	}
 	if err != nil {
-		log.Printf("Error getting rows affected for user update ID %d: %v", user.ID, err)
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		log.Printf("Error fetching user with email %s: %v", emailKey, err)
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	log.Printf("Successfully fetched user with email: %s", emailKey)
+	return user, nil
+}
+
+func (r *mysqlUserRepository) GetByEmails(ctx context.Context, emails []string) ([]*models.User, error) {
+	args := make([]interface{}, 0, len(emails))
+	for _, email := range emails {
+		// emails that cannot be normalized cannot belong to any user
+		if emailKey, err := models.NormalizeEmail(email); err == nil {
+			args = append(args, emailKey)
+		}
 	}
+	log.Printf("Fetching %d users by email", len(emails))
+	return r.getByColumn(ctx, r.db.QueryContext, "email_normalized", args)
+}
 
-	if rowsAffected == 0 {
-		log.Printf("No user found to update with ID: %d", user.ID)
-		return fmt.Errorf("user not found with ID: %d", user.ID)
+// getByColumn fetches the users whose column matches any of values with a single IN query,
+// run by query
+func (r *mysqlUserRepository) getByColumn(ctx context.Context, query queryFunc, column string, values []interface{}) ([]*models.User, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	statement := `SELECT id, name, age, phone_number, phone_number_raw, email FROM users WHERE ` + column + ` IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ") + `)`
+
+	rows, err := query(ctx, statement, values...)
+	if err != nil {
+		log.Printf("Error fetching users by %s: %v", column, err)
+		return nil, fmt.Errorf("failed to fetch users: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	users := make([]*models.User, 0, len(values))
+	for rows.Next() {
+		user := &models.User{}
+		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.PhoneNumberRaw, &user.Email); err != nil {
+			log.Printf("Error scanning user row: %v", err)
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating user rows: %v", err)
+		return nil, fmt.Errorf("failed to iterate users: %w", err)
+	}
+
+	log.Printf("Successfully fetched %d of %d users by %s", len(users), len(values), column)
+	return users, nil
+}
+
+func (r *mysqlUserRepository) Update(ctx context.Context, user *models.User) error {
+	defer r.replicas.wrote(ctx)
+
+	query := `UPDATE users SET name = ?, age = ?, phone_number = ?, phone_number_raw = ?, email = ?, email_normalized = ? WHERE id = ?`
+	log.Printf("Updating user with ID: %d", user.ID)
+
+	emailKey, err := normalizeUser(user)
+	if err != nil {
+		log.Printf("Error normalizing user with ID %d: %v", user.ID, err)
+		return fmt.Errorf("failed to normalize user: %w", err)
+	}
+
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		// The current row is needed for the revision and locking it keeps concurrent
+		// updates from interleaving between reading and writing it
+		before, err := lockUsers(ctx, tx, "id", []interface{}{user.ID})
+		if err != nil {
+			return err
+		}
+		if len(before) == 0 {
+			return fmt.Errorf("%w with ID: %d", ErrUserNotFound, user.ID)
+		}
+
+		if _, err := tx.ExecContext(ctx, query, user.Name, user.Age, user.PhoneNumber, user.PhoneNumberRaw, user.Email, emailKey, user.ID); err != nil {
+			return fmt.Errorf("failed to update user: %w", duplicateEmailError(err, user.Email))
+		}
+
+		return recordChanges(ctx, tx, newRevision(ctx, models.RevisionUpdate, before[0], user))
+	})
+	if err != nil {
+		log.Printf("Error updating user with ID %d: %v", user.ID, err)
+		return err
 	}
 
 	log.Printf("Successfully updated user with ID: %d", user.ID)
@@ -83,35 +232,41 @@ func (r *mysqlUserRepository) Update(ctx context.Context, user *models.User) err
 }
 
 func (r *mysqlUserRepository) Delete(ctx context.Context, id int64) error {
+	defer r.replicas.wrote(ctx)
+
 	query := `DELETE FROM users WHERE id = ?`
 	log.Printf("Deleting user with ID: %d", id)
 
-	result, err := r.db.ExecContext(ctx, query, id)
-	if err != nil {
-		log.Printf("Error deleting user with ID %d: %v", id, err)
-		return fmt.Errorf("failed to delete user: %w", err)
-	}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := lockUsers(ctx, tx, "id", []interface{}{id})
+		if err != nil {
+			return err
+		}
+		if len(before) == 0 {
+			return fmt.Errorf("%w with ID: %d", ErrUserNotFound, id)
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("Error getting rows affected for user deletion ID %d: %v", id, err)
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
 
-	if rowsAffected == 0 {
-		log.Printf("No user found to delete with ID: %d", id)
-		return fmt.Errorf("user not found with ID: %d", id)
+		return recordChanges(ctx, tx, newRevision(ctx, models.RevisionDelete, before[0], nil))
+	})
+	if err != nil {
+		log.Printf("Error deleting user with ID %d: %v", id, err)
+		return err
 	}
 
 	log.Printf("Successfully deleted user with ID: %d", id)
 	return nil
 }
 
-func (r *mysqlUserRepository) List(ctx context.Context) ([]*models.User, error) {
-	query := `SELECT id, name, age, phone_number, email FROM users`
-	log.Println("Fetching all users")
+func (r *mysqlUserRepository) List(ctx context.Context, filter UserFilter) ([]*models.User, error) {
+	where, args := filterClause(filter)
+	query := `SELECT id, name, age, phone_number, phone_number_raw, email FROM users` + where + ` ORDER BY id` + limitClause(filter)
+	log.Printf("Fetching users matching %+v", filter)
 
-	rows, err := r.db.QueryContext(ctx, query)
+	rows, err := r.queryRead(ctx, query, args...)
 	if err != nil {
 		log.Printf("Error fetching users: %v", err)
 		return nil, fmt.Errorf("failed to fetch users: %w", err)
@@ -125,7 +280,7 @@ func (r *mysqlUserRepository) List(ctx context.Context) ([]*models.User, error)
 	var users []*models.User
 	for rows.Next() {
 		user := &models.User{}
-		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.Email); err != nil {
+		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.PhoneNumberRaw, &user.Email); err != nil {
 			log.Printf("Error scanning user row: %v", err)
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
@@ -139,4 +294,591 @@ func (r *mysqlUserRepository) List(ctx context.Context) ([]*models.User, error)
 
 	log.Printf("Successfully fetched %d users", len(users))
 	return users, nil
-} 
\ No newline at end of file
+}
+
+func (r *mysqlUserRepository) Stream(ctx context.Context, filter UserFilter, fn func(*models.User) error) error {
+	where, args := filterClause(filter)
+	query := `SELECT id, name, age, phone_number, phone_number_raw, email FROM users` + where + ` ORDER BY id` + limitClause(filter)
+	log.Printf("Streaming users matching %+v", filter)
+
+	// A read-only REPEATABLE READ transaction reads every row from the same snapshot,
+	// no matter how long the caller takes to consume them
+	tx, err := r.beginRead(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		log.Printf("Error starting snapshot transaction: %v", err)
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Error ending snapshot transaction: %v", err)
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error streaming users: %v", err)
+		return fmt.Errorf("failed to fetch users: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var count int64
+	for rows.Next() {
+		user := &models.User{}
+		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.PhoneNumberRaw, &user.Email); err != nil {
+			log.Printf("Error scanning user row: %v", err)
+			return fmt.Errorf("failed to scan user: %w", err)
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating user rows: %v", err)
+		return fmt.Errorf("failed to iterate users: %w", err)
+	}
+
+	log.Printf("Successfully streamed %d users", count)
+	return nil
+}
+
+// filterClause builds the WHERE clause for filter, including the leading keyword
+func filterClause(filter UserFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Name != "" {
+		conditions = append(conditions, `name LIKE ?`)
+		args = append(args, "%"+likeEscaper.Replace(filter.Name)+"%")
+	}
+	if filter.Email != "" {
+		emailKey, err := models.NormalizeEmail(filter.Email)
+		if err != nil {
+			emailKey = strings.ToLower(strings.TrimSpace(filter.Email))
+		}
+		conditions = append(conditions, `email_normalized = ?`)
+		args = append(args, emailKey)
+	}
+	if filter.Phone != "" {
+		phone, err := models.NormalizePhoneNumber(filter.Phone, models.DefaultPhoneRegion)
+		if err != nil {
+			phone = strings.TrimSpace(filter.Phone)
+		}
+		conditions = append(conditions, `phone_number = ?`)
+		args = append(args, phone)
+	}
+	if filter.MinAge > 0 {
+		conditions = append(conditions, `age >= ?`)
+		args = append(args, filter.MinAge)
+	}
+	if filter.MaxAge > 0 {
+		conditions = append(conditions, `age <= ?`)
+		args = append(args, filter.MaxAge)
+	}
+	if filter.AfterID > 0 {
+		conditions = append(conditions, `id > ?`)
+		args = append(args, filter.AfterID)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return ` WHERE ` + strings.Join(conditions, " AND "), args
+}
+
+func limitClause(filter UserFilter) string {
+	if filter.Limit <= 0 {
+		return ""
+	}
+	return ` LIMIT ` + strconv.Itoa(filter.Limit)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// normalizeUser normalizes user in place and returns the value of its email_normalized column
+func normalizeUser(user *models.User) (string, error) {
+	if err := user.Normalize(); err != nil {
+		return "", err
+	}
+	return models.NormalizeEmail(user.Email)
+}
+
+// duplicateEmailError turns unique key violations into ErrEmailTaken. The only unique key
+// besides the primary key is the one on email_normalized.
+func duplicateEmailError(err error, email string) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+		return fmt.Errorf("%w: %s", ErrEmailTaken, email)
+	}
+	return err
+}
+
+// inTx runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise
+func (r *mysqlUserRepository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("Error rolling back transaction: %v", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// lockUsers fetches the users whose column matches any of values and, inside a transaction,
+// locks their rows until it ends
+func lockUsers(ctx context.Context, ex execQuerier, column string, values []interface{}) ([]*models.User, error) {
+	query := `SELECT id, name, age, phone_number, phone_number_raw, email FROM users WHERE ` + column +
+		` IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ") + `) FOR UPDATE`
+
+	rows, err := ex.QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user := &models.User{}
+		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.PhoneNumberRaw, &user.Email); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to lock users: %w", err)
+	}
+	return users, nil
+}
+
+func (r *mysqlUserRepository) Batch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	defer r.replicas.wrote(ctx)
+
+	log.Printf("Running batch of %d operations (atomic: %t)", len(ops), atomic)
+	results := make([]BatchResult, len(ops))
+
+	if !atomic {
+		r.runBatch(ctx, nil, ops, results)
+		log.Printf("Successfully ran batch of %d operations", len(ops))
+		return results, nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting batch transaction: %v", err)
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	if !r.runBatch(ctx, tx, ops, results) {
+		if err := tx.Rollback(); err != nil {
+			log.Printf("Error rolling back batch transaction: %v", err)
+		}
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = BatchResult{Err: ErrBatchAborted}
+			}
+		}
+		log.Printf("Rolled back atomic batch of %d operations", len(ops))
+		return results, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing batch transaction: %v", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("Successfully committed batch of %d operations", len(ops))
+	return results, nil
+}
+
+// runBatch applies consecutive operations of the same type together and reports whether
+// every operation succeeded. With a transaction, as in atomic mode, it returns at the first
+// failure. Without one, each group of operations runs in a transaction of its own, so that
+// its users and revisions are written together.
+func (r *mysqlUserRepository) runBatch(ctx context.Context, tx *sql.Tx, ops []BatchOperation, results []BatchResult) bool {
+	stopOnError := tx != nil
+	ok := true
+	emailKeys := make([]string, len(ops))
+	for i, op := range ops {
+		if op.User == nil {
+			continue
+		}
+		emailKey, err := normalizeUser(op.User)
+		if err != nil {
+			log.Printf("Error normalizing user in batch: %v", err)
+			results[i].Err = fmt.Errorf("failed to normalize user: %w", err)
+			if stopOnError {
+				return false
+			}
+			ok = false
+		}
+		emailKeys[i] = emailKey
+	}
+
+	for start := 0; start < len(ops); {
+		if results[start].Err != nil {
+			start++
+			continue
+		}
+		end := start + 1
+		for end < len(ops) && end-start < maxRowsPerStatement && ops[end].Type == ops[start].Type && results[end].Err == nil {
+			end++
+		}
+
+		var runOK bool
+		var err error
+		if tx != nil {
+			runOK, err = r.runGroup(ctx, tx, ops[start:end], emailKeys[start:end], results[start:end], stopOnError)
+		} else {
+			err = r.inTx(ctx, func(groupTx *sql.Tx) error {
+				var groupErr error
+				runOK, groupErr = r.runGroup(ctx, groupTx, ops[start:end], emailKeys[start:end], results[start:end], stopOnError)
+				return groupErr
+			})
+		}
+		if err != nil {
+			// nothing of the group was written
+			log.Printf("Error running batch operations %d to %d: %v", start, end-1, err)
+			for i := start; i < end; i++ {
+				results[i].Err = err
+			}
+			runOK = false
+		}
+
+		if !runOK {
+			ok = false
+			if stopOnError {
+				return false
+			}
+		}
+		start = end
+	}
+	return ok
+}
+
+// runGroup applies operations of the same type and records a revision for each one that
+// succeeded. It reports whether all of them succeeded; an error means the revisions could
+// not be recorded and the transaction has to be rolled back.
+func (r *mysqlUserRepository) runGroup(ctx context.Context, ex execQuerier, ops []BatchOperation, emailKeys []string, results []BatchResult, stopOnError bool) (bool, error) {
+	current, err := r.lockBatchTargets(ctx, ex, ops, emailKeys)
+	if err != nil {
+		return false, err
+	}
+
+	var ok bool
+	switch ops[0].Type {
+	case BatchCreate:
+		ok = r.batchCreate(ctx, ex, ops, emailKeys, results, stopOnError)
+	case BatchUpdate:
+		ok = r.batchUpdate(ctx, ex, ops, emailKeys, current, results, stopOnError)
+	case BatchDelete:
+		ok = r.batchDelete(ctx, ex, ops, results)
+	case BatchUpsert:
+		ok = r.batchUpsert(ctx, ex, ops, emailKeys, results, stopOnError)
+	default:
+		for i := range ops {
+			results[i].Err = fmt.Errorf("unknown batch operation: %q", ops[i].Type)
+		}
+		return false, nil
+	}
+
+	// current tracks each user through the group, so that a user changed twice gets
+	// two revisions that follow each other
+	revisions := make([]*models.UserRevision, 0, len(ops))
+	for i, op := range ops {
+		if results[i].Err != nil {
+			continue
+		}
+		key := strconv.FormatInt(op.ID, 10)
+		if op.Type == BatchUpsert {
+			key = emailKeys[i]
+		}
+		before := current[key]
+
+		var rev *models.UserRevision
+		switch {
+		case op.Type == BatchDelete:
+			rev = newRevision(ctx, models.RevisionDelete, before, nil)
+			current[key] = nil
+		case before == nil:
+			rev = newRevision(ctx, models.RevisionCreate, nil, op.User)
+			current[key] = op.User
+		default:
+			rev = newRevision(ctx, models.RevisionUpdate, before, op.User)
+			current[key] = op.User
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := recordChanges(ctx, ex, revisions...); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// lockBatchTargets fetches and locks the users that a group of updates, deletes or upserts
+// is about to change. They are keyed by ID, or by normalized email for upserts.
+func (r *mysqlUserRepository) lockBatchTargets(ctx context.Context, ex execQuerier, ops []BatchOperation, emailKeys []string) (map[string]*models.User, error) {
+	current := make(map[string]*models.User, len(ops))
+	column := "id"
+	values := make([]interface{}, len(ops))
+	switch ops[0].Type {
+	case BatchUpdate, BatchDelete:
+		for i, op := range ops {
+			values[i] = op.ID
+		}
+	case BatchUpsert:
+		column = "email_normalized"
+		for i := range ops {
+			values[i] = emailKeys[i]
+		}
+	default:
+		return current, nil
+	}
+
+	users, err := lockUsers(ctx, ex, column, values)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if column == "id" {
+			current[strconv.FormatInt(user.ID, 10)] = user
+		} else if emailKey, err := models.NormalizeEmail(user.Email); err == nil {
+			current[emailKey] = user
+		}
+	}
+	return current, nil
+}
+
+// batchCreate inserts users with one multi-row INSERT. The rows of one insert need not get
+// consecutive IDs, with innodb_autoinc_lock_mode=2 or an auto_increment_increment above 1,
+// so the IDs are looked up by email afterwards like in batchUpsert. If the statement fails,
+// the rows are retried one by one to find out which of them caused it.
+func (r *mysqlUserRepository) batchCreate(ctx context.Context, ex execQuerier, ops []BatchOperation, emailKeys []string, results []BatchResult, stopOnError bool) bool {
+	placeholders := make([]string, len(ops))
+	args := make([]interface{}, 0, len(ops)*6)
+	emails := make([]interface{}, len(ops))
+	for i, op := range ops {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?)"
+		args = append(args, op.User.Name, op.User.Age, op.User.PhoneNumber, op.User.PhoneNumberRaw, op.User.Email, emailKeys[i])
+		emails[i] = emailKeys[i]
+	}
+	query := `INSERT INTO users (name, age, phone_number, phone_number_raw, email, email_normalized) VALUES ` + strings.Join(placeholders, ", ")
+
+	_, err := ex.ExecContext(ctx, query, args...)
+	if err == nil {
+		var ids map[string]int64
+		ids, err = r.idsByEmail(ctx, ex, emails)
+		if err == nil {
+			ok := true
+			for i, op := range ops {
+				op.User.ID = ids[emailKeys[i]]
+				results[i] = BatchResult{ID: op.User.ID}
+				if op.User.ID == 0 {
+					results[i].Err = fmt.Errorf("failed to find created user with email %s", op.User.Email)
+					log.Printf("Error creating user in batch: %v", results[i].Err)
+					ok = false
+				}
+			}
+			if !ok {
+				return false
+			}
+			log.Printf("Successfully created %d users in batch", len(ops))
+			return true
+		}
+	}
+	if len(ops) == 1 {
+		log.Printf("Error creating user in batch: %v", err)
+		results[0].Err = fmt.Errorf("failed to create user: %w", duplicateEmailError(err, ops[0].User.Email))
+		return false
+	}
+
+	log.Printf("Multi-row insert of %d users failed, retrying individually: %v", len(ops), err)
+	ok := true
+	for i := range ops {
+		if !r.batchCreate(ctx, ex, ops[i:i+1], emailKeys[i:i+1], results[i:i+1], stopOnError) {
+			ok = false
+			if stopOnError {
+				return false
+			}
+		}
+	}
+	return ok
+}
+
+// batchUpsert inserts users with one multi-row INSERT that updates existing users with the
+// same normalized email instead. The resulting IDs are looked up by email afterwards, since
+// MySQL only reports the first inserted ID.
+func (r *mysqlUserRepository) batchUpsert(ctx context.Context, ex execQuerier, ops []BatchOperation, emailKeys []string, results []BatchResult, stopOnError bool) bool {
+	placeholders := make([]string, len(ops))
+	args := make([]interface{}, 0, len(ops)*6)
+	emails := make([]interface{}, len(ops))
+	for i, op := range ops {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?)"
+		args = append(args, op.User.Name, op.User.Age, op.User.PhoneNumber, op.User.PhoneNumberRaw, op.User.Email, emailKeys[i])
+		emails[i] = emailKeys[i]
+	}
+	query := `INSERT INTO users (name, age, phone_number, phone_number_raw, email, email_normalized) VALUES ` + strings.Join(placeholders, ", ") +
+		` ON DUPLICATE KEY UPDATE name = VALUES(name), age = VALUES(age), phone_number = VALUES(phone_number),` +
+		` phone_number_raw = VALUES(phone_number_raw), email = VALUES(email)`
+
+	_, err := ex.ExecContext(ctx, query, args...)
+	if err == nil {
+		var ids map[string]int64
+		ids, err = r.idsByEmail(ctx, ex, emails)
+		if err == nil {
+			for i, op := range ops {
+				op.User.ID = ids[emailKeys[i]]
+				results[i] = BatchResult{ID: op.User.ID}
+			}
+			log.Printf("Successfully upserted %d users in batch", len(ops))
+			return true
+		}
+	}
+	if len(ops) == 1 {
+		log.Printf("Error upserting user in batch: %v", err)
+		results[0].Err = fmt.Errorf("failed to upsert user: %w", err)
+		return false
+	}
+
+	log.Printf("Multi-row upsert of %d users failed, retrying individually: %v", len(ops), err)
+	ok := true
+	for i := range ops {
+		if !r.batchUpsert(ctx, ex, ops[i:i+1], emailKeys[i:i+1], results[i:i+1], stopOnError) {
+			ok = false
+			if stopOnError {
+				return false
+			}
+		}
+	}
+	return ok
+}
+
+// idsByEmail maps normalized emails to the IDs of their users
+func (r *mysqlUserRepository) idsByEmail(ctx context.Context, ex execQuerier, emails []interface{}) (map[string]int64, error) {
+	query := `SELECT id, email_normalized FROM users WHERE email_normalized IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(emails)), ", ") + `)`
+	rows, err := ex.QueryContext(ctx, query, emails...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user IDs: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int64, len(emails))
+	for rows.Next() {
+		var id int64
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return nil, fmt.Errorf("failed to scan user ID: %w", err)
+		}
+		ids[email] = id
+	}
+	return ids, rows.Err()
+}
+
+// batchUpdate updates users one by one. Whether a user exists is decided by the rows
+// lockBatchTargets locked rather than by the affected row count, which MySQL reports as 0
+// for an update that sets the values the row already has.
+func (r *mysqlUserRepository) batchUpdate(ctx context.Context, ex execQuerier, ops []BatchOperation, emailKeys []string, current map[string]*models.User, results []BatchResult, stopOnError bool) bool {
+	query := `UPDATE users SET name = ?, age = ?, phone_number = ?, phone_number_raw = ?, email = ?, email_normalized = ? WHERE id = ?`
+	ok := true
+	for i, op := range ops {
+		op.User.ID = op.ID
+		results[i].ID = op.ID
+
+		var err error
+		if current[strconv.FormatInt(op.ID, 10)] == nil {
+			err = fmt.Errorf("%w with ID: %d", ErrUserNotFound, op.ID)
+		} else {
+			_, err = ex.ExecContext(ctx, query, op.User.Name, op.User.Age, op.User.PhoneNumber, op.User.PhoneNumberRaw, op.User.Email, emailKeys[i], op.ID)
+			err = duplicateEmailError(err, op.User.Email)
+		}
+		if err != nil {
+			log.Printf("Error updating user with ID %d in batch: %v", op.ID, err)
+			results[i].Err = err
+			ok = false
+			if stopOnError {
+				return false
+			}
+		}
+	}
+	return ok
+}
+
+// batchDelete removes users with one multi-row DELETE after looking up which of them exist,
+// so that missing users can be reported per operation.
+func (r *mysqlUserRepository) batchDelete(ctx context.Context, ex execQuerier, ops []BatchOperation, results []BatchResult) bool {
+	placeholders := make([]string, len(ops))
+	args := make([]interface{}, len(ops))
+	for i, op := range ops {
+		placeholders[i] = "?"
+		args[i] = op.ID
+		results[i].ID = op.ID
+	}
+	in := strings.Join(placeholders, ", ")
+
+	// Inside a transaction this also locks the rows until the deletion commits
+	rows, err := ex.QueryContext(ctx, `SELECT id FROM users WHERE id IN (`+in+`) FOR UPDATE`, args...)
+	if err != nil {
+		log.Printf("Error looking up users to delete in batch: %v", err)
+		for i := range results {
+			results[i].Err = fmt.Errorf("failed to delete user: %w", err)
+		}
+		return false
+	}
+	existing := make(map[int64]bool, len(ops))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Printf("Error scanning user ID in batch: %v", err)
+			for i := range results {
+				results[i].Err = fmt.Errorf("failed to delete user: %w", err)
+			}
+			return false
+		}
+		existing[id] = true
+	}
+	rows.Close()
+
+	ok := true
+	var toDelete []interface{}
+	for i, op := range ops {
+		if !existing[op.ID] {
+			results[i].Err = fmt.Errorf("%w with ID: %d", ErrUserNotFound, op.ID)
+			ok = false
+			continue
+		}
+		// A user listed twice only counts as deleted once
+		existing[op.ID] = false
+		toDelete = append(toDelete, op.ID)
+	}
+	if len(toDelete) == 0 {
+		return ok
+	}
+
+	query := `DELETE FROM users WHERE id IN (` + strings.TrimSuffix(strings.Repeat("?, ", len(toDelete)), ", ") + `)`
+	if _, err := ex.ExecContext(ctx, query, toDelete...); err != nil {
+		log.Printf("Error deleting users in batch: %v", err)
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = fmt.Errorf("failed to delete user: %w", err)
+			}
+		}
+		return false
+	}
+
+	log.Printf("Successfully deleted %d users in batch", len(toDelete))
+	return ok
+}
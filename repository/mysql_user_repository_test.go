@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"userapi/models"
+)
+
+// fakeUsersTable answers the statements batchCreate makes, assigning IDs the way MySQL does
+// with an auto_increment_increment of increment. With interleave, every other row of an
+// insert loses an ID to a concurrent insert, as innodb_autoinc_lock_mode=2 allows.
+func fakeUsersTable(increment int64, interleave bool) *fakeSQL {
+	ids := map[string]int64{}
+	next := int64(1)
+	return &fakeSQL{
+		exec: func(query string, args []driver.Value) (driver.Result, error) {
+			if !strings.HasPrefix(query, "INSERT INTO users") {
+				return nil, fmt.Errorf("unexpected statement %s", query)
+			}
+			var first int64
+			for i := 0; i < len(args); i += 6 {
+				if interleave && i%12 == 6 {
+					next += increment
+				}
+				if first == 0 {
+					first = next
+				}
+				ids[args[i+5].(string)] = next
+				next += increment
+			}
+			return fakeResult{lastInsertID: first, rowsAffected: int64(len(args) / 6)}, nil
+		},
+		query: func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+			if !strings.HasPrefix(query, "SELECT id, email_normalized FROM users") {
+				return nil, nil, fmt.Errorf("unexpected query %s", query)
+			}
+			var rows [][]driver.Value
+			for _, arg := range args {
+				if id, ok := ids[arg.(string)]; ok {
+					rows = append(rows, []driver.Value{id, arg})
+				}
+			}
+			return []string{"id", "email_normalized"}, rows, nil
+		},
+	}
+}
+
+func TestMySQLUserRepository_BatchCreateIDs(t *testing.T) {
+	tests := []struct {
+		name       string
+		increment  int64
+		interleave bool
+		wantIDs    []int64
+	}{
+		{name: "consecutive IDs", increment: 1, wantIDs: []int64{1, 2, 3}},
+		{name: "auto_increment_increment of 2", increment: 2, wantIDs: []int64{1, 3, 5}},
+		{name: "IDs taken by a concurrent insert", increment: 1, interleave: true, wantIDs: []int64{1, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openFakeSQL(t, fakeUsersTable(tt.increment, tt.interleave))
+			r := &mysqlUserRepository{db: db}
+
+			ops := make([]BatchOperation, len(tt.wantIDs))
+			emailKeys := make([]string, len(ops))
+			for i := range ops {
+				email := fmt.Sprintf("user%d@example.com", i)
+				ops[i] = BatchOperation{Type: BatchCreate, User: &models.User{Name: "User", Age: 30, PhoneNumber: "+14155552671", Email: email}}
+				emailKeys[i] = email
+			}
+			results := make([]BatchResult, len(ops))
+
+			if !r.batchCreate(context.Background(), db, ops, emailKeys, results, true) {
+				t.Fatalf("batchCreate() failed: %+v", results)
+			}
+			for i, want := range tt.wantIDs {
+				if results[i].ID != want || ops[i].User.ID != want {
+					t.Errorf("user %d got ID %d (result %d), want %d", i, ops[i].User.ID, results[i].ID, want)
+				}
+			}
+		})
+	}
+}
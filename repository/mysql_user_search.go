@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"userapi/models"
+)
+
+// minFulltextTermLength is InnoDB's default innodb_ft_min_token_size. Shorter terms are not
+// in the FULLTEXT index, so requiring them would never match.
+const minFulltextTermLength = 3
+
+// Search uses the FULLTEXT index on name, email and phone_number_raw, requiring every term
+// as a prefix and ranking by relevance. Queries that look like phone numbers are matched
+// against the digits of the E.164 number instead, which FULLTEXT cannot search inside.
+func (r *mysqlUserRepository) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	var sqlQuery string
+	var args []interface{}
+	if isPhoneQuery(query.Text) {
+		digits := phoneSearchDigits(query.Text)
+		// numbers that end with the digits are most likely what was typed, without
+		// country code
+		sqlQuery = `SELECT id, name, age, phone_number, phone_number_raw, email,
+			IF(phone_number LIKE ?, 1, 0.8) AS score
+			FROM users WHERE phone_number LIKE ?
+			ORDER BY score DESC, id`
+		args = []interface{}{"%" + digits, "%" + digits + "%"}
+	} else {
+		var terms []string
+		for _, term := range searchTokens(query.Text) {
+			if len([]rune(term)) >= minFulltextTermLength {
+				terms = append(terms, "+"+term+"*")
+			}
+		}
+		if len(terms) == 0 {
+			log.Printf("Search for %q has no searchable terms", query.Text)
+			return []SearchResult{}, nil
+		}
+		expr := strings.Join(terms, " ")
+		sqlQuery = `SELECT id, name, age, phone_number, phone_number_raw, email,
+			MATCH(name, email, phone_number_raw) AGAINST (? IN BOOLEAN MODE) AS score
+			FROM users WHERE MATCH(name, email, phone_number_raw) AGAINST (? IN BOOLEAN MODE)
+			ORDER BY score DESC, id`
+		args = []interface{}{expr, expr}
+	}
+
+	switch {
+	case query.Limit > 0:
+		sqlQuery += ` LIMIT ? OFFSET ?`
+		args = append(args, query.Limit, query.Offset)
+	case query.Offset > 0:
+		// MySQL has no OFFSET without LIMIT, this is the documented way around it
+		sqlQuery += ` LIMIT 18446744073709551615 OFFSET ?`
+		args = append(args, query.Offset)
+	}
+	log.Printf("Searching users for %q", query.Text)
+
+	rows, err := r.queryRead(ctx, sqlQuery, args...)
+	if err != nil {
+		log.Printf("Error searching users: %v", err)
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		user := &models.User{}
+		var score float64
+		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.PhoneNumber, &user.PhoneNumberRaw, &user.Email, &score); err != nil {
+			log.Printf("Error scanning user row: %v", err)
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		results = append(results, SearchResult{User: user, Score: score})
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating user rows: %v", err)
+		return nil, fmt.Errorf("failed to iterate users: %w", err)
+	}
+
+	log.Printf("Search for %q found %d users", query.Text, len(results))
+	return results, nil
+}
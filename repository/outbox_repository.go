@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"userapi/models"
+)
+
+// OutboxRepository gives access to the outbox of user events. Every create, update and
+// delete of a UserRepository adds its event to the outbox in the same transaction as the
+// change, so an event exists if and only if the change was committed.
+type OutboxRepository interface {
+	// PendingEvents returns up to limit events that have not been published yet, oldest first
+	PendingEvents(ctx context.Context, limit int) ([]*models.UserEvent, error)
+	// MarkPublished records that the events have been delivered to every sink and sets their
+	// Seq to the next positions of the published stream, in the order given
+	MarkPublished(ctx context.Context, events ...*models.UserEvent) error
+	// MarkFailed records a failed attempt to publish an event, which stays pending
+	MarkFailed(ctx context.Context, id int64, cause error) error
+	// PublishedEvents returns up to limit published events with a Seq above afterSeq, in the
+	// order they were published, so that subscribers can catch up on what they missed
+	PublishedEvents(ctx context.Context, afterSeq int64, limit int) ([]*models.UserEvent, error)
+}
+
+// eventsFromRevisions returns the events announcing the changes recorded by revisions,
+// skipping the nil revisions of updates that changed nothing
+func eventsFromRevisions(revisions []*models.UserRevision) []*models.UserEvent {
+	events := make([]*models.UserEvent, 0, len(revisions))
+	for _, rev := range revisions {
+		if rev != nil {
+			events = append(events, models.EventFromRevision(rev))
+		}
+	}
+	return events
+}
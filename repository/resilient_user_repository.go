@@ -0,0 +1,398 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+	"userapi/models"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrCircuitOpen is returned without calling the database while it is considered down.
+// The error is a *CircuitOpenError, which tells when to try again.
+var ErrCircuitOpen = errors.New("database unavailable")
+
+// CircuitOpenError is returned while the circuit breaker is open
+type CircuitOpenError struct {
+	// RetryAfter is how long until the breaker lets a call through again
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v, retry in %v", ErrCircuitOpen, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// ResilienceOptions tune the resilient repository
+type ResilienceOptions struct {
+	// MaxAttempts bounds the attempts at an idempotent operation that fails transiently
+	MaxAttempts int
+	// BaseBackoff is the longest wait before the first retry; it doubles with every further one
+	BaseBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	// ReadTimeout and WriteTimeout bound an operation, including its retries
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// FailureThreshold is the number of consecutive failed calls that opens the breaker
+	FailureThreshold int
+	// OpenFor is how long the breaker stays open before it lets a trial call through
+	OpenFor time.Duration
+}
+
+// DefaultResilienceOptions give up well before the server's 15 second write timeout
+var DefaultResilienceOptions = ResilienceOptions{
+	MaxAttempts:      3,
+	BaseBackoff:      50 * time.Millisecond,
+	MaxBackoff:       time.Second,
+	ReadTimeout:      3 * time.Second,
+	WriteTimeout:     5 * time.Second,
+	FailureThreshold: 5,
+	OpenFor:          10 * time.Second,
+}
+
+// ResilientUserRepository guards a UserRepository backed by a database. Every operation gets
+// a deadline, reads are retried with jittered backoff when they fail in a way that is known
+// to leave nothing applied, and after FailureThreshold failures in a row a circuit breaker
+// fails every call with a *CircuitOpenError for OpenFor, after which a single trial call
+// decides whether it closes. Writes are not retried, as they may have been applied before the
+// failure, and updates also add revisions and outbox events that a retry would repeat.
+// Streams have no deadline, as they take as long as their consumer.
+type ResilientUserRepository struct {
+	UserRepository
+	opts    ResilienceOptions
+	breaker *circuitBreaker
+}
+
+var _ HistoryRepository = (*ResilientUserRepository)(nil)
+
+// NewResilientUserRepository wraps repo
+func NewResilientUserRepository(repo UserRepository, opts ResilienceOptions) *ResilientUserRepository {
+	return &ResilientUserRepository{
+		UserRepository: repo,
+		opts:           opts,
+		breaker:        &circuitBreaker{threshold: opts.FailureThreshold, openFor: opts.OpenFor, now: time.Now},
+	}
+}
+
+func (r *ResilientUserRepository) Create(ctx context.Context, user *models.User) error {
+	return r.call(ctx, false, r.opts.WriteTimeout, func(ctx context.Context) error {
+		return r.UserRepository.Create(ctx, user)
+	})
+}
+
+func (r *ResilientUserRepository) GetByID(ctx context.Context, id int64) (user *models.User, err error) {
+	err = r.call(ctx, true, r.opts.ReadTimeout, func(ctx context.Context) error {
+		user, err = r.UserRepository.GetByID(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (r *ResilientUserRepository) GetByIDs(ctx context.Context, ids []int64) (users []*models.User, err error) {
+	err = r.call(ctx, true, r.opts.ReadTimeout, func(ctx context.Context) error {
+		users, err = r.UserRepository.GetByIDs(ctx, ids)
+		return err
+	})
+	return users, err
+}
+
+func (r *ResilientUserRepository) GetByEmail(ctx context.Context, email string) (user *models.User, err error) {
+	err = r.call(ctx, true, r.opts.ReadTimeout, func(ctx context.Context) error {
+		user, err = r.UserRepository.GetByEmail(ctx, email)
+		return err
+	})
+	return user, err
+}
+
+func (r *ResilientUserRepository) GetByEmails(ctx context.Context, emails []string) (users []*models.User, err error) {
+	err = r.call(ctx, true, r.opts.ReadTimeout, func(ctx context.Context) error {
+		users, err = r.UserRepository.GetByEmails(ctx, emails)
+		return err
+	})
+	return users, err
+}
+
+func (r *ResilientUserRepository) Update(ctx context.Context, user *models.User) error {
+	return r.call(ctx, false, r.opts.WriteTimeout, func(ctx context.Context) error {
+		return r.UserRepository.Update(ctx, user)
+	})
+}
+
+func (r *ResilientUserRepository) Delete(ctx context.Context, id int64) error {
+	return r.call(ctx, false, r.opts.WriteTimeout, func(ctx context.Context) error {
+		return r.UserRepository.Delete(ctx, id)
+	})
+}
+
+func (r *ResilientUserRepository) List(ctx context.Context, filter UserFilter) (users []*models.User, err error) {
+	err = r.call(ctx, true, r.opts.ReadTimeout, func(ctx context.Context) error {
+		users, err = r.UserRepository.List(ctx, filter)
+		return err
+	})
+	return users, err
+}
+
+func (r *ResilientUserRepository) Stream(ctx context.Context, filter UserFilter, fn func(*models.User) error) error {
+	return r.call(ctx, false, 0, func(ctx context.Context) error {
+		return r.UserRepository.Stream(ctx, filter, fn)
+	})
+}
+
+func (r *ResilientUserRepository) Batch(ctx context.Context, ops []BatchOperation, atomic bool) (results []BatchResult, err error) {
+	err = r.call(ctx, false, r.opts.WriteTimeout, func(ctx context.Context) error {
+		results, err = r.UserRepository.Batch(ctx, ops, atomic)
+		return err
+	})
+	return results, err
+}
+
+func (r *ResilientUserRepository) Search(ctx context.Context, query SearchQuery) (results []SearchResult, err error) {
+	err = r.call(ctx, true, r.opts.ReadTimeout, func(ctx context.Context) error {
+		results, err = r.UserRepository.Search(ctx, query)
+		return err
+	})
+	return results, err
+}
+
+func (r *ResilientUserRepository) History(ctx context.Context, userID int64) (revisions []*models.UserRevision, err error) {
+	history, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+	err = r.call(ctx, true, r.opts.ReadTimeout, func(ctx context.Context) error {
+		revisions, err = history.History(ctx, userID)
+		return err
+	})
+	return revisions, err
+}
+
+func (r *ResilientUserRepository) AsOf(ctx context.Context, userID int64, t time.Time) (user *models.User, err error) {
+	history, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+	err = r.call(ctx, true, r.opts.ReadTimeout, func(ctx context.Context) error {
+		user, err = history.AsOf(ctx, userID, t)
+		return err
+	})
+	return user, err
+}
+
+func (r *ResilientUserRepository) Revert(ctx context.Context, userID, revision int64) (user *models.User, err error) {
+	history, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+	err = r.call(ctx, false, r.opts.WriteTimeout, func(ctx context.Context) error {
+		user, err = history.Revert(ctx, userID, revision)
+		return err
+	})
+	return user, err
+}
+
+// BreakerState returns the state of the circuit breaker: closed, open or half-open
+func (r *ResilientUserRepository) BreakerState() string {
+	return r.breaker.State()
+}
+
+// call runs fn under a deadline of timeout, if there is one, as long as the breaker allows
+// it, retrying failures that left nothing applied if the operation is idempotent
+func (r *ResilientUserRepository) call(ctx context.Context, idempotent bool, timeout time.Duration, fn func(ctx context.Context) error) error {
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		if retryAfter, ok := r.breaker.allow(); !ok {
+			return &CircuitOpenError{RetryAfter: retryAfter}
+		}
+		err := fn(ctx)
+		if err != nil && parent.Err() != nil {
+			// the caller gave up, which says nothing about the database
+			r.breaker.release()
+			return err
+		}
+		failed := isTransient(err) || errors.Is(err, context.DeadlineExceeded)
+		r.breaker.record(failed)
+		if !idempotent || !isRetryable(err) || attempt >= r.opts.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		wait := r.backoff(attempt)
+		log.Printf("Retrying database call in %v after attempt %d failed: %v", wait, attempt, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff returns how long to wait after the given failed attempt
+func (r *ResilientUserRepository) backoff(attempt int) time.Duration {
+	return jitteredBackoff(r.opts.BaseBackoff, r.opts.MaxBackoff, attempt)
+}
+
+// jitteredBackoff returns a random wait of up to base doubled for every attempt after the
+// first, capped at max, so that clients failing together do not retry together
+func jitteredBackoff(base, max time.Duration, attempt int) time.Duration {
+	limit := base
+	for i := 1; i < attempt && limit < max; i++ {
+		limit *= 2
+	}
+	if limit > max {
+		limit = max
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+func (r *ResilientUserRepository) history() (HistoryRepository, error) {
+	history, ok := r.UserRepository.(HistoryRepository)
+	if !ok {
+		return nil, fmt.Errorf("%T does not keep history", r.UserRepository)
+	}
+	return history, nil
+}
+
+// isRetryable reports whether err means the operation was not applied, so that running it
+// again cannot apply it twice: the driver reports a bad connection only before it sent
+// anything, and a deadlock or lock wait timeout rolls the transaction back. Other failures of
+// the connection, such as a timeout, may come after the server applied the operation.
+func isRetryable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1205 || mysqlErr.Number == 1213
+	}
+	return false
+}
+
+// isTransient reports whether err is a failure of the connection or the server that a
+// later attempt may not run into, as opposed to an error in the request. These count
+// towards opening the circuit breaker.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1040, // too many connections
+			1053, // server shutdown in progress
+			1205, // lock wait timeout
+			1213, // deadlock
+			2006, // server has gone away
+			2013: // lost connection during query
+			return true
+		}
+	}
+	return false
+}
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker counts consecutive failures and, past threshold, rejects calls for openFor.
+// Then a single trial call is let through, whose outcome closes or reopens the breaker.
+type circuitBreaker struct {
+	threshold int
+	openFor   time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// allow reports whether a call may go ahead, and if not, how long until one may
+func (b *circuitBreaker) allow() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openedAt.Add(b.openFor).Sub(b.now()); wait > 0 {
+			return wait, false
+		}
+		b.state = breakerHalfOpen
+		log.Println("Circuit breaker half-open, letting a trial call through")
+		return 0, true
+	case breakerHalfOpen:
+		// the trial call is still running
+		return time.Second, false
+	default:
+		return 0, true
+	}
+}
+
+// record counts the outcome of a call that allow let through
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		if b.state == breakerHalfOpen {
+			log.Println("Circuit breaker closed, the database is back")
+		}
+		if b.state != breakerOpen {
+			b.state = breakerClosed
+			b.failures = 0
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state != breakerOpen && b.failures >= b.threshold) {
+		log.Printf("Circuit breaker open for %v after %d failed calls", b.openFor, b.failures)
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// release gives up the trial call of a half-open breaker without an outcome, so that the
+// next call becomes the trial
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+// State returns closed, open or half-open
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == "" {
+		return breakerClosed
+	}
+	return b.state
+}
@@ -0,0 +1,272 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+	"userapi/models"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// failingRepository fails calls with the errors in errs, one per call, before passing them on
+type failingRepository struct {
+	UserRepository
+	errs  []error
+	calls int
+}
+
+func (r *failingRepository) fail() error {
+	r.calls++
+	if len(r.errs) == 0 {
+		return nil
+	}
+	err := r.errs[0]
+	r.errs = r.errs[1:]
+	return err
+}
+
+func (r *failingRepository) Create(ctx context.Context, user *models.User) error {
+	if err := r.fail(); err != nil {
+		return err
+	}
+	return r.UserRepository.Create(ctx, user)
+}
+
+func (r *failingRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	if err := r.fail(); err != nil {
+		return nil, err
+	}
+	return r.UserRepository.GetByID(ctx, id)
+}
+
+func (r *failingRepository) Update(ctx context.Context, user *models.User) error {
+	if err := r.fail(); err != nil {
+		return err
+	}
+	return r.UserRepository.Update(ctx, user)
+}
+
+func newResilientTestRepository(errs ...error) (*ResilientUserRepository, *failingRepository) {
+	inner := &failingRepository{UserRepository: NewMemoryUserRepository(), errs: errs}
+	opts := DefaultResilienceOptions
+	opts.BaseBackoff = time.Millisecond
+	opts.MaxBackoff = 2 * time.Millisecond
+	opts.FailureThreshold = 3
+	return NewResilientUserRepository(inner, opts), inner
+}
+
+func TestResilientUserRepository_Retries(t *testing.T) {
+	ctx := context.Background()
+	badConn := fmt.Errorf("failed to query user: %w", driver.ErrBadConn)
+	notFound := fmt.Errorf("%w with ID: %d", ErrUserNotFound, 42)
+
+	tests := []struct {
+		name      string
+		errs      []error
+		call      func(repo *ResilientUserRepository) error
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "read retried until it succeeds",
+			errs:      []error{badConn, badConn},
+			call:      func(repo *ResilientUserRepository) error { _, err := repo.GetByID(ctx, 1); return err },
+			wantCalls: 3,
+		},
+		{
+			name:      "read gives up after max attempts",
+			errs:      []error{badConn, badConn, badConn, badConn},
+			call:      func(repo *ResilientUserRepository) error { _, err := repo.GetByID(ctx, 1); return err },
+			wantErr:   driver.ErrBadConn,
+			wantCalls: 3,
+		},
+		{
+			name:      "create not retried",
+			errs:      []error{badConn},
+			call:      func(repo *ResilientUserRepository) error { return repo.Create(ctx, newTestUser("John Doe", "john@example.com")) },
+			wantErr:   driver.ErrBadConn,
+			wantCalls: 1,
+		},
+		{
+			name:      "update not retried",
+			errs:      []error{badConn},
+			call:      func(repo *ResilientUserRepository) error { return repo.Update(ctx, &models.User{ID: 42}) },
+			wantErr:   driver.ErrBadConn,
+			wantCalls: 1,
+		},
+		{
+			name:      "deadlock retried",
+			errs:      []error{&mysql.MySQLError{Number: 1213, Message: "deadlock"}},
+			call:      func(repo *ResilientUserRepository) error { _, err := repo.GetByID(ctx, 1); return err },
+			wantCalls: 2,
+		},
+		{
+			name:      "lock wait timeout retried",
+			errs:      []error{&mysql.MySQLError{Number: 1205, Message: "lock wait timeout"}},
+			call:      func(repo *ResilientUserRepository) error { _, err := repo.GetByID(ctx, 1); return err },
+			wantCalls: 2,
+		},
+		{
+			name:      "network timeout not retried",
+			errs:      []error{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}},
+			call:      func(repo *ResilientUserRepository) error { _, err := repo.GetByID(ctx, 1); return err },
+			wantErr:   os.ErrDeadlineExceeded,
+			wantCalls: 1,
+		},
+		{
+			name:      "lost connection not retried",
+			errs:      []error{&mysql.MySQLError{Number: 2013, Message: "lost connection"}},
+			call:      func(repo *ResilientUserRepository) error { _, err := repo.GetByID(ctx, 1); return err },
+			wantErr:   &mysql.MySQLError{},
+			wantCalls: 1,
+		},
+		{
+			name:      "domain error not retried",
+			errs:      []error{notFound},
+			call:      func(repo *ResilientUserRepository) error { return repo.Update(ctx, &models.User{ID: 42}) },
+			wantErr:   ErrUserNotFound,
+			wantCalls: 1,
+		},
+		{
+			name:      "syntax error not retried",
+			errs:      []error{&mysql.MySQLError{Number: 1064, Message: "syntax error"}},
+			call:      func(repo *ResilientUserRepository) error { _, err := repo.GetByID(ctx, 1); return err },
+			wantErr:   &mysql.MySQLError{},
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, inner := newResilientTestRepository(tt.errs...)
+			err := tt.call(repo)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("error = %v, want nil", err)
+				}
+			case *mysql.MySQLError:
+				if !errors.As(err, &want) {
+					t.Errorf("error = %v, want a MySQL error", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("error = %v, want %v", err, want)
+				}
+			}
+			if inner.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", inner.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResilientUserRepository_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	badConn := fmt.Errorf("failed to create user: %w", driver.ErrBadConn)
+	repo, inner := newResilientTestRepository()
+	now := time.Now()
+	repo.breaker.now = func() time.Time { return now }
+
+	// domain errors say nothing about the database
+	for i := 0; i < 5; i++ {
+		repo.Update(ctx, &models.User{ID: 42})
+	}
+	if state := repo.BreakerState(); state != breakerClosed {
+		t.Fatalf("state after domain errors = %s, want closed", state)
+	}
+
+	inner.errs = []error{badConn, badConn, badConn}
+	for i := 0; i < 3; i++ {
+		repo.Create(ctx, newTestUser("John Doe", "john@example.com"))
+	}
+	if state := repo.BreakerState(); state != breakerOpen {
+		t.Fatalf("state after 3 failures = %s, want open", state)
+	}
+
+	calls := inner.calls
+	_, err := repo.GetByID(ctx, 1)
+	var open *CircuitOpenError
+	if !errors.As(err, &open) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("GetByID() while open error = %v, want a CircuitOpenError", err)
+	}
+	if open.RetryAfter != DefaultResilienceOptions.OpenFor {
+		t.Errorf("RetryAfter = %v, want %v", open.RetryAfter, DefaultResilienceOptions.OpenFor)
+	}
+	if inner.calls != calls {
+		t.Error("GetByID() while open reached the repository")
+	}
+
+	// once the breaker has been open long enough, a successful trial closes it
+	now = now.Add(DefaultResilienceOptions.OpenFor)
+	if _, err := repo.GetByID(ctx, 1); err != nil {
+		t.Fatalf("trial GetByID() error = %v", err)
+	}
+	if state := repo.BreakerState(); state != breakerClosed {
+		t.Errorf("state after successful trial = %s, want closed", state)
+	}
+}
+
+func TestCircuitBreaker_FailedTrialReopens(t *testing.T) {
+	now := time.Now()
+	b := &circuitBreaker{threshold: 1, openFor: time.Minute, now: func() time.Time { return now }}
+
+	b.record(true)
+	if _, ok := b.allow(); ok {
+		t.Fatal("allow() after threshold failures = true, want false")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("allow() after cooldown = false, want a trial call")
+	}
+	if _, ok := b.allow(); ok {
+		t.Error("allow() during the trial call = true, want false")
+	}
+	b.record(true)
+	if wait, ok := b.allow(); ok || wait != time.Minute {
+		t.Errorf("allow() after failed trial = %v, %v, want open for another minute", wait, ok)
+	}
+}
+
+// blockingRepository holds up lookups until their context is done
+type blockingRepository struct {
+	UserRepository
+}
+
+func (r *blockingRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	<-ctx.Done()
+	return nil, fmt.Errorf("failed to query user: %w", ctx.Err())
+}
+
+func TestResilientUserRepository_Deadline(t *testing.T) {
+	opts := DefaultResilienceOptions
+	opts.ReadTimeout = 20 * time.Millisecond
+	repo := NewResilientUserRepository(&blockingRepository{NewMemoryUserRepository()}, opts)
+
+	start := time.Now()
+	_, err := repo.GetByID(context.Background(), 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetByID() error = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetByID() took %v, want about the read timeout", elapsed)
+	}
+	if repo.breaker.failures != 1 {
+		t.Errorf("failures = %d, want the timeout counted", repo.breaker.failures)
+	}
+
+	// a caller that gives up is not the database's fault
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	repo.GetByID(ctx, 1)
+	if repo.breaker.failures != 1 {
+		t.Errorf("failures = %d after a cancelled call, want 1", repo.breaker.failures)
+	}
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"userapi/models"
+)
+
+// trigramIndex is an in-memory inverted index from trigrams to the users whose name, email
+// or phone number contain them. It finds candidates for a search by shared trigrams and
+// ranks them by how well each search term matches their tokens, tolerating typos.
+type trigramIndex struct {
+	postings map[string]map[int64]struct{}
+	tokens   map[int64][]string
+}
+
+type scoredID struct {
+	id    int64
+	score float64
+}
+
+func newTrigramIndex() *trigramIndex {
+	return &trigramIndex{
+		postings: make(map[string]map[int64]struct{}),
+		tokens:   make(map[int64][]string),
+	}
+}
+
+// add indexes user, replacing any earlier version of it
+func (x *trigramIndex) add(user *models.User) {
+	x.remove(user.ID)
+
+	tokens := searchTokens(user.Name + " " + user.Email)
+	if digits := phoneDigits(user.PhoneNumber); digits != "" {
+		tokens = append(tokens, digits)
+	}
+	x.tokens[user.ID] = tokens
+	for _, token := range tokens {
+		for _, trigram := range trigrams(token) {
+			ids := x.postings[trigram]
+			if ids == nil {
+				ids = make(map[int64]struct{})
+				x.postings[trigram] = ids
+			}
+			ids[user.ID] = struct{}{}
+		}
+	}
+}
+
+func (x *trigramIndex) remove(id int64) {
+	for _, token := range x.tokens[id] {
+		for _, trigram := range trigrams(token) {
+			delete(x.postings[trigram], id)
+			if len(x.postings[trigram]) == 0 {
+				delete(x.postings, trigram)
+			}
+		}
+	}
+	delete(x.tokens, id)
+}
+
+// search returns the IDs of users matching every term of text, best matches first
+func (x *trigramIndex) search(text string) []scoredID {
+	terms := searchTokens(text)
+	if isPhoneQuery(text) {
+		terms = []string{phoneSearchDigits(text)}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	candidates := make(map[int64]struct{})
+	for _, term := range terms {
+		for _, trigram := range trigrams(term) {
+			for id := range x.postings[trigram] {
+				candidates[id] = struct{}{}
+			}
+		}
+	}
+
+	var results []scoredID
+	for id := range candidates {
+		total := 0.0
+		for _, term := range terms {
+			best := 0.0
+			for _, token := range x.tokens[id] {
+				if score := termScore(term, token); score > best {
+					best = score
+				}
+			}
+			if best == 0 {
+				total = 0
+				break
+			}
+			total += best
+		}
+		if total > 0 {
+			results = append(results, scoredID{id: id, score: total / float64(len(terms))})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].id < results[j].id
+	})
+	return results
+}
+
+// termScore rates how well a search term matches a token, from 0 (no match) to 1 (equal).
+// Substrings score higher than near misses, which are allowed one edit for short terms and
+// two for longer ones.
+func termScore(term, token string) float64 {
+	switch {
+	case token == term:
+		return 1
+	case strings.HasPrefix(token, term):
+		return 0.9
+	case strings.Contains(token, term):
+		return 0.8
+	}
+
+	t, d := []rune(term), []rune(token)
+	maxEdits := 2
+	if len(t) < 3 {
+		return 0
+	} else if len(t) <= 5 {
+		maxEdits = 1
+	}
+	if dist := editDistance(t, d); dist <= maxEdits {
+		return 0.6 - 0.1*float64(dist-1)
+	}
+	if len(d) > len(t) {
+		if dist := editDistance(t, d[:len(t)]); dist <= maxEdits {
+			return 0.5 - 0.1*float64(dist-1)
+		}
+	}
+	return 0
+}
+
+// editDistance is the optimal string alignment distance between a and b: the number of
+// insertions, deletions, substitutions and transpositions of adjacent runes between them
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// trigrams returns the distinct three-rune substrings of token, padded like pg_trgm so that
+// short tokens and word boundaries produce trigrams too
+func trigrams(token string) []string {
+	runes := []rune("  " + token + " ")
+	seen := make(map[string]struct{}, len(runes))
+	var result []string
+	for i := 0; i+3 <= len(runes); i++ {
+		trigram := string(runes[i : i+3])
+		if _, ok := seen[trigram]; !ok {
+			seen[trigram] = struct{}{}
+			result = append(result, trigram)
+		}
+	}
+	return result
+}
+
+// searchTokens lowercases text and splits it into runs of letters and digits
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// isPhoneQuery reports whether text looks like (part of) a phone number: at least three
+// digits after any leading zeros and nothing but phone punctuation besides them
+func isPhoneQuery(text string) bool {
+	if len(strings.TrimLeft(phoneDigits(text), "0")) < 3 {
+		return false
+	}
+	return strings.IndexFunc(text, func(r rune) bool {
+		return !unicode.IsDigit(r) && !strings.ContainsRune(" +-().", r)
+	}) < 0
+}
+
+// phoneSearchDigits returns the digits to look for in E.164 numbers when searching for
+// text. Complete numbers are normalized first; otherwise leading zeros, which are national
+// or international dialing prefixes in most countries, are dropped.
+func phoneSearchDigits(text string) string {
+	if phone, err := models.NormalizePhoneNumber(text, models.DefaultPhoneRegion); err == nil {
+		return phoneDigits(phone)
+	}
+	return strings.TrimLeft(phoneDigits(text), "0")
+}
+
+func phoneDigits(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, text)
+}
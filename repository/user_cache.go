@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+	"userapi/models"
+)
+
+// UserCache stores users by ID for the cached repository. Implementations own the users
+// they are given and hand out copies. A cache shared between instances handles its own
+// errors, treating a failed Get as a miss.
+type UserCache interface {
+	Get(ctx context.Context, id int64) (*models.User, bool)
+	Set(ctx context.Context, user *models.User)
+	Delete(ctx context.Context, ids ...int64)
+}
+
+// lruCache is a UserCache in process memory that holds up to capacity users, each for at
+// most ttl, evicting the least recently used when full
+type lruCache struct {
+	mu        sync.Mutex
+	capacity  int
+	ttl       time.Duration
+	entries   map[int64]*list.Element
+	order     *list.List // front is most recently used
+	evictions int64
+	now       func() time.Time
+}
+
+type lruEntry struct {
+	user      *models.User
+	expiresAt time.Time
+}
+
+// NewLRUCache creates an in-process cache of up to capacity users that expire after ttl
+func NewLRUCache(capacity int, ttl time.Duration) UserCache {
+	return &lruCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[int64]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (c *lruCache) Get(ctx context.Context, id int64) (*models.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	user := *entry.user
+	return &user, true
+}
+
+func (c *lruCache) Set(ctx context.Context, user *models.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	copied := *user
+	entry := &lruEntry{user: &copied, expiresAt: c.now().Add(c.ttl)}
+	if elem, ok := c.entries[user.ID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[user.ID] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *lruCache) Delete(ctx context.Context, ids ...int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if elem, ok := c.entries[id]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+// Len returns the number of cached users, including expired ones not yet removed
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Evictions returns how many users were removed to make room for others
+func (c *lruCache) Evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
+
+func (c *lruCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*lruEntry).user.ID)
+	c.order.Remove(elem)
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+	"userapi/models"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(2, time.Minute).(*lruCache)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.Set(ctx, &models.User{ID: 1, Name: "One"})
+	cache.Set(ctx, &models.User{ID: 2, Name: "Two"})
+	cache.Get(ctx, 1) // 2 is now the least recently used
+	cache.Set(ctx, &models.User{ID: 3, Name: "Three"})
+
+	for _, tt := range []struct {
+		id     int64
+		wantOK bool
+	}{{1, true}, {2, false}, {3, true}} {
+		if _, ok := cache.Get(ctx, tt.id); ok != tt.wantOK {
+			t.Errorf("Get(%d) found = %v, want %v", tt.id, ok, tt.wantOK)
+		}
+	}
+	if cache.Evictions() != 1 {
+		t.Errorf("Evictions() = %d, want 1", cache.Evictions())
+	}
+
+	user, _ := cache.Get(ctx, 1)
+	user.Name = "Changed"
+	if cached, _ := cache.Get(ctx, 1); cached.Name != "One" {
+		t.Errorf("changing a returned user changed the cached one")
+	}
+
+	cache.Delete(ctx, 1)
+	if _, ok := cache.Get(ctx, 1); ok {
+		t.Errorf("Get() after Delete() found the user")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get(ctx, 3); ok || cache.Len() != 0 {
+		t.Errorf("Get() after the TTL found the user, %d entries left", cache.Len())
+	}
+}
@@ -2,14 +2,132 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"userapi/models"
 )
 
+var (
+	// ErrUserNotFound is returned when an operation targets a user that does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrBatchAborted is reported for operations that were rolled back because another
+	// operation in an atomic batch failed
+	ErrBatchAborted = errors.New("batch aborted")
+	// ErrEmailTaken is returned when a user's email normalizes to the email of another user
+	ErrEmailTaken = errors.New("email already in use")
+)
+
 // UserRepository defines the interface for user data operations
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	GetByID(ctx context.Context, id int64) (*models.User, error)
+	// GetByIDs fetches the users with the given IDs in a single query. Users that do not
+	// exist are left out and the result is in no particular order.
+	GetByIDs(ctx context.Context, ids []int64) ([]*models.User, error)
+	// GetByEmail fetches the user whose email normalizes to the same value as email,
+	// or nil if there is none
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	// GetByEmails is like GetByIDs, but looks users up by normalized email
+	GetByEmails(ctx context.Context, emails []string) ([]*models.User, error)
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id int64) error
-	List(ctx context.Context) ([]*models.User, error)
-} 
\ No newline at end of file
+	List(ctx context.Context, filter UserFilter) ([]*models.User, error)
+	// Stream calls fn for every user matching filter, ordered by ID, from a consistent
+	// snapshot without loading them all into memory. It stops at the first error fn returns.
+	Stream(ctx context.Context, filter UserFilter, fn func(*models.User) error) error
+	// Batch applies ops in order. In atomic mode either every operation is applied or none is,
+	// otherwise each operation succeeds or fails on its own. There is one result per operation.
+	Batch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error)
+	// Search finds users whose name, email or phone number matches query.Text, best
+	// matches first
+	Search(ctx context.Context, query SearchQuery) ([]SearchResult, error)
+}
+
+// SearchQuery is a free-text search over users
+type SearchQuery struct {
+	Text string
+	// Limit and Offset page through the ranked results
+	Limit  int
+	Offset int
+}
+
+// SearchResult is a user found by Search. Scores are only comparable within one search.
+type SearchResult struct {
+	User  *models.User
+	Score float64
+}
+
+// UserFilter narrows down the users returned by List and Stream. Zero values match everything.
+type UserFilter struct {
+	// Name matches users whose name contains it
+	Name  string
+	Email string
+	// Phone matches users with the same number once both are normalized to E.164
+	Phone  string
+	MinAge int
+	MaxAge int
+	// AfterID and Limit page through users ordered by ID
+	AfterID int64
+	Limit   int
+}
+
+// Matcher returns a function that reports whether a user matches the filter, ignoring
+// AfterID and Limit, with the same semantics as the WHERE clause of the MySQL repository
+func (f UserFilter) Matcher() func(user *models.User) bool {
+	name := strings.ToLower(f.Name)
+	var emailKey, phone string
+	if f.Email != "" {
+		var err error
+		if emailKey, err = models.NormalizeEmail(f.Email); err != nil {
+			emailKey = strings.ToLower(strings.TrimSpace(f.Email))
+		}
+	}
+	if f.Phone != "" {
+		var err error
+		if phone, err = models.NormalizePhoneNumber(f.Phone, models.DefaultPhoneRegion); err != nil {
+			phone = strings.TrimSpace(f.Phone)
+		}
+	}
+
+	return func(user *models.User) bool {
+		switch {
+		case name != "" && !strings.Contains(strings.ToLower(user.Name), name):
+			return false
+		case emailKey != "" && !strings.EqualFold(user.Email, emailKey):
+			return false
+		case phone != "" && user.PhoneNumber != phone:
+			return false
+		case f.MinAge > 0 && user.Age < f.MinAge:
+			return false
+		case f.MaxAge > 0 && user.Age > f.MaxAge:
+			return false
+		}
+		return true
+	}
+}
+
+// BatchOpType identifies the kind of a batch operation
+type BatchOpType string
+
+const (
+	BatchCreate BatchOpType = "create"
+	BatchUpdate BatchOpType = "update"
+	BatchDelete BatchOpType = "delete"
+	// BatchUpsert creates the user or, if one with the same email exists, updates it
+	BatchUpsert BatchOpType = "upsert"
+)
+
+// BatchOperation is a single create, update or delete within a batch
+type BatchOperation struct {
+	Type BatchOpType
+	// ID is the target of update and delete operations
+	ID int64
+	// User holds the data for create and update operations
+	User *models.User
+}
+
+// BatchResult is the outcome of a single batch operation
+type BatchResult struct {
+	ID  int64
+	Err error
+}
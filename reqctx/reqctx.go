@@ -0,0 +1,48 @@
+// Package reqctx carries who made a request, and which request it was, through contexts so
+// that layers below the handlers can record them.
+package reqctx
+
+import "context"
+
+// AnonymousActor is the actor of requests that do not authenticate. Nothing ties one
+// anonymous request to the next.
+const AnonymousActor = "anonymous"
+
+type actorKey struct{}
+
+type requestIDKey struct{}
+
+// WithActor returns a context that records actor as the one making the request
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor recorded in ctx, or "" if there is none
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// WithRequestID returns a context that records the ID of the request it belongs to
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID recorded in ctx, or "" if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Detach returns a background context with the actor and request ID of ctx, for work that
+// outlives the request
+func Detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if actor := Actor(ctx); actor != "" {
+		detached = WithActor(detached, actor)
+	}
+	if id := RequestID(ctx); id != "" {
+		detached = WithRequestID(detached, id)
+	}
+	return detached
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"expvar"
+	"userapi/audit"
+	"userapi/handlers"
+
+	"github.com/gorilla/mux"
+)
+
+// apiHandlers holds the handlers the API routes are served by
+type apiHandlers struct {
+	ping        *handlers.PingHandler
+	ready       *handlers.ReadyHandler
+	user        *handlers.UserHandler
+	history     *handlers.HistoryHandler
+	audit       *handlers.AuditHandler
+	webhook     *handlers.WebhookHandler
+	webSocket   *handlers.WebSocketHandler
+	eventStream *handlers.EventStreamHandler
+	graphql     *handlers.GraphQLHandler
+	imports     *handlers.ImportHandler
+	openAPI     *handlers.OpenAPIHandler
+}
+
+// registerRoutes registers the routes of the API, each of which must be described in the
+// OpenAPI document. Without runRelay the live event streams refuse their clients.
+func registerRoutes(router *mux.Router, h apiHandlers, auditLog *audit.Logger, runRelay bool) {
+	router.HandleFunc("/ping", h.ping.Ping).Methods("GET")
+	router.HandleFunc("/ready", h.ready.Ready).Methods("GET")
+	router.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	router.HandleFunc("/users", auditLog.Handler("user.create", h.user.Create)).Methods("POST")
+	router.HandleFunc("/users:batch", auditLog.Handler("user.batch", h.user.Batch)).Methods("POST")
+	router.HandleFunc("/users/export", auditLog.Handler("user.export", h.user.Export)).Methods("GET")
+	router.HandleFunc("/users/by-email/{email}", auditLog.Handler("user.get_by_email", h.user.GetByEmail)).Methods("GET")
+	router.HandleFunc("/users/search", auditLog.Handler("user.search", h.user.Search)).Methods("GET")
+	router.HandleFunc("/users/events", auditLog.Handler("user.events", liveEvents(runRelay, h.eventStream.Stream))).Methods("GET")
+	router.HandleFunc("/users/{id}/history", auditLog.Handler("user.history", h.history.History)).Methods("GET")
+	router.HandleFunc("/users/{id}/revert", auditLog.Handler("user.revert", h.history.Revert)).Methods("POST")
+	router.HandleFunc("/users/{id}", auditLog.Handler("user.get_as_of", h.history.AsOf)).Methods("GET").Queries("as_of", "{as_of}")
+	router.HandleFunc("/users/{id}", auditLog.Handler("user.get", h.user.GetByID)).Methods("GET")
+	router.HandleFunc("/users/{id}", auditLog.Handler("user.update", h.user.Update)).Methods("PUT")
+	router.HandleFunc("/users/{id}", auditLog.Handler("user.delete", h.user.Delete)).Methods("DELETE")
+	router.HandleFunc("/users", auditLog.Handler("user.list", h.user.List)).Methods("GET")
+	router.HandleFunc("/audit", handlers.RequireActor(h.audit.List)).Methods("GET")
+	router.HandleFunc("/webhooks", handlers.RequireActor(h.webhook.Create)).Methods("POST")
+	router.HandleFunc("/webhooks", handlers.RequireActor(h.webhook.List)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", handlers.RequireActor(h.webhook.Get)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", handlers.RequireActor(h.webhook.Update)).Methods("PUT")
+	router.HandleFunc("/webhooks/{id}", handlers.RequireActor(h.webhook.Delete)).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", handlers.RequireActor(h.webhook.Deliveries)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}/deliveries/{delivery}", handlers.RequireActor(h.webhook.Delivery)).Methods("GET")
+	router.HandleFunc("/webhooks/{id}/deliveries/{delivery}/redeliver", handlers.RequireActor(h.webhook.Redeliver)).Methods("POST")
+	router.HandleFunc("/ws", liveEvents(runRelay, h.webSocket.Serve)).Methods("GET")
+	router.HandleFunc("/graphql", auditLog.Handler("user.graphql", h.graphql.Serve)).Methods("GET", "POST")
+	router.HandleFunc("/imports", auditLog.Handler("user.import", h.imports.Create)).Methods("POST")
+	router.HandleFunc("/imports/{id}", auditLog.Handler("user.import_status", h.imports.Get)).Methods("GET")
+	router.HandleFunc("/imports/{id}/errors", auditLog.Handler("user.import_errors", h.imports.Errors)).Methods("GET")
+	router.HandleFunc("/openapi.json", h.openAPI.Serve).Methods("GET")
+}
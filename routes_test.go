@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+	"userapi/audit"
+	"userapi/events"
+	"userapi/graphqlapi"
+	"userapi/handlers"
+	"userapi/imports"
+	"userapi/repository"
+	"userapi/webhooks"
+
+	"github.com/gorilla/mux"
+)
+
+// TestRegisterRoutes checks that every route main serves is described in the OpenAPI
+// document, and every described route is served
+func TestRegisterRoutes(t *testing.T) {
+	repo := repository.NewMemoryUserRepository()
+	sink, err := audit.NewFileSink(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("NewFileSink() error = %v", err)
+	}
+	auditLog, err := audit.NewLogger(context.Background(), sink, audit.Options{})
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	importManager, err := imports.NewManager(repo, t.TempDir(), 500, time.Hour)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	graphqlService, err := graphqlapi.NewService(repo)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	broadcaster := events.NewBroadcaster()
+	authenticator := handlers.TokenAuthenticator(nil)
+
+	for _, runRelay := range []bool{true, false} {
+		router := mux.NewRouter()
+		registerRoutes(router, apiHandlers{
+			ping:        handlers.NewPingHandler(),
+			ready:       handlers.NewReadyHandler(),
+			user:        handlers.NewUserHandler(repo),
+			history:     handlers.NewHistoryHandler(repo.(repository.HistoryRepository)),
+			audit:       handlers.NewAuditHandler(sink),
+			webhook:     handlers.NewWebhookHandler(webhooks.NewMemoryStore()),
+			webSocket:   handlers.NewWebSocketHandler(repo, broadcaster, authenticator, auditLog, nil),
+			eventStream: handlers.NewEventStreamHandler(broadcaster, repo.(repository.OutboxRepository), time.Second),
+			graphql:     handlers.NewGraphQLHandler(graphqlService),
+			imports:     handlers.NewImportHandler(importManager, 1<<20),
+			openAPI:     handlers.NewOpenAPIHandler(),
+		}, auditLog, runRelay)
+
+		if _, err := handlers.GenerateOpenAPI(router); err != nil {
+			t.Errorf("GenerateOpenAPI() with runRelay=%v error = %v", runRelay, err)
+		}
+	}
+}
@@ -3,8 +3,93 @@ CREATE TABLE IF NOT EXISTS users (
     name VARCHAR(255) NOT NULL,
     age INT NOT NULL,
     phone_number VARCHAR(20) NOT NULL,
+    phone_number_raw VARCHAR(64) NOT NULL DEFAULT '',
     email VARCHAR(255) NOT NULL,
+    email_normalized VARCHAR(255) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    UNIQUE KEY unique_email (email)
-); 
\ No newline at end of file
+    UNIQUE KEY unique_email_normalized (email_normalized),
+    KEY idx_phone_number (phone_number),
+    FULLTEXT KEY ft_users_search (name, email, phone_number_raw)
+);
+
+CREATE TABLE IF NOT EXISTS user_revisions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    changes JSON NOT NULL,
+    snapshot JSON NULL,
+    revert_of BIGINT NULL,
+    created_at DATETIME(6) NOT NULL,
+    KEY idx_user_revisions_user (user_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    seq BIGINT PRIMARY KEY,
+    created_at DATETIME(6) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    target VARCHAR(2048) NOT NULL,
+    outcome VARCHAR(16) NOT NULL,
+    status INT NOT NULL,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    KEY idx_audit_log_actor (actor, seq),
+    KEY idx_audit_log_created_at (created_at)
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    aggregate_id BIGINT NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    published_at DATETIME(6) NULL,
+    publish_seq BIGINT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error VARCHAR(1024) NOT NULL DEFAULT '',
+    KEY idx_outbox_pending (published_at, id),
+    UNIQUE KEY idx_outbox_publish_seq (publish_seq)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    events JSON NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME(6) NOT NULL,
+    updated_at DATETIME(6) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    subscription_id BIGINT NOT NULL,
+    event_id BIGINT NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(6) NOT NULL,
+    last_error VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    updated_at DATETIME(6) NOT NULL,
+    UNIQUE KEY unique_subscription_event (subscription_id, event_id),
+    KEY idx_webhook_deliveries_due (status, next_attempt_at),
+    KEY idx_webhook_deliveries_subscription_due (subscription_id, status, next_attempt_at),
+    FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    delivery_id BIGINT NOT NULL,
+    attempted_at DATETIME(6) NOT NULL,
+    status_code INT NOT NULL DEFAULT 0,
+    error VARCHAR(1024) NOT NULL DEFAULT '',
+    duration_ms BIGINT NOT NULL,
+    KEY idx_webhook_delivery_attempts_delivery (delivery_id, id),
+    FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id) ON DELETE CASCADE
+);
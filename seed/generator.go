@@ -0,0 +1,101 @@
+package seed
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"userapi/models"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// emailDomains are reserved for examples, so that no generated address can reach anyone
+var emailDomains = []string{"example.com", "example.org", "example.net"}
+
+// Locales returns the names of the locales users can be generated for
+func Locales() []string {
+	names := make([]string, 0, len(locales))
+	for name := range locales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Generator generates fake users that pass User.Validate: names typical of their locale,
+// mobile numbers valid under the numbering plan of its country and emails at reserved
+// example domains. Generators with the same seed and locales generate the same users.
+// Emails carry the seed and the position of the user, so they never repeat for a seed
+// and differ between seeds.
+type Generator struct {
+	rng     *rand.Rand
+	seed    int64
+	locales []locale
+	count   int64
+}
+
+// NewGenerator creates a generator of users from the named locales, or from all of them
+// if none are named
+func NewGenerator(seed int64, localeNames ...string) (*Generator, error) {
+	if len(localeNames) == 0 {
+		localeNames = Locales()
+	}
+	g := &Generator{rng: rand.New(rand.NewSource(seed)), seed: seed}
+	for _, name := range localeNames {
+		l, ok := locales[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown locale %q, want one of %s", name, strings.Join(Locales(), ", "))
+		}
+		g.locales = append(g.locales, l)
+	}
+	return g, nil
+}
+
+// Next generates the next user. It is not safe for concurrent use.
+func (g *Generator) Next() *models.User {
+	g.count++
+	l := g.locales[g.rng.Intn(len(g.locales))]
+	first := l.firstNames[g.rng.Intn(len(l.firstNames))]
+	last := l.lastNames[g.rng.Intn(len(l.lastNames))]
+
+	name := first.display + " " + last.display
+	if l.familyFirst {
+		name = last.display + " " + first.display
+	}
+	domain := emailDomains[g.rng.Intn(len(emailDomains))]
+
+	return &models.User{
+		Name:        name,
+		Age:         18 + g.rng.Intn(73),
+		PhoneNumber: g.phoneNumber(l.region),
+		Email:       fmt.Sprintf("%s.%s+%x.%d@%s", first.ascii, last.ascii, uint64(g.seed), g.count, domain),
+	}
+}
+
+// phoneNumber returns a random mobile number of region, written the way people tend to
+// enter international numbers
+func (g *Generator) phoneNumber(region string) string {
+	example := phonenumbers.GetExampleNumberForType(region, phonenumbers.MOBILE)
+	national := phonenumbers.GetNationalSignificantNumber(example)
+
+	// randomize all but the first third of an example number, which keeps it a mobile
+	// number, until the result is valid
+	num := example
+	for i := 0; i < 100; i++ {
+		digits := []byte(national)
+		for j := len(digits) / 3; j < len(digits); j++ {
+			digits[j] = byte('0' + g.rng.Intn(10))
+		}
+		candidate, err := phonenumbers.Parse(fmt.Sprintf("+%d%s", example.GetCountryCode(), digits), region)
+		if err == nil && phonenumbers.IsValidNumber(candidate) {
+			num = candidate
+			break
+		}
+	}
+
+	if g.rng.Intn(2) == 0 {
+		return phonenumbers.Format(num, phonenumbers.E164)
+	}
+	return phonenumbers.Format(num, phonenumbers.INTERNATIONAL)
+}
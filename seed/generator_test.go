@@ -0,0 +1,49 @@
+package seed
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerator(t *testing.T) {
+	for _, locale := range Locales() {
+		t.Run(locale, func(t *testing.T) {
+			gen, err := NewGenerator(42, locale)
+			if err != nil {
+				t.Fatalf("NewGenerator() error = %v", err)
+			}
+			emails := make(map[string]bool)
+			for i := 0; i < 200; i++ {
+				user := gen.Next()
+				if err := user.Validate(); err != nil {
+					t.Fatalf("generated user %+v is invalid: %v", user, err)
+				}
+				if emails[user.Email] {
+					t.Fatalf("email %s generated twice", user.Email)
+				}
+				emails[user.Email] = true
+			}
+		})
+	}
+}
+
+func TestGenerator_Deterministic(t *testing.T) {
+	a, _ := NewGenerator(7)
+	b, _ := NewGenerator(7)
+	c, _ := NewGenerator(8)
+	for i := 0; i < 50; i++ {
+		ua, ub, uc := a.Next(), b.Next(), c.Next()
+		if !reflect.DeepEqual(ua, ub) {
+			t.Fatalf("user %d differs for the same seed: %+v and %+v", i, ua, ub)
+		}
+		if ua.Email == uc.Email {
+			t.Fatalf("user %d has email %s for different seeds", i, ua.Email)
+		}
+	}
+}
+
+func TestNewGenerator_UnknownLocale(t *testing.T) {
+	if _, err := NewGenerator(1, "xx_XX"); err == nil {
+		t.Error("NewGenerator() with unknown locale error = nil, want an error")
+	}
+}
@@ -0,0 +1,76 @@
+package seed
+
+import "strings"
+
+// name is a name as displayed and as spelled in an email address
+type name struct {
+	display string
+	ascii   string
+}
+
+// names parses "display:ascii" entries; entries without an ASCII spelling use their
+// lowercased display form
+func names(entries ...string) []name {
+	parsed := make([]name, len(entries))
+	for i, entry := range entries {
+		display, ascii, ok := strings.Cut(entry, ":")
+		if !ok {
+			ascii = strings.ToLower(display)
+		}
+		parsed[i] = name{display: display, ascii: ascii}
+	}
+	return parsed
+}
+
+// locale holds the names and phone numbering plan of users from one country
+type locale struct {
+	region      string // ISO 3166-1 region of phone numbers
+	familyFirst bool   // whether the family name is written first
+	firstNames  []name
+	lastNames   []name
+}
+
+// locales are the locales users are generated for, keyed by their POSIX name
+var locales = map[string]locale{
+	"en_US": {
+		region:     "US",
+		firstNames: names("James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda", "David", "Elizabeth", "William", "Barbara", "Richard", "Susan", "Joseph", "Jessica"),
+		lastNames:  names("Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez", "Hernandez", "Lopez", "Wilson", "Anderson", "Thomas", "Taylor"),
+	},
+	"en_GB": {
+		region:     "GB",
+		firstNames: names("Oliver", "Olivia", "George", "Amelia", "Harry", "Isla", "Noah", "Ava", "Jack", "Emily", "Charlie", "Sophia", "Leo", "Grace", "Arthur", "Freya"),
+		lastNames:  names("Smith", "Jones", "Taylor", "Brown", "Williams", "Wilson", "Johnson", "Davies", "Robinson", "Wright", "Thompson", "Evans", "Walker", "White", "Roberts", "Green"),
+	},
+	"de_DE": {
+		region:     "DE",
+		firstNames: names("Maximilian", "Sophie", "Alexander", "Marie", "Paul", "Emilia", "Lukas", "Hannah", "Jürgen:juergen", "Anna", "Felix", "Lena", "Jonas", "Mia", "Björn:bjoern", "Lea"),
+		lastNames:  names("Müller:mueller", "Schmidt", "Schneider", "Fischer", "Weber", "Meyer", "Wagner", "Becker", "Schulz", "Hoffmann", "Schäfer:schaefer", "Koch", "Bauer", "Richter", "Klein", "Wolf"),
+	},
+	"fr_FR": {
+		region:     "FR",
+		firstNames: names("Gabriel", "Jade", "Louis", "Louise", "Raphaël:raphael", "Emma", "Jules", "Alice", "Adam", "Chloé:chloe", "Lucas", "Léa:lea", "Hugo", "Manon", "Théo:theo", "Inès:ines"),
+		lastNames:  names("Martin", "Bernard", "Dubois", "Thomas", "Robert", "Richard", "Petit", "Durand", "Leroy", "Moreau", "Simon", "Laurent", "Lefèvre:lefevre", "Michel", "Garcia", "Fournier"),
+	},
+	"es_ES": {
+		region:     "ES",
+		firstNames: names("Hugo", "Lucía:lucia", "Martín:martin", "Sofía:sofia", "Mateo", "Martina", "Leo", "María:maria", "Daniel", "Julia", "Alejandro", "Paula", "Pablo", "Valeria", "Álvaro:alvaro", "Carmen"),
+		lastNames:  names("García:garcia", "Rodríguez:rodriguez", "González:gonzalez", "Fernández:fernandez", "López:lopez", "Martínez:martinez", "Sánchez:sanchez", "Pérez:perez", "Gómez:gomez", "Martín:martin", "Jiménez:jimenez", "Ruiz", "Hernández:hernandez", "Díaz:diaz", "Moreno", "Muñoz:munoz"),
+	},
+	"it_IT": {
+		region:     "IT",
+		firstNames: names("Leonardo", "Sofia", "Francesco", "Aurora", "Alessandro", "Giulia", "Lorenzo", "Ginevra", "Mattia", "Alice", "Andrea", "Beatrice", "Gabriele", "Emma", "Niccolò:niccolo", "Vittoria"),
+		lastNames:  names("Rossi", "Russo", "Ferrari", "Esposito", "Bianchi", "Romano", "Colombo", "Ricci", "Marino", "Greco", "Bruno", "Gallo", "Conti", "De Luca:deluca", "Mancini", "Costa"),
+	},
+	"pt_BR": {
+		region:     "BR",
+		firstNames: names("Miguel", "Helena", "Arthur", "Alice", "Gael", "Laura", "Heitor", "Maria", "Théo:theo", "Valentina", "Davi", "Heloísa:heloisa", "Gabriel", "Júlia:julia", "Bernardo", "Cecília:cecilia"),
+		lastNames:  names("Silva", "Santos", "Oliveira", "Souza", "Rodrigues", "Ferreira", "Alves", "Pereira", "Lima", "Gomes", "Costa", "Ribeiro", "Martins", "Carvalho", "Araújo:araujo", "Rocha"),
+	},
+	"ja_JP": {
+		region:      "JP",
+		familyFirst: true,
+		firstNames:  names("陽翔:haruto", "陽葵:himari", "蓮:ren", "凛:rin", "湊:minato", "芽依:mei", "蒼:aoi", "結菜:yuina", "樹:itsuki", "紬:tsumugi", "悠真:yuma", "澪:mio", "大和:yamato", "咲良:sakura", "律:ritsu", "葵:aoi"),
+		lastNames:   names("佐藤:sato", "鈴木:suzuki", "高橋:takahashi", "田中:tanaka", "伊藤:ito", "渡辺:watanabe", "山本:yamamoto", "中村:nakamura", "小林:kobayashi", "加藤:kato", "吉田:yoshida", "山田:yamada", "佐々木:sasaki", "山口:yamaguchi", "松本:matsumoto", "井上:inoue"),
+	},
+}
@@ -0,0 +1,99 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"userapi/models"
+	"userapi/repository"
+)
+
+// Options control how many users are seeded and how fast
+type Options struct {
+	// Count is the number of users to generate
+	Count int
+	// Rate is the number of users to create per second, or 0 for as fast as possible
+	Rate float64
+	// Workers is the number of users created concurrently
+	Workers int
+}
+
+// Result counts what became of the generated users
+type Result struct {
+	Created int64 `json:"created"`
+	// Existing users have an email already in use, typically from seeding with the same seed before
+	Existing int64 `json:"existing"`
+	Failed   int64 `json:"failed"`
+}
+
+// Run generates opts.Count users with gen and creates them in repo, at most opts.Rate per
+// second. Users whose email is taken are counted as existing, so seeding again with the same
+// seed adds nothing. It stops early when ctx is done, returning what it did so far.
+func Run(ctx context.Context, repo repository.UserRepository, gen *Generator, opts Options) (Result, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	users := make(chan *models.User)
+	go func() {
+		defer close(users)
+		var tick <-chan time.Time
+		if opts.Rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i := 0; i < opts.Count; i++ {
+			user := gen.Next()
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case users <- user:
+			}
+		}
+	}()
+
+	var created, existing, failed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range users {
+				err := user.Validate()
+				if err == nil {
+					err = repo.Create(ctx, user)
+				}
+				switch {
+				case err == nil:
+					created.Add(1)
+				case errors.Is(err, repository.ErrEmailTaken):
+					existing.Add(1)
+				case ctx.Err() != nil:
+					// interrupted, not failed
+				default:
+					log.Printf("Error seeding user %s: %v", user.Email, err)
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := Result{Created: created.Load(), Existing: existing.Load(), Failed: failed.Load()}
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("seeding interrupted: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package seed
+
+import (
+	"context"
+	"testing"
+	"time"
+	"userapi/repository"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+
+	gen, _ := NewGenerator(1)
+	result, err := Run(ctx, repo, gen, Options{Count: 50, Workers: 4})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result != (Result{Created: 50}) {
+		t.Errorf("Run() = %+v, want 50 created", result)
+	}
+
+	// the same seed generates the same users again
+	gen, _ = NewGenerator(1)
+	result, err = Run(ctx, repo, gen, Options{Count: 60, Workers: 4})
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+	if result != (Result{Created: 10, Existing: 50}) {
+		t.Errorf("second Run() = %+v, want 10 created and 50 existing", result)
+	}
+
+	users, _ := repo.List(ctx, repository.UserFilter{})
+	if len(users) != 60 {
+		t.Errorf("List() returned %d users, want 60", len(users))
+	}
+}
+
+func TestRun_Rate(t *testing.T) {
+	gen, _ := NewGenerator(1)
+	start := time.Now()
+	result, err := Run(context.Background(), repository.NewMemoryUserRepository(), gen, Options{Count: 10, Rate: 200})
+	if err != nil || result.Created != 10 {
+		t.Fatalf("Run() = %+v, %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("10 users at 200 per second took %v, want about 50ms", elapsed)
+	}
+}
+
+func TestRun_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	gen, _ := NewGenerator(1)
+	result, err := Run(ctx, repository.NewMemoryUserRepository(), gen, Options{Count: 1000, Rate: 100})
+	if err == nil {
+		t.Error("Run() after cancel error = nil, want an error")
+	}
+	if result.Created == 0 || result.Created >= 1000 {
+		t.Errorf("Run() after cancel created %d users, want some", result.Created)
+	}
+}
@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cachedStore keeps the list of subscriptions for a while, as the dispatcher looks them up
+// for every published event and every batch of deliveries. Changes made through it drop the
+// list at once; changes made on other instances show once it is older than ttl.
+type cachedStore struct {
+	Store
+	ttl time.Duration
+	now func() time.Time
+
+	mu            sync.Mutex
+	subscriptions []*Subscription
+	fetchedAt     time.Time
+}
+
+// NewCachedStore wraps store so that subscriptions are read from it at most once every ttl
+func NewCachedStore(store Store, ttl time.Duration) Store {
+	return &cachedStore{Store: store, ttl: ttl, now: time.Now}
+}
+
+func (c *cachedStore) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	subscriptions, err := c.cached(ctx)
+	if err != nil {
+		return nil, err
+	}
+	copied := make([]*Subscription, len(subscriptions))
+	for i, s := range subscriptions {
+		copied[i] = copySubscription(s)
+	}
+	return copied, nil
+}
+
+// GetSubscription falls back to the store for subscriptions that are not in the cached
+// list, which may have been created on another instance since it was fetched
+func (c *cachedStore) GetSubscription(ctx context.Context, id int64) (*Subscription, error) {
+	subscriptions, err := c.cached(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subscriptions {
+		if s.ID == id {
+			return copySubscription(s), nil
+		}
+	}
+	return c.Store.GetSubscription(ctx, id)
+}
+
+func (c *cachedStore) CreateSubscription(ctx context.Context, s *Subscription) error {
+	defer c.invalidate()
+	return c.Store.CreateSubscription(ctx, s)
+}
+
+func (c *cachedStore) UpdateSubscription(ctx context.Context, s *Subscription) error {
+	defer c.invalidate()
+	return c.Store.UpdateSubscription(ctx, s)
+}
+
+func (c *cachedStore) DeleteSubscription(ctx context.Context, id int64) error {
+	defer c.invalidate()
+	return c.Store.DeleteSubscription(ctx, id)
+}
+
+// cached returns the cached list, fetching it if it is missing or too old. Callers must not
+// modify the subscriptions.
+func (c *cachedStore) cached(ctx context.Context) ([]*Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscriptions != nil && c.now().Sub(c.fetchedAt) < c.ttl {
+		return c.subscriptions, nil
+	}
+	subscriptions, err := c.Store.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.subscriptions, c.fetchedAt = subscriptions, c.now()
+	return subscriptions, nil
+}
+
+func (c *cachedStore) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions = nil
+}
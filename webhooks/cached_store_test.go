@@ -0,0 +1,69 @@
+package webhooks
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingStore counts how often the subscriptions are listed
+type countingStore struct {
+	Store
+	lists int
+}
+
+func (c *countingStore) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	c.lists++
+	return c.Store.ListSubscriptions(ctx)
+}
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingStore{Store: NewMemoryStore()}
+	store := NewCachedStore(counting, time.Minute).(*cachedStore)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	s := &Subscription{URL: "https://example.com/hooks", Secret: "secret", Active: true}
+	store.CreateSubscription(ctx, s)
+
+	tests := []struct {
+		name      string
+		change    func()
+		wantURL   string
+		wantLists int
+	}{
+		{name: "first read fetches", change: func() {}, wantURL: s.URL, wantLists: 1},
+		{name: "later reads are cached", change: func() {}, wantURL: s.URL, wantLists: 1},
+		{name: "changes made through the cache drop it", change: func() {
+			store.UpdateSubscription(ctx, &Subscription{ID: s.ID, URL: "https://example.com/v2", Active: true})
+		}, wantURL: "https://example.com/v2", wantLists: 2},
+		{name: "changes made elsewhere show after the TTL", change: func() {
+			counting.Store.UpdateSubscription(ctx, &Subscription{ID: s.ID, URL: "https://example.com/v3", Active: true})
+			now = now.Add(time.Minute)
+		}, wantURL: "https://example.com/v3", wantLists: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			subscriptions, err := store.ListSubscriptions(ctx)
+			if err != nil {
+				t.Fatalf("ListSubscriptions() error = %v", err)
+			}
+			got, _ := store.GetSubscription(ctx, s.ID)
+			if len(subscriptions) != 1 || subscriptions[0].URL != tt.wantURL || got.URL != tt.wantURL {
+				t.Errorf("subscriptions = %+v, GetSubscription() = %+v, want URL %s", subscriptions, got, tt.wantURL)
+			}
+			if counting.lists != tt.wantLists {
+				t.Errorf("listed %d times, want %d", counting.lists, tt.wantLists)
+			}
+		})
+	}
+
+	// subscriptions handed out are copies
+	subscriptions, _ := store.ListSubscriptions(ctx)
+	subscriptions[0].URL = "changed"
+	if again, _ := store.ListSubscriptions(ctx); again[0].URL == "changed" {
+		t.Error("changing a listed subscription changed the cache")
+	}
+}
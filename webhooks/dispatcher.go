@@ -0,0 +1,324 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"userapi/models"
+)
+
+// maxErrorBodyLength bounds how much of a failed response is kept in the delivery log
+const maxErrorBodyLength = 512
+
+// claimBatch is the number of due deliveries of a subscription a worker claims at a time
+const claimBatch = 10
+
+// Options tune the dispatcher
+type Options struct {
+	// Interval is how often due deliveries are looked for
+	Interval time.Duration
+	// MaxAttempts is the number of failed attempts after which a delivery is dead
+	MaxAttempts int
+	// BaseBackoff is the wait after the first failure; it doubles with every further one
+	BaseBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	// Timeout bounds a single attempt
+	Timeout time.Duration
+	// Workers is the number of subscriptions posted to at the same time. A subscription has
+	// at most one worker, so a slow endpoint only holds up its own deliveries.
+	Workers int
+	// AllowPrivateNetworks lets deliveries reach loopback, private and link-local addresses,
+	// which are refused by default so that subscriptions cannot probe the internal network
+	AllowPrivateNetworks bool
+}
+
+// DefaultOptions retry for about a day before giving up
+var DefaultOptions = Options{
+	Interval:    time.Second,
+	MaxAttempts: 12,
+	BaseBackoff: 30 * time.Second,
+	MaxBackoff:  4 * time.Hour,
+	Timeout:     10 * time.Second,
+	Workers:     8,
+}
+
+// Dispatcher turns published events into deliveries for the matching subscriptions and
+// posts due deliveries to their endpoints
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	opts   Options
+	now    func() time.Time
+
+	mu sync.Mutex
+	// busy holds the subscriptions a worker is posting to
+	busy    map[int64]bool
+	workers sync.WaitGroup
+	// finished is signalled when a worker is done, so that Run can hand out its slot
+	// without waiting for the next tick
+	finished chan struct{}
+}
+
+// NewDispatcher creates a dispatcher. It is an events.Sink, so add it to the event relay
+// and start Run. Publish lists the subscriptions for every event, so give it a store
+// wrapped by NewCachedStore.
+func NewDispatcher(store Store, opts Options) *Dispatcher {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	return &Dispatcher{
+		store:    store,
+		client:   newClient(opts.Timeout, opts.AllowPrivateNetworks),
+		opts:     opts,
+		now:      time.Now,
+		busy:     make(map[int64]bool),
+		finished: make(chan struct{}, 1),
+	}
+}
+
+// Publish enqueues a delivery of e for every active subscription that wants it
+func (d *Dispatcher) Publish(ctx context.Context, e *models.UserEvent) error {
+	subscriptions, err := d.store.ListSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+
+	var deliveries []*Delivery
+	var payload []byte
+	now := d.now().UTC()
+	for _, s := range subscriptions {
+		if !s.Matches(e.Type) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(e); err != nil {
+				return fmt.Errorf("failed to encode event: %w", err)
+			}
+		}
+		deliveries = append(deliveries, &Delivery{
+			SubscriptionID: s.ID,
+			EventID:        e.ID,
+			EventType:      e.Type,
+			Payload:        payload,
+			Status:         StatusPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := d.store.EnqueueDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+	log.Printf("Enqueued %d webhook deliveries of event %d", len(deliveries), e.ID)
+	return nil
+}
+
+// Run posts due deliveries until ctx is done, then waits for the workers to stop
+func (d *Dispatcher) Run(ctx context.Context) {
+	log.Printf("Webhook dispatcher started with %d workers, polling every %v", d.opts.Workers, d.opts.Interval)
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	logError := func(_ int, err error) {
+		if err != nil {
+			log.Printf("Error delivering webhooks: %v", err)
+		}
+	}
+	for {
+		if _, err := d.dispatch(ctx, logError); err != nil {
+			log.Printf("Error delivering webhooks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			d.workers.Wait()
+			log.Println("Webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+		case <-d.finished:
+		}
+	}
+}
+
+// DeliverDue has the free workers post a batch of due deliveries each and waits for them,
+// returning how many deliveries they attempted
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	type result struct {
+		attempted int
+		err       error
+	}
+	results := make(chan result, d.opts.Workers)
+	started, err := d.dispatch(ctx, func(attempted int, err error) { results <- result{attempted, err} })
+	if err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for i := 0; i < started; i++ {
+		r := <-results
+		attempted += r.attempted
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+	}
+	return attempted, err
+}
+
+// dispatch starts a worker for every subscription with due deliveries that has none, as
+// long as workers are free, and returns how many it started. done is called as each of
+// them finishes.
+func (d *Dispatcher) dispatch(ctx context.Context, done func(attempted int, err error)) (int, error) {
+	d.mu.Lock()
+	free := d.opts.Workers - len(d.busy)
+	skip := make([]int64, 0, len(d.busy))
+	for id := range d.busy {
+		skip = append(skip, id)
+	}
+	d.mu.Unlock()
+	if free <= 0 {
+		return 0, nil
+	}
+
+	due, err := d.store.DueSubscriptions(ctx, d.now().UTC(), skip, free)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find subscriptions with due deliveries: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	started := 0
+	for _, id := range due {
+		if d.busy[id] {
+			continue
+		}
+		d.busy[id] = true
+		started++
+		d.workers.Add(1)
+		go func(id int64) {
+			defer d.workers.Done()
+			attempted, err := d.work(ctx, id)
+			d.mu.Lock()
+			delete(d.busy, id)
+			d.mu.Unlock()
+			done(attempted, err)
+			select {
+			case d.finished <- struct{}{}:
+			default:
+			}
+		}(id)
+	}
+	return started, nil
+}
+
+// work claims a batch of due deliveries of a subscription and attempts them in turn. The
+// claim lasts until every one of them could have timed out, so dispatchers on other
+// instances leave them alone meanwhile.
+func (d *Dispatcher) work(ctx context.Context, subscriptionID int64) (int, error) {
+	s, err := d.store.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch webhook subscription: %w", err)
+	}
+	if s == nil {
+		// deleted, and its deliveries with it
+		return 0, nil
+	}
+
+	due, err := d.store.ClaimDueDeliveries(ctx, subscriptionID, d.now().UTC(), claimBatch*d.opts.Timeout, claimBatch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim due deliveries: %w", err)
+	}
+	for i, delivery := range due {
+		if err := d.attempt(ctx, s, delivery); err != nil {
+			return i, err
+		}
+	}
+	return len(due), nil
+}
+
+// attempt posts a delivery once and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, s *Subscription, delivery *Delivery) error {
+	start := d.now()
+	statusCode, err := d.post(ctx, s, delivery)
+	attempt := Attempt{
+		At:         start.UTC(),
+		StatusCode: statusCode,
+		DurationMS: d.now().Sub(start).Milliseconds(),
+	}
+
+	delivery.Attempts++
+	delivery.UpdatedAt = d.now().UTC()
+	switch {
+	case err == nil:
+		delivery.Status = StatusSucceeded
+		delivery.LastError = ""
+		log.Printf("Delivered webhook %d of event %d to subscription %d", delivery.ID, delivery.EventID, s.ID)
+	case delivery.Attempts >= d.opts.MaxAttempts:
+		attempt.Error = err.Error()
+		delivery.Status = StatusDead
+		delivery.LastError = attempt.Error
+		log.Printf("Webhook %d to subscription %d is dead after %d attempts: %v", delivery.ID, s.ID, delivery.Attempts, err)
+	default:
+		attempt.Error = err.Error()
+		delivery.LastError = attempt.Error
+		delivery.NextAttemptAt = d.now().UTC().Add(d.backoff(delivery.Attempts))
+		log.Printf("Webhook %d to subscription %d failed, retrying at %s: %v", delivery.ID, s.ID, delivery.NextAttemptAt.Format(time.RFC3339), err)
+	}
+
+	if err := d.store.RecordAttempt(ctx, delivery, attempt); err != nil {
+		return fmt.Errorf("failed to record webhook attempt: %w", err)
+	}
+	return nil
+}
+
+// post sends a delivery and returns the response status. Anything but a 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, s *Subscription, delivery *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "userapi-webhooks")
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(SignatureHeader, Sign(s.Secret, d.now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the wait after the given number of failed attempts: BaseBackoff doubled
+// for every attempt after the first, capped at MaxBackoff, with up to half of it random so
+// that deliveries that failed together do not retry together
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.opts.BaseBackoff
+	for i := 1; i < attempts && wait < d.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.opts.MaxBackoff {
+		wait = d.opts.MaxBackoff
+	}
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,275 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"userapi/models"
+)
+
+// receiver is a local webhook endpoint that checks signatures and fails on demand
+type receiver struct {
+	t      *testing.T
+	secret string
+
+	mu       sync.Mutex
+	fail     bool
+	received []*models.UserEvent
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := VerifySignature(rc.secret, r.Header.Get(SignatureHeader), body, 5*time.Minute, time.Now()); err != nil {
+		rc.t.Errorf("VerifySignature() error = %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.fail {
+		http.Error(w, "try again later", http.StatusServiceUnavailable)
+		return
+	}
+	e := &models.UserEvent{}
+	json.Unmarshal(body, e)
+	rc.received = append(rc.received, e)
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+	rc := &receiver{t: t, fail: true}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	store := NewMemoryStore()
+	secret, _ := NewSecret()
+	rc.secret = secret
+	wanted := &Subscription{URL: server.URL, Events: []string{models.EventUserCreated}, Secret: secret, Active: true}
+	inactive := &Subscription{URL: server.URL, Events: []string{}, Secret: secret, Active: false}
+	store.CreateSubscription(ctx, wanted)
+	store.CreateSubscription(ctx, inactive)
+
+	now := time.Now()
+	dispatcher := NewDispatcher(store, Options{Interval: time.Second, MaxAttempts: 2, BaseBackoff: time.Minute, MaxBackoff: time.Hour, Timeout: time.Second, AllowPrivateNetworks: true})
+	dispatcher.now = func() time.Time { return now }
+
+	created := &models.UserEvent{ID: 1, Type: models.EventUserCreated, UserID: 1}
+	dispatcher.Publish(ctx, created)
+	dispatcher.Publish(ctx, created)
+	dispatcher.Publish(ctx, &models.UserEvent{ID: 2, Type: models.EventUserDeleted, UserID: 1})
+
+	deliveries, _ := store.ListDeliveries(ctx, wanted.ID, 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("enqueued %d deliveries, want 1: duplicates and other event types are skipped", len(deliveries))
+	}
+	if others, _ := store.ListDeliveries(ctx, inactive.ID, 10); len(others) != 0 {
+		t.Errorf("inactive subscription got %d deliveries", len(others))
+	}
+
+	// the first attempt fails and is retried after the backoff
+	if n, err := dispatcher.DeliverDue(ctx); n != 1 || err != nil {
+		t.Fatalf("DeliverDue() = %d, %v", n, err)
+	}
+	d, _ := store.GetDelivery(ctx, wanted.ID, deliveries[0].ID)
+	if d.Status != StatusPending || d.Attempts != 1 || len(d.Log) != 1 || d.Log[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("delivery after failure = %+v", d)
+	}
+	if wait := d.NextAttemptAt.Sub(now); wait < 30*time.Second || wait > time.Minute {
+		t.Errorf("next attempt in %v, want between 30s and 1m", wait)
+	}
+	if n, _ := dispatcher.DeliverDue(ctx); n != 0 {
+		t.Errorf("DeliverDue() before backoff attempted %d deliveries", n)
+	}
+
+	// the second failure uses up the attempts
+	now = now.Add(2 * time.Minute)
+	dispatcher.DeliverDue(ctx)
+	if d, _ = store.GetDelivery(ctx, wanted.ID, d.ID); d.Status != StatusDead || len(d.Log) != 2 {
+		t.Fatalf("delivery after last attempt = %+v, want dead", d)
+	}
+
+	rc.mu.Lock()
+	rc.fail = false
+	rc.mu.Unlock()
+	if _, err := store.Redeliver(ctx, wanted.ID, d.ID); err != nil {
+		t.Fatalf("Redeliver() error = %v", err)
+	}
+	dispatcher.DeliverDue(ctx)
+	if d, _ = store.GetDelivery(ctx, wanted.ID, d.ID); d.Status != StatusSucceeded || len(d.Log) != 3 {
+		t.Errorf("delivery after redelivery = %+v, want succeeded", d)
+	}
+	if len(rc.received) != 1 || rc.received[0].ID != created.ID {
+		t.Errorf("receiver got %+v", rc.received)
+	}
+}
+
+func TestDispatcher_RefusesPrivateNetworks(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivery reached a loopback address")
+	}))
+	defer server.Close()
+
+	store := NewMemoryStore()
+	s := &Subscription{URL: server.URL, Secret: "secret", Active: true}
+	store.CreateSubscription(ctx, s)
+	dispatcher := NewDispatcher(store, Options{Interval: time.Second, MaxAttempts: 2, BaseBackoff: time.Minute, MaxBackoff: time.Hour, Timeout: time.Second})
+	dispatcher.Publish(ctx, &models.UserEvent{ID: 1, Type: models.EventUserCreated, UserID: 1})
+
+	if n, err := dispatcher.DeliverDue(ctx); n != 1 || err != nil {
+		t.Fatalf("DeliverDue() = %d, %v", n, err)
+	}
+	deliveries, _ := store.ListDeliveries(ctx, s.ID, 10)
+	if d := deliveries[0]; d.Attempts != 1 || !strings.Contains(d.LastError, "non-public address 127.0.0.1") {
+		t.Errorf("delivery to loopback = %+v, want refused", d)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:2800:220:1::248", want: true},
+		{ip: "127.0.0.1"},
+		{ip: "::1"},
+		{ip: "10.1.2.3"},
+		{ip: "172.16.0.1"},
+		{ip: "192.168.1.1"},
+		{ip: "169.254.169.254"},
+		{ip: "fe80::1"},
+		{ip: "fd00::1"},
+		{ip: "0.0.0.0"},
+		{ip: "100.64.0.1"},
+		{ip: "::ffff:127.0.0.1"},
+		{ip: "64:ff9b::a9fe:a9fe"},
+		{ip: "224.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := isPublic(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublic(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestDispatcher_SlowSubscription(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := &receiver{t: t, secret: "fast"}
+	fastServer := httptest.NewServer(fast)
+	defer fastServer.Close()
+
+	store := NewMemoryStore()
+	store.CreateSubscription(ctx, &Subscription{URL: slow.URL, Secret: "slow", Active: true})
+	store.CreateSubscription(ctx, &Subscription{URL: fastServer.URL, Secret: "fast", Active: true})
+	dispatcher := NewDispatcher(store, Options{Interval: 10 * time.Millisecond, MaxAttempts: 2, BaseBackoff: time.Minute, MaxBackoff: time.Hour, Timeout: 5 * time.Second, Workers: 2, AllowPrivateNetworks: true})
+	stopped := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(stopped)
+	}()
+
+	// the slow endpoint holds up its own worker, not the deliveries of the other subscription
+	for i := int64(1); i <= 3; i++ {
+		dispatcher.Publish(ctx, &models.UserEvent{ID: i, Type: models.EventUserCreated, UserID: i})
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			fast.mu.Lock()
+			received := len(fast.received)
+			fast.mu.Unlock()
+			if received == int(i) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("fast subscription received %d events, want %d", received, i)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	cancel()
+	<-stopped
+}
+
+func TestMemoryStore_ClaimDueDeliveries(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	s := &Subscription{URL: "https://example.com/hooks", Secret: "secret", Active: true}
+	store.CreateSubscription(ctx, s)
+	now := time.Now().UTC()
+	store.EnqueueDeliveries(ctx, []*Delivery{
+		{SubscriptionID: s.ID, EventID: 1, Status: StatusPending, NextAttemptAt: now},
+		{SubscriptionID: s.ID, EventID: 2, Status: StatusPending, NextAttemptAt: now},
+		{SubscriptionID: s.ID, EventID: 3, Status: StatusPending, NextAttemptAt: now.Add(time.Hour)},
+	})
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want []int64
+	}{
+		{name: "due deliveries", at: now, want: []int64{1}},
+		{name: "the rest of the due deliveries", at: now, want: []int64{2}},
+		{name: "claimed deliveries are skipped", at: now.Add(time.Minute), want: nil},
+		{name: "claims expire after the lease", at: now.Add(2 * time.Minute), want: []int64{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claimed, err := store.ClaimDueDeliveries(ctx, s.ID, tt.at, 2*time.Minute, 1)
+			if err != nil {
+				t.Fatalf("ClaimDueDeliveries() error = %v", err)
+			}
+			var got []int64
+			for _, d := range claimed {
+				got = append(got, d.EventID)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("ClaimDueDeliveries() claimed events %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	now := time.Unix(1700000000, 0)
+	header := Sign("secret", now, body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr bool
+	}{
+		{"valid", "secret", header, body, now, false},
+		{"wrong secret", "other", header, body, now, true},
+		{"changed body", "secret", header, []byte(`{"id":2}`), now, true},
+		{"too old", "secret", header, body, now.Add(10 * time.Minute), true},
+		{"no timestamp", "secret", "v1=abc", body, now, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.secret, tt.header, tt.body, 5*time.Minute, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
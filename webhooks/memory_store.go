@@ -0,0 +1,261 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	mu                 sync.RWMutex
+	nextSubscriptionID int64
+	nextDeliveryID     int64
+	subscriptions      map[int64]*Subscription
+	deliveries         map[int64]*Delivery
+}
+
+// NewMemoryStore creates a store that keeps subscriptions and deliveries in process memory
+func NewMemoryStore() Store {
+	return &memoryStore{
+		nextSubscriptionID: 1,
+		nextDeliveryID:     1,
+		subscriptions:      make(map[int64]*Subscription),
+		deliveries:         make(map[int64]*Delivery),
+	}
+}
+
+func (m *memoryStore) CreateSubscription(ctx context.Context, s *Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.ID = m.nextSubscriptionID
+	m.nextSubscriptionID++
+	m.subscriptions[s.ID] = copySubscription(s)
+	return nil
+}
+
+func (m *memoryStore) GetSubscription(ctx context.Context, id int64) (*Subscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.subscriptions[id]
+	if !ok {
+		return nil, nil
+	}
+	return copySubscription(s), nil
+}
+
+func (m *memoryStore) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	subscriptions := make([]*Subscription, 0, len(m.subscriptions))
+	for _, s := range m.subscriptions {
+		subscriptions = append(subscriptions, copySubscription(s))
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions, nil
+}
+
+func (m *memoryStore) UpdateSubscription(ctx context.Context, s *Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.subscriptions[s.ID]
+	if !ok {
+		return fmt.Errorf("%w with ID: %d", ErrSubscriptionNotFound, s.ID)
+	}
+	updated := copySubscription(existing)
+	updated.URL = s.URL
+	updated.Events = append([]string{}, s.Events...)
+	updated.Active = s.Active
+	updated.UpdatedAt = s.UpdatedAt
+	m.subscriptions[s.ID] = updated
+	return nil
+}
+
+func (m *memoryStore) DeleteSubscription(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.subscriptions[id]; !ok {
+		return fmt.Errorf("%w with ID: %d", ErrSubscriptionNotFound, id)
+	}
+	delete(m.subscriptions, id)
+	for deliveryID, d := range m.deliveries {
+		if d.SubscriptionID == id {
+			delete(m.deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) EnqueueDeliveries(ctx context.Context, deliveries []*Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range deliveries {
+		if m.findDelivery(d.SubscriptionID, d.EventID) != nil {
+			continue
+		}
+		d.ID = m.nextDeliveryID
+		m.nextDeliveryID++
+		m.deliveries[d.ID] = copyDelivery(d)
+	}
+	return nil
+}
+
+func (m *memoryStore) DueSubscriptions(ctx context.Context, now time.Time, skip []int64, limit int) ([]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// the subscriptions whose deliveries have been due longest come first
+	oldest := make(map[int64]time.Time)
+	for _, d := range m.deliveries {
+		if d.Status != StatusPending || d.NextAttemptAt.After(now) || containsID(skip, d.SubscriptionID) {
+			continue
+		}
+		if at, ok := oldest[d.SubscriptionID]; !ok || d.NextAttemptAt.Before(at) {
+			oldest[d.SubscriptionID] = d.NextAttemptAt
+		}
+	}
+	ids := make([]int64, 0, len(oldest))
+	for id := range oldest {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if !oldest[ids[i]].Equal(oldest[ids[j]]) {
+			return oldest[ids[i]].Before(oldest[ids[j]])
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func (m *memoryStore) ClaimDueDeliveries(ctx context.Context, subscriptionID int64, now time.Time, lease time.Duration, limit int) ([]*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*Delivery
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == subscriptionID && d.Status == StatusPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*Delivery, len(due))
+	for i, d := range due {
+		claimed[i] = copyDelivery(d)
+		claimed[i].Log = nil
+		// stored deliveries are replaced rather than modified, as GetDelivery hands out copies
+		updated := copyDelivery(d)
+		updated.NextAttemptAt = now.Add(lease)
+		m.deliveries[d.ID] = updated
+	}
+	return claimed, nil
+}
+
+func (m *memoryStore) RecordAttempt(ctx context.Context, d *Delivery, attempt Attempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.deliveries[d.ID]
+	if !ok {
+		return fmt.Errorf("%w with ID: %d", ErrDeliveryNotFound, d.ID)
+	}
+	updated := copyDelivery(existing)
+	updated.Status = d.Status
+	updated.Attempts = d.Attempts
+	updated.NextAttemptAt = d.NextAttemptAt
+	updated.LastError = d.LastError
+	updated.UpdatedAt = d.UpdatedAt
+	updated.Log = append(updated.Log, attempt)
+	m.deliveries[d.ID] = updated
+	return nil
+}
+
+func (m *memoryStore) ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]*Delivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	deliveries := []*Delivery{}
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == subscriptionID {
+			copied := copyDelivery(d)
+			copied.Log = nil
+			deliveries = append(deliveries, copied)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (m *memoryStore) GetDelivery(ctx context.Context, subscriptionID, id int64) (*Delivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d, ok := m.deliveries[id]
+	if !ok || d.SubscriptionID != subscriptionID {
+		return nil, nil
+	}
+	return copyDelivery(d), nil
+}
+
+func (m *memoryStore) Redeliver(ctx context.Context, subscriptionID, id int64) (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.deliveries[id]
+	if !ok || existing.SubscriptionID != subscriptionID {
+		return nil, fmt.Errorf("%w with ID: %d", ErrDeliveryNotFound, id)
+	}
+	updated := copyDelivery(existing)
+	now := time.Now().UTC()
+	updated.Status = StatusPending
+	updated.Attempts = 0
+	updated.NextAttemptAt = now
+	updated.UpdatedAt = now
+	m.deliveries[id] = updated
+	return copyDelivery(updated), nil
+}
+
+func (m *memoryStore) findDelivery(subscriptionID, eventID int64) *Delivery {
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == subscriptionID && d.EventID == eventID {
+			return d
+		}
+	}
+	return nil
+}
+
+func copySubscription(s *Subscription) *Subscription {
+	copied := *s
+	copied.Events = append([]string{}, s.Events...)
+	return &copied
+}
+
+func copyDelivery(d *Delivery) *Delivery {
+	copied := *d
+	copied.Log = append([]Attempt(nil), d.Log...)
+	return &copied
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
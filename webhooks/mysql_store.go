@@ -0,0 +1,324 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxStoredErrorLength is the size of the error columns of the webhook tables
+const maxStoredErrorLength = 1024
+
+type mysqlStore struct {
+	db *sql.DB
+}
+
+// NewMySQLStore creates a store on the webhook tables of db
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+func (m *mysqlStore) CreateSubscription(ctx context.Context, s *Subscription) error {
+	events, err := json.Marshal(s.Events)
+	if err != nil {
+		return fmt.Errorf("failed to encode events: %w", err)
+	}
+
+	query := `INSERT INTO webhook_subscriptions (url, secret, events, active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`
+	result, err := m.db.ExecContext(ctx, query, s.URL, s.Secret, string(events), s.Active, s.CreatedAt, s.UpdatedAt)
+	if err != nil {
+		log.Printf("Error creating webhook subscription: %v", err)
+		return fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+	if s.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+	return nil
+}
+
+func (m *mysqlStore) GetSubscription(ctx context.Context, id int64) (*Subscription, error) {
+	subscriptions, err := m.querySubscriptions(ctx, `WHERE id = ?`, id)
+	if err != nil || len(subscriptions) == 0 {
+		return nil, err
+	}
+	return subscriptions[0], nil
+}
+
+func (m *mysqlStore) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	return m.querySubscriptions(ctx, `ORDER BY id`)
+}
+
+func (m *mysqlStore) querySubscriptions(ctx context.Context, clauses string, args ...interface{}) ([]*Subscription, error) {
+	query := `SELECT id, url, secret, events, active, created_at, updated_at FROM webhook_subscriptions ` + clauses
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error fetching webhook subscriptions: %v", err)
+		return nil, fmt.Errorf("failed to fetch webhook subscriptions: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	subscriptions := []*Subscription{}
+	for rows.Next() {
+		s := &Subscription{}
+		var events []byte
+		if err := rows.Scan(&s.ID, &s.URL, &s.Secret, &events, &s.Active, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription: %w", err)
+		}
+		if err := json.Unmarshal(events, &s.Events); err != nil {
+			return nil, fmt.Errorf("failed to decode events of subscription %d: %w", s.ID, err)
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate webhook subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+func (m *mysqlStore) UpdateSubscription(ctx context.Context, s *Subscription) error {
+	events, err := json.Marshal(s.Events)
+	if err != nil {
+		return fmt.Errorf("failed to encode events: %w", err)
+	}
+
+	query := `UPDATE webhook_subscriptions SET url = ?, events = ?, active = ?, updated_at = ? WHERE id = ?`
+	result, err := m.db.ExecContext(ctx, query, s.URL, string(events), s.Active, s.UpdatedAt, s.ID)
+	if err != nil {
+		log.Printf("Error updating webhook subscription %d: %v", s.ID, err)
+		return fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		// nothing changed or nothing there, only the latter is an error
+		if existing, err := m.GetSubscription(ctx, s.ID); err != nil || existing == nil {
+			return fmt.Errorf("%w with ID: %d", ErrSubscriptionNotFound, s.ID)
+		}
+	}
+	return nil
+}
+
+func (m *mysqlStore) DeleteSubscription(ctx context.Context, id int64) error {
+	// deliveries and their attempts go with the subscription by ON DELETE CASCADE
+	result, err := m.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = ?`, id)
+	if err != nil {
+		log.Printf("Error deleting webhook subscription %d: %v", id, err)
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w with ID: %d", ErrSubscriptionNotFound, id)
+	}
+	return nil
+}
+
+func (m *mysqlStore) EnqueueDeliveries(ctx context.Context, deliveries []*Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(deliveries))
+	args := make([]interface{}, 0, 8*len(deliveries))
+	for i, d := range deliveries {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, d.SubscriptionID, d.EventID, d.EventType, string(d.Payload), d.Status, d.NextAttemptAt, d.CreatedAt, d.UpdatedAt)
+	}
+
+	// the unique key on (subscription_id, event_id) turns repeats into no-ops
+	query := `INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, next_attempt_at, created_at, updated_at)
+		VALUES ` + strings.Join(placeholders, ", ") + `
+		ON DUPLICATE KEY UPDATE id = id`
+	if _, err := m.db.ExecContext(ctx, query, args...); err != nil {
+		log.Printf("Error enqueueing %d webhook deliveries: %v", len(deliveries), err)
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// DueSubscriptions reads idx_webhook_deliveries_due, so the subscriptions whose deliveries
+// have been due longest come first
+func (m *mysqlStore) DueSubscriptions(ctx context.Context, now time.Time, skip []int64, limit int) ([]int64, error) {
+	query := `SELECT DISTINCT subscription_id FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ?`
+	args := []interface{}{StatusPending, now}
+	if len(skip) > 0 {
+		placeholders := make([]string, len(skip))
+		for i, id := range skip {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		query += ` AND subscription_id NOT IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+	query += ` LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error fetching subscriptions with due deliveries: %v", err)
+		return nil, fmt.Errorf("failed to fetch subscriptions with due deliveries: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate subscriptions with due deliveries: %w", err)
+	}
+	return ids, nil
+}
+
+// ClaimDueDeliveries locks the due rows with SKIP LOCKED, so that dispatchers on other
+// instances claim other rows instead of waiting, and moves their next attempt to the end of
+// the lease before the lock is released
+func (m *mysqlStore) ClaimDueDeliveries(ctx context.Context, subscriptionID int64, now time.Time, lease time.Duration, limit int) ([]*Delivery, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	due, err := m.queryDeliveries(ctx, tx, `WHERE subscription_id = ? AND status = ? AND next_attempt_at <= ? ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`, subscriptionID, StatusPending, now, limit)
+	if err != nil || len(due) == 0 {
+		return due, err
+	}
+	placeholders := make([]string, len(due))
+	args := make([]interface{}, 0, len(due)+1)
+	args = append(args, now.Add(lease))
+	for i, d := range due {
+		placeholders[i] = "?"
+		args = append(args, d.ID)
+	}
+	query := `UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id IN (` + strings.Join(placeholders, ", ") + `)`
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit webhook delivery claims: %w", err)
+	}
+	return due, nil
+}
+
+func (m *mysqlStore) ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]*Delivery, error) {
+	return m.queryDeliveries(ctx, m.db, `WHERE subscription_id = ? ORDER BY id DESC LIMIT ?`, subscriptionID, limit)
+}
+
+func (m *mysqlStore) GetDelivery(ctx context.Context, subscriptionID, id int64) (*Delivery, error) {
+	deliveries, err := m.queryDeliveries(ctx, m.db, `WHERE id = ? AND subscription_id = ?`, id, subscriptionID)
+	if err != nil || len(deliveries) == 0 {
+		return nil, err
+	}
+	d := deliveries[0]
+
+	query := `SELECT attempted_at, status_code, error, duration_ms FROM webhook_delivery_attempts WHERE delivery_id = ? ORDER BY id`
+	rows, err := m.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch delivery attempts: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		var attempt Attempt
+		if err := rows.Scan(&attempt.At, &attempt.StatusCode, &attempt.Error, &attempt.DurationMS); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery attempt: %w", err)
+		}
+		d.Log = append(d.Log, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate delivery attempts: %w", err)
+	}
+	return d, nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (m *mysqlStore) queryDeliveries(ctx context.Context, q queryer, clauses string, args ...interface{}) ([]*Delivery, error) {
+	query := `SELECT id, subscription_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_error, created_at, updated_at
+		FROM webhook_deliveries ` + clauses
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error fetching webhook deliveries: %v", err)
+		return nil, fmt.Errorf("failed to fetch webhook deliveries: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		d := &Delivery{}
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastError, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (m *mysqlStore) RecordAttempt(ctx context.Context, d *Delivery, attempt Attempt) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, updated_at = ? WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttemptAt, truncate(d.LastError), d.UpdatedAt, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, status_code, error, duration_ms) VALUES (?, ?, ?, ?, ?)`,
+		d.ID, attempt.At, attempt.StatusCode, truncate(attempt.Error), attempt.DurationMS)
+	if err != nil {
+		return fmt.Errorf("failed to record delivery attempt: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit delivery attempt: %w", err)
+	}
+	return nil
+}
+
+func (m *mysqlStore) Redeliver(ctx context.Context, subscriptionID, id int64) (*Delivery, error) {
+	now := time.Now().UTC()
+	result, err := m.db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE id = ? AND subscription_id = ?`, StatusPending, now, now, id, subscriptionID)
+	if err != nil {
+		log.Printf("Error redelivering webhook %d: %v", id, err)
+		return nil, fmt.Errorf("failed to redeliver webhook: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, fmt.Errorf("%w with ID: %d", ErrDeliveryNotFound, id)
+	}
+	return m.GetDelivery(ctx, subscriptionID, id)
+}
+
+func truncate(message string) string {
+	if len(message) > maxStoredErrorLength {
+		return message[:maxStoredErrorLength]
+	}
+	return message
+}
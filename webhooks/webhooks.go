@@ -0,0 +1,200 @@
+// Package webhooks delivers user events to partner endpoints over HTTP. Deliveries are
+// signed with the subscription's secret, retried with exponential backoff and moved to the
+// dead state after too many failures, from where they can be redelivered by hand.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"userapi/models"
+)
+
+// Delivery states
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+// Headers of a delivery request
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+)
+
+var (
+	// ErrSubscriptionNotFound is returned for unknown subscription IDs
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	// ErrDeliveryNotFound is returned for unknown delivery IDs
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	// ErrInvalidSignature is returned by VerifySignature for requests that were not signed
+	// with the secret, or too long ago
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// EventTypes are the event types a subscription can filter on
+var EventTypes = []string{models.EventUserCreated, models.EventUserUpdated, models.EventUserDeleted}
+
+// Subscription asks for events to be posted to URL. An empty Events list subscribes to
+// every event type.
+type Subscription struct {
+	ID     int64    `json:"id" xml:"id"`
+	URL    string   `json:"url" xml:"url"`
+	Events []string `json:"events" xml:"events>event"`
+	// Secret signs deliveries. It is only returned when the subscription is created.
+	Secret    string    `json:"secret,omitempty" xml:"secret,omitempty"`
+	Active    bool      `json:"active" xml:"active"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// Validate checks the URL and event types of the subscription
+func (s *Subscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	for _, event := range s.Events {
+		if !contains(EventTypes, event) {
+			return fmt.Errorf("unknown event type: %s", event)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the subscription wants events of eventType
+func (s *Subscription) Matches(eventType string) bool {
+	return s.Active && (len(s.Events) == 0 || contains(s.Events, eventType))
+}
+
+// Delivery is one event for one subscription, with every attempt to post it
+type Delivery struct {
+	ID             int64     `json:"id" xml:"id"`
+	SubscriptionID int64     `json:"subscription_id" xml:"subscription_id"`
+	EventID        int64     `json:"event_id" xml:"event_id"`
+	EventType      string    `json:"event_type" xml:"event_type"`
+	Payload        []byte    `json:"-" xml:"-"`
+	Status         string    `json:"status" xml:"status"`
+	Attempts       int       `json:"attempts" xml:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at" xml:"next_attempt_at"`
+	LastError      string    `json:"last_error,omitempty" xml:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" xml:"updated_at"`
+	// Log lists the attempts, oldest first. It is only filled in by Store.GetDelivery.
+	Log []Attempt `json:"log,omitempty" xml:"log>attempt,omitempty"`
+}
+
+// Attempt is one try to post a delivery
+type Attempt struct {
+	At         time.Time `json:"at" xml:"at"`
+	StatusCode int       `json:"status_code,omitempty" xml:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty" xml:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms" xml:"duration_ms"`
+}
+
+// Store keeps subscriptions and deliveries
+type Store interface {
+	CreateSubscription(ctx context.Context, s *Subscription) error
+	// GetSubscription returns the subscription, or nil if there is none
+	GetSubscription(ctx context.Context, id int64) (*Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]*Subscription, error)
+	// UpdateSubscription changes the URL, events and active flag of a subscription
+	UpdateSubscription(ctx context.Context, s *Subscription) error
+	// DeleteSubscription deletes a subscription and its deliveries
+	DeleteSubscription(ctx context.Context, id int64) error
+
+	// EnqueueDeliveries stores new pending deliveries. A delivery of an event that its
+	// subscription already has is skipped, so events published twice are delivered once.
+	EnqueueDeliveries(ctx context.Context, deliveries []*Delivery) error
+	// DueSubscriptions returns up to limit subscriptions, other than those in skip, that have
+	// pending deliveries whose next attempt is due
+	DueSubscriptions(ctx context.Context, now time.Time, skip []int64, limit int) ([]int64, error)
+	// ClaimDueDeliveries returns up to limit due deliveries of a subscription, oldest first, and
+	// claims them by moving their next attempt lease ahead, so that other dispatchers pass
+	// over them. A claim that is not followed by RecordAttempt, because its dispatcher
+	// stopped, runs out and the delivery is attempted again.
+	ClaimDueDeliveries(ctx context.Context, subscriptionID int64, now time.Time, lease time.Duration, limit int) ([]*Delivery, error)
+	// RecordAttempt saves the status, attempts, next attempt and last error of d and adds
+	// attempt to its log
+	RecordAttempt(ctx context.Context, d *Delivery, attempt Attempt) error
+	// ListDeliveries returns up to limit deliveries of a subscription, newest first
+	ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]*Delivery, error)
+	// GetDelivery returns a delivery of a subscription with its log, or nil if there is none
+	GetDelivery(ctx context.Context, subscriptionID, id int64) (*Delivery, error)
+	// Redeliver makes a delivery pending and due now, with a fresh set of attempts
+	Redeliver(ctx context.Context, subscriptionID, id int64) (*Delivery, error)
+}
+
+// NewSecret generates a random signing secret
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value for body sent at t: the Unix timestamp and the
+// hex HMAC-SHA256 of "<timestamp>.<body>" under secret
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + computeSignature(secret, timestamp, body)
+}
+
+// VerifySignature checks a signature header as receivers should: the HMAC must match and the
+// timestamp must be within tolerance of now, which stops replays of old deliveries
+func VerifySignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", ErrInvalidSignature)
+	}
+	if age := now.Sub(time.Unix(sent, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+	expected := computeSignature(secret, timestamp, body)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature mismatch", ErrInvalidSignature)
+}
+
+func computeSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}